@@ -0,0 +1,219 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// keyPrefix отличает API ключи от других секретов, например в сканерах утечек
+	keyPrefix = "ddk_"
+	// displayLength - сколько первых символов ключа хранится открыто для списка ключей
+	displayLength = len(keyPrefix) + 8
+	// touchInterval - как часто обновляется время последнего использования ключа
+	touchInterval = time.Minute
+	// initRetryDelay - пауза между проверками, созданы ли таблицы ключей
+	initRetryDelay = time.Second
+)
+
+var (
+	// ErrInvalidKey возвращается для неизвестного, отозванного или истекшего ключа
+	ErrInvalidKey = errors.New("invalid API key")
+	// ErrNotFound возвращается, если ключ не найден среди ключей владельца
+	ErrNotFound = errors.New("API key not found")
+)
+
+// Key - API ключ машинного клиента, выпущенный от имени пользователя
+type Key struct {
+	ID     string
+	UserID string
+	Email  string
+	Name   string
+	Prefix string
+	Scopes []string
+	// DailyQuota - суточная квота запросов ключа, 0 - квота сервиса по умолчанию
+	DailyQuota int
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+}
+
+// Store хранит API ключи в Postgres. Сам ключ не хранится, только его
+// sha256: ключи случайные и длинные, поэтому медленный хэш не нужен.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore создает хранилище API ключей
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Init ждет, пока миграции user сервиса создадут таблицу ключей с колонкой
+// daily_quota: auth может запуститься раньше. Возвращает ошибку, если схемы
+// нет к отмене ctx.
+func (s *Store) Init(ctx context.Context) error {
+	for {
+		var exists bool
+		err := s.db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM information_schema.columns
+			  WHERE table_schema = current_schema() AND table_name = 'api_keys' AND column_name = 'daily_quota')`,
+		).Scan(&exists)
+		if err == nil && exists {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("table api_keys is not migrated, run user service migrations")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("API keys are not ready: %v", err)
+		case <-time.After(initRetryDelay):
+		}
+	}
+}
+
+const keyColumns = `id, user_id, email, name, prefix, scopes, daily_quota, created_at, last_used_at, expires_at, revoked_at`
+
+// Create выпускает ключ и возвращает его вместе с секретом, который больше нигде не сохраняется
+func (s *Store) Create(ctx context.Context, userID, email, name string, scopes []string, dailyQuota int, expiresAt *time.Time) (*Key, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+	secret := keyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	key, err := scanKey(s.db.QueryRowContext(ctx,
+		`INSERT INTO api_keys (user_id, email, name, prefix, key_hash, scopes, daily_quota, expires_at)
+         VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+         RETURNING `+keyColumns,
+		userID, email, name, secret[:displayLength], hashKey(secret), pq.Array(scopes), dailyQuota, expiresAt))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to store API key: %v", err)
+	}
+
+	return key, secret, nil
+}
+
+// List возвращает ключи пользователя, включая отозванные
+func (s *Store) List(ctx context.Context, userID string) ([]*Key, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+keyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []*Key
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %v", err)
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %v", err)
+	}
+	return keys, nil
+}
+
+// Revoke отзывает ключ id. Пустой userID снимает проверку владельца.
+func (s *Store) Revoke(ctx context.Context, id, userID string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = NOW()
+         WHERE id = $1 AND ($2 = '' OR user_id::text = $2) AND revoked_at IS NULL`,
+		id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAll отзывает все действующие ключи пользователя и возвращает их число
+func (s *Store) RevokeAll(ctx context.Context, userID string) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke API keys: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// DeleteAll удаляет все ключи пользователя, включая отозванные, и возвращает их число
+func (s *Store) DeleteAll(ctx context.Context, userID string) (int64, error) {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM api_keys WHERE user_id = $1`,
+		userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete API keys: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// Resolve возвращает действующий ключ по секрету и отмечает его использование
+func (s *Store) Resolve(ctx context.Context, secret string) (*Key, error) {
+	if len(secret) <= displayLength || secret[:len(keyPrefix)] != keyPrefix {
+		return nil, ErrInvalidKey
+	}
+
+	key, err := scanKey(s.db.QueryRowContext(ctx,
+		`SELECT `+keyColumns+` FROM api_keys
+         WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`,
+		hashKey(secret)))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %v", err)
+	}
+
+	// Время использования обновляется не чаще touchInterval, чтобы не писать в базу на каждый запрос
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > touchInterval {
+		if _, err := s.db.ExecContext(ctx,
+			`UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`,
+			key.ID); err != nil {
+			log.Printf("Failed to update last use of API key %s: %v", key.ID, err)
+		}
+	}
+
+	return key, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanKey(row scanner) (*Key, error) {
+	var key Key
+	err := row.Scan(&key.ID, &key.UserID, &key.Email, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.DailyQuota,
+		&key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
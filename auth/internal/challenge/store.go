@@ -0,0 +1,114 @@
+package challenge
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const challengePrefix = "mfa:challenge:"
+
+// ErrInvalidChallenge возвращается для неизвестного, истекшего или исчерпанного токена
+var ErrInvalidChallenge = errors.New("invalid or expired MFA challenge")
+
+// Challenge - вход, прошедший проверку пароля и ожидающий второй фактор
+type Challenge struct {
+	UserID string
+	Email  string
+	Role   string
+}
+
+// Store хранит незавершенные входы в Redis. Токен выдается клиенту один раз,
+// в Redis лежит только его хэш. После maxAttempts неверных кодов вход
+// приходится начинать заново с пароля.
+type Store struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+	maxAttempts int
+}
+
+// NewStore создает хранилище MFA челленджей
+func NewStore(redisClient *redis.Client, ttl time.Duration, maxAttempts int) *Store {
+	return &Store{
+		redisClient: redisClient,
+		ttl:         ttl,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Create сохраняет челлендж и возвращает токен для второго шага входа
+func (s *Store) Create(ctx context.Context, c Challenge) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate MFA token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	key := challengePrefix + hashToken(token)
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", c.UserID, "email", c.Email, "role", c.Role, "attempts", 0)
+	pipe.Expire(ctx, key, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("failed to store MFA challenge: %v", err)
+	}
+
+	return token, nil
+}
+
+// Get возвращает челлендж по токену
+func (s *Store) Get(ctx context.Context, token string) (*Challenge, error) {
+	if token == "" {
+		return nil, ErrInvalidChallenge
+	}
+
+	fields, err := s.redisClient.HGetAll(ctx, challengePrefix+hashToken(token)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MFA challenge: %v", err)
+	}
+	if fields["user_id"] == "" {
+		return nil, ErrInvalidChallenge
+	}
+
+	return &Challenge{
+		UserID: fields["user_id"],
+		Email:  fields["email"],
+		Role:   fields["role"],
+	}, nil
+}
+
+// Fail учитывает неверный код и удаляет челлендж, когда попытки исчерпаны
+func (s *Store) Fail(ctx context.Context, token string) error {
+	key := challengePrefix + hashToken(token)
+
+	pipe := s.redisClient.TxPipeline()
+	attempts := pipe.HIncrBy(ctx, key, "attempts", 1)
+	exists := pipe.HExists(ctx, key, "user_id")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record MFA failure: %v", err)
+	}
+	// Челлендж мог истечь между Get и Fail: HINCRBY тогда создал бы ключ без TTL
+	if !exists.Val() || attempts.Val() >= int64(s.maxAttempts) {
+		return s.Delete(ctx, token)
+	}
+	return nil
+}
+
+// Delete удаляет челлендж, чтобы токен нельзя было использовать повторно
+func (s *Store) Delete(ctx context.Context, token string) error {
+	if err := s.redisClient.Del(ctx, challengePrefix+hashToken(token)).Err(); err != nil {
+		return fmt.Errorf("failed to delete MFA challenge: %v", err)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,15 +1,125 @@
 package config
 
+import (
+    "os"
+    "time"
+
+    "dd/auth/internal/oidc"
+    "dd/auth/internal/throttle"
+    "dd/pkg/authz"
+)
+
 type Config struct {
-    GRPCPort    string
-    UserService string
-    JWTSecret   string
+    GRPCPort            string
+    UserService         string
+    RedisAddr           string
+    // DB хранит API ключи
+    DB                  DBConfig
+    JWTAlgorithm        string
+    JWTIssuer           string
+    JWTAudience         string
+    TokenTTL            time.Duration
+    RefreshTokenTTL     time.Duration
+    KeyRotationInterval time.Duration
+    // SigningKeyEncryptionKey - ключ AES-256 в base64, которым ключи подписи
+    // шифруются в Redis. Задается переменной AUTH_SIGNING_KEY_ENCRYPTION_KEY.
+    SigningKeyEncryptionKey string
+    LoginThrottle       throttle.Config
+    // MFAChallengeTTL - сколько ждать код второго фактора после проверки пароля
+    MFAChallengeTTL     time.Duration
+    MFAMaxAttempts      int
+    // AdminRequiresMFA - администратор без подключенного TOTP получает токен
+    // только с ролью обычного пользователя
+    AdminRequiresMFA    bool
+    // OAuthProviders - OIDC провайдеры для входа; провайдер без ClientID отключен
+    OAuthProviders      map[string]oidc.ProviderConfig
+    OAuthStateTTL       time.Duration
+    // MockIdPAddr - адрес встроенного тестового провайдера "mock"; пустой - не запускать.
+    // Провайдер впускает под любым email без пароля, поэтому задается только
+    // в dev окружении переменной AUTH_MOCK_IDP_ADDR.
+    MockIdPAddr         string
+    // ServiceClients - сервисы, получающие токены по client_credentials, по client_id.
+    // Секреты задаются переменными AUTH_<CLIENT>_CLIENT_SECRET; клиент без
+    // секрета токен не получит.
+    ServiceClients      map[string]ServiceClient
+    // ProxyClientID - client_id proxy; только ему auth доверяет адрес клиента из x-client-ip
+    ProxyClientID       string
+    ServiceTokenTTL     time.Duration
+}
+
+// ServiceClient - зарегистрированный клиент client_credentials
+type ServiceClient struct {
+    Secret string
+    // Scopes - разрешения, которые клиент может запросить
+    Scopes []string
+}
+
+type DBConfig struct {
+    Host     string
+    Port     string
+    User     string
+    Password string
+    DBName   string
 }
 
 func New() *Config {
     return &Config{
-        GRPCPort:    ":50051",
-        UserService: "user:50053",
-        JWTSecret:   "your-secret-key",
+        GRPCPort:            ":50051",
+        UserService:         "user:50053",
+        RedisAddr:           "redis:6379",
+        DB: DBConfig{
+            Host:     "postgres",
+            Port:     "5432",
+            User:     "postgres",
+            Password: "postgres",
+            DBName:   "userdb",
+        },
+        JWTAlgorithm:        "RS256",
+        JWTIssuer:           "dd-auth",
+        JWTAudience:         "dd-api",
+        TokenTTL:            15 * time.Minute,
+        RefreshTokenTTL:     30 * 24 * time.Hour,
+        KeyRotationInterval: 24 * time.Hour,
+        LoginThrottle: throttle.Config{
+            Window:           time.Hour,
+            AccountThreshold: 3,
+            IPThreshold:      20,
+            BackoffBase:      time.Second,
+            BackoffMax:       5 * time.Minute,
+            LockoutThreshold: 10,
+            LockoutDuration:  15 * time.Minute,
+        },
+        MFAChallengeTTL:     5 * time.Minute,
+        MFAMaxAttempts:      5,
+        AdminRequiresMFA:    true,
+        OAuthProviders: map[string]oidc.ProviderConfig{
+            // Учетные данные клиента задаются при запуске встроенного провайдера, см. MockIdPAddr
+            "mock": {
+                Issuer:      "http://localhost:9096",
+                AuthURL:     "http://localhost:9096/authorize",
+                TokenURL:    "http://localhost:9096/token",
+                JWKSURL:     "http://localhost:9096/jwks",
+                RedirectURL: "http://localhost:8080/api/auth/oauth/mock/callback",
+                Scopes:      []string{"openid", "email"},
+            },
+            "google": {
+                Issuer:      "https://accounts.google.com",
+                AuthURL:     "https://accounts.google.com/o/oauth2/v2/auth",
+                TokenURL:    "https://oauth2.googleapis.com/token",
+                JWKSURL:     "https://www.googleapis.com/oauth2/v3/certs",
+                RedirectURL: "http://localhost:8080/api/auth/oauth/google/callback",
+                Scopes:      []string{"openid", "email"},
+            },
+        },
+        OAuthStateTTL:       10 * time.Minute,
+        MockIdPAddr:         os.Getenv("AUTH_MOCK_IDP_ADDR"),
+        SigningKeyEncryptionKey: os.Getenv("AUTH_SIGNING_KEY_ENCRYPTION_KEY"),
+        ServiceClients: map[string]ServiceClient{
+            "geo":   {Secret: os.Getenv("AUTH_GEO_CLIENT_SECRET")},
+            "proxy": {Secret: os.Getenv("AUTH_PROXY_CLIENT_SECRET")},
+            "user":  {Secret: os.Getenv("AUTH_USER_CLIENT_SECRET"), Scopes: []string{authz.ScopeUserData}},
+        },
+        ProxyClientID:       "proxy",
+        ServiceTokenTTL:     10 * time.Minute,
     }
-} 
\ No newline at end of file
+}
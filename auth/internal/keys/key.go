@@ -0,0 +1,105 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"dd/pkg/authn"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// AlgorithmRS256 - подпись RSA PKCS#1 v1.5 с SHA-256
+	AlgorithmRS256 = "RS256"
+	// AlgorithmEdDSA - подпись Ed25519
+	AlgorithmEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+)
+
+// Key - ключ подписи токенов, идентифицируемый по kid
+type Key struct {
+	ID        string
+	Algorithm string
+	CreatedAt time.Time
+	// RetiredAt - момент, когда ключ перестал подписывать токены; нулевой у текущего ключа
+	RetiredAt time.Time
+
+	private crypto.Signer
+}
+
+// Generate создает новый ключ для алгоритма alg
+func Generate(alg string) (*Key, error) {
+	var private crypto.Signer
+	switch alg {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate RSA key: %v", err)
+		}
+		private = key
+	case AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate Ed25519 key: %v", err)
+		}
+		private = key
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate key id: %v", err)
+	}
+
+	return &Key{
+		ID:        hex.EncodeToString(id),
+		Algorithm: alg,
+		CreatedAt: time.Now(),
+		private:   private,
+	}, nil
+}
+
+// SigningMethod возвращает метод подписи jwt для ключа
+func (k *Key) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// PrivateKey возвращает ключ в виде, ожидаемом jwt при подписи
+func (k *Key) PrivateKey() interface{} {
+	return k.private
+}
+
+// PublicKey возвращает ключ в виде, ожидаемом jwt при проверке подписи
+func (k *Key) PublicKey() crypto.PublicKey {
+	return k.private.Public()
+}
+
+// JWK возвращает публичную часть ключа для публикации
+func (k *Key) JWK() authn.JSONWebKey {
+	return authn.NewJSONWebKey(k.ID, k.Algorithm, k.PublicKey())
+}
+
+func (k *Key) marshalPrivate() ([]byte, error) {
+	return x509.MarshalPKCS8PrivateKey(k.private)
+}
+
+func unmarshalPrivate(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
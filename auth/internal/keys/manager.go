@@ -0,0 +1,358 @@
+package keys
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"dd/pkg/authn"
+	"dd/pkg/secretbox"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	keysKey     = "jwt:keys"
+	lockKey     = "jwt:keys:lock"
+	lockTTL     = 30 * time.Second
+	syncPeriod  = time.Minute
+	lockRetries = 10
+)
+
+type storedKey struct {
+	Algorithm string    `json:"alg"`
+	CreatedAt time.Time `json:"created_at"`
+	RetiredAt time.Time `json:"retired_at"`
+	// SealedKey - закрытый ключ в DER, зашифрованный secretbox
+	SealedKey []byte `json:"sealed_key,omitempty"`
+	// PrivateKey - закрытый ключ без шифрования, как его хранили прежние
+	// версии. Такие ключи перешифровываются при синхронизации.
+	PrivateKey []byte `json:"private_key,omitempty"`
+}
+
+// unlockScript снимает блокировку, только если ее держит владелец токена:
+// блокировка могла истечь и достаться другому экземпляру
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Manager хранит набор ключей подписи и ротирует их по расписанию.
+//
+// Ключи хранятся в Redis, чтобы все экземпляры auth подписывали одним
+// ключом и переживали перезапуск. Закрытые ключи шифруются box: доступа к
+// Redis недостаточно, чтобы подписывать токены. Выведенный из подписи ключ остается
+// доступным для проверки еще grace - максимальное время жизни выпущенных
+// им токенов - и публикуется в JWKS до тех пор. Экземпляр узнает о ротации
+// только при очередной синхронизации и до нее подписывает старым ключом,
+// поэтому к grace добавляется период синхронизации.
+type Manager struct {
+	redisClient      *redis.Client
+	box              *secretbox.Box
+	algorithm        string
+	rotationInterval time.Duration
+	grace            time.Duration
+
+	mu   sync.RWMutex
+	keys []*Key // от новых к старым
+}
+
+// NewManager создает менеджер ключей, шифрующий закрытые ключи box
+func NewManager(redisClient *redis.Client, box *secretbox.Box, algorithm string, rotationInterval, grace time.Duration) *Manager {
+	return &Manager{
+		redisClient:      redisClient,
+		box:              box,
+		algorithm:        algorithm,
+		rotationInterval: rotationInterval,
+		grace:            grace + syncPeriod,
+	}
+}
+
+// Init загружает ключи и создает первый ключ, если их еще нет
+func (m *Manager) Init(ctx context.Context) error {
+	return m.sync(ctx)
+}
+
+// Run периодически подхватывает ключи других экземпляров и выполняет
+// плановую ротацию, пока не будет отменен ctx
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(syncPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.sync(ctx); err != nil {
+				log.Printf("Failed to sync signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// SigningKey возвращает текущий ключ подписи
+func (m *Manager) SigningKey() (*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.keys) == 0 || !m.keys[0].RetiredAt.IsZero() {
+		return nil, fmt.Errorf("no active signing key")
+	}
+	return m.keys[0], nil
+}
+
+// VerificationKey возвращает ключ по kid, если им еще можно проверять подписи
+func (m *Manager) VerificationKey(kid string) (*Key, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	for _, key := range m.keys {
+		if key.ID == kid {
+			return key, !m.expired(key, now)
+		}
+	}
+	return nil, false
+}
+
+// PublicKeys возвращает публичные части всех ключей, пригодных для проверки
+func (m *Manager) PublicKeys() []authn.JSONWebKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var jwks []authn.JSONWebKey
+	for _, key := range m.keys {
+		if !m.expired(key, now) {
+			jwks = append(jwks, key.JWK())
+		}
+	}
+	return jwks
+}
+
+// Rotate выводит текущий ключ из подписи и создает новый
+func (m *Manager) Rotate(ctx context.Context) error {
+	return m.withLock(ctx, func() error {
+		keys, _, err := m.load(ctx)
+		if err != nil {
+			return err
+		}
+		return m.rotate(ctx, keys)
+	})
+}
+
+// sync перечитывает ключи из Redis и ротирует текущий ключ, если пришло
+// время. Ключи, сохраненные без шифрования, перешифровываются.
+func (m *Manager) sync(ctx context.Context) error {
+	keys, plaintext, err := m.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !m.rotationDue(keys) && !plaintext {
+		m.set(keys)
+		return nil
+	}
+
+	return m.withLock(ctx, func() error {
+		// Пока мы ждали блокировку, ротацию мог выполнить другой экземпляр
+		keys, plaintext, err := m.load(ctx)
+		if err != nil {
+			return err
+		}
+		if m.rotationDue(keys) {
+			return m.rotate(ctx, keys)
+		}
+		if plaintext {
+			if err := m.reseal(ctx, keys); err != nil {
+				return err
+			}
+		}
+		m.set(keys)
+		return nil
+	})
+}
+
+// reseal перезаписывает ключи зашифрованными
+func (m *Manager) reseal(ctx context.Context, keys []*Key) error {
+	pipe := m.redisClient.TxPipeline()
+	for _, key := range keys {
+		if err := m.save(ctx, pipe, key); err != nil {
+			return err
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store signing keys: %v", err)
+	}
+
+	log.Printf("Encrypted %d signing keys stored in plaintext", len(keys))
+	return nil
+}
+
+func (m *Manager) rotate(ctx context.Context, keys []*Key) error {
+	next, err := Generate(m.algorithm)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	pipe := m.redisClient.TxPipeline()
+	for _, key := range keys {
+		if key.RetiredAt.IsZero() {
+			key.RetiredAt = now
+		}
+		if m.expired(key, now) {
+			pipe.HDel(ctx, keysKey, key.ID)
+			continue
+		}
+		if err := m.save(ctx, pipe, key); err != nil {
+			return err
+		}
+	}
+	if err := m.save(ctx, pipe, next); err != nil {
+		return err
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store signing keys: %v", err)
+	}
+
+	log.Printf("Rotated signing key, new kid %s (%s)", next.ID, next.Algorithm)
+
+	m.set(append([]*Key{next}, keys...))
+	return nil
+}
+
+func (m *Manager) rotationDue(keys []*Key) bool {
+	if len(keys) == 0 || !keys[0].RetiredAt.IsZero() {
+		return true
+	}
+	current := keys[0]
+	return current.Algorithm != m.algorithm || time.Since(current.CreatedAt) >= m.rotationInterval
+}
+
+func (m *Manager) expired(key *Key, now time.Time) bool {
+	return !key.RetiredAt.IsZero() && now.After(key.RetiredAt.Add(m.grace))
+}
+
+func (m *Manager) set(keys []*Key) {
+	now := time.Now()
+	active := make([]*Key, 0, len(keys))
+	for _, key := range keys {
+		if !m.expired(key, now) {
+			active = append(active, key)
+		}
+	}
+
+	m.mu.Lock()
+	m.keys = active
+	m.mu.Unlock()
+}
+
+// load читает ключи из Redis; plaintext сообщает, что среди них есть
+// сохраненные без шифрования
+func (m *Manager) load(ctx context.Context) (keys []*Key, plaintext bool, err error) {
+	values, err := m.redisClient.HGetAll(ctx, keysKey).Result()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load signing keys: %v", err)
+	}
+
+	keys = make([]*Key, 0, len(values))
+	for kid, value := range values {
+		var stored storedKey
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			return nil, false, fmt.Errorf("failed to decode signing key %s: %v", kid, err)
+		}
+		der := stored.PrivateKey
+		if len(stored.SealedKey) > 0 {
+			if der, err = m.box.Open(stored.SealedKey); err != nil {
+				return nil, false, fmt.Errorf("failed to decrypt signing key %s: %v", kid, err)
+			}
+		} else {
+			plaintext = true
+		}
+		private, err := unmarshalPrivate(der)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to decode signing key %s: %v", kid, err)
+		}
+		keys = append(keys, &Key{
+			ID:        kid,
+			Algorithm: stored.Algorithm,
+			CreatedAt: stored.CreatedAt,
+			RetiredAt: stored.RetiredAt,
+			private:   private,
+		})
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.After(keys[j].CreatedAt)
+	})
+	return keys, plaintext, nil
+}
+
+func (m *Manager) save(ctx context.Context, pipe redis.Pipeliner, key *Key) error {
+	der, err := key.marshalPrivate()
+	if err != nil {
+		return fmt.Errorf("failed to encode signing key %s: %v", key.ID, err)
+	}
+	sealed, err := m.box.Seal(der)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt signing key %s: %v", key.ID, err)
+	}
+	value, err := json.Marshal(storedKey{
+		Algorithm: key.Algorithm,
+		CreatedAt: key.CreatedAt,
+		RetiredAt: key.RetiredAt,
+		SealedKey: sealed,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode signing key %s: %v", key.ID, err)
+	}
+	pipe.HSet(ctx, keysKey, key.ID, value)
+	return nil
+}
+
+// withLock выполняет fn под распределенной блокировкой ротации. Блокировка
+// помечается случайным токеном и снимается, только пока принадлежит этому
+// вызову: если fn работала дольше lockTTL и блокировку взял другой
+// экземпляр, его блокировка остается.
+func (m *Manager) withLock(ctx context.Context, fn func() error) error {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("failed to generate lock token: %v", err)
+	}
+	token := hex.EncodeToString(raw)
+
+	for i := 0; i < lockRetries; i++ {
+		ok, err := m.redisClient.SetNX(ctx, lockKey, token, lockTTL).Result()
+		if err != nil {
+			return fmt.Errorf("failed to acquire key rotation lock: %v", err)
+		}
+		if ok {
+			defer m.unlock(token)
+			return fn()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	return fmt.Errorf("failed to acquire key rotation lock: timeout")
+}
+
+// unlock снимает блокировку ротации, если ее все еще держит token
+func (m *Manager) unlock(token string) {
+	// Контекст вызова может быть уже отменен, а блокировку нужно снять в любом случае
+	if err := unlockScript.Run(context.Background(), m.redisClient, []string{lockKey}, token).Err(); err != nil {
+		log.Printf("Failed to release key rotation lock: %v", err)
+	}
+}
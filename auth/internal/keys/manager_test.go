@@ -0,0 +1,60 @@
+package keys
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"dd/pkg/secretbox"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager_withLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+
+	box, err := secretbox.New("mWqv0eJ3Xq0a8C5l2mFQk7b1gOaQz4n6Yt9rS3uVw8E=")
+	assert.NoError(t, err)
+	manager := NewManager(redisClient, box, AlgorithmEdDSA, 24*time.Hour, time.Hour)
+
+	t.Run("lock is released", func(t *testing.T) {
+		err := manager.withLock(context.Background(), func() error {
+			assert.True(t, mr.Exists(lockKey))
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.False(t, mr.Exists(lockKey))
+	})
+
+	t.Run("lock of another instance is kept", func(t *testing.T) {
+		err := manager.withLock(context.Background(), func() error {
+			// Блокировка истекла, пока шла ротация, и ее взял другой экземпляр
+			mr.Del(lockKey)
+			assert.NoError(t, mr.Set(lockKey, "other"))
+			return nil
+		})
+		assert.NoError(t, err)
+
+		value, err := mr.Get(lockKey)
+		assert.NoError(t, err)
+		assert.Equal(t, "other", value)
+	})
+
+	t.Run("tokens differ between calls", func(t *testing.T) {
+		mr.Del(lockKey)
+		var tokens []string
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, manager.withLock(context.Background(), func() error {
+				token, err := mr.Get(lockKey)
+				assert.NoError(t, err)
+				tokens = append(tokens, token)
+				return nil
+			}))
+		}
+		assert.NotEqual(t, tokens[0], tokens[1])
+	})
+}
@@ -0,0 +1,258 @@
+// Package fakeidp - OIDC провайдер для локальной разработки и тестов.
+// Страница входа не спрашивает пароль: пользователь задается параметром
+// login_hint, так что весь сценарий входа проходит без внешней сети.
+package fakeidp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"dd/auth/internal/oidc"
+	"dd/pkg/authn"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// DefaultEmail - пользователь, если login_hint не передан
+	DefaultEmail = "user@mock.idp"
+
+	codeTTL    = time.Minute
+	idTokenTTL = time.Hour
+	keyID      = "fakeidp-1"
+)
+
+type client struct {
+	secret      string
+	redirectURI string
+}
+
+type authCode struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	expiresAt     time.Time
+}
+
+// Server - OIDC провайдер с authorization code flow и обязательным PKCE
+type Server struct {
+	issuer string
+	key    *rsa.PrivateKey
+
+	mu      sync.Mutex
+	clients map[string]client
+	codes   map[string]authCode
+}
+
+// New создает провайдер с издателем issuer - внешним адресом, по которому
+// доступен Handler
+func New(issuer string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		issuer:  issuer,
+		key:     key,
+		clients: make(map[string]client),
+		codes:   make(map[string]authCode),
+	}, nil
+}
+
+// RegisterClient регистрирует клиента с единственным разрешенным redirectURI
+func (s *Server) RegisterClient(clientID, clientSecret, redirectURI string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[clientID] = client{secret: clientSecret, redirectURI: redirectURI}
+}
+
+// ProviderConfig возвращает настройки клиента для этого провайдера
+func (s *Server) ProviderConfig(clientID, clientSecret, redirectURI string) oidc.ProviderConfig {
+	return oidc.ProviderConfig{
+		Issuer:       s.issuer,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AuthURL:      s.issuer + "/authorize",
+		TokenURL:     s.issuer + "/token",
+		JWKSURL:      s.issuer + "/jwks",
+		RedirectURL:  redirectURI,
+		Scopes:       []string{"openid", "email"},
+	}
+}
+
+// Handler возвращает HTTP обработчик провайдера
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	return mux
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.issuer,
+		"authorization_endpoint":                s.issuer + "/authorize",
+		"token_endpoint":                        s.issuer + "/token",
+		"jwks_uri":                              s.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize сразу "входит" пользователем из login_hint и возвращает его на redirect_uri
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	c, ok := s.clients[q.Get("client_id")]
+	s.mu.Unlock()
+	// Без известного клиента и его redirect_uri ошибку некуда вернуть
+	if !ok || q.Get("redirect_uri") != c.redirectURI {
+		http.Error(w, "unknown client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	redirect := func(params url.Values) {
+		params.Set("state", q.Get("state"))
+		http.Redirect(w, r, c.redirectURI+"?"+params.Encode(), http.StatusFound)
+	}
+
+	if q.Get("response_type") != "code" {
+		redirect(url.Values{"error": {"unsupported_response_type"}})
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		redirect(url.Values{"error": {"invalid_request"}, "error_description": {"PKCE with S256 is required"}})
+		return
+	}
+
+	email := q.Get("login_hint")
+	if email == "" {
+		email = DefaultEmail
+	}
+
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = authCode{
+		clientID:      q.Get("client_id"),
+		redirectURI:   c.redirectURI,
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		email:         email,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	s.mu.Unlock()
+
+	redirect(url.Values{"code": {code}})
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	s.mu.Lock()
+	c, known := s.clients[clientID]
+	code, found := s.codes[r.PostForm.Get("code")]
+	// Код одноразовый независимо от исхода обмена
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	if !known || subtle.ConstantTimeCompare([]byte(c.secret), []byte(clientSecret)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Basic")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type")
+		return
+	}
+	if !found || time.Now().After(code.expiresAt) || code.clientID != clientID ||
+		code.redirectURI != r.PostForm.Get("redirect_uri") ||
+		oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != code.codeChallenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            s.issuer,
+		"sub":            subject(code.email),
+		"aud":            clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(idTokenTTL).Unix(),
+		"nonce":          code.nonce,
+		"email":          code.email,
+		"email_verified": true,
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(s.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int64(idTokenTTL.Seconds()),
+		"id_token":     signed,
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []authn.JSONWebKey{authn.NewJSONWebKey(keyID, "RS256", &s.key.PublicKey)},
+	})
+}
+
+// subject - постоянный идентификатор пользователя у провайдера
+func subject(email string) string {
+	sum := sha256.Sum256([]byte(email))
+	return hex.EncodeToString(sum[:16])
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	raw := make([]byte, 24)
+	rand.Read(raw)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"dd/pkg/authn"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	// clockSkew - допустимое расхождение часов с провайдером
+	clockSkew = time.Minute
+	// minKeyRefresh ограничивает перечитывание JWKS из-за незнакомого kid
+	minKeyRefresh = 10 * time.Second
+)
+
+// audience - claim aud, который по спецификации бывает строкой или массивом
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(v string) bool {
+	for _, aud := range a {
+		if aud == v {
+			return true
+		}
+	}
+	return false
+}
+
+// idTokenClaims - claims ID токена, которые мы проверяем и используем
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+}
+
+// Valid проверяет сроки действия токена
+func (c *idTokenClaims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return fmt.Errorf("token is expired")
+	}
+	if c.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)) {
+		return fmt.Errorf("token is issued in the future")
+	}
+	return nil
+}
+
+// verifyIDToken проверяет подпись, издателя, аудиторию, сроки и nonce ID токена
+func (p *Provider) verifyIDToken(ctx context.Context, raw, nonce string) (*Identity, error) {
+	claims := &idTokenClaims{}
+	token, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.keys.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		// Алгоритм задается ключом, а не заголовком токена
+		if token.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.pub, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	if claims.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	if !claims.Audience.contains(p.cfg.ClientID) {
+		return nil, fmt.Errorf("%w: unexpected audience %v", ErrInvalidIDToken, claims.Audience)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidIDToken)
+	}
+	// nonce связывает токен с нашим запросом и не дает подставить чужой токен
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
+
+type signingKey struct {
+	alg string
+	pub crypto.PublicKey
+}
+
+// keySet загружает и кэширует ключи провайдера. Провайдеры ротируют ключи,
+// поэтому незнакомый kid приводит к перечитыванию набора.
+type keySet struct {
+	url        string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]signingKey
+	fetchedAt time.Time
+}
+
+func newKeySet(url string, httpClient *http.Client) *keySet {
+	return &keySet{
+		url:        url,
+		httpClient: httpClient,
+		keys:       make(map[string]signingKey),
+	}
+}
+
+func (s *keySet) key(ctx context.Context, kid string) (signingKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(s.fetchedAt) < minKeyRefresh {
+		return signingKey{}, fmt.Errorf("unknown signing key: %q", kid)
+	}
+
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		return signingKey{}, err
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return signingKey{}, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	return key, nil
+}
+
+func (s *keySet) fetch(ctx context.Context) (map[string]signingKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %v", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []authn.JSONWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	keys := make(map[string]signingKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("Skipping provider key %s: %v", jwk.Kid, err)
+			continue
+		}
+		alg := jwk.Alg
+		// alg в JWK необязателен, RS256 обязателен для всех провайдеров OIDC
+		if alg == "" && jwk.Kty == "RSA" {
+			alg = "RS256"
+		}
+		keys[jwk.Kid] = signingKey{alg: alg, pub: pub}
+	}
+	return keys, nil
+}
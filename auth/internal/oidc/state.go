@@ -0,0 +1,76 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const statePrefix = "oauth:state:"
+
+// ErrInvalidState возвращается для неизвестного, истекшего или использованного state
+var ErrInvalidState = errors.New("invalid or expired oauth state")
+
+// AuthRequest - начатый вход через провайдера, ожидающий возврата пользователя
+type AuthRequest struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+// StateStore хранит начатые входы в Redis по state до возврата пользователя
+// от провайдера. Каждый state можно использовать один раз.
+type StateStore struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+}
+
+// NewStateStore создает хранилище state
+func NewStateStore(redisClient *redis.Client, ttl time.Duration) *StateStore {
+	return &StateStore{
+		redisClient: redisClient,
+		ttl:         ttl,
+	}
+}
+
+// Save сохраняет вход под ключом state
+func (s *StateStore) Save(ctx context.Context, state string, r AuthRequest) error {
+	key := statePrefix + state
+
+	pipe := s.redisClient.TxPipeline()
+	pipe.HSet(ctx, key, "provider", r.Provider, "nonce", r.Nonce, "code_verifier", r.CodeVerifier)
+	pipe.Expire(ctx, key, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store oauth state: %v", err)
+	}
+	return nil
+}
+
+// Take возвращает и удаляет вход, сохраненный под state
+func (s *StateStore) Take(ctx context.Context, state string) (*AuthRequest, error) {
+	if state == "" {
+		return nil, ErrInvalidState
+	}
+	key := statePrefix + state
+
+	pipe := s.redisClient.TxPipeline()
+	fields := pipe.HGetAll(ctx, key)
+	pipe.Del(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load oauth state: %v", err)
+	}
+
+	values := fields.Val()
+	if values["provider"] == "" {
+		return nil, ErrInvalidState
+	}
+
+	return &AuthRequest{
+		Provider:     values["provider"],
+		Nonce:        values["nonce"],
+		CodeVerifier: values["code_verifier"],
+	}, nil
+}
@@ -0,0 +1,439 @@
+package refresh
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	tokenPrefix  = "refresh:token:"
+	familyPrefix = "refresh:family:"
+	// userPrefix - множество семейств пользователя для списка сессий
+	userPrefix = "refresh:user:"
+	// touchInterval - как часто обновляется время последней активности сессии
+	touchInterval = time.Minute
+)
+
+var (
+	// ErrInvalidToken возвращается для неизвестного, истекшего или отозванного токена
+	ErrInvalidToken = errors.New("invalid refresh token")
+	// ErrTokenReused возвращается при повторном предъявлении уже использованного токена
+	ErrTokenReused = errors.New("refresh token reuse detected")
+	// ErrSessionNotFound возвращается, если сессия не найдена среди сессий пользователя
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// Client описывает устройство, с которого выполнен вход
+type Client struct {
+	UserAgent string
+	IP        string
+}
+
+// Session описывает владельца refresh-токена. Семейство токенов - это
+// одна сессия входа, FamilyID служит ее идентификатором.
+type Session struct {
+	UserID   string
+	Email    string
+	Role     string
+	FamilyID string
+	// IssuedAt - время входа, с которого началось семейство
+	IssuedAt time.Time
+	// Client и LastSeenAt заполняются только в List
+	Client     Client
+	LastSeenAt time.Time
+}
+
+// Store хранит хэши refresh-токенов в Redis.
+//
+// Все токены, полученные друг из друга ротацией, образуют семейство.
+// Каждый токен можно использовать один раз; повторное предъявление
+// использованного токена отзывает все семейство целиком. Семейство
+// живет не дольше ttl с момента входа, ротация его не продлевает.
+type Store struct {
+	redisClient *redis.Client
+	ttl         time.Duration
+}
+
+// NewStore создает хранилище refresh-токенов
+func NewStore(redisClient *redis.Client, ttl time.Duration) *Store {
+	return &Store{
+		redisClient: redisClient,
+		ttl:         ttl,
+	}
+}
+
+// Issue выпускает первый токен нового семейства, то есть начинает новую сессию
+func (s *Store) Issue(ctx context.Context, userID, email, role string, client Client) (string, *Session, error) {
+	familyID, err := randomString(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	session := &Session{
+		UserID:     userID,
+		Email:      email,
+		Role:       role,
+		FamilyID:   familyID,
+		IssuedAt:   time.Now(),
+		Client:     client,
+		LastSeenAt: time.Now(),
+	}
+
+	familyKey := familyPrefix + familyID
+	userKey := userPrefix + userID
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, familyKey,
+			"user_id", userID,
+			"user_agent", client.UserAgent,
+			"ip", client.IP,
+			"created_at", session.IssuedAt.Unix(),
+			"last_seen_at", session.LastSeenAt.Unix(),
+		)
+		pipe.Expire(ctx, familyKey, s.ttl)
+		// Семейства живут не дольше ttl, поэтому множеству достаточно
+		// прожить ttl с последнего входа
+		pipe.SAdd(ctx, userKey, familyID)
+		pipe.Expire(ctx, userKey, s.ttl)
+		return nil
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to store refresh family: %v", err)
+	}
+
+	token, err := s.issue(ctx, *session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, session, nil
+}
+
+// Rotate погашает предъявленный токен и выпускает следующий в том же семействе.
+// При ErrTokenReused возвращается сессия, семейство которой было отозвано.
+func (s *Store) Rotate(ctx context.Context, token string) (string, *Session, error) {
+	key := tokenPrefix + hashToken(token)
+
+	values, err := s.redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load refresh token: %v", err)
+	}
+	if len(values) == 0 {
+		return "", nil, ErrInvalidToken
+	}
+
+	issuedAt, _ := strconv.ParseInt(values["issued_at"], 10, 64)
+	session := &Session{
+		UserID:   values["user_id"],
+		Email:    values["email"],
+		Role:     values["role"],
+		FamilyID: values["family_id"],
+		IssuedAt: time.Unix(issuedAt, 0),
+	}
+
+	active, err := s.Touch(ctx, session.FamilyID)
+	if err != nil {
+		return "", nil, err
+	}
+	if !active {
+		return "", nil, ErrInvalidToken
+	}
+
+	// HSETNX атомарно помечает токен использованным, поэтому из двух
+	// одновременных запросов с одним токеном успешным будет только один
+	first, err := s.redisClient.HSetNX(ctx, key, "used", "1").Result()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to mark refresh token as used: %v", err)
+	}
+	if !first {
+		if err := s.RevokeFamily(ctx, session.FamilyID); err != nil {
+			return "", nil, err
+		}
+		return "", session, ErrTokenReused
+	}
+
+	next, err := s.issue(ctx, *session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return next, session, nil
+}
+
+// Revoke отзывает семейство, которому принадлежит токен
+func (s *Store) Revoke(ctx context.Context, token string) error {
+	familyID, err := s.redisClient.HGet(ctx, tokenPrefix+hashToken(token), "family_id").Result()
+	if err == redis.Nil {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load refresh token: %v", err)
+	}
+
+	return s.RevokeFamily(ctx, familyID)
+}
+
+// RevokeFamily делает недействительными все токены семейства.
+// Запись в множестве сессий пользователя удаляется при следующем List.
+func (s *Store) RevokeFamily(ctx context.Context, familyID string) error {
+	if err := s.redisClient.Del(ctx, familyPrefix+familyID).Err(); err != nil {
+		return fmt.Errorf("failed to revoke refresh family: %v", err)
+	}
+	return nil
+}
+
+// RevokeSession завершает сессию familyID пользователя userID
+func (s *Store) RevokeSession(ctx context.Context, userID, familyID string) error {
+	owner, err := s.redisClient.HGet(ctx, familyPrefix+familyID, "user_id").Result()
+	if err == redis.Nil {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load refresh family: %v", err)
+	}
+	if owner != userID {
+		return ErrSessionNotFound
+	}
+
+	if err := s.RevokeFamily(ctx, familyID); err != nil {
+		return err
+	}
+	if err := s.redisClient.SRem(ctx, userPrefix+userID, familyID).Err(); err != nil {
+		return fmt.Errorf("failed to update user sessions: %v", err)
+	}
+	return nil
+}
+
+// RevokeOtherSessions завершает все сессии пользователя, кроме keepFamilyID
+func (s *Store) RevokeOtherSessions(ctx context.Context, userID, keepFamilyID string) error {
+	userKey := userPrefix + userID
+	familyIDs, err := s.redisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list user sessions: %v", err)
+	}
+
+	var families []string
+	var members []interface{}
+	for _, familyID := range familyIDs {
+		if familyID == keepFamilyID {
+			continue
+		}
+		families = append(families, familyPrefix+familyID)
+		members = append(members, familyID)
+	}
+	if len(families) == 0 {
+		return nil
+	}
+
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, families...)
+		pipe.SRem(ctx, userKey, members...)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh families: %v", err)
+	}
+	return nil
+}
+
+// DeleteUser удаляет все сессии пользователя вместе с их токенами и возвращает
+// число сессий. Токены не связаны с пользователем никаким индексом, поэтому
+// ищутся перебором; вызывается только при стирании учетной записи.
+func (s *Store) DeleteUser(ctx context.Context, userID string) (int, error) {
+	userKey := userPrefix + userID
+	familyIDs, err := s.redisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list user sessions: %v", err)
+	}
+
+	keys := []string{userKey}
+	for _, familyID := range familyIDs {
+		keys = append(keys, familyPrefix+familyID)
+	}
+	if err := s.redisClient.Del(ctx, keys...).Err(); err != nil {
+		return 0, fmt.Errorf("failed to delete refresh families: %v", err)
+	}
+
+	iter := s.redisClient.Scan(ctx, 0, tokenPrefix+"*", 1000).Iterator()
+	var batch []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		cmds := make([]*redis.StringCmd, len(batch))
+		_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, key := range batch {
+				cmds[i] = pipe.HGet(ctx, key, "user_id")
+			}
+			return nil
+		})
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to load refresh tokens: %v", err)
+		}
+		var owned []string
+		for i, cmd := range cmds {
+			if cmd.Val() == userID {
+				owned = append(owned, batch[i])
+			}
+		}
+		batch = batch[:0]
+		if len(owned) == 0 {
+			return nil
+		}
+		if err := s.redisClient.Del(ctx, owned...).Err(); err != nil {
+			return fmt.Errorf("failed to delete refresh tokens: %v", err)
+		}
+		return nil
+	}
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == 1000 {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("failed to scan refresh tokens: %v", err)
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	return len(familyIDs), nil
+}
+
+// touchScript проверяет, что семейство не отозвано, и обновляет время
+// последней активности не чаще ARGV[2] секунд. Семейства, выпущенные до
+// учета сессий, хранятся строкой без данных о клиенте и просто считаются активными.
+var touchScript = redis.NewScript(`
+local kind = redis.call('TYPE', KEYS[1])['ok']
+if kind == 'none' then
+	return 0
+end
+if kind == 'hash' then
+	local seen = tonumber(redis.call('HGET', KEYS[1], 'last_seen_at') or '0') or 0
+	if seen + tonumber(ARGV[2]) <= tonumber(ARGV[1]) then
+		redis.call('HSET', KEYS[1], 'last_seen_at', ARGV[1])
+	end
+end
+return 1
+`)
+
+// Touch отмечает активность сессии и сообщает, не отозвана ли она
+func (s *Store) Touch(ctx context.Context, familyID string) (bool, error) {
+	active, err := touchScript.Run(ctx, s.redisClient,
+		[]string{familyPrefix + familyID},
+		time.Now().Unix(), int64(touchInterval.Seconds()),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to load refresh family: %v", err)
+	}
+	return active == 1, nil
+}
+
+// List возвращает активные сессии пользователя, последние активные первыми
+func (s *Store) List(ctx context.Context, userID string) ([]*Session, error) {
+	userKey := userPrefix + userID
+	familyIDs, err := s.redisClient.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list user sessions: %v", err)
+	}
+	if len(familyIDs) == 0 {
+		return nil, nil
+	}
+
+	cmds := make([]*redis.MapStringStringCmd, len(familyIDs))
+	_, err = s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, familyID := range familyIDs {
+			cmds[i] = pipe.HGetAll(ctx, familyPrefix+familyID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user sessions: %v", err)
+	}
+
+	var sessions []*Session
+	var stale []interface{}
+	for i, cmd := range cmds {
+		values := cmd.Val()
+		if len(values) == 0 {
+			stale = append(stale, familyIDs[i])
+			continue
+		}
+		createdAt, _ := strconv.ParseInt(values["created_at"], 10, 64)
+		lastSeenAt, _ := strconv.ParseInt(values["last_seen_at"], 10, 64)
+		sessions = append(sessions, &Session{
+			UserID:   values["user_id"],
+			FamilyID: familyIDs[i],
+			IssuedAt: time.Unix(createdAt, 0),
+			Client: Client{
+				UserAgent: values["user_agent"],
+				IP:        values["ip"],
+			},
+			LastSeenAt: time.Unix(lastSeenAt, 0),
+		})
+	}
+
+	// Истекшие и отозванные семейства убираются из множества лениво
+	if len(stale) > 0 {
+		if err := s.redisClient.SRem(ctx, userKey, stale...).Err(); err != nil {
+			return nil, fmt.Errorf("failed to update user sessions: %v", err)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *Store) issue(ctx context.Context, session Session) (string, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	key := tokenPrefix + hashToken(token)
+	_, err = s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key,
+			"user_id", session.UserID,
+			"email", session.Email,
+			"role", session.Role,
+			"family_id", session.FamilyID,
+			"issued_at", session.IssuedAt.Unix(),
+		)
+		pipe.Expire(ctx, key, s.ttl)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	return token, nil
+}
+
+// hashToken возвращает ключ хранения токена: сам токен на сервере не сохраняется
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
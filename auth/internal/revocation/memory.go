@@ -0,0 +1,75 @@
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	value     time.Time
+	expiresAt time.Time
+}
+
+// MemoryStore реализует Store в памяти процесса.
+// Подходит для тестов и запуска в одном экземпляре.
+type MemoryStore struct {
+	mu     sync.Mutex
+	tokens map[string]entry
+	users  map[string]entry
+}
+
+// NewMemoryStore создает список отзыва в памяти
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tokens: make(map[string]entry),
+		users:  make(map[string]entry),
+	}
+}
+
+func (s *MemoryStore) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[jti] = entry{expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.lookup(s.tokens, jti)
+	return ok, nil
+}
+
+func (s *MemoryStore) RevokeUser(ctx context.Context, userID string, notBefore time.Time, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[userID] = entry{value: notBefore, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) NotBefore(ctx context.Context, userID string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, _ := s.lookup(s.users, userID)
+	return e.value, nil
+}
+
+// lookup возвращает запись, удаляя ее, если срок хранения истек
+func (s *MemoryStore) lookup(m map[string]entry, key string) (entry, bool) {
+	e, ok := m[key]
+	if !ok {
+		return entry{}, false
+	}
+	if !time.Now().Before(e.expiresAt) {
+		delete(m, key)
+		return entry{}, false
+	}
+	return e, true
+}
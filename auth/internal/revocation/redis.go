@@ -0,0 +1,63 @@
+package revocation
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	tokenPrefix = "revoked:jti:"
+	userPrefix  = "revoked:user:"
+)
+
+// RedisStore реализует Store поверх Redis, используя TTL ключей
+type RedisStore struct {
+	redisClient *redis.Client
+}
+
+// NewRedisStore создает список отзыва в Redis
+func NewRedisStore(redisClient *redis.Client) *RedisStore {
+	return &RedisStore{redisClient: redisClient}
+}
+
+func (s *RedisStore) RevokeToken(ctx context.Context, jti string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.redisClient.Set(ctx, tokenPrefix+jti, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	n, err := s.redisClient.Exists(ctx, tokenPrefix+jti).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check token revocation: %v", err)
+	}
+	return n > 0, nil
+}
+
+func (s *RedisStore) RevokeUser(ctx context.Context, userID string, notBefore time.Time, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	if err := s.redisClient.Set(ctx, userPrefix+userID, notBefore.Unix(), ttl).Err(); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %v", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) NotBefore(ctx context.Context, userID string) (time.Time, error) {
+	ts, err := s.redisClient.Get(ctx, userPrefix+userID).Int64()
+	if err == redis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to check user revocation: %v", err)
+	}
+	return time.Unix(ts, 0), nil
+}
@@ -0,0 +1,21 @@
+package revocation
+
+import (
+	"context"
+	"time"
+)
+
+// Store хранит отозванные токены и отметки "не раньше" для пользователей.
+//
+// Записи живут не дольше оставшегося срока действия токенов, к которым они
+// относятся: после истечения токен отклоняется и без списка отзыва.
+type Store interface {
+	// RevokeToken отзывает токен с идентификатором jti на время ttl
+	RevokeToken(ctx context.Context, jti string, ttl time.Duration) error
+	// IsRevoked сообщает, отозван ли токен с идентификатором jti
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	// RevokeUser отзывает все токены пользователя, выпущенные не позже notBefore
+	RevokeUser(ctx context.Context, userID string, notBefore time.Time, ttl time.Duration) error
+	// NotBefore возвращает отметку RevokeUser для пользователя или нулевое время
+	NotBefore(ctx context.Context, userID string) (time.Time, error)
+}
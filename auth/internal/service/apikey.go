@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"dd/auth/internal/apikey"
+	"dd/pkg/audit"
+	pb "dd/pkg/auth"
+	"dd/pkg/authz"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxAPIKeyNameLength = 100
+
+// CreateAPIKey выпускает API ключ от имени вызывающего. Ключ наследует
+// только перечисленные разрешения, но не роли владельца.
+func (s *AuthService) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" || len(req.Name) > maxAPIKeyNameLength {
+		return nil, status.Errorf(codes.InvalidArgument, "name is required and must be at most %d characters", maxAPIKeyNameLength)
+	}
+	if len(req.Scopes) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if !contains(authz.Scopes, scope) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown scope %q", scope)
+		}
+	}
+	if req.TtlSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl must not be negative")
+	}
+	if req.DailyQuota < 0 {
+		return nil, status.Error(codes.InvalidArgument, "daily quota must not be negative")
+	}
+
+	var expiresAt *time.Time
+	if req.TtlSeconds > 0 {
+		t := time.Now().Add(time.Duration(req.TtlSeconds) * time.Second)
+		expiresAt = &t
+	}
+
+	key, secret, err := s.apiKeys.Create(ctx, claims.Subject, claims.Email, req.Name, req.Scopes, int(req.DailyQuota), expiresAt)
+	if err != nil {
+		log.Printf("Failed to create API key: %v", err)
+		return nil, status.Error(codes.Internal, "failed to create API key")
+	}
+
+	log.Printf("User %s created API key %s", claims.Subject, key.ID)
+	s.record(ctx, callerEvent(claims, audit.ActionAPIKeyCreate, key.ID))
+
+	return &pb.CreateAPIKeyResponse{
+		ApiKey: apiKeyToProto(key),
+		Key:    secret,
+	}, nil
+}
+
+// ListAPIKeys возвращает ключи вызывающего
+func (s *AuthService) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.apiKeys.List(ctx, claims.Subject)
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		return nil, status.Error(codes.Internal, "failed to list API keys")
+	}
+
+	resp := &pb.ListAPIKeysResponse{}
+	for _, key := range keys {
+		resp.ApiKeys = append(resp.ApiKeys, apiKeyToProto(key))
+	}
+	return resp, nil
+}
+
+// RevokeAPIKey отзывает ключ вызывающего. Администратор может отозвать любой ключ.
+func (s *AuthService) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	owner := claims.Subject
+	if hasRole(claims, authz.RoleAdmin) {
+		owner = ""
+	}
+
+	if err := s.apiKeys.Revoke(ctx, req.Id, owner); err != nil {
+		if errors.Is(err, apikey.ErrNotFound) {
+			return nil, status.Error(codes.NotFound, "API key not found")
+		}
+		log.Printf("Failed to revoke API key: %v", err)
+		return nil, status.Error(codes.Internal, "failed to revoke API key")
+	}
+
+	log.Printf("User %s revoked API key %s", claims.Subject, req.Id)
+	s.record(ctx, callerEvent(claims, audit.ActionAPIKeyRevoke, req.Id))
+
+	return &pb.RevokeAPIKeyResponse{}, nil
+}
+
+// ValidateAPIKey проверяет ключ для сервисов, принимающих X-API-Key.
+// Доступен только сервисам, чтобы его нельзя было использовать для перебора ключей.
+func (s *AuthService) ValidateAPIKey(ctx context.Context, req *pb.ValidateAPIKeyRequest) (*pb.ValidateAPIKeyResponse, error) {
+	if _, err := s.authenticateService(ctx); err != nil {
+		return nil, err
+	}
+
+	key, err := s.apiKeys.Resolve(ctx, req.Key)
+	if err != nil {
+		if errors.Is(err, apikey.ErrInvalidKey) {
+			return &pb.ValidateAPIKeyResponse{
+				Valid: false,
+			}, nil
+		}
+		log.Printf("Failed to validate API key: %v", err)
+		return nil, status.Error(codes.Internal, "failed to validate API key")
+	}
+
+	return &pb.ValidateAPIKeyResponse{
+		Valid:      true,
+		KeyId:      key.ID,
+		UserId:     key.UserID,
+		Email:      key.Email,
+		Scopes:     key.Scopes,
+		DailyQuota: int32(key.DailyQuota),
+	}, nil
+}
+
+func apiKeyToProto(key *apikey.Key) *pb.APIKey {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	return &pb.APIKey{
+		Id:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     key.Scopes,
+		DailyQuota: int32(key.DailyQuota),
+		CreatedAt:  key.CreatedAt.Format(time.RFC3339),
+		LastUsedAt: formatTime(key.LastUsedAt),
+		ExpiresAt:  formatTime(key.ExpiresAt),
+		RevokedAt:  formatTime(key.RevokedAt),
+	}
+}
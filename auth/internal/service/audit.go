@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"dd/pkg/audit"
+	"dd/pkg/authn"
+)
+
+// record пишет событие в журнал аудита. Сбой журнала не прерывает действие:
+// вход не должен зависеть от доступности базы журнала. Журнал доверяет
+// адресу клиента из метаданных, только если запрос передал proxy.
+func (s *AuthService) record(ctx context.Context, event audit.Event) {
+	if err := s.audit.Record(s.serviceContext(ctx), event); err != nil {
+		log.Printf("Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// callerEvent - событие от имени вызывающего с токеном
+func callerEvent(claims *authn.Claims, action, target string) audit.Event {
+	actorType := audit.ActorUser
+	if claims.ClientID != "" {
+		actorType = audit.ActorService
+	}
+	return audit.Event{Actor: claims.Subject, ActorType: actorType, Action: action, Target: target}
+}
@@ -2,27 +2,81 @@ package service
 
 import (
 	"context"
+	"database/sql"
+	"dd/auth/internal/apikey"
+	"dd/auth/internal/challenge"
+	"dd/auth/internal/config"
+	"dd/auth/internal/keys"
+	"dd/auth/internal/oidc"
+	"dd/auth/internal/refresh"
+	"dd/auth/internal/revocation"
+	"dd/auth/internal/throttle"
+	"dd/pkg/audit"
 	pb "dd/pkg/auth"
+	"dd/pkg/authz"
 	userpb "dd/pkg/user"
+	"errors"
 	"log"
 	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"fmt"
 )
 
 type AuthService struct {
 	pb.UnimplementedAuthServiceServer
-	userClient userpb.UserServiceClient
-	jwtSecret  string
+	userClient       userpb.UserServiceClient
+	refreshTokens    *refresh.Store
+	revoked          revocation.Store
+	loginLimiter     *throttle.Limiter
+	mfaChallenges    *challenge.Store
+	oauthProviders   map[string]*oidc.Provider
+	oauthStates      *oidc.StateStore
+	apiKeys          *apikey.Store
+	serviceClients   map[string]config.ServiceClient
+	proxyClientID    string
+	keys             *keys.Manager
+	issuer           string
+	audience         string
+	tokenTTL         time.Duration
+	refreshTokenTTL  time.Duration
+	serviceTokenTTL  time.Duration
+	adminRequiresMFA bool
+	audit            audit.Recorder
 }
 
-func New(userConn *grpc.ClientConn, jwtSecret string) *AuthService {
+func New(userConn *grpc.ClientConn, redisClient *redis.Client, db *sql.DB, keyManager *keys.Manager, auditLog audit.Recorder, cfg *config.Config) *AuthService {
+	providers := make(map[string]*oidc.Provider)
+	for name, providerCfg := range cfg.OAuthProviders {
+		if providerCfg.ClientID == "" {
+			continue
+		}
+		providers[name] = oidc.NewProvider(name, providerCfg, nil)
+	}
+
 	return &AuthService{
-		userClient: userpb.NewUserServiceClient(userConn),
-		jwtSecret:  jwtSecret,
+		userClient:       userpb.NewUserServiceClient(userConn),
+		refreshTokens:    refresh.NewStore(redisClient, cfg.RefreshTokenTTL),
+		revoked:          revocation.NewRedisStore(redisClient),
+		loginLimiter:     throttle.NewLimiter(redisClient, cfg.LoginThrottle),
+		mfaChallenges:    challenge.NewStore(redisClient, cfg.MFAChallengeTTL, cfg.MFAMaxAttempts),
+		oauthProviders:   providers,
+		oauthStates:      oidc.NewStateStore(redisClient, cfg.OAuthStateTTL),
+		apiKeys:          apikey.NewStore(db),
+		serviceClients:   cfg.ServiceClients,
+		proxyClientID:    cfg.ProxyClientID,
+		keys:             keyManager,
+		issuer:           cfg.JWTIssuer,
+		audience:         cfg.JWTAudience,
+		tokenTTL:         cfg.TokenTTL,
+		refreshTokenTTL:  cfg.RefreshTokenTTL,
+		serviceTokenTTL:  cfg.ServiceTokenTTL,
+		adminRequiresMFA: cfg.AdminRequiresMFA,
+		audit:            auditLog,
 	}
 }
 
@@ -39,19 +93,27 @@ func (s *AuthService) Register(ctx context.Context, req *pb.RegisterRequest) (*p
 	})
 	if err != nil {
 		log.Printf("Failed to check user existence: %v", err)
+		// Отказ в данных регистрации, например слабый пароль, передается клиенту вместе с деталями
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.AlreadyExists:
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to create user: %v", err)
 	}
 
-	token, err := s.generateToken(userResp.User.Id)
+	token, refreshToken, err := s.issueTokens(ctx, userResp.User)
 	if err != nil {
 		log.Printf("Failed to generate token: %v", err)
 		return nil, err
 	}
 
 	log.Printf("Generated token for user %s", req.Email)
+	s.record(ctx, audit.Event{Actor: userResp.User.Id, Action: audit.ActionRegister, Target: userResp.User.Id})
 
 	return &pb.RegisterResponse{
-		Token: token,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.tokenTTL.Seconds()),
 	}, nil
 }
 
@@ -60,46 +122,175 @@ func (s *AuthService) Login(ctx context.Context, req *pb.LoginRequest) (*pb.Logi
 		return nil, fmt.Errorf("email and password are required")
 	}
 
-	// Проверяем пользователя через user service
-	userResp, err := s.userClient.GetProfile(ctx, &userpb.GetProfileRequest{
+	ip := s.clientIP(ctx)
+	if err := s.loginLimiter.Check(ctx, req.Email, ip); err != nil {
+		var limitErr *throttle.LimitError
+		if errors.As(err, &limitErr) {
+			log.Printf("Login attempt for %s from %s rejected: %v", req.Email, ip, err)
+			s.record(ctx, audit.Event{Action: audit.ActionLogin, Target: req.Email, Result: audit.ResultDenied, Reason: err.Error()})
+			return nil, limitStatus(limitErr)
+		}
+		log.Printf("Failed to check login throttling: %v", err)
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+
+	// Проверяем пароль через user service
+	userResp, err := s.userClient.VerifyCredentials(ctx, &userpb.VerifyCredentialsRequest{
 		Email:    req.Email,
 		Password: req.Password,
 	})
 	if err != nil {
-		log.Printf("Failed to get user profile: %v", err)
-		return nil, fmt.Errorf("authentication failed")
+		if status.Code(err) == codes.Unauthenticated {
+			if err := s.loginLimiter.Failure(ctx, req.Email, ip); err != nil {
+				log.Printf("Failed to record login failure: %v", err)
+			}
+			s.record(ctx, audit.Event{Action: audit.ActionLogin, Target: req.Email, Result: audit.ResultFailure, Reason: "invalid credentials"})
+			return nil, status.Error(codes.Unauthenticated, "authentication failed")
+		}
+		log.Printf("Failed to verify credentials: %v", err)
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+
+	if err := s.loginLimiter.Success(ctx, req.Email); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
 	}
 
 	if userResp.User == nil {
 		return nil, fmt.Errorf("user not found")
 	}
 
-	token, err := s.generateToken(userResp.User.Id)
+	resp, err := s.completeLogin(ctx, userResp.User)
+	if err != nil {
+		return nil, err
+	}
+	s.record(ctx, loginEvent(audit.ActionLogin, userResp.User, resp))
+	return resp, nil
+}
+
+// loginEvent - событие успешной проверки первого фактора
+func loginEvent(action string, user *userpb.User, resp *pb.LoginResponse) audit.Event {
+	event := audit.Event{Actor: user.Id, Action: action, Target: user.Id}
+	if resp.MfaRequired {
+		event.Reason = "second factor required"
+	}
+	return event
+}
+
+// completeLogin выдает токены пользователю, прошедшему первый фактор,
+// или MFA челлендж, если у него подключен второй
+func (s *AuthService) completeLogin(ctx context.Context, user *userpb.User) (*pb.LoginResponse, error) {
+	// С подключенным TOTP токены выдаются только после VerifyMFA
+	if user.MfaEnabled {
+		mfaToken, err := s.mfaChallenges.Create(ctx, challenge.Challenge{
+			UserID: user.Id,
+			Email:  user.Email,
+			Role:   user.Role,
+		})
+		if err != nil {
+			log.Printf("Failed to create MFA challenge: %v", err)
+			return nil, status.Error(codes.Internal, "authentication failed")
+		}
+
+		log.Printf("User %s passed first factor, waiting for second factor", user.Email)
+
+		return &pb.LoginResponse{
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		}, nil
+	}
+
+	token, refreshToken, err := s.issueTokens(ctx, user)
 	if err != nil {
 		log.Printf("Failed to generate token: %v", err)
 		return nil, err
 	}
 
-	log.Printf("User %s logged in successfully", req.Email)
+	log.Printf("User %s logged in successfully", user.Email)
 
 	return &pb.LoginResponse{
-		Token: token,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.tokenTTL.Seconds()),
 	}, nil
 }
 
-func (s *AuthService) ValidateToken(ctx context.Context, req *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
-	token, err := jwt.Parse(req.Token, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.jwtSecret), nil
+// VerifyMFA завершает вход, начатый Login, проверкой кода второго фактора
+func (s *AuthService) VerifyMFA(ctx context.Context, req *pb.VerifyMFARequest) (*pb.VerifyMFAResponse, error) {
+	if req.MfaToken == "" || req.Code == "" {
+		return nil, status.Error(codes.InvalidArgument, "mfa token and code are required")
+	}
+
+	c, err := s.mfaChallenges.Get(ctx, req.MfaToken)
+	if err != nil {
+		if errors.Is(err, challenge.ErrInvalidChallenge) {
+			s.record(ctx, audit.Event{Action: audit.ActionMFAVerify, Result: audit.ResultFailure, Reason: "invalid or expired mfa token"})
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired mfa token")
+		}
+		log.Printf("Failed to load MFA challenge: %v", err)
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+
+	verifyResp, err := s.userClient.VerifyTOTP(ctx, &userpb.VerifyTOTPRequest{
+		UserId: c.UserID,
+		Code:   req.Code,
+	})
+	if err != nil {
+		if status.Code(err) == codes.Unauthenticated {
+			if err := s.mfaChallenges.Fail(ctx, req.MfaToken); err != nil {
+				log.Printf("Failed to record MFA failure: %v", err)
+			}
+			s.record(ctx, audit.Event{Actor: c.UserID, Action: audit.ActionMFAVerify, Target: c.UserID, Result: audit.ResultFailure, Reason: "invalid code"})
+			return nil, status.Error(codes.Unauthenticated, "invalid code")
+		}
+		log.Printf("Failed to verify second factor: %v", err)
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+
+	// Токен челленджа одноразовый
+	if err := s.mfaChallenges.Delete(ctx, req.MfaToken); err != nil {
+		log.Printf("Failed to delete MFA challenge: %v", err)
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+
+	if verifyResp.RecoveryCodeUsed {
+		log.Printf("User %s logged in with a recovery code, %d left", c.Email, verifyResp.RecoveryCodesLeft)
+	}
+
+	token, refreshToken, err := s.issueTokens(ctx, &userpb.User{
+		Id:         c.UserID,
+		Email:      c.Email,
+		Role:       c.Role,
+		MfaEnabled: true,
 	})
+	if err != nil {
+		log.Printf("Failed to generate token: %v", err)
+		return nil, err
+	}
 
-	if err != nil || !token.Valid {
+	log.Printf("User %s logged in successfully", c.Email)
+	event := audit.Event{Actor: c.UserID, Action: audit.ActionMFAVerify, Target: c.UserID}
+	if verifyResp.RecoveryCodeUsed {
+		event.Reason = "recovery code used"
+	}
+	s.record(ctx, event)
+
+	return &pb.VerifyMFAResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.tokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *AuthService) ValidateToken(ctx context.Context, req *pb.ValidateTokenRequest) (*pb.ValidateTokenResponse, error) {
+	claims, err := s.parseToken(req.Token)
+	if err != nil {
 		return &pb.ValidateTokenResponse{
 			Valid: false,
 		}, nil
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
+	if err := s.checkRevoked(ctx, claims); err != nil {
+		log.Printf("Rejected token %s of user %s: %v", claims.Id, claims.Subject, err)
 		return &pb.ValidateTokenResponse{
 			Valid: false,
 		}, nil
@@ -107,16 +298,210 @@ func (s *AuthService) ValidateToken(ctx context.Context, req *pb.ValidateTokenRe
 
 	return &pb.ValidateTokenResponse{
 		Valid:  true,
-		UserId: claims["user_id"].(string),
+		UserId: claims.Subject,
+		Email:  claims.Email,
+	}, nil
+}
+
+func (s *AuthService) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+	}
+
+	refreshToken, session, err := s.refreshTokens.Rotate(ctx, req.RefreshToken)
+	if errors.Is(err, refresh.ErrTokenReused) {
+		log.Printf("Refresh token reuse detected for user %s, session family revoked", session.UserID)
+		s.record(ctx, audit.Event{Action: audit.ActionRefreshReuse, Target: session.UserID, Result: audit.ResultDenied, Reason: "session family revoked"})
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	if errors.Is(err, refresh.ErrInvalidToken) {
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	if err != nil {
+		log.Printf("Failed to rotate refresh token: %v", err)
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	notBefore, err := s.revoked.NotBefore(ctx, session.UserID)
+	if err != nil {
+		log.Printf("Failed to check user revocation: %v", err)
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+	if !session.IssuedAt.After(notBefore) {
+		if err := s.refreshTokens.RevokeFamily(ctx, session.FamilyID); err != nil {
+			log.Printf("Failed to revoke refresh family: %v", err)
+		}
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+
+	token, err := s.generateToken(&userpb.User{Id: session.UserID, Email: session.Email, Role: session.Role}, session.FamilyID)
+	if err != nil {
+		log.Printf("Failed to generate token: %v", err)
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+
+	return &pb.RefreshTokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.tokenTTL.Seconds()),
+	}, nil
+}
+
+func (s *AuthService) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token is required")
+	}
+
+	// Выход с уже недействительным токеном не считается ошибкой
+	if err := s.refreshTokens.Revoke(ctx, req.RefreshToken); err != nil && !errors.Is(err, refresh.ErrInvalidToken) {
+		log.Printf("Failed to revoke refresh token: %v", err)
+		return nil, status.Error(codes.Internal, "failed to logout")
+	}
+
+	return &pb.LogoutResponse{}, nil
+}
+
+func (s *AuthService) RevokeToken(ctx context.Context, req *pb.RevokeTokenRequest) (*pb.RevokeTokenResponse, error) {
+	claims, err := s.parseToken(req.Token)
+	if err != nil {
+		// Истекший или поддельный токен и так будет отклонен
+		return &pb.RevokeTokenResponse{}, nil
+	}
+
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0))
+	if err := s.revoked.RevokeToken(ctx, claims.Id, ttl); err != nil {
+		log.Printf("Failed to revoke token: %v", err)
+		return nil, status.Error(codes.Internal, "failed to revoke token")
+	}
+
+	log.Printf("Token %s of user %s revoked", claims.Id, claims.Subject)
+
+	return &pb.RevokeTokenResponse{}, nil
+}
+
+func (s *AuthService) RevokeAllSessions(ctx context.Context, req *pb.RevokeAllSessionsRequest) (*pb.RevokeAllSessionsResponse, error) {
+	caller, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user id is required")
+	}
+	// Администратор завершает сессии чужой учетной записи, например при ее удалении
+	if caller.Subject != req.UserId && !hasRole(caller, authz.RoleAdmin) {
+		event := callerEvent(caller, audit.ActionSessionsRevoke, req.UserId)
+		event.Result = audit.ResultDenied
+		s.record(ctx, event)
+		return nil, status.Error(codes.PermissionDenied, "cannot revoke sessions of another user")
+	}
+
+	if req.RevokeApiKeys {
+		n, err := s.apiKeys.RevokeAll(ctx, req.UserId)
+		if err != nil {
+			log.Printf("Failed to revoke API keys: %v", err)
+			return nil, status.Error(codes.Internal, "failed to revoke API keys")
+		}
+		log.Printf("%d API keys of user %s revoked", n, req.UserId)
+	}
+
+	if req.KeepCurrent && caller.Subject == req.UserId {
+		// Отметка времени отозвала бы и текущую сессию, поэтому завершаются
+		// остальные сессии по одной; их access-токены отклоняет проверка сессии
+		if err := s.refreshTokens.RevokeOtherSessions(ctx, req.UserId, caller.SessionID); err != nil {
+			log.Printf("Failed to revoke user sessions: %v", err)
+			return nil, status.Error(codes.Internal, "failed to revoke sessions")
+		}
+		log.Printf("Other sessions of user %s revoked", req.UserId)
+		event := callerEvent(caller, audit.ActionSessionsRevoke, req.UserId)
+		event.Reason = "current session kept"
+		s.record(ctx, event)
+		return &pb.RevokeAllSessionsResponse{}, nil
+	}
+
+	// Отметка должна пережить самый долгоживущий из уже выпущенных токенов
+	ttl := s.tokenTTL
+	if s.refreshTokenTTL > ttl {
+		ttl = s.refreshTokenTTL
+	}
+	if err := s.revoked.RevokeUser(ctx, req.UserId, time.Now(), ttl); err != nil {
+		log.Printf("Failed to revoke user sessions: %v", err)
+		return nil, status.Error(codes.Internal, "failed to revoke sessions")
+	}
+
+	log.Printf("All sessions of user %s revoked by %s", req.UserId, caller.Subject)
+	s.record(ctx, callerEvent(caller, audit.ActionSessionsRevoke, req.UserId))
+
+	return &pb.RevokeAllSessionsResponse{}, nil
+}
+
+func (s *AuthService) UnlockAccount(ctx context.Context, req *pb.UnlockAccountRequest) (*pb.UnlockAccountResponse, error) {
+	caller, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !hasRole(caller, authz.RoleAdmin) {
+		event := callerEvent(caller, audit.ActionAccountUnlock, req.Email)
+		event.Result = audit.ResultDenied
+		s.record(ctx, event)
+		return nil, status.Error(codes.PermissionDenied, "admin role required")
+	}
+
+	if req.Email == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+	if err := s.loginLimiter.Unlock(ctx, req.Email); err != nil {
+		log.Printf("Failed to unlock account: %v", err)
+		return nil, status.Error(codes.Internal, "failed to unlock account")
+	}
+
+	log.Printf("Account %s unlocked by %s", req.Email, caller.Subject)
+	s.record(ctx, callerEvent(caller, audit.ActionAccountUnlock, req.Email))
+
+	return &pb.UnlockAccountResponse{}, nil
+}
+
+func (s *AuthService) GetJWKS(ctx context.Context, req *pb.GetJWKSRequest) (*pb.GetJWKSResponse, error) {
+	var jwks []*pb.JSONWebKey
+	for _, key := range s.keys.PublicKeys() {
+		jwks = append(jwks, &pb.JSONWebKey{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Use: key.Use,
+			Alg: key.Alg,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+		})
+	}
+
+	return &pb.GetJWKSResponse{
+		Keys: jwks,
 	}, nil
 }
 
-func (s *AuthService) generateToken(email string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"email":   email,
-		"user_id": "user-id", // В реальном приложении здесь будет ID из базы
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+// issueTokens выпускает пару access/refresh токенов для нового входа
+func (s *AuthService) issueTokens(ctx context.Context, user *userpb.User) (string, string, error) {
+	// Без второго фактора права администратора не выдаются. Пониженная роль
+	// сохраняется и в refresh-сессии, чтобы ротация ее не вернула.
+	if s.adminRequiresMFA && user.Role == authz.RoleAdmin && !user.MfaEnabled {
+		log.Printf("Admin %s has no second factor, issuing token with role %s", user.Email, authz.RoleUser)
+		user = &userpb.User{Id: user.Id, Email: user.Email, Role: authz.RoleUser}
+	}
+
+	refreshToken, session, err := s.refreshTokens.Issue(ctx, user.Id, user.Email, user.Role, refresh.Client{
+		UserAgent: userAgent(ctx),
+		IP:        s.clientIP(ctx),
 	})
+	if err != nil {
+		return "", "", err
+	}
+
+	token, err := s.generateToken(user, session.FamilyID)
+	if err != nil {
+		return "", "", err
+	}
 
-	return token.SignedString([]byte(s.jwtSecret))
+	return token, refreshToken, nil
 }
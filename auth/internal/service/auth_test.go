@@ -2,16 +2,45 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"dd/auth/internal/apikey"
+	"dd/auth/internal/challenge"
+	"dd/auth/internal/config"
+	"dd/auth/internal/keys"
+	"dd/auth/internal/oidc"
+	"dd/auth/internal/oidc/fakeidp"
+	"dd/auth/internal/refresh"
+	"dd/auth/internal/revocation"
+	"dd/auth/internal/throttle"
+	"dd/pkg/audit"
 	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+	"dd/pkg/secretbox"
 	pb_user "dd/pkg/user"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 // Мок для клиента user сервиса
@@ -27,6 +56,14 @@ func (m *MockUserClient) CreateUser(ctx context.Context, req *pb_user.CreateUser
 	return nil, args.Error(1)
 }
 
+func (m *MockUserClient) VerifyCredentials(ctx context.Context, req *pb_user.VerifyCredentialsRequest, opts ...grpc.CallOption) (*pb_user.VerifyCredentialsResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.VerifyCredentialsResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func (m *MockUserClient) GetProfile(ctx context.Context, req *pb_user.GetProfileRequest, opts ...grpc.CallOption) (*pb_user.GetProfileResponse, error) {
 	args := m.Called(ctx, req)
 	if resp := args.Get(0); resp != nil {
@@ -43,15 +80,192 @@ func (m *MockUserClient) ListUsers(ctx context.Context, req *pb_user.ListUsersRe
 	return nil, args.Error(1)
 }
 
+func (m *MockUserClient) RequestPasswordReset(ctx context.Context, req *pb_user.RequestPasswordResetRequest, opts ...grpc.CallOption) (*pb_user.RequestPasswordResetResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.RequestPasswordResetResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ResetPassword(ctx context.Context, req *pb_user.ResetPasswordRequest, opts ...grpc.CallOption) (*pb_user.ResetPasswordResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ResetPasswordResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) SendVerification(ctx context.Context, req *pb_user.SendVerificationRequest, opts ...grpc.CallOption) (*pb_user.SendVerificationResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.SendVerificationResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) VerifyEmail(ctx context.Context, req *pb_user.VerifyEmailRequest, opts ...grpc.CallOption) (*pb_user.VerifyEmailResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.VerifyEmailResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) EnrollTOTP(ctx context.Context, req *pb_user.EnrollTOTPRequest, opts ...grpc.CallOption) (*pb_user.EnrollTOTPResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.EnrollTOTPResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ConfirmTOTP(ctx context.Context, req *pb_user.ConfirmTOTPRequest, opts ...grpc.CallOption) (*pb_user.ConfirmTOTPResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ConfirmTOTPResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) VerifyTOTP(ctx context.Context, req *pb_user.VerifyTOTPRequest, opts ...grpc.CallOption) (*pb_user.VerifyTOTPResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.VerifyTOTPResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ResolveExternalIdentity(ctx context.Context, req *pb_user.ResolveExternalIdentityRequest, opts ...grpc.CallOption) (*pb_user.ResolveExternalIdentityResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ResolveExternalIdentityResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) UpdateUser(ctx context.Context, req *pb_user.UpdateUserRequest, opts ...grpc.CallOption) (*pb_user.UpdateUserResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.UpdateUserResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ChangePassword(ctx context.Context, req *pb_user.ChangePasswordRequest, opts ...grpc.CallOption) (*pb_user.ChangePasswordResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ChangePasswordResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) DeleteUser(ctx context.Context, req *pb_user.DeleteUserRequest, opts ...grpc.CallOption) (*pb_user.DeleteUserResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.DeleteUserResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) UpdateProfile(ctx context.Context, req *pb_user.UpdateProfileRequest, opts ...grpc.CallOption) (*pb_user.UpdateProfileResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.UpdateProfileResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ExportUserData(ctx context.Context, req *pb_user.ExportUserDataRequest, opts ...grpc.CallOption) (*pb_user.ExportUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ExportUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) EraseUser(ctx context.Context, req *pb_user.EraseUserRequest, opts ...grpc.CallOption) (*pb_user.EraseUserResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.EraseUserResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) GetDataJob(ctx context.Context, req *pb_user.GetDataJobRequest, opts ...grpc.CallOption) (*pb_user.GetDataJobResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.GetDataJobResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) GetDataExport(ctx context.Context, req *pb_user.GetDataExportRequest, opts ...grpc.CallOption) (*pb_user.GetDataExportResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.GetDataExportResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) QueryAuditLog(ctx context.Context, req *pb_user.QueryAuditLogRequest, opts ...grpc.CallOption) (*pb_user.QueryAuditLogResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.QueryAuditLogResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// testKeyBox шифрует ключи подписи в тестах
+func testKeyBox(t *testing.T) *secretbox.Box {
+	box, err := secretbox.New("mWqv0eJ3Xq0a8C5l2mFQk7b1gOaQz4n6Yt9rS3uVw8E=")
+	assert.NoError(t, err)
+	return box
+}
+
 func setupTest(t *testing.T) (*AuthService, *MockUserClient) {
+	mr := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: mr.Addr(),
+	})
+	t.Cleanup(func() { redisClient.Close() })
+
+	keyManager := keys.NewManager(redisClient, testKeyBox(t), keys.AlgorithmEdDSA, 24*time.Hour, time.Hour)
+	assert.NoError(t, keyManager.Init(context.Background()))
+
 	mockUser := &MockUserClient{}
 	service := &AuthService{
-		userClient: mockUser,
-		jwtSecret:  "test-secret",
+		userClient:      mockUser,
+		refreshTokens:   refresh.NewStore(redisClient, 24*time.Hour),
+		revoked:         revocation.NewMemoryStore(),
+		loginLimiter:    throttle.NewLimiter(redisClient, throttle.Config{Window: time.Hour}),
+		mfaChallenges:   challenge.NewStore(redisClient, 5*time.Minute, 3),
+		oauthStates:     oidc.NewStateStore(redisClient, 10*time.Minute),
+		keys:            keyManager,
+		issuer:          "test-issuer",
+		audience:        "test-audience",
+		tokenTTL:        time.Hour,
+		refreshTokenTTL: 24 * time.Hour,
+		serviceTokenTTL: 10 * time.Minute,
+		serviceClients: map[string]config.ServiceClient{
+			"geo": {Secret: "geo-secret", Scopes: []string{"geo:search"}},
+		},
+		proxyClientID: "proxy",
+		audit:         audit.NewMemory("auth", audit.WithProxy("proxy")),
 	}
 	return service, mockUser
 }
 
+// fromProxy возвращает контекст запроса, который proxy подписал своим токеном
+// сервиса, с метаданными клиента pairs
+func fromProxy(t *testing.T, service *AuthService, pairs ...string) context.Context {
+	token, err := service.generateServiceToken("proxy", nil)
+	assert.NoError(t, err)
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		append([]string{"authorization", "Bearer " + token}, pairs...)...,
+	))
+}
+
 func TestAuthService_Register(t *testing.T) {
 	service, mockUser := setupTest(t)
 
@@ -74,6 +288,8 @@ func TestAuthService_Register(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotNil(t, resp)
 		assert.NotEmpty(t, resp.Token)
+		assert.NotEmpty(t, resp.RefreshToken)
+		assert.Equal(t, int64(3600), resp.ExpiresIn)
 	})
 
 	t.Run("user already exists", func(t *testing.T) {
@@ -89,19 +305,39 @@ func TestAuthService_Register(t *testing.T) {
 		assert.Nil(t, resp)
 		assert.Contains(t, err.Error(), "failed to create user")
 	})
+
+	t.Run("weak password is reported with details", func(t *testing.T) {
+		service, mockUser := setupTest(t)
+		st, err := status.New(codes.InvalidArgument, "password does not meet requirements").WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "password", Description: "must be at least 10 characters long"},
+			},
+		})
+		assert.NoError(t, err)
+		mockUser.On("CreateUser", mock.Anything, mock.Anything).Return(nil, st.Err())
+
+		_, err = service.Register(context.Background(), &pb_auth.RegisterRequest{
+			Email:    "weak@example.com",
+			Password: "short",
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Len(t, status.Convert(err).Details(), 1)
+	})
 }
 
 func TestAuthService_Login(t *testing.T) {
 	service, mockUser := setupTest(t)
 
 	t.Run("successful login", func(t *testing.T) {
-		mockUser.On("GetProfile", mock.Anything, mock.MatchedBy(func(req *pb_user.GetProfileRequest) bool {
+		mockUser.On("VerifyCredentials", mock.Anything, mock.MatchedBy(func(req *pb_user.VerifyCredentialsRequest) bool {
 			return req.Email == "test@example.com" && req.Password == "password123"
-		})).Return(&pb_user.GetProfileResponse{
+		})).Return(&pb_user.VerifyCredentialsResponse{
 			User: &pb_user.User{
 				Id:        "123",
 				Email:     "test@example.com",
 				CreatedAt: time.Now().String(),
+				Role:      "admin",
 			},
 		}, nil)
 
@@ -113,11 +349,30 @@ func TestAuthService_Login(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotNil(t, resp)
 		assert.NotEmpty(t, resp.Token)
+		assert.NotEmpty(t, resp.RefreshToken)
+
+		claims, err := service.parseToken(resp.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"admin"}, claims.Roles)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		mockUser.On("VerifyCredentials", mock.Anything, mock.MatchedBy(func(req *pb_user.VerifyCredentialsRequest) bool {
+			return req.Email == "test@example.com" && req.Password != "password123"
+		})).Return(nil, status.Error(codes.Unauthenticated, "invalid credentials"))
+
+		resp, err := service.Login(context.Background(), &pb_auth.LoginRequest{
+			Email:    "test@example.com",
+			Password: "wrong-password",
+		})
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
 	})
 
 	t.Run("user not found", func(t *testing.T) {
-		mockUser.On("GetProfile", mock.Anything, mock.Anything).
-			Return(nil, fmt.Errorf("authentication failed"))
+		mockUser.On("VerifyCredentials", mock.Anything, mock.Anything).
+			Return(nil, status.Error(codes.Unauthenticated, "invalid credentials"))
 
 		resp, err := service.Login(context.Background(), &pb_auth.LoginRequest{
 			Email:    "nonexistent@example.com",
@@ -126,48 +381,1549 @@ func TestAuthService_Login(t *testing.T) {
 
 		assert.Error(t, err)
 		assert.Nil(t, resp)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
 		assert.Contains(t, err.Error(), "authentication failed")
 	})
+
+	t.Run("user service unavailable", func(t *testing.T) {
+		service, mockUser := setupTest(t)
+		mockUser.On("VerifyCredentials", mock.Anything, mock.Anything).
+			Return(nil, status.Error(codes.Unavailable, "connection refused"))
+
+		_, err := service.Login(context.Background(), &pb_auth.LoginRequest{
+			Email:    "test@example.com",
+			Password: "password123",
+		})
+
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
 }
 
-func TestAuthService_ValidateToken(t *testing.T) {
-	service, _ := setupTest(t)
+func TestAuthService_LoginMFA(t *testing.T) {
+	mfaUser := &pb_user.User{Id: "123", Email: "test@example.com", Role: "admin", MfaEnabled: true}
 
-	t.Run("valid token", func(t *testing.T) {
-		// Создаем валидный токен
-		token, err := service.generateToken("test@example.com")
-		assert.NoError(t, err)
+	login := func(t *testing.T, service *AuthService, mockUser *MockUserClient) string {
+		mockUser.On("VerifyCredentials", mock.Anything, mock.Anything).
+			Return(&pb_user.VerifyCredentialsResponse{User: mfaUser}, nil)
 
-		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
-			Token: token,
+		resp, err := service.Login(context.Background(), &pb_auth.LoginRequest{
+			Email:    "test@example.com",
+			Password: "password123",
 		})
+		assert.NoError(t, err)
+		assert.True(t, resp.MfaRequired)
+		assert.NotEmpty(t, resp.MfaToken)
+		assert.Empty(t, resp.Token)
+		assert.Empty(t, resp.RefreshToken)
+		return resp.MfaToken
+	}
+
+	t.Run("valid code", func(t *testing.T) {
+		service, mockUser := setupTest(t)
+		mfaToken := login(t, service, mockUser)
+
+		mockUser.On("VerifyTOTP", mock.Anything, &pb_user.VerifyTOTPRequest{UserId: "123", Code: "123456"}).
+			Return(&pb_user.VerifyTOTPResponse{}, nil)
 
+		resp, err := service.VerifyMFA(context.Background(), &pb_auth.VerifyMFARequest{MfaToken: mfaToken, Code: "123456"})
 		assert.NoError(t, err)
-		assert.NotNil(t, resp)
-		assert.True(t, resp.Valid)
-		assert.Equal(t, "user-id", resp.UserId)
+		assert.NotEmpty(t, resp.RefreshToken)
+
+		claims, err := service.parseToken(resp.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, "123", claims.Subject)
+		assert.Equal(t, []string{"admin"}, claims.Roles)
+
+		// Токен челленджа одноразовый
+		_, err = service.VerifyMFA(context.Background(), &pb_auth.VerifyMFARequest{MfaToken: mfaToken, Code: "123456"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
 	})
 
-	t.Run("invalid token", func(t *testing.T) {
-		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
-			Token: "invalid-token",
+	t.Run("attempts are limited", func(t *testing.T) {
+		service, mockUser := setupTest(t)
+		mfaToken := login(t, service, mockUser)
+
+		mockUser.On("VerifyTOTP", mock.Anything, mock.Anything).
+			Return(nil, status.Error(codes.Unauthenticated, "invalid code")).Times(3)
+
+		for i := 0; i < 3; i++ {
+			_, err := service.VerifyMFA(context.Background(), &pb_auth.VerifyMFARequest{MfaToken: mfaToken, Code: "000000"})
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		}
+
+		// После исчерпания попыток челлендж удален, user сервис больше не вызывается
+		_, err := service.VerifyMFA(context.Background(), &pb_auth.VerifyMFARequest{MfaToken: mfaToken, Code: "123456"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		mockUser.AssertNumberOfCalls(t, "VerifyTOTP", 3)
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		service, _ := setupTest(t)
+
+		_, err := service.VerifyMFA(context.Background(), &pb_auth.VerifyMFARequest{MfaToken: "unknown", Code: "123456"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("admin without MFA is downgraded", func(t *testing.T) {
+		service, mockUser := setupTest(t)
+		service.adminRequiresMFA = true
+		mockUser.On("VerifyCredentials", mock.Anything, mock.Anything).
+			Return(&pb_user.VerifyCredentialsResponse{
+				User: &pb_user.User{Id: "123", Email: "test@example.com", Role: "admin"},
+			}, nil)
+
+		resp, err := service.Login(context.Background(), &pb_auth.LoginRequest{
+			Email:    "test@example.com",
+			Password: "password123",
 		})
+		assert.NoError(t, err)
 
+		claims, err := service.parseToken(resp.Token)
 		assert.NoError(t, err)
-		assert.NotNil(t, resp)
-		assert.False(t, resp.Valid)
-		assert.Empty(t, resp.UserId)
+		assert.Equal(t, []string{"user"}, claims.Roles)
+
+		refreshResp, err := service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{RefreshToken: resp.RefreshToken})
+		assert.NoError(t, err)
+		claims, err = service.parseToken(refreshResp.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"user"}, claims.Roles)
 	})
 }
 
-func TestNew(t *testing.T) {
-	conn, err := grpc.Dial("dummy", grpc.WithInsecure())
+func TestAuthService_OAuth(t *testing.T) {
+	const redirectURL = "http://localhost:8080/api/auth/oauth/mock/callback"
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	idp, err := fakeidp.New(server.URL)
 	assert.NoError(t, err)
-	defer conn.Close()
+	idp.RegisterClient("dd", "secret", redirectURL)
+	mux.Handle("/", idp.Handler())
+
+	setup := func(t *testing.T) (*AuthService, *MockUserClient) {
+		service, mockUser := setupTest(t)
+		service.oauthProviders = map[string]*oidc.Provider{
+			"mock": oidc.NewProvider("mock", idp.ProviderConfig("dd", "secret", redirectURL), server.Client()),
+		}
+		return service, mockUser
+	}
+
+	// authorize проходит страницу входа провайдера и возвращает параметры редиректа на callback
+	authorize := func(t *testing.T, service *AuthService, email string) url.Values {
+		resp, err := service.StartOAuth(context.Background(), &pb_auth.StartOAuthRequest{Provider: "mock"})
+		assert.NoError(t, err)
+		assert.Contains(t, resp.AuthorizationUrl, "code_challenge_method=S256")
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		httpResp, err := client.Get(resp.AuthorizationUrl + "&login_hint=" + url.QueryEscape(email))
+		assert.NoError(t, err)
+		httpResp.Body.Close()
+		assert.Equal(t, http.StatusFound, httpResp.StatusCode)
+
+		location, err := url.Parse(httpResp.Header.Get("Location"))
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(location.String(), redirectURL))
+		return location.Query()
+	}
+
+	t.Run("successful login", func(t *testing.T) {
+		service, mockUser := setup(t)
+		mockUser.On("ResolveExternalIdentity", mock.Anything, mock.MatchedBy(func(req *pb_user.ResolveExternalIdentityRequest) bool {
+			return req.Provider == "mock" && req.Subject != "" && req.Email == "oauth@example.com" && req.EmailVerified
+		})).Return(&pb_user.ResolveExternalIdentityResponse{
+			User: &pb_user.User{Id: "123", Email: "oauth@example.com", Role: "user"},
+		}, nil)
+
+		params := authorize(t, service, "oauth@example.com")
+		resp, err := service.CompleteOAuth(context.Background(), &pb_auth.CompleteOAuthRequest{
+			Provider: "mock",
+			State:    params.Get("state"),
+			Code:     params.Get("code"),
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, resp.RefreshToken)
+
+		claims, err := service.parseToken(resp.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, "123", claims.Subject)
+
+		// state одноразовый
+		_, err = service.CompleteOAuth(context.Background(), &pb_auth.CompleteOAuthRequest{
+			Provider: "mock",
+			State:    params.Get("state"),
+			Code:     params.Get("code"),
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("user with MFA", func(t *testing.T) {
+		service, mockUser := setup(t)
+		mockUser.On("ResolveExternalIdentity", mock.Anything, mock.Anything).
+			Return(&pb_user.ResolveExternalIdentityResponse{
+				User: &pb_user.User{Id: "123", Email: "oauth@example.com", Role: "user", MfaEnabled: true},
+			}, nil)
+
+		params := authorize(t, service, "oauth@example.com")
+		resp, err := service.CompleteOAuth(context.Background(), &pb_auth.CompleteOAuthRequest{
+			Provider: "mock",
+			State:    params.Get("state"),
+			Code:     params.Get("code"),
+		})
+		assert.NoError(t, err)
+		assert.True(t, resp.MfaRequired)
+		assert.Empty(t, resp.Token)
+	})
+
+	t.Run("unknown state", func(t *testing.T) {
+		service, _ := setup(t)
+
+		_, err := service.CompleteOAuth(context.Background(), &pb_auth.CompleteOAuthRequest{
+			Provider: "mock",
+			State:    "forged",
+			Code:     "code",
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("code from another login", func(t *testing.T) {
+		service, mockUser := setup(t)
+
+		first := authorize(t, service, "victim@example.com")
+		second := authorize(t, service, "attacker@example.com")
+
+		// Код не подходит к чужому state: PKCE verifier и nonce другие
+		_, err := service.CompleteOAuth(context.Background(), &pb_auth.CompleteOAuthRequest{
+			Provider: "mock",
+			State:    second.Get("state"),
+			Code:     first.Get("code"),
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		mockUser.AssertNotCalled(t, "ResolveExternalIdentity", mock.Anything, mock.Anything)
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		service, _ := setup(t)
+
+		_, err := service.StartOAuth(context.Background(), &pb_auth.StartOAuthRequest{Provider: "github"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+func TestAuthService_LoginThrottling(t *testing.T) {
+	newLimiter := func(t *testing.T, cfg throttle.Config) *throttle.Limiter {
+		redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+		t.Cleanup(func() { redisClient.Close() })
+		cfg.Window = time.Hour
+		return throttle.NewLimiter(redisClient, cfg)
+	}
+	fromIP := func(service *AuthService, ip string) context.Context {
+		return fromProxy(t, service, "x-client-ip", ip)
+	}
+	login := func(service *AuthService, ctx context.Context, email, password string) error {
+		_, err := service.Login(ctx, &pb_auth.LoginRequest{Email: email, Password: password})
+		return err
+	}
+	setup := func(t *testing.T, cfg throttle.Config) *AuthService {
+		service, mockUser := setupTest(t)
+		service.loginLimiter = newLimiter(t, cfg)
+		mockUser.On("VerifyCredentials", mock.Anything, mock.MatchedBy(func(req *pb_user.VerifyCredentialsRequest) bool {
+			return req.Password == "password123"
+		})).Return(&pb_user.VerifyCredentialsResponse{
+			User: &pb_user.User{Id: "123", Email: "test@example.com"},
+		}, nil)
+		mockUser.On("VerifyCredentials", mock.Anything, mock.Anything).
+			Return(nil, status.Error(codes.Unauthenticated, "invalid credentials"))
+		return service
+	}
+
+	t.Run("account backoff", func(t *testing.T) {
+		service := setup(t, throttle.Config{AccountThreshold: 2, BackoffBase: time.Hour, BackoffMax: time.Hour})
+
+		for i := 0; i < 2; i++ {
+			err := login(service, fromIP(service, "10.0.0.1"), "test@example.com", "wrong")
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		}
+
+		// Даже верный пароль не проверяется, пока не истекла пауза
+		err := login(service, fromIP(service, "10.0.0.2"), "Test@Example.com", "password123")
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		var retryInfo *errdetails.RetryInfo
+		for _, detail := range status.Convert(err).Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				retryInfo = info
+			}
+		}
+		if assert.NotNil(t, retryInfo) {
+			assert.InDelta(t, time.Hour.Seconds(), retryInfo.RetryDelay.AsDuration().Seconds(), 1)
+		}
+
+		// Другие учетные записи не затронуты
+		assert.NoError(t, login(service, fromIP(service, "10.0.0.1"), "other@example.com", "password123"))
+	})
+
+	t.Run("ip backoff", func(t *testing.T) {
+		service := setup(t, throttle.Config{IPThreshold: 3, BackoffBase: time.Hour, BackoffMax: time.Hour})
+
+		for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+			err := login(service, fromIP(service, "10.0.0.1"), email, "wrong")
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		}
+
+		err := login(service, fromIP(service, "10.0.0.1"), "d@example.com", "password123")
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		assert.NoError(t, login(service, fromIP(service, "10.0.0.2"), "d@example.com", "password123"))
+	})
+
+	t.Run("client ip is trusted only from proxy", func(t *testing.T) {
+		service := setup(t, throttle.Config{IPThreshold: 3, BackoffBase: time.Hour, BackoffMax: time.Hour})
+
+		// Без токена proxy x-client-ip не учитывается, считается адрес соединения
+		direct := func(ip string) context.Context {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{
+				Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000},
+			})
+			return metadata.NewIncomingContext(ctx, metadata.Pairs("x-client-ip", ip))
+		}
+		for i, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+			err := login(service, direct(fmt.Sprintf("10.0.1.%d", i)), email, "wrong")
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		}
+
+		err := login(service, direct("10.0.1.99"), "d@example.com", "password123")
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		// Токен другого сервиса тоже не дает права передавать адрес клиента
+		token, err := service.generateServiceToken("geo", nil)
+		assert.NoError(t, err)
+		ctx := metadata.NewIncomingContext(peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000},
+		}), metadata.Pairs("authorization", "Bearer "+token, "x-client-ip", "10.0.1.100"))
+		err = login(service, ctx, "d@example.com", "password123")
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+
+	t.Run("client ip is trusted on user calls from proxy", func(t *testing.T) {
+		service := setup(t, throttle.Config{})
+		conn := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000},
+		})
+
+		// authorization занят токеном пользователя, токен proxy идет отдельным ключом
+		proxyToken, err := service.generateServiceToken("proxy", nil)
+		assert.NoError(t, err)
+		ctx := metadata.NewIncomingContext(conn, metadata.Pairs(
+			"authorization", "Bearer user-token",
+			authn.ServiceAuthorizationKey, "Bearer "+proxyToken,
+			"x-client-ip", "10.0.2.1",
+		))
+		assert.Equal(t, "10.0.2.1", service.clientIP(ctx))
+
+		geoToken, err := service.generateServiceToken("geo", nil)
+		assert.NoError(t, err)
+		ctx = metadata.NewIncomingContext(conn, metadata.Pairs(
+			"authorization", "Bearer user-token",
+			authn.ServiceAuthorizationKey, "Bearer "+geoToken,
+			"x-client-ip", "10.0.2.1",
+		))
+		assert.Equal(t, "192.0.2.10", service.clientIP(ctx))
+	})
+
+	t.Run("successful login resets account failures", func(t *testing.T) {
+		service := setup(t, throttle.Config{LockoutThreshold: 3, LockoutDuration: time.Hour})
+
+		for i := 0; i < 2; i++ {
+			login(service, fromIP(service, "10.0.0.1"), "test@example.com", "wrong")
+		}
+		assert.NoError(t, login(service, fromIP(service, "10.0.0.1"), "test@example.com", "password123"))
+		for i := 0; i < 2; i++ {
+			login(service, fromIP(service, "10.0.0.1"), "test@example.com", "wrong")
+		}
+		assert.NoError(t, login(service, fromIP(service, "10.0.0.1"), "test@example.com", "password123"))
+	})
+
+	t.Run("lockout and unlock", func(t *testing.T) {
+		service := setup(t, throttle.Config{LockoutThreshold: 3, LockoutDuration: time.Hour})
+
+		for i := 0; i < 3; i++ {
+			err := login(service, fromIP(service, "10.0.0.1"), "test@example.com", "wrong")
+			assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		}
+
+		err := login(service, fromIP(service, "10.0.0.1"), "test@example.com", "password123")
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.True(t, authn.IsAccountLocked(err))
+
+		withToken := func(user *pb_user.User) context.Context {
+			token, err := service.generateToken(user, "")
+			assert.NoError(t, err)
+			return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+				"authorization", "Bearer "+token,
+			))
+		}
+
+		_, err = service.UnlockAccount(withToken(&pb_user.User{Id: "123", Email: "test@example.com", Role: "user"}),
+			&pb_auth.UnlockAccountRequest{Email: "test@example.com"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		_, err = service.UnlockAccount(context.Background(), &pb_auth.UnlockAccountRequest{Email: "test@example.com"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = service.UnlockAccount(withToken(&pb_user.User{Id: "1", Email: "admin@example.com", Role: "admin"}),
+			&pb_auth.UnlockAccountRequest{Email: "test@example.com"})
+		assert.NoError(t, err)
+
+		assert.NoError(t, login(service, fromIP(service, "10.0.0.1"), "test@example.com", "password123"))
+	})
+}
+
+func TestAuthService_ValidateToken(t *testing.T) {
+	service, _ := setupTest(t)
+	user := &pb_user.User{Id: "7d0b2f4e-3c1a-4b8e-9f6d-2a5c8e1b4d70", Email: "test@example.com"}
+
+	t.Run("valid token", func(t *testing.T) {
+		// Создаем валидный токен
+		token, err := service.generateToken(user, "")
+		assert.NoError(t, err)
+
+		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+			Token: token,
+		})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		assert.True(t, resp.Valid)
+		assert.Equal(t, user.Id, resp.UserId)
+		assert.Equal(t, user.Email, resp.Email)
+	})
+
+	t.Run("standard claims", func(t *testing.T) {
+		token, err := service.generateToken(user, "")
+		assert.NoError(t, err)
+
+		claims, err := service.parseToken(token)
+		assert.NoError(t, err)
+		assert.Equal(t, user.Id, claims.Subject)
+		assert.Equal(t, "test-issuer", claims.Issuer)
+		assert.Equal(t, "test-audience", claims.Audience)
+		assert.NotEmpty(t, claims.Id)
+		assert.NotZero(t, claims.IssuedAt)
+		assert.NotZero(t, claims.NotBefore)
+		assert.Equal(t, "geo:search geo:geocode", claims.Scope)
+
+		other, err := service.generateToken(user, "")
+		assert.NoError(t, err)
+		otherClaims, err := service.parseToken(other)
+		assert.NoError(t, err)
+		assert.NotEqual(t, claims.Id, otherClaims.Id)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+			Token: "invalid-token",
+		})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, resp)
+		assert.False(t, resp.Valid)
+		assert.Empty(t, resp.UserId)
+	})
+
+	signingKey, err := service.keys.SigningKey()
+	assert.NoError(t, err)
+	foreignKey, err := keys.Generate(keys.AlgorithmEdDSA)
+	assert.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims authn.Claims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		assert.NoError(t, err)
+		return signed
+	}
+	signValid := func(claims authn.Claims) string {
+		return sign(signingKey.SigningMethod(), signingKey.ID, signingKey.PrivateKey(), claims)
+	}
+	validClaims := func() authn.Claims {
+		now := time.Now()
+		return authn.Claims{
+			Email: user.Email,
+			StandardClaims: jwt.StandardClaims{
+				Subject:   user.Id,
+				Issuer:    "test-issuer",
+				Audience:  "test-audience",
+				IssuedAt:  now.Unix(),
+				NotBefore: now.Unix(),
+				ExpiresAt: now.Add(time.Hour).Unix(),
+			},
+		}
+	}
+
+	t.Run("manually signed token", func(t *testing.T) {
+		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+			Token: signValid(validClaims()),
+		})
+		assert.NoError(t, err)
+		assert.True(t, resp.Valid)
+	})
+
+	rejected := map[string]string{
+		"hmac algorithm": sign(jwt.SigningMethodHS256, signingKey.ID, []byte("test-secret"), validClaims()),
+		"none algorithm": sign(jwt.SigningMethodNone, signingKey.ID, jwt.UnsafeAllowNoneSignatureType, validClaims()),
+		"unknown kid":    sign(foreignKey.SigningMethod(), foreignKey.ID, foreignKey.PrivateKey(), validClaims()),
+		"wrong key":      sign(foreignKey.SigningMethod(), signingKey.ID, foreignKey.PrivateKey(), validClaims()),
+		"no kid":         sign(signingKey.SigningMethod(), "", signingKey.PrivateKey(), validClaims()),
+		"wrong issuer": func() string {
+			c := validClaims()
+			c.Issuer = "someone-else"
+			return signValid(c)
+		}(),
+		"wrong audience": func() string {
+			c := validClaims()
+			c.Audience = "other-api"
+			return signValid(c)
+		}(),
+		"expired": func() string {
+			c := validClaims()
+			c.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+			return signValid(c)
+		}(),
+		"not yet valid": func() string {
+			c := validClaims()
+			c.NotBefore = time.Now().Add(time.Hour).Unix()
+			return signValid(c)
+		}(),
+	}
+	for name, token := range rejected {
+		t.Run(name, func(t *testing.T) {
+			resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+				Token: token,
+			})
+
+			assert.NoError(t, err)
+			assert.False(t, resp.Valid)
+			assert.Empty(t, resp.UserId)
+		})
+	}
+}
+
+func TestAuthService_KeyRotation(t *testing.T) {
+	service, _ := setupTest(t)
+	user := &pb_user.User{Id: "123", Email: "test@example.com"}
+
+	before, err := service.keys.SigningKey()
+	assert.NoError(t, err)
+	oldToken, err := service.generateToken(user, "")
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.keys.Rotate(context.Background()))
+
+	after, err := service.keys.SigningKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, before.ID, after.ID)
+
+	newToken, err := service.generateToken(user, "")
+	assert.NoError(t, err)
+	claims, err := service.parseToken(newToken)
+	assert.NoError(t, err)
+	assert.Equal(t, user.Id, claims.Subject)
+
+	// Токены старого ключа действуют до истечения своего срока
+	resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+		Token: oldToken,
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Valid)
+
+	// Оба ключа опубликованы
+	jwks, err := service.GetJWKS(context.Background(), &pb_auth.GetJWKSRequest{})
+	assert.NoError(t, err)
+	var kids []string
+	for _, key := range jwks.Keys {
+		assert.Equal(t, "OKP", key.Kty)
+		assert.Equal(t, "Ed25519", key.Crv)
+		assert.Equal(t, "EdDSA", key.Alg)
+		assert.NotEmpty(t, key.X)
+		kids = append(kids, key.Kid)
+	}
+	assert.ElementsMatch(t, []string{before.ID, after.ID}, kids)
+
+	t.Run("retired key expires after grace period", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer redisClient.Close()
+
+		manager := keys.NewManager(redisClient, testKeyBox(t), keys.AlgorithmEdDSA, 24*time.Hour, 0)
+		assert.NoError(t, manager.Init(context.Background()))
+		first, err := manager.SigningKey()
+		assert.NoError(t, err)
+
+		assert.NoError(t, manager.Rotate(context.Background()))
+
+		// Экземпляры, еще не узнавшие о ротации, подписывают старым ключом до
+		// следующей синхронизации, поэтому сразу он не пропадает
+		_, ok := manager.VerificationKey(first.ID)
+		assert.True(t, ok)
+		assert.Len(t, manager.PublicKeys(), 2)
+
+		// Ключ выведен из подписи дольше периода синхронизации назад
+		var stored map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(mr.HGet("jwt:keys", first.ID)), &stored))
+		stored["retired_at"] = time.Now().Add(-2 * time.Minute)
+		value, err := json.Marshal(stored)
+		assert.NoError(t, err)
+		mr.HSet("jwt:keys", first.ID, string(value))
+
+		assert.NoError(t, manager.Init(context.Background()))
+		_, ok = manager.VerificationKey(first.ID)
+		assert.False(t, ok)
+		assert.Len(t, manager.PublicKeys(), 1)
+	})
+
+	t.Run("private keys are encrypted at rest", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer redisClient.Close()
+
+		manager := keys.NewManager(redisClient, testKeyBox(t), keys.AlgorithmEdDSA, 24*time.Hour, time.Hour)
+		assert.NoError(t, manager.Init(context.Background()))
+		key, err := manager.SigningKey()
+		assert.NoError(t, err)
+
+		var stored map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(mr.HGet("jwt:keys", key.ID)), &stored))
+		assert.NotEmpty(t, stored["sealed_key"])
+		assert.NotContains(t, stored, "private_key")
+
+		// Без ключа шифрования подписывать нечем
+		otherBox, err := secretbox.New("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+		assert.NoError(t, err)
+		other := keys.NewManager(redisClient, otherBox, keys.AlgorithmEdDSA, 24*time.Hour, time.Hour)
+		assert.Error(t, other.Init(context.Background()))
+	})
+
+	t.Run("plaintext keys are encrypted on sync", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer redisClient.Close()
+
+		manager := keys.NewManager(redisClient, testKeyBox(t), keys.AlgorithmEdDSA, 24*time.Hour, time.Hour)
+		assert.NoError(t, manager.Init(context.Background()))
+		key, err := manager.SigningKey()
+		assert.NoError(t, err)
+
+		// Так ключ хранили прежние версии
+		var stored map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(mr.HGet("jwt:keys", key.ID)), &stored))
+		sealed, err := base64.StdEncoding.DecodeString(stored["sealed_key"].(string))
+		assert.NoError(t, err)
+		der, err := testKeyBox(t).Open(sealed)
+		assert.NoError(t, err)
+		delete(stored, "sealed_key")
+		stored["private_key"] = der
+		value, err := json.Marshal(stored)
+		assert.NoError(t, err)
+		mr.HSet("jwt:keys", key.ID, string(value))
+
+		assert.NoError(t, manager.Init(context.Background()))
+		same, err := manager.SigningKey()
+		assert.NoError(t, err)
+		assert.Equal(t, key.ID, same.ID)
+
+		stored = nil
+		assert.NoError(t, json.Unmarshal([]byte(mr.HGet("jwt:keys", key.ID)), &stored))
+		assert.NotEmpty(t, stored["sealed_key"])
+		assert.NotContains(t, stored, "private_key")
+	})
+
+	t.Run("keys are shared between instances", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer redisClient.Close()
+
+		first := keys.NewManager(redisClient, testKeyBox(t), keys.AlgorithmRS256, 24*time.Hour, time.Hour)
+		assert.NoError(t, first.Init(context.Background()))
+		second := keys.NewManager(redisClient, testKeyBox(t), keys.AlgorithmRS256, 24*time.Hour, time.Hour)
+		assert.NoError(t, second.Init(context.Background()))
+
+		a, err := first.SigningKey()
+		assert.NoError(t, err)
+		b, err := second.SigningKey()
+		assert.NoError(t, err)
+		assert.Equal(t, a.ID, b.ID)
+
+		jwks := second.PublicKeys()
+		assert.Len(t, jwks, 1)
+		assert.Equal(t, "RSA", jwks[0].Kty)
+		assert.Equal(t, "AQAB", jwks[0].E)
+	})
+}
+
+func TestAuthService_RefreshToken(t *testing.T) {
+	service, _ := setupTest(t)
+	user := &pb_user.User{Id: "123", Email: "test@example.com", Role: "admin"}
+
+	t.Run("rotation", func(t *testing.T) {
+		_, refreshToken, err := service.issueTokens(context.Background(), user)
+		assert.NoError(t, err)
+
+		resp, err := service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: refreshToken,
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, resp.Token)
+		assert.NotEmpty(t, resp.RefreshToken)
+		assert.NotEqual(t, refreshToken, resp.RefreshToken)
+
+		claims, err := service.parseToken(resp.Token)
+		assert.NoError(t, err)
+		assert.Equal(t, "123", claims.Subject)
+		assert.Equal(t, "test@example.com", claims.Email)
+		assert.Equal(t, []string{"admin"}, claims.Roles)
+
+		// Новый токен тоже можно обменять
+		next, err := service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: resp.RefreshToken,
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, next.RefreshToken)
+	})
+
+	t.Run("reuse revokes the whole family", func(t *testing.T) {
+		_, first, err := service.issueTokens(context.Background(), user)
+		assert.NoError(t, err)
+
+		resp, err := service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: first,
+		})
+		assert.NoError(t, err)
+		second := resp.RefreshToken
+
+		// Повторное использование погашенного токена
+		_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: first,
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		// Токен, выпущенный ротацией, отозван вместе с семейством
+		_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: second,
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		_, err := service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: "unknown",
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("empty token", func(t *testing.T) {
+		_, err := service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestAuthService_Logout(t *testing.T) {
+	service, _ := setupTest(t)
+	user := &pb_user.User{Id: "123", Email: "test@example.com"}
+
+	_, refreshToken, err := service.issueTokens(context.Background(), user)
+	assert.NoError(t, err)
+
+	resp, err := service.Logout(context.Background(), &pb_auth.LogoutRequest{
+		RefreshToken: refreshToken,
+	})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+
+	_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+		RefreshToken: refreshToken,
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Повторный выход не считается ошибкой
+	_, err = service.Logout(context.Background(), &pb_auth.LogoutRequest{
+		RefreshToken: refreshToken,
+	})
+	assert.NoError(t, err)
+}
+
+func TestAuthService_RevokeToken(t *testing.T) {
+	service, _ := setupTest(t)
+	user := &pb_user.User{Id: "123", Email: "test@example.com"}
+
+	token, err := service.generateToken(user, "")
+	assert.NoError(t, err)
+	other, err := service.generateToken(user, "")
+	assert.NoError(t, err)
+
+	_, err = service.RevokeToken(context.Background(), &pb_auth.RevokeTokenRequest{
+		Token: token,
+	})
+	assert.NoError(t, err)
+
+	resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+		Token: token,
+	})
+	assert.NoError(t, err)
+	assert.False(t, resp.Valid)
+
+	// Остальные токены пользователя продолжают работать
+	resp, err = service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+		Token: other,
+	})
+	assert.NoError(t, err)
+	assert.True(t, resp.Valid)
+
+	t.Run("invalid token is ignored", func(t *testing.T) {
+		_, err := service.RevokeToken(context.Background(), &pb_auth.RevokeTokenRequest{
+			Token: "invalid-token",
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestAuthService_RevokeAllSessions(t *testing.T) {
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+token,
+		))
+	}
+
+	t.Run("revokes access and refresh tokens", func(t *testing.T) {
+		service, _ := setupTest(t)
+		user := &pb_user.User{Id: "123", Email: "test@example.com"}
+
+		token, refreshToken, err := service.issueTokens(context.Background(), user)
+		assert.NoError(t, err)
+
+		_, err = service.RevokeAllSessions(withToken(token), &pb_auth.RevokeAllSessionsRequest{
+			UserId: "123",
+		})
+		assert.NoError(t, err)
+
+		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+			Token: token,
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.Valid)
+
+		_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: refreshToken,
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("tokens issued after revocation stay valid", func(t *testing.T) {
+		service, _ := setupTest(t)
+		err := service.revoked.RevokeUser(context.Background(), "123", time.Now().Add(-time.Minute), time.Hour)
+		assert.NoError(t, err)
+
+		token, err := service.generateToken(&pb_user.User{Id: "123", Email: "test@example.com"}, "")
+		assert.NoError(t, err)
+
+		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+			Token: token,
+		})
+		assert.NoError(t, err)
+		assert.True(t, resp.Valid)
+	})
+
+	t.Run("another user", func(t *testing.T) {
+		service, _ := setupTest(t)
+		token, err := service.generateToken(&pb_user.User{Id: "123", Email: "test@example.com"}, "")
+		assert.NoError(t, err)
+
+		_, err = service.RevokeAllSessions(withToken(token), &pb_auth.RevokeAllSessionsRequest{
+			UserId: "456",
+		})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("keeps current session", func(t *testing.T) {
+		service, _ := setupTest(t)
+		user := &pb_user.User{Id: "123", Email: "test@example.com"}
+
+		current, currentRefresh, err := service.issueTokens(context.Background(), user)
+		assert.NoError(t, err)
+		other, otherRefresh, err := service.issueTokens(context.Background(), user)
+		assert.NoError(t, err)
+
+		_, err = service.RevokeAllSessions(withToken(current), &pb_auth.RevokeAllSessionsRequest{
+			UserId:      "123",
+			KeepCurrent: true,
+		})
+		assert.NoError(t, err)
+
+		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{Token: current})
+		assert.NoError(t, err)
+		assert.True(t, resp.Valid)
+		_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{RefreshToken: currentRefresh})
+		assert.NoError(t, err)
+
+		resp, err = service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{Token: other})
+		assert.NoError(t, err)
+		assert.False(t, resp.Valid)
+		_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{RefreshToken: otherRefresh})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("admin revokes another user with API keys", func(t *testing.T) {
+		service, _ := setupTest(t)
+		db, sqlMock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+		service.apiKeys = apikey.NewStore(db)
+
+		victim, _, err := service.issueTokens(context.Background(), &pb_user.User{Id: "456", Email: "victim@example.com"})
+		assert.NoError(t, err)
+		admin, err := service.generateToken(&pb_user.User{Id: "1", Email: "admin@example.com", Role: authz.RoleAdmin}, "")
+		assert.NoError(t, err)
+
+		sqlMock.ExpectExec("UPDATE api_keys SET revoked_at = NOW\\(\\) WHERE user_id = (.+) AND revoked_at IS NULL").
+			WithArgs("456").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		_, err = service.RevokeAllSessions(withToken(admin), &pb_auth.RevokeAllSessionsRequest{
+			UserId:        "456",
+			RevokeApiKeys: true,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+
+		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{Token: victim})
+		assert.NoError(t, err)
+		assert.False(t, resp.Valid)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		service, _ := setupTest(t)
+
+		_, err := service.RevokeAllSessions(context.Background(), &pb_auth.RevokeAllSessionsRequest{
+			UserId: "123",
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
+
+func TestAuthService_Sessions(t *testing.T) {
+	withToken := func(token string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+token,
+		))
+	}
+	login := func(t *testing.T, service *AuthService, userID, userAgent string) (string, string) {
+		ctx := fromProxy(t, service,
+			"x-client-ip", "203.0.113.7",
+			"x-user-agent", userAgent,
+		)
+		token, refreshToken, err := service.issueTokens(ctx, &pb_user.User{Id: userID, Email: userID + "@example.com"})
+		assert.NoError(t, err)
+		return token, refreshToken
+	}
+
+	t.Run("lists sessions of the caller", func(t *testing.T) {
+		service, _ := setupTest(t)
+		laptop, _ := login(t, service, "123", "Firefox")
+		login(t, service, "123", "Safari")
+		login(t, service, "456", "Chrome")
+
+		resp, err := service.ListSessions(withToken(laptop), &pb_auth.ListSessionsRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Sessions, 2)
+
+		var current []string
+		agents := map[string]bool{}
+		for _, session := range resp.Sessions {
+			assert.Equal(t, "203.0.113.7", session.Ip)
+			assert.NotEmpty(t, session.CreatedAt)
+			assert.NotEmpty(t, session.LastSeenAt)
+			agents[session.UserAgent] = true
+			if session.Current {
+				current = append(current, session.UserAgent)
+			}
+		}
+		assert.Equal(t, map[string]bool{"Firefox": true, "Safari": true}, agents)
+		assert.Equal(t, []string{"Firefox"}, current)
+	})
+
+	t.Run("revoked session invalidates its tokens", func(t *testing.T) {
+		service, _ := setupTest(t)
+		laptop, _ := login(t, service, "123", "Firefox")
+		phone, phoneRefresh := login(t, service, "123", "Safari")
+
+		var phoneID string
+		sessions, err := service.ListSessions(withToken(phone), &pb_auth.ListSessionsRequest{})
+		assert.NoError(t, err)
+		for _, session := range sessions.Sessions {
+			if session.Current {
+				phoneID = session.Id
+			}
+		}
+
+		_, err = service.RevokeSession(withToken(laptop), &pb_auth.RevokeSessionRequest{
+			Id: phoneID,
+		})
+		assert.NoError(t, err)
+
+		resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+			Token: phone,
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.Valid)
+
+		_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: phoneRefresh,
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		// Остальные сессии продолжают работать
+		resp, err = service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+			Token: laptop,
+		})
+		assert.NoError(t, err)
+		assert.True(t, resp.Valid)
+
+		sessions, err = service.ListSessions(withToken(laptop), &pb_auth.ListSessionsRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, sessions.Sessions, 1)
+	})
+
+	t.Run("refreshed token stays in the session", func(t *testing.T) {
+		service, _ := setupTest(t)
+		token, refreshToken := login(t, service, "123", "Firefox")
+
+		refreshed, err := service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{
+			RefreshToken: refreshToken,
+		})
+		assert.NoError(t, err)
+
+		_, err = service.Logout(context.Background(), &pb_auth.LogoutRequest{
+			RefreshToken: refreshed.RefreshToken,
+		})
+		assert.NoError(t, err)
+
+		for _, access := range []string{token, refreshed.Token} {
+			resp, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{
+				Token: access,
+			})
+			assert.NoError(t, err)
+			assert.False(t, resp.Valid)
+		}
+	})
+
+	t.Run("session of another user", func(t *testing.T) {
+		service, _ := setupTest(t)
+		token, _ := login(t, service, "123", "Firefox")
+		other, _ := login(t, service, "456", "Chrome")
+
+		sessions, err := service.ListSessions(withToken(other), &pb_auth.ListSessionsRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, sessions.Sessions, 1)
+
+		_, err = service.RevokeSession(withToken(token), &pb_auth.RevokeSessionRequest{
+			Id: sessions.Sessions[0].Id,
+		})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		service, _ := setupTest(t)
+
+		_, err := service.ListSessions(context.Background(), &pb_auth.ListSessionsRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = service.RevokeSession(context.Background(), &pb_auth.RevokeSessionRequest{Id: "x"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
+
+func TestAuthService_APIKeys(t *testing.T) {
+	columns := []string{"id", "user_id", "email", "name", "prefix", "scopes", "daily_quota", "created_at", "last_used_at", "expires_at", "revoked_at"}
+
+	setup := func(t *testing.T) (*AuthService, sqlmock.Sqlmock) {
+		service, _ := setupTest(t)
+		db, sqlMock, err := sqlmock.New()
+		assert.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		service.apiKeys = apikey.NewStore(db)
+		return service, sqlMock
+	}
+
+	withUser := func(t *testing.T, service *AuthService, role string) context.Context {
+		token, err := service.generateToken(&pb_user.User{Id: "123", Email: "test@example.com", Role: role}, "")
+		assert.NoError(t, err)
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+token,
+		))
+	}
+
+	t.Run("create", func(t *testing.T) {
+		service, sqlMock := setup(t)
+		now := time.Now()
+
+		sqlMock.ExpectQuery("INSERT INTO api_keys").
+			WithArgs("123", "test@example.com", "ci", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 500, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("key-1", "123", "test@example.com", "ci", "ddk_abcdefgh", "{geo:search}", 500, now, nil, nil, nil))
+
+		resp, err := service.CreateAPIKey(withUser(t, service, "user"), &pb_auth.CreateAPIKeyRequest{
+			Name:       "ci",
+			Scopes:     []string{"geo:search"},
+			DailyQuota: 500,
+		})
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(resp.Key, "ddk_"))
+		assert.Equal(t, "key-1", resp.ApiKey.Id)
+		assert.Equal(t, []string{"geo:search"}, resp.ApiKey.Scopes)
+		assert.Equal(t, int32(500), resp.ApiKey.DailyQuota)
+		assert.Empty(t, resp.ApiKey.LastUsedAt)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("create validates request", func(t *testing.T) {
+		service, _ := setup(t)
+		ctx := withUser(t, service, "user")
+
+		for name, req := range map[string]*pb_auth.CreateAPIKeyRequest{
+			"no name":        {Scopes: []string{"geo:search"}},
+			"no scopes":      {Name: "ci"},
+			"unknown scope":  {Name: "ci", Scopes: []string{"users:read"}},
+			"negative ttl":   {Name: "ci", Scopes: []string{"geo:search"}, TtlSeconds: -1},
+			"negative quota": {Name: "ci", Scopes: []string{"geo:search"}, DailyQuota: -1},
+		} {
+			_, err := service.CreateAPIKey(ctx, req)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+		}
+	})
+
+	t.Run("create unauthenticated", func(t *testing.T) {
+		service, _ := setup(t)
+
+		_, err := service.CreateAPIKey(context.Background(), &pb_auth.CreateAPIKeyRequest{
+			Name:   "ci",
+			Scopes: []string{"geo:search"},
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("list", func(t *testing.T) {
+		service, sqlMock := setup(t)
+		now := time.Now()
+
+		sqlMock.ExpectQuery("SELECT (.+) FROM api_keys WHERE user_id").
+			WithArgs("123").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("key-1", "123", "test@example.com", "ci", "ddk_abcdefgh", "{geo:search}", 0, now, now, nil, now))
+
+		resp, err := service.ListAPIKeys(withUser(t, service, "user"), &pb_auth.ListAPIKeysRequest{})
+		assert.NoError(t, err)
+		assert.Len(t, resp.ApiKeys, 1)
+		assert.NotEmpty(t, resp.ApiKeys[0].LastUsedAt)
+		assert.NotEmpty(t, resp.ApiKeys[0].RevokedAt)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("revoke own key", func(t *testing.T) {
+		service, sqlMock := setup(t)
+
+		sqlMock.ExpectExec("UPDATE api_keys SET revoked_at").
+			WithArgs("key-1", "123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := service.RevokeAPIKey(withUser(t, service, "user"), &pb_auth.RevokeAPIKeyRequest{Id: "key-1"})
+		assert.NoError(t, err)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("revoke foreign key", func(t *testing.T) {
+		service, sqlMock := setup(t)
+
+		sqlMock.ExpectExec("UPDATE api_keys SET revoked_at").
+			WithArgs("key-2", "123").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := service.RevokeAPIKey(withUser(t, service, "user"), &pb_auth.RevokeAPIKeyRequest{Id: "key-2"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("admin revokes any key", func(t *testing.T) {
+		service, sqlMock := setup(t)
+
+		sqlMock.ExpectExec("UPDATE api_keys SET revoked_at").
+			WithArgs("key-2", "").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, err := service.RevokeAPIKey(withUser(t, service, "admin"), &pb_auth.RevokeAPIKeyRequest{Id: "key-2"})
+		assert.NoError(t, err)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		service, sqlMock := setup(t)
+		now := time.Now()
+
+		sqlMock.ExpectQuery("SELECT (.+) FROM api_keys").
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("key-1", "123", "test@example.com", "ci", "ddk_abcdefgh", "{geo:search}", 0, now, nil, nil, nil))
+		sqlMock.ExpectExec("UPDATE api_keys SET last_used_at").
+			WithArgs("key-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		resp, err := service.ValidateAPIKey(withService(t, service), &pb_auth.ValidateAPIKeyRequest{
+			Key: "ddk_0123456789abcdefghijklmnopqrstuvwxyzABCDE",
+		})
+		assert.NoError(t, err)
+		assert.True(t, resp.Valid)
+		assert.Equal(t, "key-1", resp.KeyId)
+		assert.Equal(t, "123", resp.UserId)
+		assert.Equal(t, []string{"geo:search"}, resp.Scopes)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("validate unknown key", func(t *testing.T) {
+		service, sqlMock := setup(t)
+
+		sqlMock.ExpectQuery("SELECT (.+) FROM api_keys").
+			WithArgs(sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		resp, err := service.ValidateAPIKey(withService(t, service), &pb_auth.ValidateAPIKeyRequest{
+			Key: "ddk_0123456789abcdefghijklmnopqrstuvwxyzABCDE",
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.Valid)
+
+		// Строка другого формата не доходит до базы
+		resp, err = service.ValidateAPIKey(withService(t, service), &pb_auth.ValidateAPIKeyRequest{
+			Key: "not-a-key",
+		})
+		assert.NoError(t, err)
+		assert.False(t, resp.Valid)
+	})
+
+	t.Run("validate requires service token", func(t *testing.T) {
+		service, _ := setup(t)
+
+		_, err := service.ValidateAPIKey(context.Background(), &pb_auth.ValidateAPIKeyRequest{
+			Key: "ddk_0123456789abcdefghijklmnopqrstuvwxyzABCDE",
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = service.ValidateAPIKey(withUser(t, service, "admin"), &pb_auth.ValidateAPIKeyRequest{
+			Key: "ddk_0123456789abcdefghijklmnopqrstuvwxyzABCDE",
+		})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+}
+
+func withService(t *testing.T, service *AuthService) context.Context {
+	token, _, err := service.ServiceToken(context.Background())
+	assert.NoError(t, err)
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer "+token,
+	))
+}
+
+// fromUserService возвращает контекст вызова от имени user сервиса
+func fromUserService(t *testing.T, service *AuthService) context.Context {
+	token, err := service.generateServiceToken("user", []string{authz.ScopeUserData})
+	assert.NoError(t, err)
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer "+token,
+	))
+}
+
+func TestAuthService_Token(t *testing.T) {
+	service, _ := setupTest(t)
+
+	t.Run("client credentials", func(t *testing.T) {
+		resp, err := service.Token(context.Background(), &pb_auth.TokenRequest{
+			GrantType:    "client_credentials",
+			ClientId:     "geo",
+			ClientSecret: "geo-secret",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer", resp.TokenType)
+		assert.Equal(t, int64(600), resp.ExpiresIn)
+		assert.Equal(t, "geo:search", resp.Scope)
+
+		claims, err := service.parseToken(resp.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, "geo", claims.ClientID)
+		assert.Equal(t, "geo", claims.Subject)
+		assert.Equal(t, []string{"service"}, claims.Roles)
+
+		// Токен сервиса не принимается там, где нужен пользователь
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+resp.AccessToken,
+		))
+		_, err = service.ListAPIKeys(ctx, &pb_auth.ListAPIKeysRequest{})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("invalid secret", func(t *testing.T) {
+		_, err := service.Token(context.Background(), &pb_auth.TokenRequest{
+			GrantType:    "client_credentials",
+			ClientId:     "geo",
+			ClientSecret: "wrong",
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		_, err := service.Token(context.Background(), &pb_auth.TokenRequest{
+			GrantType:    "client_credentials",
+			ClientId:     "unknown",
+			ClientSecret: "geo-secret",
+		})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("unsupported grant type", func(t *testing.T) {
+		_, err := service.Token(context.Background(), &pb_auth.TokenRequest{
+			GrantType:    "password",
+			ClientId:     "geo",
+			ClientSecret: "geo-secret",
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("scope not allowed", func(t *testing.T) {
+		_, err := service.Token(context.Background(), &pb_auth.TokenRequest{
+			GrantType:    "client_credentials",
+			ClientId:     "geo",
+			ClientSecret: "geo-secret",
+			Scope:        "geo:geocode",
+		})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("own service token", func(t *testing.T) {
+		token, _, err := service.ServiceToken(context.Background())
+		assert.NoError(t, err)
+
+		// Регистрировать и проверять пользователей в user сервисе может только auth
+		claims, err := service.parseToken(token)
+		assert.NoError(t, err)
+		assert.Equal(t, "auth", claims.ClientID)
+		assert.Equal(t, authz.ScopeUserAuthenticate, claims.Scope)
+	})
+}
+
+func TestAuthService_UserData(t *testing.T) {
+	columns := []string{"id", "user_id", "email", "name", "prefix", "scopes", "daily_quota", "created_at", "last_used_at", "expires_at", "revoked_at"}
+
+	setup := func(t *testing.T) (*AuthService, sqlmock.Sqlmock, *miniredis.Miniredis) {
+		service, _ := setupTest(t)
+		db, sqlMock, err := sqlmock.New()
+		assert.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		service.apiKeys = apikey.NewStore(db)
+
+		// Отдельный Redis, чтобы проверить, какие ключи остались после удаления
+		mr := miniredis.RunT(t)
+		redisClient := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { redisClient.Close() })
+		service.refreshTokens = refresh.NewStore(redisClient, 24*time.Hour)
+		return service, sqlMock, mr
+	}
+
+	t.Run("export", func(t *testing.T) {
+		service, sqlMock, _ := setup(t)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-user-agent", "Firefox"))
+		_, _, err := service.issueTokens(ctx, &pb_user.User{Id: "123", Email: "test@example.com"})
+		assert.NoError(t, err)
+		_, _, err = service.issueTokens(context.Background(), &pb_user.User{Id: "456", Email: "other@example.com"})
+		assert.NoError(t, err)
+
+		now := time.Now()
+		sqlMock.ExpectQuery("SELECT (.+) FROM api_keys WHERE user_id = (.+)").
+			WithArgs("123").
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("key-1", "123", "test@example.com", "ci", "ddk_abcdefgh", "{geo:search}", 0, now, nil, nil, now))
+
+		resp, err := service.ExportUserData(fromUserService(t, service), &pb_auth.ExportUserDataRequest{UserId: "123"})
+		assert.NoError(t, err)
+		if assert.Len(t, resp.Sessions, 1) {
+			assert.Equal(t, "Firefox", resp.Sessions[0].UserAgent)
+		}
+		if assert.Len(t, resp.ApiKeys, 1) {
+			assert.Equal(t, "key-1", resp.ApiKeys[0].Id)
+			assert.NotEmpty(t, resp.ApiKeys[0].RevokedAt)
+		}
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		service, sqlMock, mr := setup(t)
+		token, refreshToken, err := service.issueTokens(context.Background(), &pb_user.User{Id: "123", Email: "test@example.com"})
+		assert.NoError(t, err)
+		_, rotated, err := service.issueTokens(context.Background(), &pb_user.User{Id: "123", Email: "test@example.com"})
+		assert.NoError(t, err)
+		_, _, err = service.refreshTokens.Rotate(context.Background(), rotated)
+		assert.NoError(t, err)
+		_, otherRefresh, err := service.issueTokens(context.Background(), &pb_user.User{Id: "456", Email: "other@example.com"})
+		assert.NoError(t, err)
+
+		sqlMock.ExpectExec("DELETE FROM api_keys WHERE user_id = (.+)").
+			WithArgs("123").
+			WillReturnResult(sqlmock.NewResult(0, 2))
+
+		resp, err := service.PurgeUserData(fromUserService(t, service), &pb_auth.PurgeUserDataRequest{UserId: "123"})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), resp.Sessions)
+		assert.Equal(t, int32(2), resp.ApiKeys)
+		assert.NoError(t, sqlMock.ExpectationsWereMet())
+
+		valid, err := service.ValidateToken(context.Background(), &pb_auth.ValidateTokenRequest{Token: token})
+		assert.NoError(t, err)
+		assert.False(t, valid.Valid)
+		_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{RefreshToken: refreshToken})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		// В Redis остаются только семейство и токен другого пользователя
+		var left []string
+		for _, key := range mr.Keys() {
+			if strings.HasPrefix(key, "refresh:") {
+				left = append(left, strings.Join(strings.Split(key, ":")[:2], ":"))
+			}
+		}
+		assert.ElementsMatch(t, []string{"refresh:family", "refresh:token", "refresh:user"}, left)
+		_, err = service.RefreshToken(context.Background(), &pb_auth.RefreshTokenRequest{RefreshToken: otherRefresh})
+		assert.NoError(t, err)
+	})
+
+	t.Run("requires service token", func(t *testing.T) {
+		service, _, _ := setup(t)
+		token, err := service.generateToken(&pb_user.User{Id: "1", Email: "admin@example.com", Role: authz.RoleAdmin}, "")
+		assert.NoError(t, err)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+
+		_, err = service.ExportUserData(ctx, &pb_auth.ExportUserDataRequest{UserId: "123"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = service.PurgeUserData(ctx, &pb_auth.PurgeUserDataRequest{UserId: "123"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = service.PurgeUserData(fromUserService(t, service), &pb_auth.PurgeUserDataRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("requires user service", func(t *testing.T) {
+		service, _, _ := setup(t)
+
+		// Токен сервиса без разрешения user:data, например самого auth или proxy
+		for _, ctx := range []context.Context{withService(t, service), fromProxy(t, service)} {
+			_, err := service.ExportUserData(ctx, &pb_auth.ExportUserDataRequest{UserId: "123"})
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			_, err = service.PurgeUserData(ctx, &pb_auth.PurgeUserDataRequest{UserId: "123"})
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		}
+	})
+}
+
+func TestAuthService_Audit(t *testing.T) {
+	service, mockUser := setupTest(t)
+	auditLog := service.audit.(*audit.Memory)
+	ctx := fromProxy(t, service,
+		"x-client-ip", "203.0.113.7",
+		"x-user-agent", "Firefox",
+	)
+
+	mockUser.On("VerifyCredentials", mock.Anything, &pb_user.VerifyCredentialsRequest{Email: "test@example.com", Password: "wrong-password"}).
+		Return(nil, status.Error(codes.Unauthenticated, "invalid credentials"))
+	mockUser.On("VerifyCredentials", mock.Anything, &pb_user.VerifyCredentialsRequest{Email: "test@example.com", Password: "password123"}).
+		Return(&pb_user.VerifyCredentialsResponse{User: &pb_user.User{Id: "123", Email: "test@example.com", Role: "user"}}, nil)
+
+	_, err := service.Login(ctx, &pb_auth.LoginRequest{Email: "test@example.com", Password: "wrong-password"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	resp, err := service.Login(ctx, &pb_auth.LoginRequest{Email: "test@example.com", Password: "password123"})
+	assert.NoError(t, err)
+
+	// Разблокировать учетную запись может только администратор. Адрес от
+	// вызывающего, который не является proxy, в журнал не попадает.
+	_, err = service.UnlockAccount(metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer "+resp.Token,
+		"x-client-ip", "198.51.100.1",
+	)), &pb_auth.UnlockAccountRequest{Email: "victim@example.com"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Вызов пользователя через proxy: токен proxy передан отдельным ключом,
+	// и адрес клиента попадает в журнал
+	proxyToken, err := service.generateServiceToken("proxy", nil)
+	assert.NoError(t, err)
+	_, err = service.UnlockAccount(metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer "+resp.Token,
+		authn.ServiceAuthorizationKey, "Bearer "+proxyToken,
+		"x-client-ip", "203.0.113.8",
+		"x-user-agent", "Firefox",
+	)), &pb_auth.UnlockAccountRequest{Email: "victim@example.com"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	events := auditLog.Events()
+	if !assert.Len(t, events, 4) {
+		return
+	}
+
+	assert.Equal(t, audit.ActionLogin, events[0].Action)
+	assert.Equal(t, audit.ResultFailure, events[0].Result)
+	assert.Equal(t, audit.ActorAnonymous, events[0].ActorType)
+	assert.Equal(t, "test@example.com", events[0].Target)
+	assert.Equal(t, "203.0.113.7", events[0].IP)
+	assert.Equal(t, "Firefox", events[0].UserAgent)
+	assert.Equal(t, "auth", events[0].Service)
+
+	assert.Equal(t, audit.ActionLogin, events[1].Action)
+	assert.Equal(t, audit.ResultSuccess, events[1].Result)
+	assert.Equal(t, "123", events[1].Actor)
+	assert.Equal(t, events[0].Hash, events[1].PrevHash)
+
+	assert.Equal(t, audit.ActionAccountUnlock, events[2].Action)
+	assert.Equal(t, audit.ResultDenied, events[2].Result)
+	assert.Equal(t, "123", events[2].Actor)
+	assert.Equal(t, "victim@example.com", events[2].Target)
+	assert.Empty(t, events[2].IP)
+	assert.Empty(t, events[2].UserAgent)
+
+	assert.Equal(t, "123", events[3].Actor)
+	assert.Equal(t, "203.0.113.8", events[3].IP)
+	assert.Equal(t, "Firefox", events[3].UserAgent)
+}
+
+func TestNew(t *testing.T) {
+	conn, err := grpc.Dial("dummy", grpc.WithInsecure())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: miniredis.RunT(t).Addr(),
+	})
+	defer redisClient.Close()
+
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
 
-	service := New(conn, "test-secret")
+	cfg := config.New()
+	keyManager := keys.NewManager(redisClient, testKeyBox(t), cfg.JWTAlgorithm, cfg.KeyRotationInterval, cfg.TokenTTL)
+	auditLog := audit.NewPostgres(db, "auth")
+	service := New(conn, redisClient, db, keyManager, auditLog, cfg)
 
 	assert.NotNil(t, service)
 	assert.NotNil(t, service.userClient)
-	assert.Equal(t, "test-secret", service.jwtSecret)
+	assert.NotNil(t, service.refreshTokens)
+	assert.NotNil(t, service.apiKeys)
+	assert.IsType(t, &revocation.RedisStore{}, service.revoked)
+	assert.Equal(t, keyManager, service.keys)
+	assert.Equal(t, auditLog, service.audit)
+	assert.Equal(t, cfg.JWTIssuer, service.issuer)
+	assert.Equal(t, cfg.JWTAudience, service.audience)
+	assert.Equal(t, cfg.ServiceClients, service.serviceClients)
 }
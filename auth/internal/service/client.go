@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"strings"
+	"time"
+
+	"dd/pkg/audit"
+	pb "dd/pkg/auth"
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// selfClientID - client_id, от имени которого auth вызывает другие сервисы
+const selfClientID = "auth"
+
+// Token выпускает токен сервису по grant_type=client_credentials
+func (s *AuthService) Token(ctx context.Context, req *pb.TokenRequest) (*pb.TokenResponse, error) {
+	if req.GrantType != "client_credentials" {
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported grant type %q", req.GrantType)
+	}
+
+	client, ok := s.serviceClients[req.ClientId]
+	if !ok || client.Secret == "" ||
+		subtle.ConstantTimeCompare([]byte(client.Secret), []byte(req.ClientSecret)) != 1 {
+		log.Printf("Rejected client credentials for %q", req.ClientId)
+		s.record(ctx, audit.Event{Action: audit.ActionServiceToken, Target: req.ClientId, Result: audit.ResultFailure, Reason: "invalid client credentials"})
+		return nil, status.Error(codes.Unauthenticated, "invalid client credentials")
+	}
+
+	scopes := client.Scopes
+	if req.Scope != "" {
+		scopes = strings.Fields(req.Scope)
+		for _, scope := range scopes {
+			if !contains(client.Scopes, scope) {
+				return nil, status.Errorf(codes.PermissionDenied, "scope %q is not allowed for client", scope)
+			}
+		}
+	}
+
+	token, err := s.generateServiceToken(req.ClientId, scopes)
+	if err != nil {
+		log.Printf("Failed to generate service token: %v", err)
+		return nil, status.Error(codes.Internal, "failed to generate token")
+	}
+
+	log.Printf("Issued service token for %s", req.ClientId)
+
+	return &pb.TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.serviceTokenTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// ServiceToken выпускает токен, которым auth подписывает свои вызовы других
+// сервисов. Подходит как authn.ServiceTokenSource.
+func (s *AuthService) ServiceToken(ctx context.Context) (string, time.Time, error) {
+	token, err := s.generateServiceToken(selfClientID, []string{authz.ScopeUserAuthenticate})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, time.Now().Add(s.serviceTokenTTL), nil
+}
+
+// generateServiceToken выпускает access-токен клиента client_credentials
+func (s *AuthService) generateServiceToken(clientID string, scopes []string) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := authn.Claims{
+		Roles:    []string{authz.RoleService},
+		Scope:    strings.Join(scopes, " "),
+		ClientID: clientID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   clientID,
+			Issuer:    s.issuer,
+			Audience:  s.audience,
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(s.serviceTokenTTL).Unix(),
+		},
+	}
+
+	key, err := s.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey())
+}
+
+// authenticateService проверяет, что вызывающий - сервис с токеном client_credentials
+func (s *AuthService) authenticateService(ctx context.Context) (*authn.Claims, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("authorization")) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no token provided")
+	}
+	return s.serviceClaims(ctx, md.Get("authorization")[0])
+}
+
+// serviceClaims проверяет токен сервиса, выпущенный по client_credentials
+func (s *AuthService) serviceClaims(ctx context.Context, token string) (*authn.Claims, error) {
+	claims, err := s.parseToken(token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if err := s.checkRevoked(ctx, claims); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "token is not valid")
+	}
+	if claims.ClientID == "" || !hasRole(claims, authz.RoleService) {
+		return nil, status.Error(codes.PermissionDenied, "service token required")
+	}
+
+	return claims, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
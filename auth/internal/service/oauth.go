@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"dd/auth/internal/oidc"
+	"dd/pkg/audit"
+	pb "dd/pkg/auth"
+	userpb "dd/pkg/user"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StartOAuth начинает вход через внешнего провайдера и возвращает адрес его
+// страницы входа. state, nonce и PKCE verifier остаются в Redis до CompleteOAuth.
+func (s *AuthService) StartOAuth(ctx context.Context, req *pb.StartOAuthRequest) (*pb.StartOAuthResponse, error) {
+	provider, ok := s.oauthProviders[req.Provider]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown provider %q", req.Provider)
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			log.Printf("Failed to start OAuth login: %v", err)
+			return nil, status.Error(codes.Internal, "failed to start login")
+		}
+		values[i] = v
+	}
+	state, nonce, codeVerifier := values[0], values[1], values[2]
+
+	if err := s.oauthStates.Save(ctx, state, oidc.AuthRequest{
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+	}); err != nil {
+		log.Printf("Failed to start OAuth login: %v", err)
+		return nil, status.Error(codes.Internal, "failed to start login")
+	}
+
+	return &pb.StartOAuthResponse{
+		AuthorizationUrl: provider.AuthCodeURL(state, nonce, codeVerifier),
+	}, nil
+}
+
+// CompleteOAuth обменивает код провайдера на ID токен, находит или создает
+// по нему пользователя и завершает вход так же, как Login
+func (s *AuthService) CompleteOAuth(ctx context.Context, req *pb.CompleteOAuthRequest) (*pb.LoginResponse, error) {
+	if req.State == "" || req.Code == "" {
+		return nil, status.Error(codes.InvalidArgument, "state and code are required")
+	}
+
+	authReq, err := s.oauthStates.Take(ctx, req.State)
+	if err != nil {
+		if errors.Is(err, oidc.ErrInvalidState) {
+			return nil, status.Error(codes.Unauthenticated, "invalid or expired state")
+		}
+		log.Printf("Failed to load OAuth state: %v", err)
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+	// state выдан для другого провайдера: код мог быть получен не от того, кого мы спрашивали
+	if authReq.Provider != req.Provider {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired state")
+	}
+
+	provider, ok := s.oauthProviders[req.Provider]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown provider %q", req.Provider)
+	}
+
+	identity, err := provider.Exchange(ctx, req.Code, authReq.CodeVerifier, authReq.Nonce)
+	if err != nil {
+		log.Printf("OAuth login via %s failed: %v", req.Provider, err)
+		s.record(ctx, audit.Event{Action: audit.ActionOAuthLogin, Result: audit.ResultFailure, Reason: req.Provider + ": identity token rejected"})
+		return nil, status.Error(codes.Unauthenticated, "authentication failed")
+	}
+
+	userResp, err := s.userClient.ResolveExternalIdentity(ctx, &userpb.ResolveExternalIdentityRequest{
+		Provider:      req.Provider,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+	})
+	if err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			s.record(ctx, audit.Event{Action: audit.ActionOAuthLogin, Target: identity.Email, Result: audit.ResultDenied, Reason: req.Provider + ": email is already registered"})
+			return nil, status.Error(codes.FailedPrecondition, "email is already registered, sign in with password")
+		}
+		log.Printf("Failed to resolve %s identity: %v", req.Provider, err)
+		return nil, status.Error(codes.Internal, "authentication failed")
+	}
+
+	resp, err := s.completeLogin(ctx, userResp.User)
+	if err != nil {
+		return nil, err
+	}
+	s.record(ctx, loginEvent(audit.ActionOAuthLogin, userResp.User, resp))
+	return resp, nil
+}
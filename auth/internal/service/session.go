@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"dd/auth/internal/refresh"
+	"dd/pkg/audit"
+	pb "dd/pkg/auth"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxUserAgentLength ограничивает User-Agent, сохраняемый в сессии
+const maxUserAgentLength = 256
+
+// ListSessions возвращает сессии вызывающего, в которых еще действует refresh-токен
+func (s *AuthService) ListSessions(ctx context.Context, req *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.refreshTokens.List(ctx, claims.Subject)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		return nil, status.Error(codes.Internal, "failed to list sessions")
+	}
+
+	// Сессии, начатые до RevokeAllSessions, уже не обновить, хотя их семейства еще не истекли
+	notBefore, err := s.revoked.NotBefore(ctx, claims.Subject)
+	if err != nil {
+		log.Printf("Failed to check user revocation: %v", err)
+		return nil, status.Error(codes.Internal, "failed to list sessions")
+	}
+
+	resp := &pb.ListSessionsResponse{}
+	for _, session := range sessions {
+		if !session.IssuedAt.After(notBefore) {
+			continue
+		}
+		resp.Sessions = append(resp.Sessions, &pb.Session{
+			Id:         session.FamilyID,
+			UserAgent:  session.Client.UserAgent,
+			Ip:         session.Client.IP,
+			CreatedAt:  session.IssuedAt.UTC().Format(time.RFC3339),
+			LastSeenAt: session.LastSeenAt.UTC().Format(time.RFC3339),
+			Current:    session.FamilyID == claims.SessionID,
+		})
+	}
+	return resp, nil
+}
+
+// RevokeSession завершает сессию вызывающего: ее refresh-токен перестает
+// обновляться, а выпущенные в ней access-токены - проходить проверку
+func (s *AuthService) RevokeSession(ctx context.Context, req *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	claims, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Id == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+
+	if err := s.refreshTokens.RevokeSession(ctx, claims.Subject, req.Id); err != nil {
+		if errors.Is(err, refresh.ErrSessionNotFound) {
+			return nil, status.Error(codes.NotFound, "session not found")
+		}
+		log.Printf("Failed to revoke session: %v", err)
+		return nil, status.Error(codes.Internal, "failed to revoke session")
+	}
+
+	log.Printf("User %s revoked session %s", claims.Subject, req.Id)
+	s.record(ctx, callerEvent(claims, audit.ActionSessionRevoke, req.Id))
+
+	return &pb.RevokeSessionResponse{}, nil
+}
+
+// userAgent возвращает User-Agent клиента, переданный proxy в метаданных x-user-agent
+func userAgent(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get("x-user-agent")
+	if len(values) == 0 {
+		return ""
+	}
+	if len(values[0]) > maxUserAgentLength {
+		return values[0][:maxUserAgentLength]
+	}
+	return values[0]
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"dd/auth/internal/throttle"
+	"dd/pkg/audit"
+	"dd/pkg/authn"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// clientIP возвращает адрес клиента по правилу журнала аудита: x-client-ip
+// только от proxy, иначе адрес соединения, см. audit.ClientIP
+func (s *AuthService) clientIP(ctx context.Context) string {
+	return audit.ClientIP(s.serviceContext(ctx), s.proxyClientID)
+}
+
+// serviceContext сохраняет в контексте сервис, передавший запрос, см.
+// authn.ServiceFromContext. В вызовах от имени пользователя authorization
+// занят токеном пользователя, и токен сервиса передается под ключом
+// authn.ServiceAuthorizationKey. auth сам выпускает токены и проверяет их
+// без authn.Verifier.
+func (s *AuthService) serviceContext(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	tokens := md.Get(authn.ServiceAuthorizationKey)
+	if len(tokens) == 0 {
+		tokens = md.Get("authorization")
+	}
+	if len(tokens) == 0 {
+		return ctx
+	}
+	claims, err := s.serviceClaims(ctx, tokens[0])
+	if err != nil {
+		return ctx
+	}
+	return authn.NewServiceContext(ctx, claims.ClientID)
+}
+
+// limitStatus превращает отказ ограничителя во gRPC статус: блокировка
+// учетной записи - PermissionDenied с ErrorInfo authn.ReasonAccountLocked,
+// торможение - ResourceExhausted. Время до следующей попытки передается в
+// деталях RetryInfo.
+func limitStatus(err *throttle.LimitError) error {
+	code := codes.ResourceExhausted
+	msg := "too many failed login attempts"
+	if err.Locked {
+		code = codes.PermissionDenied
+		msg = "account is temporarily locked"
+	}
+
+	st := status.New(code, msg)
+	retryAfter := err.RetryAfter.Round(time.Second)
+	if retryAfter < err.RetryAfter {
+		retryAfter += time.Second
+	}
+	if withDetails, detailsErr := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryAfter),
+	}); detailsErr == nil {
+		st = withDetails
+	}
+	if err.Locked {
+		if withDetails, detailsErr := st.WithDetails(&errdetails.ErrorInfo{
+			Domain: authn.ErrorDomain,
+			Reason: authn.ReasonAccountLocked,
+		}); detailsErr == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+func hasRole(claims *authn.Claims, role string) bool {
+	for _, r := range claims.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
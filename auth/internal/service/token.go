@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+	userpb "dd/pkg/user"
+
+	"github.com/golang-jwt/jwt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// generateToken выпускает access-токен для пользователя из user сервиса
+// в сессии sessionID
+func (s *AuthService) generateToken(user *userpb.User, sessionID string) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	// Пользователи, созданные до появления ролей, получают роль по умолчанию
+	role := user.Role
+	if role == "" {
+		role = authz.RoleUser
+	}
+
+	now := time.Now()
+	claims := authn.Claims{
+		Email:     user.Email,
+		Roles:     []string{role},
+		Scope:     strings.Join(authz.Scopes, " "),
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.Id,
+			Issuer:    s.issuer,
+			Audience:  s.audience,
+			Id:        jti,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(s.tokenTTL).Unix(),
+		},
+	}
+
+	key, err := s.keys.SigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey())
+}
+
+// parseToken проверяет подпись, алгоритм, срок действия, издателя и аудиторию токена
+func (s *AuthService) parseToken(tokenString string) (*authn.Claims, error) {
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	claims := &authn.Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys.VerificationKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+		// Алгоритм задается ключом, а не заголовком токена
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.PublicKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("token is not valid")
+	}
+
+	if !claims.VerifyIssuer(s.issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer: %q", claims.Issuer)
+	}
+	if !claims.VerifyAudience(s.audience, true) {
+		return nil, fmt.Errorf("unexpected audience: %q", claims.Audience)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("token has no subject")
+	}
+
+	return claims, nil
+}
+
+// checkRevoked сверяет токен со списком отзыва
+func (s *AuthService) checkRevoked(ctx context.Context, claims *authn.Claims) error {
+	revoked, err := s.revoked.IsRevoked(ctx, claims.Id)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("token is revoked")
+	}
+
+	notBefore, err := s.revoked.NotBefore(ctx, claims.Subject)
+	if err != nil {
+		return err
+	}
+	// iat хранится с точностью до секунды, поэтому токены, выпущенные
+	// в ту же секунду, что и отзыв, тоже считаются отозванными
+	if !notBefore.IsZero() && claims.IssuedAt <= notBefore.Unix() {
+		return fmt.Errorf("all sessions of the user are revoked")
+	}
+
+	if claims.SessionID != "" {
+		active, err := s.refreshTokens.Touch(ctx, claims.SessionID)
+		if err != nil {
+			return err
+		}
+		if !active {
+			return fmt.Errorf("session is revoked")
+		}
+	}
+
+	return nil
+}
+
+// authenticate проверяет access-токен вызывающего из метаданных запроса
+func (s *AuthService) authenticate(ctx context.Context) (*authn.Claims, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no metadata provided")
+	}
+
+	tokens := md.Get("authorization")
+	if len(tokens) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "no token provided")
+	}
+
+	claims, err := s.parseToken(tokens[0])
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if err := s.checkRevoked(ctx, claims); err != nil {
+		return nil, status.Error(codes.Unauthenticated, "token is not valid")
+	}
+	// Токен сервиса не представляет пользователя
+	if claims.ClientID != "" {
+		return nil, status.Error(codes.PermissionDenied, "service tokens are not accepted")
+	}
+
+	return claims, nil
+}
+
+// newTokenID генерирует случайный идентификатор токена (jti)
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
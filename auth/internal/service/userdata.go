@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	pb "dd/pkg/auth"
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ExportUserData возвращает сессии и API ключи пользователя для выгрузки его
+// данных. Доступен только user сервису: он же проверяет право субъекта на выгрузку.
+func (s *AuthService) ExportUserData(ctx context.Context, req *pb.ExportUserDataRequest) (*pb.ExportUserDataResponse, error) {
+	if _, err := s.authenticateUserData(ctx); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user id is required")
+	}
+
+	sessions, err := s.refreshTokens.List(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		return nil, status.Error(codes.Internal, "failed to export user data")
+	}
+	keys, err := s.apiKeys.List(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to list API keys: %v", err)
+		return nil, status.Error(codes.Internal, "failed to export user data")
+	}
+
+	resp := &pb.ExportUserDataResponse{}
+	for _, session := range sessions {
+		resp.Sessions = append(resp.Sessions, &pb.Session{
+			Id:         session.FamilyID,
+			UserAgent:  session.Client.UserAgent,
+			Ip:         session.Client.IP,
+			CreatedAt:  session.IssuedAt.UTC().Format(time.RFC3339),
+			LastSeenAt: session.LastSeenAt.UTC().Format(time.RFC3339),
+		})
+	}
+	for _, key := range keys {
+		resp.ApiKeys = append(resp.ApiKeys, apiKeyToProto(key))
+	}
+	return resp, nil
+}
+
+// PurgeUserData удаляет сессии и API ключи пользователя. Выпущенные
+// access-токены отзываются отметкой времени, как в RevokeAllSessions.
+// Повторный вызов ничего не меняет, поэтому его можно безопасно повторять.
+func (s *AuthService) PurgeUserData(ctx context.Context, req *pb.PurgeUserDataRequest) (*pb.PurgeUserDataResponse, error) {
+	caller, err := s.authenticateUserData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user id is required")
+	}
+
+	// Отметка ставится первой: пока данные удаляются, токены уже не принимаются
+	ttl := s.tokenTTL
+	if s.refreshTokenTTL > ttl {
+		ttl = s.refreshTokenTTL
+	}
+	if err := s.revoked.RevokeUser(ctx, req.UserId, time.Now(), ttl); err != nil {
+		log.Printf("Failed to revoke user sessions: %v", err)
+		return nil, status.Error(codes.Internal, "failed to purge user data")
+	}
+
+	sessions, err := s.refreshTokens.DeleteUser(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to delete sessions: %v", err)
+		return nil, status.Error(codes.Internal, "failed to purge user data")
+	}
+	keys, err := s.apiKeys.DeleteAll(ctx, req.UserId)
+	if err != nil {
+		log.Printf("Failed to delete API keys: %v", err)
+		return nil, status.Error(codes.Internal, "failed to purge user data")
+	}
+
+	log.Printf("Data of user %s purged by %s: %d sessions, %d API keys", req.UserId, caller.ClientID, sessions, keys)
+
+	return &pb.PurgeUserDataResponse{
+		Sessions: int32(sessions),
+		ApiKeys:  int32(keys),
+	}, nil
+}
+
+// authenticateUserData проверяет, что вызывающий - сервис с разрешением
+// authz.ScopeUserData. Его выдают только user сервису.
+func (s *AuthService) authenticateUserData(ctx context.Context) (*authn.Claims, error) {
+	claims, err := s.authenticateService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !contains(strings.Fields(claims.Scope), authz.ScopeUserData) {
+		return nil, status.Error(codes.PermissionDenied, "user data access is not allowed")
+	}
+	return claims, nil
+}
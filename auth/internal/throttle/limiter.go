@@ -0,0 +1,181 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	accountPrefix = "login:failures:account:"
+	ipPrefix      = "login:failures:ip:"
+	lockPrefix    = "login:locked:"
+)
+
+// Config задает пороги защиты входа от перебора паролей
+type Config struct {
+	// Window - сколько хранится счетчик неудачных попыток после последней из них
+	Window time.Duration
+	// AccountThreshold и IPThreshold - число неудач, после которого
+	// каждая следующая попытка откладывается с экспоненциальным ростом паузы
+	AccountThreshold int
+	IPThreshold      int
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+	// LockoutThreshold - число неудач подряд, после которого учетная запись
+	// блокируется на LockoutDuration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+// LimitError сообщает, что попытка входа сейчас не допускается
+type LimitError struct {
+	// Locked - учетная запись заблокирована, а не просто приторможена
+	Locked     bool
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account is locked, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter)
+}
+
+// Limiter считает неудачные попытки входа по учетной записи и по IP в Redis,
+// чтобы ограничения действовали для всех экземпляров auth сервиса
+type Limiter struct {
+	redisClient *redis.Client
+	cfg         Config
+}
+
+// NewLimiter создает ограничитель попыток входа
+func NewLimiter(redisClient *redis.Client, cfg Config) *Limiter {
+	return &Limiter{
+		redisClient: redisClient,
+		cfg:         cfg,
+	}
+}
+
+// Check возвращает *LimitError, если попытку входа в учетную запись email
+// с адреса ip нужно отклонить, не проверяя пароль
+func (l *Limiter) Check(ctx context.Context, email, ip string) error {
+	email = normalize(email)
+
+	ttl, err := l.redisClient.PTTL(ctx, lockPrefix+email).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check account lock: %v", err)
+	}
+	if ttl > 0 {
+		return &LimitError{Locked: true, RetryAfter: ttl}
+	}
+
+	if err := l.checkBackoff(ctx, accountPrefix+email, l.cfg.AccountThreshold); err != nil {
+		return err
+	}
+	if ip != "" {
+		if err := l.checkBackoff(ctx, ipPrefix+ip, l.cfg.IPThreshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Failure учитывает неудачную попытку и блокирует учетную запись при превышении порога
+func (l *Limiter) Failure(ctx context.Context, email, ip string) error {
+	email = normalize(email)
+
+	failures, err := l.recordFailure(ctx, accountPrefix+email)
+	if err != nil {
+		return err
+	}
+	if ip != "" {
+		if _, err := l.recordFailure(ctx, ipPrefix+ip); err != nil {
+			return err
+		}
+	}
+
+	if l.cfg.LockoutThreshold > 0 && failures >= l.cfg.LockoutThreshold {
+		_, err := l.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, lockPrefix+email, 1, l.cfg.LockoutDuration)
+			pipe.Del(ctx, accountPrefix+email)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to lock account: %v", err)
+		}
+	}
+	return nil
+}
+
+// Success сбрасывает счетчик неудач учетной записи после успешного входа.
+// Счетчик IP не сбрасывается: с одного адреса могут перебирать много учетных записей.
+func (l *Limiter) Success(ctx context.Context, email string) error {
+	if err := l.redisClient.Del(ctx, accountPrefix+normalize(email)).Err(); err != nil {
+		return fmt.Errorf("failed to reset login failures: %v", err)
+	}
+	return nil
+}
+
+// Unlock снимает блокировку и сбрасывает счетчик неудач учетной записи
+func (l *Limiter) Unlock(ctx context.Context, email string) error {
+	email = normalize(email)
+	if err := l.redisClient.Del(ctx, lockPrefix+email, accountPrefix+email).Err(); err != nil {
+		return fmt.Errorf("failed to unlock account: %v", err)
+	}
+	return nil
+}
+
+func (l *Limiter) checkBackoff(ctx context.Context, key string, threshold int) error {
+	values, err := l.redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load login failures: %v", err)
+	}
+
+	failures, _ := strconv.Atoi(values["count"])
+	if threshold <= 0 || failures < threshold {
+		return nil
+	}
+	last, _ := strconv.ParseInt(values["last"], 10, 64)
+
+	wait := l.backoff(failures - threshold)
+	retryAfter := time.Until(time.UnixMilli(last).Add(wait))
+	if retryAfter > 0 {
+		return &LimitError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// backoff возвращает паузу после n-й неудачи сверх порога: base, 2*base, 4*base, ... до max
+func (l *Limiter) backoff(n int) time.Duration {
+	wait := l.cfg.BackoffBase
+	for i := 0; i < n && wait < l.cfg.BackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > l.cfg.BackoffMax {
+		wait = l.cfg.BackoffMax
+	}
+	return wait
+}
+
+func (l *Limiter) recordFailure(ctx context.Context, key string) (int, error) {
+	var count *redis.IntCmd
+	_, err := l.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.HIncrBy(ctx, key, "count", 1)
+		pipe.HSet(ctx, key, "last", time.Now().UnixMilli())
+		pipe.Expire(ctx, key, l.cfg.Window)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %v", err)
+	}
+	return int(count.Val()), nil
+}
+
+func normalize(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -1,25 +1,126 @@
 package main
 
 import (
+    "context"
+    "crypto/rand"
+    "database/sql"
+    "encoding/hex"
+    "fmt"
     "log"
     "net"
+    "net/http"
+    "time"
+    _ "github.com/lib/pq"
+    "github.com/redis/go-redis/v9"
     "google.golang.org/grpc"
+    "dd/auth/internal/apikey"
     "dd/auth/internal/config"
+    "dd/auth/internal/keys"
+    "dd/auth/internal/oidc/fakeidp"
     "dd/auth/internal/service"
+    "dd/pkg/audit"
     pb "dd/pkg/auth"
+    "dd/pkg/authn"
+    "dd/pkg/secretbox"
 )
 
 func main() {
     cfg := config.New()
 
+    // Вызовы user сервиса подписываются токеном сервиса, который auth выпускает сам.
+    // Сервис создается позже соединения, поэтому токен берется через замыкание.
+    var authService *service.AuthService
+    serviceCreds := authn.NewServiceCredentials(authn.ServiceTokenFunc(func(ctx context.Context) (string, time.Time, error) {
+        return authService.ServiceToken(ctx)
+    }))
+
     // Подключаемся к сервису пользователей
-    userConn, err := grpc.Dial(cfg.UserService, grpc.WithInsecure())
+    userConn, err := grpc.Dial(cfg.UserService, grpc.WithInsecure(), grpc.WithPerRPCCredentials(serviceCreds))
     if err != nil {
         log.Fatalf("failed to connect to user service: %v", err)
     }
     defer userConn.Close()
 
-    
+    // Подключаемся к Redis
+    redisClient := redis.NewClient(&redis.Options{
+        Addr: cfg.RedisAddr,
+    })
+    defer redisClient.Close()
+
+    // Подключаемся к базе данных с API ключами
+    dbURL := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+        cfg.DB.Host, cfg.DB.Port, cfg.DB.User, cfg.DB.Password, cfg.DB.DBName)
+
+    db, err := sql.Open("postgres", dbURL)
+    if err != nil {
+        log.Fatalf("failed to connect to database: %v", err)
+    }
+    defer db.Close()
+
+    if err := db.Ping(); err != nil {
+        log.Fatalf("failed to ping database: %v", err)
+    }
+
+    // Загружаем ключи подписи и запускаем их плановую ротацию
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    keyBox, err := secretbox.New(cfg.SigningKeyEncryptionKey)
+    if err != nil {
+        log.Fatalf("invalid AUTH_SIGNING_KEY_ENCRYPTION_KEY: %v", err)
+    }
+    keyManager := keys.NewManager(redisClient, keyBox, cfg.JWTAlgorithm, cfg.KeyRotationInterval, cfg.TokenTTL)
+    if err := keyManager.Init(ctx); err != nil {
+        log.Fatalf("failed to init signing keys: %v", err)
+    }
+    go keyManager.Run(ctx)
+
+    // Таблицу журнала аудита создают миграции user сервиса: если auth
+    // запущен раньше, он ждет их, а не теряет события
+    auditLog := audit.NewPostgres(db, "auth", audit.WithProxy(cfg.ProxyClientID))
+    initCtx, cancelInit := context.WithTimeout(ctx, 2*time.Minute)
+    err = auditLog.Init(initCtx)
+    cancelInit()
+    if err != nil {
+        log.Fatalf("failed to init audit log: %v", err)
+    }
+    go auditLog.Run(ctx)
+
+    // Таблицу API ключей и ее колонки тоже создают миграции user сервиса
+    initCtx, cancelInit = context.WithTimeout(ctx, 2*time.Minute)
+    err = apikey.NewStore(db).Init(initCtx)
+    cancelInit()
+    if err != nil {
+        log.Fatalf("failed to init API keys: %v", err)
+    }
+
+    // Встроенный провайдер "mock" позволяет проверить вход через OIDC без внешней сети.
+    // Он впускает под любым email, поэтому запускается только в dev окружении;
+    // секрет клиента живет столько же, сколько процесс.
+    if mock, ok := cfg.OAuthProviders["mock"]; ok && cfg.MockIdPAddr != "" {
+        log.Printf("WARNING: mock identity provider is enabled, it signs in as any email without a password")
+
+        secret := make([]byte, 32)
+        if _, err := rand.Read(secret); err != nil {
+            log.Fatalf("failed to generate mock client secret: %v", err)
+        }
+        mock.ClientID = "dd"
+        mock.ClientSecret = hex.EncodeToString(secret)
+        cfg.OAuthProviders["mock"] = mock
+
+        idp, err := fakeidp.New(mock.Issuer)
+        if err != nil {
+            log.Fatalf("failed to create mock identity provider: %v", err)
+        }
+        idp.RegisterClient(mock.ClientID, mock.ClientSecret, mock.RedirectURL)
+
+        go func() {
+            log.Printf("Starting mock identity provider on %s", cfg.MockIdPAddr)
+            if err := http.ListenAndServe(cfg.MockIdPAddr, idp.Handler()); err != nil {
+                log.Fatalf("mock identity provider failed: %v", err)
+            }
+        }()
+    }
 
     // Создаем gRPC сервер
     lis, err := net.Listen("tcp", cfg.GRPCPort)
@@ -28,7 +129,7 @@ func main() {
     }
 
     grpcServer := grpc.NewServer()
-    authService := service.New(userConn, cfg.JWTSecret)
+    authService = service.New(userConn, redisClient, db, keyManager, auditLog, cfg)
     pb.RegisterAuthServiceServer(grpcServer, authService)
 
     log.Printf("Starting Auth service on port %s", cfg.GRPCPort)
@@ -1,11 +1,37 @@
 package config
 
+import (
+    "os"
+    "time"
+)
+
 type Config struct {
-    GRPCPort string
+    GRPCPort           string
+    AuthService        string
+    // ClientID и ClientSecret - учетные данные geo для получения токена сервиса.
+    // Секрет задается переменной GEO_CLIENT_SECRET и должен совпадать с
+    // AUTH_GEO_CLIENT_SECRET auth сервиса.
+    ClientID           string
+    ClientSecret       string
+    RedisAddr          string
+    JWTIssuer          string
+    JWTAudience        string
+    RevocationCacheTTL time.Duration
+    // DailyQuota - сколько запросов к DaData вызывающий может сделать за сутки,
+    // если у его API ключа нет своей квоты; 0 - без ограничения
+    DailyQuota         int
 }
 
 func New() *Config {
     return &Config{
-        GRPCPort: ":50052",
+        GRPCPort:           ":50052",
+        AuthService:        "auth:50051",
+        ClientID:           "geo",
+        ClientSecret:       os.Getenv("GEO_CLIENT_SECRET"),
+        RedisAddr:          "redis:6379",
+        JWTIssuer:          "dd-auth",
+        JWTAudience:        "dd-api",
+        RevocationCacheTTL: 30 * time.Second,
+        DailyQuota:         1000,
     }
-} 
\ No newline at end of file
+}
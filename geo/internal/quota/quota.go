@@ -0,0 +1,131 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const usagePrefix = "quota:usage:"
+
+// ExceededError сообщает, что суточная квота вызывающего исчерпана
+type ExceededError struct {
+	Limit int
+	// RetryAfter - время до начала следующих суток, когда квота обновится
+	RetryAfter time.Duration
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("daily quota of %d requests exceeded, retry after %s", e.Limit, e.RetryAfter)
+}
+
+// Limiter считает запросы вызывающих за сутки (UTC) в Redis, чтобы квота
+// действовала для всех экземпляров сервиса
+type Limiter struct {
+	redisClient *redis.Client
+	now         func() time.Time
+}
+
+// NewLimiter создает счетчик суточных квот
+func NewLimiter(redisClient *redis.Client) *Limiter {
+	return &Limiter{
+		redisClient: redisClient,
+		now:         time.Now,
+	}
+}
+
+// Take учитывает один запрос вызывающего principal. Если за текущие сутки
+// запросов уже больше limit, возвращает *ExceededError. limit <= 0 - без ограничения.
+func (l *Limiter) Take(ctx context.Context, principal string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	now := l.now().UTC()
+	dayEnd := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	key := usagePrefix + now.Format("2006-01-02") + ":" + principal
+
+	pipe := l.redisClient.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	// Счетчик живет чуть дольше суток, чтобы не зависеть от расхождения часов
+	pipe.ExpireAt(ctx, key, dayEnd.Add(time.Hour))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to count request: %v", err)
+	}
+
+	if incr.Val() > int64(limit) {
+		return &ExceededError{Limit: limit, RetryAfter: dayEnd.Sub(now)}
+	}
+	return nil
+}
+
+// DailyUsage - число запросов за сутки (UTC)
+type DailyUsage struct {
+	// Date - дата в формате 2006-01-02
+	Date     string
+	Requests int64
+}
+
+// Usage возвращает число запросов principal по дням, начиная с самого раннего.
+// Счетчики хранятся чуть больше суток, поэтому дней обычно не больше двух.
+func (l *Limiter) Usage(ctx context.Context, principal string) ([]DailyUsage, error) {
+	keys, err := l.keys(ctx, principal)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	values, err := l.redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %v", err)
+	}
+
+	var usage []DailyUsage
+	for i, key := range keys {
+		s, ok := values[i].(string)
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			continue
+		}
+		usage = append(usage, DailyUsage{
+			Date:     strings.TrimPrefix(key, usagePrefix)[:len("2006-01-02")],
+			Requests: n,
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Date < usage[j].Date })
+	return usage, nil
+}
+
+// Reset удаляет счетчики principal за все дни
+func (l *Limiter) Reset(ctx context.Context, principal string) error {
+	keys, err := l.keys(ctx, principal)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	if err := l.redisClient.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to reset usage: %v", err)
+	}
+	return nil
+}
+
+func (l *Limiter) keys(ctx context.Context, principal string) ([]string, error) {
+	var keys []string
+	iter := l.redisClient.Scan(ctx, 0, usagePrefix+"????-??-??:"+principal, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list usage: %v", err)
+	}
+	return keys, nil
+}
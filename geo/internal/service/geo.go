@@ -3,19 +3,22 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
-	"google.golang.org/grpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"dd/geo/internal/dadata"
 	"dd/geo/internal/domain"
-	pb_auth "dd/pkg/auth"
+	"dd/geo/internal/quota"
+	"dd/pkg/authn"
 	pb "dd/pkg/geo"
 )
 
@@ -23,55 +26,87 @@ const (
 	searchCachePrefix = "search:"
 	geoCachePrefix    = "geo:"
 	cacheDuration     = 24 * time.Hour
+	// lookupsPrefix - множество ключей кэша, которые запрашивал пользователь.
+	// По нему выгружаются и удаляются его данные, см. ExportUserData.
+	lookupsPrefix = "lookups:user:"
 )
 
 type GeoService struct {
 	pb.UnimplementedGeoServiceServer
-	authClient  pb_auth.AuthServiceClient
+	verifier    *authn.Verifier
 	redisClient *redis.Client
 	geoProvider domain.GeoProvider
+	quota       *quota.Limiter
+	// dailyQuota - суточная квота запросов к провайдеру для вызывающих без собственной квоты
+	dailyQuota int
 }
 
-func New(authConn *grpc.ClientConn, redisClient *redis.Client) *GeoService {
+func New(verifier *authn.Verifier, redisClient *redis.Client, dailyQuota int) *GeoService {
 	provider := dadata.NewProvider(
 		"627de73a10855ebb80eb0191f2bbb55cc72eef89",
 		"7886bc85cac2562af90304564e7f04078d18dc4b",
 	)
 
 	return &GeoService{
-		authClient:  pb_auth.NewAuthServiceClient(authConn),
+		verifier:    verifier,
 		redisClient: redisClient,
 		geoProvider: provider,
+		quota:       quota.NewLimiter(redisClient),
+		dailyQuota:  dailyQuota,
 	}
 }
 
-func (s *GeoService) validateToken(ctx context.Context) error {
-	md, ok := metadata.FromIncomingContext(ctx)
+// authenticate проверяет токен вызывающего локально, по ключам auth сервиса,
+// либо API ключ, и права вызывающего на операцию op
+func (s *GeoService) authenticate(ctx context.Context, op string) (*authn.Principal, error) {
+	principal, ok, err := authn.Authenticate(ctx, s.verifier)
 	if !ok {
-		return status.Error(codes.Unauthenticated, "no metadata provided")
+		return nil, status.Error(codes.Unauthenticated, "no token provided")
 	}
-
-	tokens := md.Get("authorization")
-	if len(tokens) == 0 {
-		return status.Error(codes.Unauthenticated, "no token provided")
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "token is not valid")
 	}
 
-	resp, err := s.authClient.ValidateToken(ctx, &pb_auth.ValidateTokenRequest{
-		Token: tokens[0],
-	})
-	if err != nil {
-		return status.Error(codes.Unauthenticated, "invalid token")
+	if err := Policy.Authorize(authn.NewContext(ctx, principal), op, nil); err != nil {
+		return nil, err
 	}
 
-	if !resp.Valid {
-		return status.Error(codes.Unauthenticated, "token is not valid")
+	return principal, nil
+}
+
+// takeQuota учитывает запрос к провайдеру в суточной квоте вызывающего.
+// Ответы из кэша в квоту не входят.
+func (s *GeoService) takeQuota(ctx context.Context, p *authn.Principal) error {
+	limit := s.dailyQuota
+	id := userQuotaID(p.UserID)
+	if p.APIKeyID != "" {
+		id = "key:" + p.APIKeyID
+		if p.DailyQuota > 0 {
+			limit = p.DailyQuota
+		}
 	}
 
+	err := s.quota.Take(ctx, id, limit)
+	var exceeded *quota.ExceededError
+	if errors.As(err, &exceeded) {
+		st := status.New(codes.ResourceExhausted, "daily quota exceeded")
+		if withDetails, detailsErr := st.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(exceeded.RetryAfter),
+		}); detailsErr == nil {
+			st = withDetails
+		}
+		return st.Err()
+	}
+	if err != nil {
+		// Без Redis квоту проверить нельзя, но отказывать из-за этого в поиске не стоит
+		log.Printf("Failed to check quota of %s: %v", id, err)
+	}
 	return nil
 }
 
 func (s *GeoService) SearchAddress(ctx context.Context, req *pb.SearchAddressRequest) (*pb.SearchAddressResponse, error) {
-	if err := s.validateToken(ctx); err != nil {
+	principal, err := s.authenticate(ctx, "/geo.GeoService/SearchAddress")
+	if err != nil {
 		return nil, err
 	}
 
@@ -80,10 +115,15 @@ func (s *GeoService) SearchAddress(ctx context.Context, req *pb.SearchAddressReq
 	if cached, err := s.redisClient.Get(ctx, cacheKey).Result(); err == nil {
 		var response pb.SearchAddressResponse
 		if err := json.Unmarshal([]byte(cached), &response); err == nil {
+			s.rememberLookup(ctx, principal, cacheKey)
 			return &response, nil
 		}
 	}
 
+	if err := s.takeQuota(ctx, principal); err != nil {
+		return nil, err
+	}
+
 	addresses, err := s.geoProvider.AddressSearch(req.Query)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to search address: %v", err))
@@ -108,12 +148,14 @@ func (s *GeoService) SearchAddress(ctx context.Context, req *pb.SearchAddressReq
 	if cached, err := json.Marshal(response); err == nil {
 		s.redisClient.Set(ctx, cacheKey, string(cached), cacheDuration)
 	}
+	s.rememberLookup(ctx, principal, cacheKey)
 
 	return response, nil
 }
 
 func (s *GeoService) Geocode(ctx context.Context, req *pb.GeocodeRequest) (*pb.GeocodeResponse, error) {
-	if err := s.validateToken(ctx); err != nil {
+	principal, err := s.authenticate(ctx, "/geo.GeoService/Geocode")
+	if err != nil {
 		return nil, err
 	}
 
@@ -130,10 +172,15 @@ func (s *GeoService) Geocode(ctx context.Context, req *pb.GeocodeRequest) (*pb.G
 	if cached, err := s.redisClient.Get(ctx, cacheKey).Result(); err == nil {
 		var response pb.GeocodeResponse
 		if err := json.Unmarshal([]byte(cached), &response); err == nil {
+			s.rememberLookup(ctx, principal, cacheKey)
 			return &response, nil
 		}
 	}
 
+	if err := s.takeQuota(ctx, principal); err != nil {
+		return nil, err
+	}
+
 	addresses, err := s.geoProvider.GeoCode(lat, lon)
 	if err != nil {
 		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to geocode: %v", err))
@@ -162,6 +209,30 @@ func (s *GeoService) Geocode(ctx context.Context, req *pb.GeocodeRequest) (*pb.G
 	if cached, err := json.Marshal(response); err == nil {
 		s.redisClient.Set(ctx, cacheKey, string(cached), cacheDuration)
 	}
+	s.rememberLookup(ctx, principal, cacheKey)
 
 	return response, nil
 }
+
+// rememberLookup связывает ключ кэша с пользователем. Множество живет столько
+// же, сколько кэш, и продлевается каждым запросом. Запросы сервисов от своего
+// имени пользователю не принадлежат и не запоминаются.
+func (s *GeoService) rememberLookup(ctx context.Context, p *authn.Principal, cacheKey string) {
+	if p.ClientID != "" || p.UserID == "" {
+		return
+	}
+	key := lookupsPrefix + p.UserID
+	_, err := s.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, key, cacheKey)
+		pipe.Expire(ctx, key, cacheDuration)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to remember lookup of user %s: %v", p.UserID, err)
+	}
+}
+
+// userQuotaID - идентификатор пользователя в счетчиках квоты
+func userQuotaID(userID string) string {
+	return "user:" + userID
+}
@@ -2,19 +2,27 @@ package service
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"testing"
+	"time"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"dd/geo/internal/dadata"
 	"dd/geo/internal/domain"
+	"dd/geo/internal/quota"
 	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
 	pb "dd/pkg/geo"
 )
 
@@ -36,6 +44,119 @@ func (m *MockAuthClient) ValidateToken(ctx context.Context, req *pb_auth.Validat
 	return args.Get(0).(*pb_auth.ValidateTokenResponse), args.Error(1)
 }
 
+func (m *MockAuthClient) RefreshToken(ctx context.Context, req *pb_auth.RefreshTokenRequest, opts ...grpc.CallOption) (*pb_auth.RefreshTokenResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) Logout(ctx context.Context, req *pb_auth.LogoutRequest, opts ...grpc.CallOption) (*pb_auth.LogoutResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) RevokeToken(ctx context.Context, req *pb_auth.RevokeTokenRequest, opts ...grpc.CallOption) (*pb_auth.RevokeTokenResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) RevokeAllSessions(ctx context.Context, req *pb_auth.RevokeAllSessionsRequest, opts ...grpc.CallOption) (*pb_auth.RevokeAllSessionsResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) GetJWKS(ctx context.Context, req *pb_auth.GetJWKSRequest, opts ...grpc.CallOption) (*pb_auth.GetJWKSResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) UnlockAccount(ctx context.Context, req *pb_auth.UnlockAccountRequest, opts ...grpc.CallOption) (*pb_auth.UnlockAccountResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) VerifyMFA(ctx context.Context, req *pb_auth.VerifyMFARequest, opts ...grpc.CallOption) (*pb_auth.VerifyMFAResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) StartOAuth(ctx context.Context, req *pb_auth.StartOAuthRequest, opts ...grpc.CallOption) (*pb_auth.StartOAuthResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) CompleteOAuth(ctx context.Context, req *pb_auth.CompleteOAuthRequest, opts ...grpc.CallOption) (*pb_auth.LoginResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) CreateAPIKey(ctx context.Context, req *pb_auth.CreateAPIKeyRequest, opts ...grpc.CallOption) (*pb_auth.CreateAPIKeyResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) ListAPIKeys(ctx context.Context, req *pb_auth.ListAPIKeysRequest, opts ...grpc.CallOption) (*pb_auth.ListAPIKeysResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) RevokeAPIKey(ctx context.Context, req *pb_auth.RevokeAPIKeyRequest, opts ...grpc.CallOption) (*pb_auth.RevokeAPIKeyResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) ValidateAPIKey(ctx context.Context, req *pb_auth.ValidateAPIKeyRequest, opts ...grpc.CallOption) (*pb_auth.ValidateAPIKeyResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.ValidateAPIKeyResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) Token(ctx context.Context, req *pb_auth.TokenRequest, opts ...grpc.CallOption) (*pb_auth.TokenResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) ListSessions(ctx context.Context, req *pb_auth.ListSessionsRequest, opts ...grpc.CallOption) (*pb_auth.ListSessionsResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) RevokeSession(ctx context.Context, req *pb_auth.RevokeSessionRequest, opts ...grpc.CallOption) (*pb_auth.RevokeSessionResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) ExportUserData(ctx context.Context, req *pb_auth.ExportUserDataRequest, opts ...grpc.CallOption) (*pb_auth.ExportUserDataResponse, error) {
+	return nil, nil
+}
+
+func (m *MockAuthClient) PurgeUserData(ctx context.Context, req *pb_auth.PurgeUserDataRequest, opts ...grpc.CallOption) (*pb_auth.PurgeUserDataResponse, error) {
+	return nil, nil
+}
+
+// Ключ, которым в тестах подписываются токены вместо auth сервиса
+var _, testSigningKey, _ = ed25519.GenerateKey(rand.Reader)
+
+type testKeySource struct{}
+
+func (testKeySource) Keys(ctx context.Context) ([]authn.JSONWebKey, error) {
+	return []authn.JSONWebKey{
+		authn.NewJSONWebKey("test-key", "EdDSA", testSigningKey.Public()),
+	}, nil
+}
+
+// testToken выпускает токен, подписанный тестовым ключом
+func testToken(t *testing.T, expiresAt time.Time) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, authn.Claims{
+		Email: "test@example.com",
+		Scope: "geo:search geo:geocode",
+		StandardClaims: jwt.StandardClaims{
+			Id:        "test-jti",
+			Subject:   "user-1",
+			Issuer:    "test-issuer",
+			Audience:  "test-audience",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(testSigningKey)
+	assert.NoError(t, err)
+	return signed
+}
+
+func authContext(t *testing.T) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer "+testToken(t, time.Now().Add(time.Hour)),
+	))
+}
+
 // MockDaDataProvider - мок для DaData провайдера
 type MockDaDataProvider struct {
 	mock.Mock
@@ -62,10 +183,17 @@ func setupTest(t *testing.T) (*GeoService, *MockAuthClient, *MockDaDataProvider,
 	mockAuth := &MockAuthClient{}
 	mockDaData := &MockDaDataProvider{}
 
+	// Кэши проверки отзыва и API ключей выключены, чтобы каждый запрос доходил до мока
+	verifier := authn.NewVerifier(testKeySource{}, "test-issuer", "test-audience",
+		authn.WithRevocationCheck(authn.NewAuthServiceRevocationChecker(mockAuth), 0),
+		authn.WithAPIKeys(authn.NewAuthServiceAPIKeyResolver(mockAuth), 0))
+
 	service := &GeoService{
-		authClient:  mockAuth,
+		verifier:    verifier,
 		redisClient: redisClient,
 		geoProvider: mockDaData,
+		quota:       quota.NewLimiter(redisClient),
+		dailyQuota:  100,
 	}
 
 	cleanup := func() {
@@ -105,9 +233,7 @@ func TestGeoService_SearchAddress(t *testing.T) {
 		mockDaData.On("AddressSearch", "сухаревская 11").Return(mockAddresses, nil)
 
 		// Выполняем запрос
-		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
-			"authorization", "test-token",
-		))
+		ctx := authContext(t)
 		resp, err := service.SearchAddress(ctx, &pb.SearchAddressRequest{
 			Query: "сухаревская 11",
 		})
@@ -140,6 +266,56 @@ func TestGeoService_SearchAddress(t *testing.T) {
 		// Проверяем, что AddressSearch не был вызван
 		mockDaData.AssertNotCalled(t, "AddressSearch")
 	})
+
+	t.Run("revoked token", func(t *testing.T) {
+		mockAuth.ExpectedCalls = nil
+		mockDaData.ExpectedCalls = nil
+		mockAuth.On("ValidateToken", mock.Anything, mock.Anything).Return(&pb_auth.ValidateTokenResponse{
+			Valid: false,
+		}, nil)
+
+		resp, err := service.SearchAddress(authContext(t), &pb.SearchAddressRequest{
+			Query: "test",
+		})
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Nil(t, resp)
+		mockDaData.AssertNotCalled(t, "AddressSearch")
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		mockAuth.ExpectedCalls = nil
+		mockDaData.ExpectedCalls = nil
+
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+testToken(t, time.Now().Add(-time.Minute)),
+		))
+		resp, err := service.SearchAddress(ctx, &pb.SearchAddressRequest{
+			Query: "test",
+		})
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Nil(t, resp)
+		mockAuth.AssertNotCalled(t, "ValidateToken")
+	})
+
+	t.Run("auth service unavailable", func(t *testing.T) {
+		service.redisClient.FlushAll(context.Background())
+		mockAuth.ExpectedCalls = nil
+		mockDaData.ExpectedCalls = nil
+		mockAuth.On("ValidateToken", mock.Anything, mock.Anything).Return(
+			(*pb_auth.ValidateTokenResponse)(nil), status.Error(codes.Unavailable, "connection refused"))
+		mockDaData.On("AddressSearch", "test").Return([]*domain.Address{
+			{City: "Москва", Street: "Тестовая", House: "1"},
+		}, nil)
+
+		resp, err := service.SearchAddress(authContext(t), &pb.SearchAddressRequest{
+			Query: "test",
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, resp.Addresses, 1)
+	})
 }
 
 func TestGeoService_Geocode(t *testing.T) {
@@ -162,9 +338,7 @@ func TestGeoService_Geocode(t *testing.T) {
 		}
 		mockDaData.On("GeoCode", "55.77412", "37.624065").Return(mockAddresses, nil)
 
-		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
-			"authorization", "test-token",
-		))
+		ctx := authContext(t)
 		resp, err := service.Geocode(ctx, &pb.GeocodeRequest{
 			Address: "55.77412,37.624065",
 		})
@@ -176,6 +350,137 @@ func TestGeoService_Geocode(t *testing.T) {
 	})
 }
 
+func TestGeoService_APIKey(t *testing.T) {
+	service, mockAuth, mockDaData, cleanup := setupTest(t)
+	defer cleanup()
+
+	keyContext := func(key string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"x-api-key", key,
+		))
+	}
+
+	t.Run("key with scope", func(t *testing.T) {
+		service.redisClient.FlushAll(context.Background())
+		mockAuth.ExpectedCalls = nil
+		mockDaData.ExpectedCalls = nil
+		mockAuth.On("ValidateAPIKey", mock.Anything, &pb_auth.ValidateAPIKeyRequest{Key: "ddk_search"}).
+			Return(&pb_auth.ValidateAPIKeyResponse{
+				Valid:  true,
+				KeyId:  "key-1",
+				UserId: "user-1",
+				Scopes: []string{"geo:search"},
+			}, nil)
+		mockDaData.On("AddressSearch", "test").Return([]*domain.Address{
+			{City: "Москва", Street: "Тестовая", House: "1"},
+		}, nil)
+
+		resp, err := service.SearchAddress(keyContext("ddk_search"), &pb.SearchAddressRequest{
+			Query: "test",
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, resp.Addresses, 1)
+	})
+
+	t.Run("key without scope", func(t *testing.T) {
+		mockAuth.ExpectedCalls = nil
+		mockDaData.ExpectedCalls = nil
+		mockAuth.On("ValidateAPIKey", mock.Anything, mock.Anything).
+			Return(&pb_auth.ValidateAPIKeyResponse{
+				Valid:  true,
+				KeyId:  "key-1",
+				UserId: "user-1",
+				Scopes: []string{"geo:search"},
+			}, nil)
+
+		resp, err := service.Geocode(keyContext("ddk_search"), &pb.GeocodeRequest{
+			Address: "55.77412,37.624065",
+		})
+
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Nil(t, resp)
+		mockDaData.AssertNotCalled(t, "GeoCode")
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		mockAuth.ExpectedCalls = nil
+		mockDaData.ExpectedCalls = nil
+		mockAuth.On("ValidateAPIKey", mock.Anything, mock.Anything).
+			Return(&pb_auth.ValidateAPIKeyResponse{Valid: false}, nil)
+
+		resp, err := service.SearchAddress(keyContext("ddk_revoked"), &pb.SearchAddressRequest{
+			Query: "test",
+		})
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		assert.Nil(t, resp)
+		mockDaData.AssertNotCalled(t, "AddressSearch")
+	})
+}
+
+func TestGeoService_Quota(t *testing.T) {
+	service, mockAuth, mockDaData, cleanup := setupTest(t)
+	defer cleanup()
+	service.dailyQuota = 2
+
+	mockAuth.On("ValidateToken", mock.Anything, mock.Anything).Return(&pb_auth.ValidateTokenResponse{
+		Valid: true,
+	}, nil)
+	mockDaData.On("AddressSearch", mock.Anything).Return([]*domain.Address{
+		{City: "Москва", Street: "Тестовая", House: "1"},
+	}, nil)
+
+	t.Run("exhausted", func(t *testing.T) {
+		for _, query := range []string{"first", "second"} {
+			_, err := service.SearchAddress(authContext(t), &pb.SearchAddressRequest{Query: query})
+			assert.NoError(t, err)
+		}
+
+		// Ответ из кэша не расходует квоту
+		_, err := service.SearchAddress(authContext(t), &pb.SearchAddressRequest{Query: "first"})
+		assert.NoError(t, err)
+
+		_, err = service.SearchAddress(authContext(t), &pb.SearchAddressRequest{Query: "third"})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		mockDaData.AssertNumberOfCalls(t, "AddressSearch", 2)
+
+		var retryInfo *errdetails.RetryInfo
+		for _, detail := range status.Convert(err).Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				retryInfo = info
+			}
+		}
+		if assert.NotNil(t, retryInfo) {
+			assert.True(t, retryInfo.RetryDelay.AsDuration() > 0)
+			assert.True(t, retryInfo.RetryDelay.AsDuration() <= 24*time.Hour)
+		}
+	})
+
+	t.Run("api key has own quota", func(t *testing.T) {
+		mockAuth.On("ValidateAPIKey", mock.Anything, mock.Anything).
+			Return(&pb_auth.ValidateAPIKeyResponse{
+				Valid:      true,
+				KeyId:      "key-1",
+				UserId:     "user-1",
+				Scopes:     []string{"geo:search"},
+				DailyQuota: 3,
+			}, nil)
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"x-api-key", "ddk_search",
+		))
+
+		// Квота ключа не зависит от исчерпанной квоты владельца
+		for _, query := range []string{"k1", "k2", "k3"} {
+			_, err := service.SearchAddress(ctx, &pb.SearchAddressRequest{Query: query})
+			assert.NoError(t, err)
+		}
+
+		_, err := service.SearchAddress(ctx, &pb.SearchAddressRequest{Query: "k4"})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+}
+
 func TestGeoService_SearchAddress_CachedResponse(t *testing.T) {
 	service, mockAuth, mockDaData, cleanup := setupTest(t)
 	defer cleanup()
@@ -200,9 +505,7 @@ func TestGeoService_SearchAddress_CachedResponse(t *testing.T) {
 		}
 		mockDaData.On("AddressSearch", "test cache").Return(mockAddresses, nil).Once()
 
-		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
-			"authorization", "test-token",
-		))
+		ctx := authContext(t)
 
 		// Первый запрос (сохранение в кэш)
 		firstResp, err := service.SearchAddress(ctx, &pb.SearchAddressRequest{
@@ -246,9 +549,7 @@ func TestGeoService_SearchAddress_CachedResponse(t *testing.T) {
 		}
 		mockDaData.On("AddressSearch", "test cache").Return(mockAddresses, nil).Once()
 
-		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
-			"authorization", "test-token",
-		))
+		ctx := authContext(t)
 
 		// Первый запрос (сохранение в кэш)
 		firstResp, err := service.SearchAddress(ctx, &pb.SearchAddressRequest{
@@ -273,6 +574,101 @@ func TestGeoService_SearchAddress_CachedResponse(t *testing.T) {
 	})
 }
 
+// serviceContext - вызов от имени user сервиса
+func serviceContext(t *testing.T) context.Context {
+	return clientContext(t, "user", "user:data")
+}
+
+// clientContext - вызов от имени сервиса clientID с разрешениями scope
+func clientContext(t *testing.T, clientID, scope string) context.Context {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, authn.Claims{
+		Roles:    []string{"service"},
+		Scope:    scope,
+		ClientID: clientID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        "service-jti",
+			Subject:   clientID,
+			Issuer:    "test-issuer",
+			Audience:  "test-audience",
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(testSigningKey)
+	assert.NoError(t, err)
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer "+signed,
+	))
+}
+
+func TestGeoService_UserData(t *testing.T) {
+	service, mockAuth, mockDaData, cleanup := setupTest(t)
+	defer cleanup()
+
+	mockAuth.On("ValidateToken", mock.Anything, mock.Anything).Return(&pb_auth.ValidateTokenResponse{
+		Valid: true,
+	}, nil)
+	mockDaData.On("AddressSearch", "Сухаревская 11").Return([]*domain.Address{
+		{City: "Москва", Street: "Сухаревская", House: "11"},
+	}, nil)
+	mockDaData.On("GeoCode", "55.77412", "37.624065").Return([]*domain.Address{
+		{City: "Москва", Street: "Сухаревская", House: "11", Lat: "55.77412", Lon: "37.624065"},
+	}, nil)
+
+	_, err := service.SearchAddress(authContext(t), &pb.SearchAddressRequest{Query: "Сухаревская 11"})
+	assert.NoError(t, err)
+	_, err = service.Geocode(authContext(t), &pb.GeocodeRequest{Address: "55.77412, 37.624065"})
+	assert.NoError(t, err)
+	// Ответ другому пользователю из общего кэша в его данные не попадает
+	service.redisClient.Set(context.Background(), searchCachePrefix+"other", `{"addresses":[]}`, time.Hour)
+
+	t.Run("export", func(t *testing.T) {
+		resp, err := service.ExportUserData(serviceContext(t), &pb.ExportUserDataRequest{UserId: "user-1"})
+		assert.NoError(t, err)
+		if assert.Len(t, resp.Lookups, 2) {
+			assert.Equal(t, "geocode", resp.Lookups[0].Kind)
+			assert.Equal(t, "55.77412,37.624065", resp.Lookups[0].Query)
+			assert.Equal(t, "search", resp.Lookups[1].Kind)
+			assert.Equal(t, "Сухаревская 11", resp.Lookups[1].Query)
+			assert.Equal(t, "Москва", resp.Lookups[1].Addresses[0].City)
+		}
+		if assert.Len(t, resp.QuotaUsage, 1) {
+			assert.Equal(t, time.Now().UTC().Format("2006-01-02"), resp.QuotaUsage[0].Date)
+			assert.Equal(t, int64(2), resp.QuotaUsage[0].Requests)
+		}
+	})
+
+	t.Run("user token is rejected", func(t *testing.T) {
+		_, err := service.ExportUserData(authContext(t), &pb.ExportUserDataRequest{UserId: "user-1"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = service.PurgeUserData(authContext(t), &pb.PurgeUserDataRequest{UserId: "user-1"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("other service is rejected", func(t *testing.T) {
+		_, err := service.ExportUserData(clientContext(t, "proxy", ""), &pb.ExportUserDataRequest{UserId: "user-1"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = service.PurgeUserData(clientContext(t, "proxy", ""), &pb.PurgeUserDataRequest{UserId: "user-1"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		resp, err := service.PurgeUserData(serviceContext(t), &pb.PurgeUserDataRequest{UserId: "user-1"})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(2), resp.Lookups)
+
+		keys, err := service.redisClient.Keys(context.Background(), "*").Result()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{searchCachePrefix + "other"}, keys)
+
+		export, err := service.ExportUserData(serviceContext(t), &pb.ExportUserDataRequest{UserId: "user-1"})
+		assert.NoError(t, err)
+		assert.Empty(t, export.Lookups)
+		assert.Empty(t, export.QuotaUsage)
+	})
+}
+
 func TestNew(t *testing.T) {
 	// Создаем тестовый Redis клиент
 	mr, err := miniredis.Run()
@@ -284,25 +680,21 @@ func TestNew(t *testing.T) {
 	})
 	defer redisClient.Close()
 
-	// Создаем тестовое gRPC подключение
-	conn, err := grpc.DialContext(
-		context.Background(),
-		"bufnet",
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
-	assert.NoError(t, err)
-	defer conn.Close()
+	verifier := authn.NewVerifier(testKeySource{}, "test-issuer", "test-audience")
 
 	// Создаем сервис
-	service := New(conn, redisClient)
+	service := New(verifier, redisClient, 1000)
 
 	// Проверяем что сервис создан корректно
 	assert.NotNil(t, service)
-	assert.NotNil(t, service.authClient)
+	assert.NotNil(t, service.verifier)
 	assert.NotNil(t, service.redisClient)
 	assert.NotNil(t, service.geoProvider)
+	assert.NotNil(t, service.quota)
+	assert.Equal(t, 1000, service.dailyQuota)
 
 	// Проверяем что все зависимости установлены
+	assert.Equal(t, verifier, service.verifier)
 	assert.Equal(t, redisClient, service.redisClient)
 	assert.IsType(t, &dadata.Provider{}, service.geoProvider)
 }
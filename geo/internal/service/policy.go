@@ -0,0 +1,14 @@
+package service
+
+import "dd/pkg/authz"
+
+// Policy - правила доступа к методам GeoService. Методы доступны любому
+// пользователю, а API ключу - при наличии соответствующего разрешения.
+var Policy = authz.Policy{
+	"/geo.GeoService/SearchAddress": {Scopes: []string{authz.ScopeGeoSearch}},
+	"/geo.GeoService/Geocode":       {Scopes: []string{authz.ScopeGeoGeocode}},
+
+	// Выгрузку и удаление данных пользователя выполняет user сервис от своего имени
+	"/geo.GeoService/ExportUserData": {Roles: []string{authz.RoleService}, Scopes: []string{authz.ScopeUserData}},
+	"/geo.GeoService/PurgeUserData":  {Roles: []string{authz.RoleService}, Scopes: []string{authz.ScopeUserData}},
+}
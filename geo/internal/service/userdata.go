@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "dd/pkg/geo"
+)
+
+// ExportUserData возвращает запросы пользователя, ответы на которые еще в
+// кэше, и его расход суточной квоты
+func (s *GeoService) ExportUserData(ctx context.Context, req *pb.ExportUserDataRequest) (*pb.ExportUserDataResponse, error) {
+	if _, err := s.authenticate(ctx, "/geo.GeoService/ExportUserData"); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user id is required")
+	}
+
+	cacheKeys, err := s.redisClient.SMembers(ctx, lookupsPrefix+req.UserId).Result()
+	if err != nil {
+		log.Printf("Failed to list lookups of user %s: %v", req.UserId, err)
+		return nil, status.Error(codes.Internal, "failed to export user data")
+	}
+	sort.Strings(cacheKeys)
+
+	resp := &pb.ExportUserDataResponse{}
+	if len(cacheKeys) > 0 {
+		values, err := s.redisClient.MGet(ctx, cacheKeys...).Result()
+		if err != nil {
+			log.Printf("Failed to load lookups of user %s: %v", req.UserId, err)
+			return nil, status.Error(codes.Internal, "failed to export user data")
+		}
+		for i, cacheKey := range cacheKeys {
+			// Ответ мог уже истечь или быть удаленным вместе с данными другого пользователя
+			cached, ok := values[i].(string)
+			if !ok {
+				continue
+			}
+			if lookup := decodeLookup(cacheKey, cached); lookup != nil {
+				resp.Lookups = append(resp.Lookups, lookup)
+			}
+		}
+	}
+
+	usage, err := s.quota.Usage(ctx, userQuotaID(req.UserId))
+	if err != nil {
+		log.Printf("Failed to load quota usage of user %s: %v", req.UserId, err)
+		return nil, status.Error(codes.Internal, "failed to export user data")
+	}
+	for _, day := range usage {
+		resp.QuotaUsage = append(resp.QuotaUsage, &pb.QuotaUsage{
+			Date:     day.Date,
+			Requests: day.Requests,
+		})
+	}
+
+	return resp, nil
+}
+
+// PurgeUserData удаляет из кэша ответы на запросы пользователя и его счетчики
+// квоты. Ответ общий для всех, кто делал тот же запрос, и после удаления будет
+// снова получен у провайдера.
+func (s *GeoService) PurgeUserData(ctx context.Context, req *pb.PurgeUserDataRequest) (*pb.PurgeUserDataResponse, error) {
+	if _, err := s.authenticate(ctx, "/geo.GeoService/PurgeUserData"); err != nil {
+		return nil, err
+	}
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "user id is required")
+	}
+
+	lookupsKey := lookupsPrefix + req.UserId
+	cacheKeys, err := s.redisClient.SMembers(ctx, lookupsKey).Result()
+	if err != nil {
+		log.Printf("Failed to list lookups of user %s: %v", req.UserId, err)
+		return nil, status.Error(codes.Internal, "failed to purge user data")
+	}
+	if err := s.redisClient.Del(ctx, append(cacheKeys, lookupsKey)...).Err(); err != nil {
+		log.Printf("Failed to delete lookups of user %s: %v", req.UserId, err)
+		return nil, status.Error(codes.Internal, "failed to purge user data")
+	}
+
+	if err := s.quota.Reset(ctx, userQuotaID(req.UserId)); err != nil {
+		log.Printf("Failed to reset quota of user %s: %v", req.UserId, err)
+		return nil, status.Error(codes.Internal, "failed to purge user data")
+	}
+
+	log.Printf("Data of user %s purged: %d lookups", req.UserId, len(cacheKeys))
+
+	return &pb.PurgeUserDataResponse{
+		Lookups: int32(len(cacheKeys)),
+	}, nil
+}
+
+// decodeLookup восстанавливает запрос по ключу кэша и ответ по его значению
+func decodeLookup(cacheKey, cached string) *pb.CachedLookup {
+	// Ответы обоих методов - это список адресов
+	var response pb.SearchAddressResponse
+	if err := json.Unmarshal([]byte(cached), &response); err != nil {
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(cacheKey, searchCachePrefix):
+		return &pb.CachedLookup{
+			Kind:      "search",
+			Query:     strings.TrimPrefix(cacheKey, searchCachePrefix),
+			Addresses: response.Addresses,
+		}
+	case strings.HasPrefix(cacheKey, geoCachePrefix):
+		return &pb.CachedLookup{
+			Kind:      "geocode",
+			Query:     strings.TrimPrefix(cacheKey, geoCachePrefix),
+			Addresses: response.Addresses,
+		}
+	}
+	return nil
+}
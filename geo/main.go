@@ -9,14 +9,29 @@ import (
 
 	"dd/geo/internal/config"
 	"dd/geo/internal/service"
+	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
 	pb "dd/pkg/geo"
 )
 
 func main() {
 	cfg := config.New()
+	if cfg.ClientSecret == "" {
+		log.Fatalf("GEO_CLIENT_SECRET is not set")
+	}
 
-	// Подключаемся к auth сервису
-	authConn, err := grpc.Dial("auth:50051", grpc.WithInsecure())
+	// Токен сервиса запрашивается по отдельному соединению без учетных данных
+	tokenConn, err := grpc.Dial(cfg.AuthService, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("failed to connect to auth service: %v", err)
+	}
+	defer tokenConn.Close()
+
+	serviceCreds := authn.NewServiceCredentials(authn.NewAuthServiceTokenSource(
+		pb_auth.NewAuthServiceClient(tokenConn), cfg.ClientID, cfg.ClientSecret))
+
+	// Подключаемся к auth сервису; вызовы подписываются токеном geo
+	authConn, err := grpc.Dial(cfg.AuthService, grpc.WithInsecure(), grpc.WithPerRPCCredentials(serviceCreds))
 	if err != nil {
 		log.Fatalf("failed to connect to auth service: %v", err)
 	}
@@ -24,9 +39,21 @@ func main() {
 
 	// Подключаемся к Redis
 	redisClient := redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
+		Addr: cfg.RedisAddr,
 	})
 
+	// Токены проверяются локально по ключам auth сервиса,
+	// RPC ValidateToken используется только для проверки отзыва.
+	// API ключи проверяются через RPC ValidateAPIKey.
+	authClient := pb_auth.NewAuthServiceClient(authConn)
+	verifier := authn.NewVerifier(
+		authn.NewAuthServiceKeySource(authClient),
+		cfg.JWTIssuer,
+		cfg.JWTAudience,
+		authn.WithRevocationCheck(authn.NewAuthServiceRevocationChecker(authClient), cfg.RevocationCacheTTL),
+		authn.WithAPIKeys(authn.NewAuthServiceAPIKeyResolver(authClient), cfg.RevocationCacheTTL),
+	)
+
 	// Создаем gRPC сервер
 	lis, err := net.Listen("tcp", cfg.GRPCPort)
 	if err != nil {
@@ -34,7 +61,7 @@ func main() {
 	}
 
 	grpcServer := grpc.NewServer()
-	geoService := service.New(authConn, redisClient)
+	geoService := service.New(verifier, redisClient, cfg.DailyQuota)
 	pb.RegisterGeoServiceServer(grpcServer, geoService)
 
 	log.Printf("Starting Geo service on port %s", cfg.GRPCPort)
@@ -0,0 +1,237 @@
+// Package audit ведет журнал значимых для безопасности действий: входов,
+// смены паролей, просмотра списка пользователей и т.п.
+//
+// Журнал только дополняется. Каждая запись содержит хэш предыдущей, поэтому
+// удаление, вставка или правка записи задним числом обнаруживаются проверкой
+// цепочки, см. Postgres.Verify.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"dd/pkg/authn"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Действия auth сервиса
+const (
+	ActionRegister       = "auth.register"
+	ActionLogin          = "auth.login"
+	ActionOAuthLogin     = "auth.oauth_login"
+	ActionMFAVerify      = "auth.mfa_verify"
+	ActionRefreshReuse   = "auth.refresh_reuse"
+	ActionSessionRevoke  = "auth.session_revoke"
+	ActionSessionsRevoke = "auth.sessions_revoke"
+	ActionAccountUnlock  = "auth.account_unlock"
+	ActionAPIKeyCreate   = "auth.api_key_create"
+	ActionAPIKeyRevoke   = "auth.api_key_revoke"
+	ActionServiceToken   = "auth.service_token"
+)
+
+// Действия user сервиса
+const (
+	ActionPasswordChange = "user.password_change"
+	ActionPasswordReset  = "user.password_reset"
+	ActionEmailChange    = "user.email_change"
+	ActionEmailVerify    = "user.email_verify"
+	ActionMFAEnable      = "user.mfa_enable"
+	ActionUserList       = "user.list"
+	ActionUserDelete     = "user.delete"
+	ActionDataExport     = "user.data_export"
+	ActionDataErase      = "user.data_erase"
+	// ActionAuditQuery - чтение самого журнала
+	ActionAuditQuery = "audit.query"
+)
+
+// Результат действия
+const (
+	ResultSuccess = "success"
+	// ResultFailure - действие не удалось, например неверный пароль
+	ResultFailure = "failure"
+	// ResultDenied - вызывающему не хватило прав или он заблокирован
+	ResultDenied = "denied"
+)
+
+// Тип вызывающего
+const (
+	ActorUser      = "user"
+	ActorService   = "service"
+	ActorAnonymous = "anonymous"
+)
+
+// maxUserAgentLength ограничивает сохраняемый User-Agent
+const maxUserAgentLength = 256
+
+// genesisHash - предыдущий хэш первой записи журнала
+var genesisHash = strings.Repeat("0", 64)
+
+// Event - запись журнала. Actor - ID пользователя или client_id сервиса,
+// Target - ID объекта действия: пользователя, сессии, API ключа. Для входа с
+// неизвестным паролем Target - email, под которым пытались войти.
+type Event struct {
+	ID        int64
+	Time      time.Time
+	Service   string
+	Actor     string
+	ActorType string
+	Action    string
+	Target    string
+	IP        string
+	UserAgent string
+	Result    string
+	// Reason - причина отказа или уточнение результата
+	Reason   string
+	PrevHash string
+	Hash     string
+}
+
+// Recorder записывает события в журнал
+type Recorder interface {
+	Record(ctx context.Context, event Event) error
+}
+
+// Filter - условия выборки журнала. Пустые поля не ограничивают выборку.
+type Filter struct {
+	Actor   string
+	Target  string
+	Action  string
+	Result  string
+	Service string
+	// Since включительно, Until - нет
+	Since time.Time
+	Until time.Time
+	// BeforeID - курсор: только записи старше указанной
+	BeforeID int64
+	Limit    int
+}
+
+// Log - журнал, который можно не только дополнять, но и читать
+type Log interface {
+	Recorder
+	// Query возвращает записи по фильтру, начиная с новых
+	Query(ctx context.Context, filter Filter) ([]Event, error)
+}
+
+// Option настраивает журнал
+type Option func(*options)
+
+type options struct {
+	proxyClientID string
+}
+
+// WithProxy задает client_id proxy: только от него журнал принимает адрес и
+// User-Agent клиента из метаданных, см. FromProxy
+func WithProxy(clientID string) Option {
+	return func(o *options) {
+		o.proxyClientID = clientID
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// FromProxy сообщает, что запрос передал proxy с client_id proxyClientID:
+// сам или приложив свой токен к вызову пользователя, см.
+// authn.ServiceFromContext. Только такому запросу доверяются x-client-ip и
+// x-user-agent: иначе любой, кто достучится до сервиса, подставлял бы в них
+// что угодно, в том числе чтобы обойти ограничение попыток входа по IP.
+func FromProxy(ctx context.Context, proxyClientID string) bool {
+	service, ok := authn.ServiceFromContext(ctx)
+	return ok && proxyClientID != "" && service == proxyClientID
+}
+
+// ClientIP возвращает адрес клиента: x-client-ip от proxy, см. FromProxy,
+// а в остальных случаях - адрес соединения
+func ClientIP(ctx context.Context, proxyClientID string) string {
+	if FromProxy(ctx, proxyClientID) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get("x-client-ip"); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+		return host
+	}
+	return ""
+}
+
+// prepare дополняет событие данными запроса: временем, вызывающим из
+// контекста, адресом и User-Agent клиента
+func prepare(ctx context.Context, service string, o options, event *Event) {
+	// Postgres хранит время с точностью до микросекунды; хэш считается от сохраненного значения
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC().Truncate(time.Microsecond)
+	event.Service = service
+
+	if event.Actor == "" {
+		if p, ok := authn.FromContext(ctx); ok {
+			event.Actor = p.UserID
+			event.ActorType = ActorUser
+			if p.ClientID != "" {
+				event.ActorType = ActorService
+			}
+		}
+	}
+	switch {
+	case event.Actor == "":
+		event.ActorType = ActorAnonymous
+	case event.ActorType == "":
+		event.ActorType = ActorUser
+	}
+
+	if event.Result == "" {
+		event.Result = ResultSuccess
+	}
+
+	if event.IP == "" {
+		event.IP = ClientIP(ctx, o.proxyClientID)
+	}
+	if event.UserAgent == "" && FromProxy(ctx, o.proxyClientID) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get("x-user-agent"); len(values) > 0 {
+			event.UserAgent = values[0]
+		}
+	}
+	if len(event.UserAgent) > maxUserAgentLength {
+		event.UserAgent = event.UserAgent[:maxUserAgentLength]
+	}
+}
+
+// computeHash считает хэш записи вместе с хэшем предыдущей. ID в хэш не
+// входит: порядок записей задает сама цепочка.
+func computeHash(event Event) string {
+	data, _ := json.Marshal([]string{
+		event.PrevHash,
+		event.Time.UTC().Format(time.RFC3339Nano),
+		event.Service,
+		event.Actor,
+		event.ActorType,
+		event.Action,
+		event.Target,
+		event.IP,
+		event.UserAgent,
+		event.Result,
+		event.Reason,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
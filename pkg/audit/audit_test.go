@@ -0,0 +1,254 @@
+package audit
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"dd/pkg/authn"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestPrepare(t *testing.T) {
+	proxy := options{proxyClientID: "proxy"}
+
+	t.Run("caller from context and client from metadata", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"x-client-ip", "203.0.113.7",
+			"x-user-agent", strings.Repeat("a", 300),
+		))
+		ctx = authn.NewContext(ctx, &authn.Principal{UserID: "123"})
+		ctx = authn.NewServiceContext(ctx, "proxy")
+
+		event := Event{Action: ActionPasswordChange}
+		prepare(ctx, "user", proxy, &event)
+
+		assert.Equal(t, "user", event.Service)
+		assert.Equal(t, "123", event.Actor)
+		assert.Equal(t, ActorUser, event.ActorType)
+		assert.Equal(t, ResultSuccess, event.Result)
+		assert.Equal(t, "203.0.113.7", event.IP)
+		assert.Len(t, event.UserAgent, maxUserAgentLength)
+		assert.Equal(t, time.UTC, event.Time.Location())
+	})
+
+	t.Run("service caller", func(t *testing.T) {
+		ctx := authn.NewContext(context.Background(), &authn.Principal{UserID: "billing", ClientID: "billing"})
+
+		event := Event{Action: ActionUserList}
+		prepare(ctx, "user", proxy, &event)
+
+		assert.Equal(t, "billing", event.Actor)
+		assert.Equal(t, ActorService, event.ActorType)
+	})
+
+	t.Run("anonymous caller falls back to peer address", func(t *testing.T) {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 40000},
+		})
+
+		event := Event{Action: ActionLogin, Result: ResultFailure, Target: "test@example.com"}
+		prepare(ctx, "auth", proxy, &event)
+
+		assert.Empty(t, event.Actor)
+		assert.Equal(t, ActorAnonymous, event.ActorType)
+		assert.Equal(t, ResultFailure, event.Result)
+		assert.Equal(t, "10.0.0.5", event.IP)
+	})
+
+	t.Run("client metadata is trusted only from proxy", func(t *testing.T) {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 40000},
+		})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(
+			"x-client-ip", "203.0.113.7",
+			"x-user-agent", "Firefox",
+		))
+
+		for name, ctx := range map[string]context.Context{
+			"no service":    ctx,
+			"other service": authn.NewServiceContext(ctx, "geo"),
+		} {
+			event := Event{Action: ActionLogin}
+			prepare(ctx, "auth", proxy, &event)
+			assert.Equal(t, "10.0.0.5", event.IP, name)
+			assert.Empty(t, event.UserAgent, name)
+		}
+
+		// Без WithProxy адрес из метаданных не принимается ни от кого
+		event := Event{Action: ActionLogin}
+		prepare(authn.NewServiceContext(ctx, "proxy"), "auth", options{}, &event)
+		assert.Equal(t, "10.0.0.5", event.IP)
+	})
+}
+
+func TestMemory_Chain(t *testing.T) {
+	m := NewMemory("auth")
+	for _, action := range []string{ActionRegister, ActionLogin, ActionSessionRevoke} {
+		assert.NoError(t, m.Record(context.Background(), Event{Actor: "123", Action: action}))
+	}
+
+	events := m.Events()
+	assert.Len(t, events, 3)
+	assert.Equal(t, genesisHash, events[0].PrevHash)
+	for i, event := range events {
+		assert.Equal(t, computeHash(event), event.Hash)
+		if i > 0 {
+			assert.Equal(t, events[i-1].Hash, event.PrevHash)
+		}
+	}
+
+	// Любое изменение записи меняет ее хэш
+	tampered := events[1]
+	tampered.Result = ResultFailure
+	assert.NotEqual(t, events[1].Hash, computeHash(tampered))
+
+	found, err := m.Query(context.Background(), Filter{BeforeID: 3, Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, ActionLogin, found[0].Action)
+	}
+}
+
+func TestPostgres_Record(t *testing.T) {
+	t.Run("queued events are written after cancellation", func(t *testing.T) {
+		db, dbMock, err := sqlmock.New()
+		assert.NoError(t, err)
+		defer db.Close()
+
+		p := NewPostgres(db, "user")
+
+		// Запрос завершился раньше, чем событие записано
+		ctx, cancel := context.WithCancel(context.Background())
+		assert.NoError(t, p.Record(ctx, Event{Actor: "123", Action: ActionEmailChange, Target: "123"}))
+		assert.NoError(t, p.Record(ctx, Event{Actor: "123", Action: ActionPasswordChange, Target: "123"}))
+		cancel()
+
+		// Обе записи добавляются одной транзакцией под одной блокировкой
+		prev := strings.Repeat("a", 64)
+		dbMock.ExpectBegin()
+		dbMock.ExpectExec("SELECT pg_advisory_xact_lock").
+			WithArgs(chainLockID).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		dbMock.ExpectQuery("SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").
+			WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow(prev))
+		dbMock.ExpectExec("INSERT INTO audit_log").
+			WithArgs(sqlmock.AnyArg(), "user", "123", ActorUser, ActionEmailChange, "123",
+				"", "", ResultSuccess, "", prev, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		dbMock.ExpectExec("INSERT INTO audit_log").
+			WithArgs(sqlmock.AnyArg(), "user", "123", ActorUser, ActionPasswordChange, "123",
+				"", "", ResultSuccess, "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(2, 1))
+		dbMock.ExpectCommit()
+
+		p.Run(ctx)
+
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+
+	t.Run("full queue", func(t *testing.T) {
+		p := &Postgres{service: "auth", queue: make(chan Event, 1)}
+
+		assert.NoError(t, p.Record(context.Background(), Event{Action: ActionLogin}))
+		assert.Error(t, p.Record(context.Background(), Event{Action: ActionLogin}))
+	})
+}
+
+func TestPostgres_Init(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// Таблица появляется, когда user сервис применит миграции
+	dbMock.ExpectQuery("SELECT to_regclass").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	dbMock.ExpectQuery("SELECT to_regclass").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	assert.NoError(t, NewPostgres(db, "auth").Init(context.Background()))
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+
+	t.Run("gives up when context is done", func(t *testing.T) {
+		dbMock.ExpectQuery("SELECT to_regclass").
+			WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := NewPostgres(db, "auth").Init(ctx)
+		assert.ErrorContains(t, err, "audit_log does not exist")
+	})
+}
+
+func TestPostgres_Verify(t *testing.T) {
+	m := NewMemory("auth")
+	for _, action := range []string{ActionRegister, ActionLogin, ActionLogin} {
+		assert.NoError(t, m.Record(context.Background(), Event{Actor: "123", Action: action}))
+	}
+	events := m.Events()
+
+	rows := func(events []Event) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"id", "occurred_at", "service", "actor", "actor_type", "action",
+			"target", "ip", "user_agent", "result", "reason", "prev_hash", "hash"})
+		for _, e := range events {
+			rows.AddRow(e.ID, e.Time, e.Service, e.Actor, e.ActorType, e.Action,
+				e.Target, e.IP, e.UserAgent, e.Result, e.Reason, e.PrevHash, e.Hash)
+		}
+		return rows
+	}
+
+	tests := []struct {
+		name     string
+		events   func() []Event
+		checked  int64
+		brokenID int64
+	}{
+		{
+			name:    "intact chain",
+			events:  func() []Event { return events },
+			checked: 3,
+		},
+		{
+			name: "edited record",
+			events: func() []Event {
+				edited := append([]Event(nil), events...)
+				edited[1].Result = ResultFailure
+				return edited
+			},
+			checked:  2,
+			brokenID: 2,
+		},
+		{
+			name: "deleted record",
+			events: func() []Event {
+				return []Event{events[0], events[2]}
+			},
+			checked:  2,
+			brokenID: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, dbMock, err := sqlmock.New()
+			assert.NoError(t, err)
+			defer db.Close()
+
+			dbMock.ExpectQuery("SELECT (.+) FROM audit_log ORDER BY id").WillReturnRows(rows(tt.events()))
+
+			result, err := NewPostgres(db, "auth").Verify(context.Background())
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.checked, result.Checked)
+			assert.Equal(t, tt.brokenID, result.BrokenID)
+			assert.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
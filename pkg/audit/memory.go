@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory хранит журнал в памяти процесса, для тестов и запуска без базы
+type Memory struct {
+	mu      sync.Mutex
+	service string
+	options options
+	events  []Event
+}
+
+func NewMemory(service string, opts ...Option) *Memory {
+	return &Memory{service: service, options: newOptions(opts)}
+}
+
+func (m *Memory) Record(ctx context.Context, event Event) error {
+	prepare(ctx, m.service, m.options, &event)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event.ID = int64(len(m.events) + 1)
+	event.PrevHash = genesisHash
+	if len(m.events) > 0 {
+		event.PrevHash = m.events[len(m.events)-1].Hash
+	}
+	event.Hash = computeHash(event)
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *Memory) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []Event
+	for i := len(m.events) - 1; i >= 0; i-- {
+		event := m.events[i]
+		switch {
+		case filter.Actor != "" && event.Actor != filter.Actor,
+			filter.Target != "" && event.Target != filter.Target,
+			filter.Action != "" && event.Action != filter.Action,
+			filter.Result != "" && event.Result != filter.Result,
+			filter.Service != "" && event.Service != filter.Service,
+			!filter.Since.IsZero() && event.Time.Before(filter.Since),
+			!filter.Until.IsZero() && !event.Time.Before(filter.Until),
+			filter.BeforeID > 0 && event.ID >= filter.BeforeID:
+			continue
+		}
+		events = append(events, event)
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+// Events возвращает все записи по порядку
+func (m *Memory) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Event(nil), m.events...)
+}
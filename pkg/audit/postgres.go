@@ -0,0 +1,295 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// chainLockID - ключ advisory lock журнала: записи добавляются по одной,
+// иначе две записи сослались бы на один и тот же предыдущий хэш
+const chainLockID int64 = 0x61756469746c6f67 // "auditlog"
+
+const (
+	// queueSize - сколько событий могут ждать записи
+	queueSize = 1024
+	// batchSize - сколько событий записываются под одной блокировкой
+	batchSize = 100
+	// retryDelay - пауза перед повторной записью после ошибки
+	retryDelay = time.Second
+	// flushTimeout ограничивает запись очереди при остановке
+	flushTimeout = 5 * time.Second
+)
+
+const columns = `id, occurred_at, service, actor, actor_type, action, target, ip, user_agent, result, reason, prev_hash, hash`
+
+// Postgres хранит журнал в таблице audit_log. Таблицу создают миграции
+// user сервиса; изменение и удаление ее строк запрещены триггерами.
+//
+// Цепочка дописывается под общей блокировкой журнала, поэтому запросы ее не
+// ждут: Record ставит событие в очередь, а Run записывает очередь пачками.
+// События, не записанные к остановке процесса дольше flushTimeout, теряются.
+type Postgres struct {
+	db *sql.DB
+	// service - сервис, от имени которого пишутся события
+	service string
+	options options
+	queue   chan Event
+}
+
+func NewPostgres(db *sql.DB, service string, opts ...Option) *Postgres {
+	return &Postgres{db: db, service: service, options: newOptions(opts), queue: make(chan Event, queueSize)}
+}
+
+// Init ждет, пока миграции user сервиса создадут таблицу журнала: auth может
+// запуститься раньше. Возвращает ошибку, если таблицы нет к отмене ctx.
+func (p *Postgres) Init(ctx context.Context) error {
+	for {
+		var exists bool
+		err := p.db.QueryRowContext(ctx, `SELECT to_regclass('audit_log') IS NOT NULL`).Scan(&exists)
+		if err == nil && exists {
+			return nil
+		}
+		if err == nil {
+			err = fmt.Errorf("table audit_log does not exist, run user service migrations")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("audit log is not ready: %v", err)
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// Record дополняет событие данными запроса и ставит его в очередь, не
+// дожидаясь записи. Отмена запроса после возврата на событие не влияет.
+func (p *Postgres) Record(ctx context.Context, event Event) error {
+	prepare(ctx, p.service, p.options, &event)
+
+	select {
+	case p.queue <- event:
+		return nil
+	default:
+		return fmt.Errorf("audit queue is full")
+	}
+}
+
+// Run записывает события из очереди, пока не будет отменен ctx. Пачка, которую
+// не удалось записать, повторяется; после отмены оставшиеся события
+// дописываются в течение flushTimeout.
+func (p *Postgres) Run(ctx context.Context) {
+	var batch []Event
+	for {
+		if len(batch) == 0 {
+			select {
+			case <-ctx.Done():
+				p.flush(ctx, nil)
+				return
+			case event := <-p.queue:
+				batch = append(batch, event)
+			}
+		}
+		batch = p.drain(batch)
+
+		if err := p.write(ctx, batch); err != nil {
+			log.Printf("Failed to write %d audit events: %v", len(batch), err)
+			select {
+			case <-ctx.Done():
+				p.flush(ctx, batch)
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+		batch = batch[:0]
+	}
+}
+
+// drain добавляет в пачку ожидающие события, пока она не заполнится
+func (p *Postgres) drain(batch []Event) []Event {
+	for len(batch) < batchSize {
+		select {
+		case event := <-p.queue:
+			batch = append(batch, event)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// flush дописывает batch и очередь после отмены ctx
+func (p *Postgres) flush(ctx context.Context, batch []Event) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flushTimeout)
+	defer cancel()
+
+	for {
+		batch = p.drain(batch)
+		if len(batch) == 0 {
+			return
+		}
+		if err := p.write(ctx, batch); err != nil {
+			log.Printf("Failed to write audit events on shutdown, %d events lost: %v", len(batch)+len(p.queue), err)
+			return
+		}
+		batch = batch[:0]
+	}
+}
+
+// write дописывает события в цепочку одной транзакцией
+func (p *Postgres) write(ctx context.Context, events []Event) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Блокировка снимается вместе с транзакцией, уже после того как записи станут видны следующей
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, chainLockID); err != nil {
+		return fmt.Errorf("failed to lock audit log: %v", err)
+	}
+
+	var prev string
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1`).Scan(&prev)
+	if err == sql.ErrNoRows {
+		prev = genesisHash
+	} else if err != nil {
+		return fmt.Errorf("failed to read audit log: %v", err)
+	}
+
+	// Хэши считаются заново при каждой попытке: после отката предыдущая запись могла измениться
+	for _, event := range events {
+		event.PrevHash = prev
+		event.Hash = computeHash(event)
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO audit_log (occurred_at, service, actor, actor_type, action, target, ip, user_agent, result, reason, prev_hash, hash)
+             VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			event.Time, event.Service, event.Actor, event.ActorType, event.Action, event.Target,
+			event.IP, event.UserAgent, event.Result, event.Reason, event.PrevHash, event.Hash); err != nil {
+			return fmt.Errorf("failed to write audit event: %v", err)
+		}
+		prev = event.Hash
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit audit events: %v", err)
+	}
+	return nil
+}
+
+func (p *Postgres) Query(ctx context.Context, filter Filter) ([]Event, error) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Actor != "" {
+		add("actor = $%d", filter.Actor)
+	}
+	if filter.Target != "" {
+		add("target = $%d", filter.Target)
+	}
+	if filter.Action != "" {
+		add("action = $%d", filter.Action)
+	}
+	if filter.Result != "" {
+		add("result = $%d", filter.Result)
+	}
+	if filter.Service != "" {
+		add("service = $%d", filter.Service)
+	}
+	if !filter.Since.IsZero() {
+		add("occurred_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("occurred_at < $%d", filter.Until)
+	}
+	if filter.BeforeID > 0 {
+		add("id < $%d", filter.BeforeID)
+	}
+
+	query := `SELECT ` + columns + ` FROM audit_log`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	query += ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %v", err)
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		event, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %v", err)
+	}
+	return events, nil
+}
+
+// Verification - результат проверки цепочки
+type Verification struct {
+	// Checked - сколько записей проверено
+	Checked int64
+	// BrokenID - первая запись, не сходящаяся с цепочкой; 0 - цепочка цела
+	BrokenID int64
+}
+
+// Verify пересчитывает хэши всего журнала по порядку записей. Запись не
+// сходится, если изменено ее содержимое или перед ней удалена либо вставлена
+// другая запись.
+func (p *Postgres) Verify(ctx context.Context) (Verification, error) {
+	var result Verification
+
+	rows, err := p.db.QueryContext(ctx, `SELECT `+columns+` FROM audit_log ORDER BY id`)
+	if err != nil {
+		return result, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer rows.Close()
+
+	prev := genesisHash
+	for rows.Next() {
+		event, err := scan(rows)
+		if err != nil {
+			return result, err
+		}
+		result.Checked++
+		if event.PrevHash != prev || computeHash(event) != event.Hash {
+			result.BrokenID = event.ID
+			return result, nil
+		}
+		prev = event.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return result, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	return result, nil
+}
+
+func scan(rows *sql.Rows) (Event, error) {
+	var event Event
+	err := rows.Scan(&event.ID, &event.Time, &event.Service, &event.Actor, &event.ActorType, &event.Action,
+		&event.Target, &event.IP, &event.UserAgent, &event.Result, &event.Reason, &event.PrevHash, &event.Hash)
+	if err != nil {
+		return event, fmt.Errorf("failed to scan audit event: %v", err)
+	}
+	event.Time = event.Time.UTC()
+	return event, nil
+}
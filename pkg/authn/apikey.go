@@ -0,0 +1,119 @@
+package authn
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	pb_auth "dd/pkg/auth"
+)
+
+const maxAPIKeyCacheSize = 10000
+
+// APIKeyResolver находит вызывающего по API ключу.
+// Для неизвестного, отозванного или истекшего ключа возвращает ErrInvalidToken.
+type APIKeyResolver interface {
+	ResolveAPIKey(ctx context.Context, key string) (*Principal, error)
+}
+
+// AuthServiceAPIKeyResolver проверяет ключи через RPC ValidateAPIKey auth сервиса
+type AuthServiceAPIKeyResolver struct {
+	authClient pb_auth.AuthServiceClient
+}
+
+// NewAuthServiceAPIKeyResolver создает проверку API ключей поверх клиента auth сервиса
+func NewAuthServiceAPIKeyResolver(authClient pb_auth.AuthServiceClient) *AuthServiceAPIKeyResolver {
+	return &AuthServiceAPIKeyResolver{authClient: authClient}
+}
+
+func (r *AuthServiceAPIKeyResolver) ResolveAPIKey(ctx context.Context, key string) (*Principal, error) {
+	resp, err := r.authClient.ValidateAPIKey(ctx, &pb_auth.ValidateAPIKeyRequest{
+		Key: key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	return &Principal{
+		UserID:     resp.UserId,
+		Email:      resp.Email,
+		Scopes:     resp.Scopes,
+		APIKeyID:   resp.KeyId,
+		DailyQuota: int(resp.DailyQuota),
+	}, nil
+}
+
+type apiKeyEntry struct {
+	principal *Principal
+	expiresAt time.Time
+}
+
+// apiKeyCache кэширует результаты APIKeyResolver по хэшу ключа, в том числе
+// отказы, чтобы перебор ключей не превращался в нагрузку на auth сервис.
+// Отзыв ключа вступает в силу не позже чем через cacheTTL.
+type apiKeyCache struct {
+	resolver APIKeyResolver
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]apiKeyEntry
+}
+
+func newAPIKeyCache(resolver APIKeyResolver, cacheTTL time.Duration) *apiKeyCache {
+	return &apiKeyCache{
+		resolver: resolver,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]apiKeyEntry),
+	}
+}
+
+func (c *apiKeyCache) resolve(ctx context.Context, key string) (*Principal, error) {
+	now := time.Now()
+	sum := sha256.Sum256([]byte(key))
+	id := hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	entry, ok := c.cache[id]
+	c.mu.Unlock()
+
+	if !ok || now.After(entry.expiresAt) {
+		principal, err := c.resolver.ResolveAPIKey(ctx, key)
+		if err != nil && err != ErrInvalidToken {
+			// Недоступность auth сервиса не кэшируется: ключ может быть действительным
+			return nil, fmt.Errorf("failed to resolve API key: %v", err)
+		}
+
+		entry = apiKeyEntry{principal: principal, expiresAt: now.Add(c.cacheTTL)}
+		c.store(id, entry, now)
+	}
+
+	if entry.principal == nil {
+		return nil, ErrInvalidToken
+	}
+	// Копия, чтобы вызывающие не меняли закэшированного вызывающего
+	p := *entry.principal
+	return &p, nil
+}
+
+func (c *apiKeyCache) store(id string, entry apiKeyEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cache) >= maxAPIKeyCacheSize {
+		for k, e := range c.cache {
+			if now.After(e.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= maxAPIKeyCacheSize {
+			c.cache = make(map[string]apiKeyEntry)
+		}
+	}
+	c.cache[id] = entry
+}
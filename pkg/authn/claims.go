@@ -0,0 +1,16 @@
+package authn
+
+import "github.com/golang-jwt/jwt"
+
+// Claims описывает содержимое access-токена, выпускаемого auth сервисом
+type Claims struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles,omitempty"`
+	// Scope - разрешения через пробел, как в RFC 9068
+	Scope string `json:"scope,omitempty"`
+	// ClientID - клиент, получивший токен по client_credentials; в токенах пользователей пуст
+	ClientID string `json:"client_id,omitempty"`
+	// SessionID - сессия входа, в которой выпущен токен; отзыв сессии отзывает и токен
+	SessionID string `json:"sid,omitempty"`
+	jwt.StandardClaims
+}
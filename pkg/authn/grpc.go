@@ -0,0 +1,108 @@
+package authn
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TokenFromMetadata возвращает токен из заголовка authorization входящего запроса
+func TokenFromMetadata(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	tokens := md.Get("authorization")
+	if len(tokens) == 0 || tokens[0] == "" {
+		return "", false
+	}
+	return tokens[0], true
+}
+
+// APIKeyFromMetadata возвращает API ключ из заголовка x-api-key входящего запроса
+func APIKeyFromMetadata(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	keys := md.Get("x-api-key")
+	if len(keys) == 0 || keys[0] == "" {
+		return "", false
+	}
+	return keys[0], true
+}
+
+// ServiceTokenFromMetadata возвращает токен сервиса, передавшего вызов от
+// имени пользователя, из заголовка ServiceAuthorizationKey входящего запроса
+func ServiceTokenFromMetadata(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	tokens := md.Get(ServiceAuthorizationKey)
+	if len(tokens) == 0 || tokens[0] == "" {
+		return "", false
+	}
+	return tokens[0], true
+}
+
+// Authenticate проверяет токен или, если токена нет, API ключ из метаданных.
+// Без того и другого возвращает ok == false.
+func Authenticate(ctx context.Context, v *Verifier) (p *Principal, ok bool, err error) {
+	if token, ok := TokenFromMetadata(ctx); ok {
+		p, err = v.Verify(ctx, token)
+		return p, true, err
+	}
+	if key, ok := APIKeyFromMetadata(ctx); ok {
+		p, err = v.VerifyAPIKey(ctx, key)
+		return p, true, err
+	}
+	return nil, false, nil
+}
+
+// UnaryServerInterceptor проверяет токен или API ключ из метаданных и сохраняет
+// вызывающего в контексте. Запросы без них пропускаются анонимно: требовать
+// аутентификацию - дело конкретного метода, см. Require.
+//
+// Сервис, передавший запрос, тоже сохраняется в контексте, см.
+// ServiceFromContext: это вызывающий сервис или сервис, приложивший к вызову
+// пользователя свой токен под ключом ServiceAuthorizationKey.
+func UnaryServerInterceptor(v *Verifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		principal, ok, err := Authenticate(ctx, v)
+		if ok && err != nil {
+			return nil, status.Error(codes.Unauthenticated, "invalid token")
+		}
+		if ok {
+			ctx = NewContext(ctx, principal)
+			if principal.ClientID != "" {
+				ctx = NewServiceContext(ctx, principal.ClientID)
+			}
+		}
+
+		if token, ok := ServiceTokenFromMetadata(ctx); ok {
+			service, err := v.Verify(ctx, token)
+			if err != nil || service.ClientID == "" {
+				return nil, status.Error(codes.Unauthenticated, "invalid service token")
+			}
+			ctx = NewServiceContext(ctx, service.ClientID)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// Require возвращает вызывающего из контекста или ошибку Unauthenticated
+func Require(ctx context.Context) (*Principal, error) {
+	principal, ok := FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	}
+	return principal, nil
+}
@@ -0,0 +1,44 @@
+package authn
+
+import (
+	"context"
+
+	pb_auth "dd/pkg/auth"
+)
+
+// KeySource поставляет актуальный набор ключей проверки подписи
+type KeySource interface {
+	Keys(ctx context.Context) ([]JSONWebKey, error)
+}
+
+// AuthServiceKeySource получает ключи через RPC GetJWKS auth сервиса
+type AuthServiceKeySource struct {
+	authClient pb_auth.AuthServiceClient
+}
+
+// NewAuthServiceKeySource создает источник ключей поверх клиента auth сервиса
+func NewAuthServiceKeySource(authClient pb_auth.AuthServiceClient) *AuthServiceKeySource {
+	return &AuthServiceKeySource{authClient: authClient}
+}
+
+func (s *AuthServiceKeySource) Keys(ctx context.Context) ([]JSONWebKey, error) {
+	resp, err := s.authClient.GetJWKS(ctx, &pb_auth.GetJWKSRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]JSONWebKey, 0, len(resp.Keys))
+	for _, k := range resp.Keys {
+		keys = append(keys, JSONWebKey{
+			Kty: k.Kty,
+			Kid: k.Kid,
+			Use: k.Use,
+			Alg: k.Alg,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Crv,
+			X:   k.X,
+		})
+	}
+	return keys, nil
+}
@@ -0,0 +1,24 @@
+package authn
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// Отказ во входе из-за блокировки учетной записи помечается деталями
+// ErrorInfo с этой причиной, чтобы отличать его от прочих PermissionDenied
+const (
+	ErrorDomain         = "dd-auth"
+	ReasonAccountLocked = "ACCOUNT_LOCKED"
+)
+
+// IsAccountLocked проверяет, что err - отказ из-за блокировки учетной записи
+func IsAccountLocked(err error) bool {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok &&
+			info.Domain == ErrorDomain && info.Reason == ReasonAccountLocked {
+			return true
+		}
+	}
+	return false
+}
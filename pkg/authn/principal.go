@@ -0,0 +1,83 @@
+package authn
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Principal - аутентифицированный вызывающий
+type Principal struct {
+	UserID    string
+	Email     string
+	Roles     []string
+	Scopes    []string
+	TokenID   string
+	ExpiresAt time.Time
+	// APIKeyID - ID API ключа, если вызывающий аутентифицирован ключом, а не токеном
+	APIKeyID string
+	// DailyQuota - собственная суточная квота API ключа, 0 - квота сервиса по умолчанию
+	DailyQuota int
+	// ClientID - сервис, вызывающий от своего имени, а не от имени пользователя
+	ClientID string
+}
+
+// HasRole сообщает, есть ли у вызывающего роль role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope сообщает, есть ли у вызывающего разрешение scope
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func principalFromClaims(claims *Claims) *Principal {
+	return &Principal{
+		UserID:    claims.Subject,
+		Email:     claims.Email,
+		Roles:     claims.Roles,
+		Scopes:    strings.Fields(claims.Scope),
+		TokenID:   claims.Id,
+		ClientID:  claims.ClientID,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}
+}
+
+type principalKey struct{}
+
+// NewContext возвращает контекст с вызывающим p
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext возвращает вызывающего, сохраненного в контексте
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+type serviceKey struct{}
+
+// NewServiceContext возвращает контекст с client_id сервиса, передавшего
+// запрос: вызывающего сервиса или сервиса, передавшего вызов пользователя со
+// своим токеном под ключом ServiceAuthorizationKey
+func NewServiceContext(ctx context.Context, clientID string) context.Context {
+	return context.WithValue(ctx, serviceKey{}, clientID)
+}
+
+// ServiceFromContext возвращает client_id сервиса, передавшего запрос
+func ServiceFromContext(ctx context.Context) (string, bool) {
+	clientID, ok := ctx.Value(serviceKey{}).(string)
+	return clientID, ok && clientID != ""
+}
@@ -0,0 +1,109 @@
+package authn
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	pb_auth "dd/pkg/auth"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxRevocationCacheSize = 10000
+
+// RevocationChecker сообщает, действителен ли токен с точки зрения auth сервиса
+type RevocationChecker interface {
+	Valid(ctx context.Context, token string) (bool, error)
+}
+
+// AuthServiceRevocationChecker проверяет отзыв через RPC ValidateToken
+type AuthServiceRevocationChecker struct {
+	authClient pb_auth.AuthServiceClient
+}
+
+// NewAuthServiceRevocationChecker создает проверку отзыва поверх клиента auth сервиса
+func NewAuthServiceRevocationChecker(authClient pb_auth.AuthServiceClient) *AuthServiceRevocationChecker {
+	return &AuthServiceRevocationChecker{authClient: authClient}
+}
+
+func (c *AuthServiceRevocationChecker) Valid(ctx context.Context, token string) (bool, error) {
+	resp, err := c.authClient.ValidateToken(ctx, &pb_auth.ValidateTokenRequest{
+		Token: token,
+	})
+	if err != nil {
+		return false, err
+	}
+	return resp.Valid, nil
+}
+
+type revocationEntry struct {
+	valid     bool
+	expiresAt time.Time
+}
+
+// revocationChecker кэширует ответы RevocationChecker по jti.
+//
+// Если auth сервис недоступен, токен с корректной подписью принимается:
+// отзыв - дополнительная проверка, и ее отказ не должен останавливать сервис.
+type revocationChecker struct {
+	checker  RevocationChecker
+	cacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]revocationEntry
+}
+
+func newRevocationChecker(checker RevocationChecker, cacheTTL time.Duration) *revocationChecker {
+	return &revocationChecker{
+		checker:  checker,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]revocationEntry),
+	}
+}
+
+func (c *revocationChecker) check(ctx context.Context, token string, claims *Claims) error {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.cache[claims.Id]
+	c.mu.Unlock()
+
+	if !ok || now.After(entry.expiresAt) {
+		valid, err := c.checker.Valid(ctx, token)
+		if err != nil {
+			if status.Code(err) == codes.Unavailable || status.Code(err) == codes.DeadlineExceeded {
+				log.Printf("Revocation check unavailable, accepting token %s: %v", claims.Id, err)
+				return nil
+			}
+			return err
+		}
+
+		entry = revocationEntry{valid: valid, expiresAt: now.Add(c.cacheTTL)}
+		c.store(claims.Id, entry, now)
+	}
+
+	if !entry.valid {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+func (c *revocationChecker) store(jti string, entry revocationEntry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cache) >= maxRevocationCacheSize {
+		for k, e := range c.cache {
+			if now.After(e.expiresAt) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= maxRevocationCacheSize {
+			c.cache = make(map[string]revocationEntry)
+		}
+	}
+	c.cache[jti] = entry
+}
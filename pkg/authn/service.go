@@ -0,0 +1,127 @@
+package authn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	pb_auth "dd/pkg/auth"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// tokenMethod - RPC выпуска токена; сам он вызывается без токена сервиса
+	tokenMethod = "/auth.AuthService/Token"
+	// serviceTokenRefreshMargin - за сколько до истечения токен сервиса перевыпускается
+	serviceTokenRefreshMargin = time.Minute
+
+	// ServiceAuthorizationKey - ключ метаданных с токеном сервиса, который
+	// передает вызов от имени пользователя: authorization занят токеном
+	// пользователя. По нему auth узнает proxy и принимает от него адрес клиента.
+	ServiceAuthorizationKey = "x-service-authorization"
+)
+
+// ServiceTokenSource выпускает токены, которыми сервис подписывает свои вызовы
+type ServiceTokenSource interface {
+	ServiceToken(ctx context.Context) (token string, expiresAt time.Time, err error)
+}
+
+// ServiceTokenFunc позволяет использовать функцию как ServiceTokenSource
+type ServiceTokenFunc func(ctx context.Context) (string, time.Time, error)
+
+func (f ServiceTokenFunc) ServiceToken(ctx context.Context) (string, time.Time, error) {
+	return f(ctx)
+}
+
+// AuthServiceTokenSource получает токены через RPC Token auth сервиса
+// по grant_type=client_credentials
+type AuthServiceTokenSource struct {
+	authClient   pb_auth.AuthServiceClient
+	clientID     string
+	clientSecret string
+}
+
+// NewAuthServiceTokenSource создает источник токенов для клиента clientID
+func NewAuthServiceTokenSource(authClient pb_auth.AuthServiceClient, clientID, clientSecret string) *AuthServiceTokenSource {
+	return &AuthServiceTokenSource{
+		authClient:   authClient,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+	}
+}
+
+func (s *AuthServiceTokenSource) ServiceToken(ctx context.Context) (string, time.Time, error) {
+	resp, err := s.authClient.Token(ctx, &pb_auth.TokenRequest{
+		GrantType:    "client_credentials",
+		ClientId:     s.clientID,
+		ClientSecret: s.clientSecret,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return resp.AccessToken, time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second), nil
+}
+
+// ServiceCredentials - gRPC PerRPCCredentials, добавляющие к вызовам токен
+// сервиса. Токен кэшируется и перевыпускается незадолго до истечения.
+//
+// Вызовы, в метаданных которых уже есть токен пользователя или API ключ,
+// выполняются от имени пользователя: токен сервиса передается в них под
+// ключом ServiceAuthorizationKey и прав вызову не дает.
+type ServiceCredentials struct {
+	source ServiceTokenSource
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewServiceCredentials создает учетные данные сервиса поверх source
+func NewServiceCredentials(source ServiceTokenSource) *ServiceCredentials {
+	return &ServiceCredentials{source: source}
+}
+
+func (c *ServiceCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	if ri, ok := credentials.RequestInfoFromContext(ctx); ok && ri.Method == tokenMethod {
+		return nil, nil
+	}
+	key := "authorization"
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if len(md.Get("authorization")) > 0 || len(md.Get("x-api-key")) > 0 {
+			key = ServiceAuthorizationKey
+		}
+	}
+
+	token, err := c.serviceToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{key: "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity разрешает передачу токена без TLS: сервисы
+// общаются во внутренней сети
+func (c *ServiceCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+func (c *ServiceCredentials) serviceToken(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Until(c.expiresAt) > serviceTokenRefreshMargin {
+		return c.token, nil
+	}
+
+	token, expiresAt, err := c.source.ServiceToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get service token: %v", err)
+	}
+	c.token = strings.TrimPrefix(token, "Bearer ")
+	c.expiresAt = expiresAt
+	return c.token, nil
+}
@@ -0,0 +1,210 @@
+// Package authn проверяет access-токены auth сервиса локально, по
+// опубликованным публичным ключам, без RPC на каждый запрос.
+package authn
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	defaultKeysTTL       = 5 * time.Minute
+	defaultMinKeyRefresh = 10 * time.Second
+)
+
+// ErrInvalidToken возвращается для токена, не прошедшего проверку
+var ErrInvalidToken = errors.New("invalid token")
+
+type verificationKey struct {
+	alg string
+	pub crypto.PublicKey
+}
+
+// Verifier проверяет подпись и стандартные claims токенов.
+//
+// Ключи кэшируются на keysTTL и перечитываются досрочно, если встретился
+// незнакомый kid (не чаще раза в minKeyRefresh). Если источник ключей
+// недоступен, проверка продолжается по уже загруженным ключам.
+type Verifier struct {
+	source        KeySource
+	issuer        string
+	audience      string
+	keysTTL       time.Duration
+	minKeyRefresh time.Duration
+	revocation    *revocationChecker
+	apiKeys       *apiKeyCache
+
+	mu        sync.RWMutex
+	keys      map[string]verificationKey
+	fetchedAt time.Time
+	fetchMu   sync.Mutex
+}
+
+// Option настраивает Verifier
+type Option func(*Verifier)
+
+// WithKeysTTL задает время кэширования ключей
+func WithKeysTTL(ttl time.Duration) Option {
+	return func(v *Verifier) {
+		v.keysTTL = ttl
+	}
+}
+
+// WithRevocationCheck включает проверку отзыва токенов через checker
+// с кэшированием результата на cacheTTL
+func WithRevocationCheck(checker RevocationChecker, cacheTTL time.Duration) Option {
+	return func(v *Verifier) {
+		v.revocation = newRevocationChecker(checker, cacheTTL)
+	}
+}
+
+// WithAPIKeys включает прием API ключей через resolver с кэшированием
+// результата на cacheTTL
+func WithAPIKeys(resolver APIKeyResolver, cacheTTL time.Duration) Option {
+	return func(v *Verifier) {
+		v.apiKeys = newAPIKeyCache(resolver, cacheTTL)
+	}
+}
+
+// NewVerifier создает проверяющего для токенов издателя issuer с аудиторией audience
+func NewVerifier(source KeySource, issuer, audience string, opts ...Option) *Verifier {
+	v := &Verifier{
+		source:        source,
+		issuer:        issuer,
+		audience:      audience,
+		keysTTL:       defaultKeysTTL,
+		minKeyRefresh: defaultMinKeyRefresh,
+		keys:          make(map[string]verificationKey),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// Verify проверяет токен и возвращает вызывающего
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (*Principal, error) {
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := v.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		// Алгоритм задается ключом, а не заголовком токена
+		if token.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.pub, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	if !claims.VerifyIssuer(v.issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	}
+	if !claims.VerifyAudience(v.audience, true) {
+		return nil, fmt.Errorf("%w: unexpected audience %q", ErrInvalidToken, claims.Audience)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidToken)
+	}
+
+	if v.revocation != nil {
+		if err := v.revocation.check(ctx, tokenString, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	return principalFromClaims(claims), nil
+}
+
+// VerifyAPIKey проверяет API ключ и возвращает его владельца с разрешениями ключа
+func (v *Verifier) VerifyAPIKey(ctx context.Context, key string) (*Principal, error) {
+	if v.apiKeys == nil {
+		return nil, fmt.Errorf("%w: API keys are not accepted", ErrInvalidToken)
+	}
+	if key == "" {
+		return nil, ErrInvalidToken
+	}
+	return v.apiKeys.resolve(ctx, key)
+}
+
+// key возвращает ключ по kid, при необходимости перечитывая набор ключей
+func (v *Verifier) key(ctx context.Context, kid string) (verificationKey, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) > v.keysTTL
+	v.mu.RUnlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	if err := v.refresh(ctx, !ok); err != nil {
+		if ok {
+			log.Printf("Failed to refresh verification keys, using cached ones: %v", err)
+			return key, nil
+		}
+		return verificationKey{}, err
+	}
+
+	v.mu.RLock()
+	key, ok = v.keys[kid]
+	v.mu.RUnlock()
+	if !ok {
+		return verificationKey{}, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	return key, nil
+}
+
+// refresh перечитывает ключи; unknownKid ограничивает частоту внеплановых запросов
+func (v *Verifier) refresh(ctx context.Context, unknownKid bool) error {
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+
+	v.mu.RLock()
+	since := time.Since(v.fetchedAt)
+	v.mu.RUnlock()
+
+	// Пока ждали блокировку, ключи мог перечитать другой запрос
+	if since < v.minKeyRefresh || (!unknownKid && since <= v.keysTTL) {
+		return nil
+	}
+
+	jwks, err := v.source.Keys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch verification keys: %v", err)
+	}
+
+	keys := make(map[string]verificationKey, len(jwks))
+	for _, jwk := range jwks {
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("Skipping verification key %s: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = verificationKey{alg: jwk.Alg, pub: pub}
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+
+	return nil
+}
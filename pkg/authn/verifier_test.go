@@ -0,0 +1,403 @@
+package authn
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testKey struct {
+	kid     string
+	alg     string
+	private interface{}
+	public  interface{}
+}
+
+func newEdDSAKey(t *testing.T, kid string) testKey {
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	return testKey{kid: kid, alg: "EdDSA", private: private, public: pub}
+}
+
+// fakeKeySource отдает заданный набор ключей и считает обращения
+type fakeKeySource struct {
+	mu    sync.Mutex
+	keys  []JSONWebKey
+	err   error
+	calls int
+}
+
+func (s *fakeKeySource) Keys(ctx context.Context) ([]JSONWebKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return s.keys, s.err
+}
+
+func (s *fakeKeySource) set(keys ...testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	for _, k := range keys {
+		s.keys = append(s.keys, NewJSONWebKey(k.kid, k.alg, k.public))
+	}
+}
+
+type fakeRevocationChecker struct {
+	valid bool
+	err   error
+	calls int
+}
+
+func (c *fakeRevocationChecker) Valid(ctx context.Context, token string) (bool, error) {
+	c.calls++
+	return c.valid, c.err
+}
+
+func validClaims() Claims {
+	now := time.Now()
+	return Claims{
+		Email: "test@example.com",
+		Roles: []string{"user"},
+		Scope: "geo:search geo:geocode",
+		StandardClaims: jwt.StandardClaims{
+			Id:        "jti-1",
+			Subject:   "user-1",
+			Issuer:    "test-issuer",
+			Audience:  "test-audience",
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		},
+	}
+}
+
+func sign(t *testing.T, key testKey, claims Claims) string {
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.alg), claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	key := newEdDSAKey(t, "k1")
+	source := &fakeKeySource{}
+	source.set(key)
+	v := NewVerifier(source, "test-issuer", "test-audience")
+
+	t.Run("valid token", func(t *testing.T) {
+		principal, err := v.Verify(context.Background(), "Bearer "+sign(t, key, validClaims()))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "user-1", principal.UserID)
+		assert.Equal(t, "test@example.com", principal.Email)
+		assert.Equal(t, "jti-1", principal.TokenID)
+		assert.True(t, principal.HasRole("user"))
+		assert.False(t, principal.HasRole("admin"))
+		assert.Equal(t, []string{"geo:search", "geo:geocode"}, principal.Scopes)
+		assert.True(t, principal.HasScope("geo:search"))
+	})
+
+	t.Run("keys are cached", func(t *testing.T) {
+		calls := source.calls
+		_, err := v.Verify(context.Background(), sign(t, key, validClaims()))
+		assert.NoError(t, err)
+		assert.Equal(t, calls, source.calls)
+	})
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	rejected := map[string]string{
+		"wrong issuer": func() string {
+			c := validClaims()
+			c.Issuer = "other"
+			return sign(t, key, c)
+		}(),
+		"wrong audience": func() string {
+			c := validClaims()
+			c.Audience = "other"
+			return sign(t, key, c)
+		}(),
+		"expired": func() string {
+			c := validClaims()
+			c.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+			return sign(t, key, c)
+		}(),
+		"no subject": func() string {
+			c := validClaims()
+			c.Subject = ""
+			return sign(t, key, c)
+		}(),
+		"algorithm differs from key": sign(t, testKey{kid: "k1", alg: "RS256", private: rsaKey}, validClaims()),
+		"hmac":                       sign(t, testKey{kid: "k1", alg: "HS256", private: []byte("secret")}, validClaims()),
+		"garbage":                    "not-a-token",
+	}
+	for name, token := range rejected {
+		t.Run(name, func(t *testing.T) {
+			_, err := v.Verify(context.Background(), token)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestVerifier_KeyRotation(t *testing.T) {
+	oldKey := newEdDSAKey(t, "old")
+	newKey := newEdDSAKey(t, "new")
+	source := &fakeKeySource{}
+	source.set(oldKey)
+	v := NewVerifier(source, "test-issuer", "test-audience")
+	v.minKeyRefresh = 0
+
+	_, err := v.Verify(context.Background(), sign(t, oldKey, validClaims()))
+	assert.NoError(t, err)
+
+	// Незнакомый kid приводит к внеплановому перечитыванию ключей
+	source.set(oldKey, newKey)
+	_, err = v.Verify(context.Background(), sign(t, newKey, validClaims()))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, source.calls)
+
+	t.Run("unknown kid refresh is rate limited", func(t *testing.T) {
+		v.minKeyRefresh = time.Hour
+		calls := source.calls
+
+		for i := 0; i < 3; i++ {
+			_, err := v.Verify(context.Background(), sign(t, newEdDSAKey(t, "unknown"), validClaims()))
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		}
+		assert.Equal(t, calls, source.calls)
+	})
+
+	t.Run("cached keys survive source outage", func(t *testing.T) {
+		v.minKeyRefresh = 0
+		v.keysTTL = 0
+		source.err = errors.New("auth is down")
+
+		_, err := v.Verify(context.Background(), sign(t, newKey, validClaims()))
+		assert.NoError(t, err)
+	})
+}
+
+func TestVerifier_RevocationCheck(t *testing.T) {
+	key := newEdDSAKey(t, "k1")
+	source := &fakeKeySource{}
+	source.set(key)
+	token := sign(t, key, validClaims())
+
+	t.Run("revoked", func(t *testing.T) {
+		checker := &fakeRevocationChecker{valid: false}
+		v := NewVerifier(source, "test-issuer", "test-audience", WithRevocationCheck(checker, time.Minute))
+
+		_, err := v.Verify(context.Background(), token)
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("result is cached", func(t *testing.T) {
+		checker := &fakeRevocationChecker{valid: true}
+		v := NewVerifier(source, "test-issuer", "test-audience", WithRevocationCheck(checker, time.Minute))
+
+		for i := 0; i < 3; i++ {
+			_, err := v.Verify(context.Background(), token)
+			assert.NoError(t, err)
+		}
+		assert.Equal(t, 1, checker.calls)
+	})
+
+	t.Run("auth unavailable", func(t *testing.T) {
+		checker := &fakeRevocationChecker{err: status.Error(codes.Unavailable, "down")}
+		v := NewVerifier(source, "test-issuer", "test-audience", WithRevocationCheck(checker, time.Minute))
+
+		_, err := v.Verify(context.Background(), token)
+		assert.NoError(t, err)
+	})
+}
+
+type fakeAPIKeyResolver struct {
+	principal *Principal
+	err       error
+	calls     int
+}
+
+func (r *fakeAPIKeyResolver) ResolveAPIKey(ctx context.Context, key string) (*Principal, error) {
+	r.calls++
+	return r.principal, r.err
+}
+
+func TestVerifier_APIKeys(t *testing.T) {
+	source := &fakeKeySource{}
+
+	t.Run("not accepted", func(t *testing.T) {
+		v := NewVerifier(source, "test-issuer", "test-audience")
+
+		_, err := v.VerifyAPIKey(context.Background(), "ddk_key")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("result is cached", func(t *testing.T) {
+		resolver := &fakeAPIKeyResolver{principal: &Principal{UserID: "user-1", APIKeyID: "key-1", Scopes: []string{"geo:search"}}}
+		v := NewVerifier(source, "test-issuer", "test-audience", WithAPIKeys(resolver, time.Minute))
+
+		for i := 0; i < 3; i++ {
+			p, err := v.VerifyAPIKey(context.Background(), "ddk_key")
+			assert.NoError(t, err)
+			assert.Equal(t, "key-1", p.APIKeyID)
+		}
+		assert.Equal(t, 1, resolver.calls)
+	})
+
+	t.Run("invalid key is cached", func(t *testing.T) {
+		resolver := &fakeAPIKeyResolver{err: ErrInvalidToken}
+		v := NewVerifier(source, "test-issuer", "test-audience", WithAPIKeys(resolver, time.Minute))
+
+		for i := 0; i < 3; i++ {
+			_, err := v.VerifyAPIKey(context.Background(), "ddk_key")
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		}
+		assert.Equal(t, 1, resolver.calls)
+	})
+
+	t.Run("auth unavailable", func(t *testing.T) {
+		resolver := &fakeAPIKeyResolver{err: status.Error(codes.Unavailable, "down")}
+		v := NewVerifier(source, "test-issuer", "test-audience", WithAPIKeys(resolver, time.Minute))
+
+		for i := 0; i < 2; i++ {
+			_, err := v.VerifyAPIKey(context.Background(), "ddk_key")
+			assert.Error(t, err)
+		}
+		assert.Equal(t, 2, resolver.calls)
+	})
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	key := newEdDSAKey(t, "k1")
+	source := &fakeKeySource{}
+	source.set(key)
+	interceptor := UnaryServerInterceptor(NewVerifier(source, "test-issuer", "test-audience"))
+
+	var got *Principal
+	var gotService string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		got, _ = FromContext(ctx)
+		gotService, _ = ServiceFromContext(ctx)
+		return "ok", nil
+	}
+	call := func(ctx context.Context) error {
+		got, gotService = nil, ""
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}, handler)
+		return err
+	}
+
+	t.Run("authenticated", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+sign(t, key, validClaims()),
+		))
+		assert.NoError(t, call(ctx))
+		assert.Equal(t, "user-1", got.UserID)
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		assert.NoError(t, call(context.Background()))
+		assert.Nil(t, got)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer garbage",
+		))
+		assert.Equal(t, codes.Unauthenticated, status.Code(call(ctx)))
+	})
+
+	serviceClaims := func(clientID string) Claims {
+		claims := validClaims()
+		claims.Subject = clientID
+		claims.ClientID = clientID
+		return claims
+	}
+
+	t.Run("service caller", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+sign(t, key, serviceClaims("proxy")),
+		))
+		assert.NoError(t, call(ctx))
+		assert.Equal(t, "proxy", got.ClientID)
+		assert.Equal(t, "proxy", gotService)
+	})
+
+	t.Run("user call forwarded by service", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+sign(t, key, validClaims()),
+			ServiceAuthorizationKey, "Bearer "+sign(t, key, serviceClaims("proxy")),
+		))
+		assert.NoError(t, call(ctx))
+		assert.Equal(t, "user-1", got.UserID)
+		assert.Equal(t, "proxy", gotService)
+	})
+
+	t.Run("user token is not a service token", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"authorization", "Bearer "+sign(t, key, validClaims()),
+			ServiceAuthorizationKey, "Bearer "+sign(t, key, validClaims()),
+		))
+		assert.Equal(t, codes.Unauthenticated, status.Code(call(ctx)))
+	})
+
+	t.Run("api key without resolver", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"x-api-key", "ddk_key",
+		))
+		assert.Equal(t, codes.Unauthenticated, status.Code(call(ctx)))
+	})
+}
+
+func TestServiceCredentials(t *testing.T) {
+	calls := 0
+	expiresAt := time.Now().Add(10 * time.Minute)
+	creds := NewServiceCredentials(ServiceTokenFunc(func(ctx context.Context) (string, time.Time, error) {
+		calls++
+		return fmt.Sprintf("token-%d", calls), expiresAt, nil
+	}))
+
+	t.Run("token is cached", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			md, err := creds.GetRequestMetadata(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "Bearer token-1", md["authorization"])
+		}
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("token is refreshed before expiry", func(t *testing.T) {
+		expiresAt = time.Now().Add(30 * time.Second)
+		creds.expiresAt = expiresAt
+
+		md, err := creds.GetRequestMetadata(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "Bearer token-2", md["authorization"])
+	})
+
+	t.Run("user token is kept", func(t *testing.T) {
+		// Токен сервиса передается отдельно, чтобы получатель знал, кто передал вызов
+		expiresAt = time.Now().Add(10 * time.Minute)
+		creds.expiresAt = time.Time{}
+
+		for _, pairs := range [][]string{{"authorization", "Bearer user-token"}, {"x-api-key", "key"}} {
+			md, err := creds.GetRequestMetadata(metadata.NewOutgoingContext(context.Background(), metadata.Pairs(pairs...)))
+			assert.NoError(t, err)
+			assert.Equal(t, map[string]string{ServiceAuthorizationKey: "Bearer token-3"}, md)
+		}
+	})
+}
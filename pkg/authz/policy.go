@@ -0,0 +1,125 @@
+// Package authz решает, может ли аутентифицированный вызывающий выполнить
+// операцию. Правила задаются декларативно, картой операция -> Rule, и
+// применяются одинаково в gRPC сервисах и в proxy.
+package authz
+
+import (
+	"context"
+
+	"dd/pkg/authn"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// RoleUser - роль обычного пользователя, назначается при регистрации
+	RoleUser = "user"
+	// RoleAdmin - роль администратора
+	RoleAdmin = "admin"
+	// RoleService - роль токенов, выпущенных сервисам по client_credentials
+	RoleService = "service"
+)
+
+// Разрешения, которые можно выдать API ключу
+const (
+	ScopeGeoSearch  = "geo:search"
+	ScopeGeoGeocode = "geo:geocode"
+)
+
+// Scopes - все разрешения, известные сервисам. Токены пользователей
+// выпускаются со всеми разрешениями, API ключи - с выбранными при создании.
+var Scopes = []string{ScopeGeoSearch, ScopeGeoGeocode}
+
+// Разрешения сервисов. Выдаются только клиентам client_credentials и не
+// входят в Scopes, поэтому их не получат ни пользователи, ни API ключи.
+const (
+	// ScopeUserAuthenticate - регистрация и вход пользователей, выдается auth сервису
+	ScopeUserAuthenticate = "user:authenticate"
+	// ScopeUserData - выгрузка и удаление данных пользователя в других сервисах, выдается user сервису
+	ScopeUserData = "user:data"
+)
+
+// OwnerFunc сообщает, принадлежит ли ресурс, к которому обращается req, вызывающему p
+type OwnerFunc func(p *authn.Principal, req interface{}) bool
+
+// Rule - требования к вызывающему для одной операции.
+//
+// Аутентифицированный вызывающий допускается, если у него есть одна из
+// Roles либо Owner признает его владельцем ресурса. Правило без Roles и
+// Owner допускает любого аутентифицированного вызывающего.
+//
+// Если у правила заданы Scopes, вызывающему нужно еще и одно из этих
+// разрешений. API ключ допускается только к операциям со Scopes, а
+// сервис - только к операциям, в Roles которых явно указана RoleService.
+type Rule struct {
+	// Public допускает вызов без аутентификации
+	Public bool
+	Roles  []string
+	Owner  OwnerFunc
+	Scopes []string
+}
+
+// Policy сопоставляет операциям правила доступа. Операция без правила запрещена.
+type Policy map[string]Rule
+
+// Authorize проверяет доступ вызывающего из ctx к операции op.
+// Ошибки возвращаются в виде gRPC статусов Unauthenticated и PermissionDenied.
+func (p Policy) Authorize(ctx context.Context, op string, req interface{}) error {
+	rule, ok := p[op]
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "access to %s is not allowed", op)
+	}
+
+	principal, ok := authn.FromContext(ctx)
+	if !ok {
+		if rule.Public {
+			return nil
+		}
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	if rule.allows(principal, req) {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "access to %s is not allowed", op)
+}
+
+func (r Rule) allows(p *authn.Principal, req interface{}) bool {
+	if len(r.Scopes) > 0 && !r.allowsScopes(p) {
+		return false
+	}
+	if len(r.Scopes) == 0 && p.APIKeyID != "" {
+		return false
+	}
+	if p.ClientID != "" && !r.hasRole(RoleService) {
+		return false
+	}
+	if len(r.Roles) == 0 && r.Owner == nil {
+		return true
+	}
+	for _, role := range r.Roles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+	return r.Owner != nil && r.Owner(p, req)
+}
+
+func (r Rule) allowsScopes(p *authn.Principal) bool {
+	for _, scope := range r.Scopes {
+		if p.HasScope(scope) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) hasRole(role string) bool {
+	for _, rr := range r.Roles {
+		if rr == role {
+			return true
+		}
+	}
+	return false
+}
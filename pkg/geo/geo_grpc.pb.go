@@ -24,6 +24,8 @@ const _ = grpc.SupportPackageIsVersion7
 type GeoServiceClient interface {
 	SearchAddress(ctx context.Context, in *SearchAddressRequest, opts ...grpc.CallOption) (*SearchAddressResponse, error)
 	Geocode(ctx context.Context, in *GeocodeRequest, opts ...grpc.CallOption) (*GeocodeResponse, error)
+	ExportUserData(ctx context.Context, in *ExportUserDataRequest, opts ...grpc.CallOption) (*ExportUserDataResponse, error)
+	PurgeUserData(ctx context.Context, in *PurgeUserDataRequest, opts ...grpc.CallOption) (*PurgeUserDataResponse, error)
 }
 
 type geoServiceClient struct {
@@ -52,12 +54,32 @@ func (c *geoServiceClient) Geocode(ctx context.Context, in *GeocodeRequest, opts
 	return out, nil
 }
 
+func (c *geoServiceClient) ExportUserData(ctx context.Context, in *ExportUserDataRequest, opts ...grpc.CallOption) (*ExportUserDataResponse, error) {
+	out := new(ExportUserDataResponse)
+	err := c.cc.Invoke(ctx, "/geo.GeoService/ExportUserData", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *geoServiceClient) PurgeUserData(ctx context.Context, in *PurgeUserDataRequest, opts ...grpc.CallOption) (*PurgeUserDataResponse, error) {
+	out := new(PurgeUserDataResponse)
+	err := c.cc.Invoke(ctx, "/geo.GeoService/PurgeUserData", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GeoServiceServer is the server API for GeoService service.
 // All implementations must embed UnimplementedGeoServiceServer
 // for forward compatibility
 type GeoServiceServer interface {
 	SearchAddress(context.Context, *SearchAddressRequest) (*SearchAddressResponse, error)
 	Geocode(context.Context, *GeocodeRequest) (*GeocodeResponse, error)
+	ExportUserData(context.Context, *ExportUserDataRequest) (*ExportUserDataResponse, error)
+	PurgeUserData(context.Context, *PurgeUserDataRequest) (*PurgeUserDataResponse, error)
 	mustEmbedUnimplementedGeoServiceServer()
 }
 
@@ -71,6 +93,12 @@ func (UnimplementedGeoServiceServer) SearchAddress(context.Context, *SearchAddre
 func (UnimplementedGeoServiceServer) Geocode(context.Context, *GeocodeRequest) (*GeocodeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Geocode not implemented")
 }
+func (UnimplementedGeoServiceServer) ExportUserData(context.Context, *ExportUserDataRequest) (*ExportUserDataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportUserData not implemented")
+}
+func (UnimplementedGeoServiceServer) PurgeUserData(context.Context, *PurgeUserDataRequest) (*PurgeUserDataResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PurgeUserData not implemented")
+}
 func (UnimplementedGeoServiceServer) mustEmbedUnimplementedGeoServiceServer() {}
 
 // UnsafeGeoServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +148,42 @@ func _GeoService_Geocode_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _GeoService_ExportUserData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportUserDataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GeoServiceServer).ExportUserData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/geo.GeoService/ExportUserData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GeoServiceServer).ExportUserData(ctx, req.(*ExportUserDataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GeoService_PurgeUserData_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PurgeUserDataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GeoServiceServer).PurgeUserData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/geo.GeoService/PurgeUserData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GeoServiceServer).PurgeUserData(ctx, req.(*PurgeUserDataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // GeoService_ServiceDesc is the grpc.ServiceDesc for GeoService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,6 +199,14 @@ var GeoService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Geocode",
 			Handler:    _GeoService_Geocode_Handler,
 		},
+		{
+			MethodName: "ExportUserData",
+			Handler:    _GeoService_ExportUserData_Handler,
+		},
+		{
+			MethodName: "PurgeUserData",
+			Handler:    _GeoService_PurgeUserData_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/geo.proto",
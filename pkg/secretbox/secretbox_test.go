@@ -0,0 +1,86 @@
+package secretbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testKey = "mWqv0eJ3Xq0a8C5l2mFQk7b1gOaQz4n6Yt9rS3uVw8E="
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		err  string
+	}{
+		{name: "valid key", key: testKey},
+		{name: "not base64", key: "not a key!", err: "failed to decode encryption key"},
+		{name: "short key", key: "c2hvcnQ=", err: "encryption key must be 32 bytes, got 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			box, err := New(tt.key)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, box)
+		})
+	}
+}
+
+func TestBox(t *testing.T) {
+	box, err := New(testKey)
+	assert.NoError(t, err)
+	secret := []byte("totp secret")
+
+	t.Run("round trip", func(t *testing.T) {
+		sealed, err := box.Seal(secret)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(sealed), string(secret))
+
+		opened, err := box.Open(sealed)
+		assert.NoError(t, err)
+		assert.Equal(t, secret, opened)
+	})
+
+	t.Run("nonce is random", func(t *testing.T) {
+		first, err := box.Seal(secret)
+		assert.NoError(t, err)
+		second, err := box.Seal(secret)
+		assert.NoError(t, err)
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("tampered ciphertext is rejected", func(t *testing.T) {
+		sealed, err := box.Seal(secret)
+		assert.NoError(t, err)
+
+		// Любой измененный байт - в nonce, шифртексте или теге - ломает проверку
+		for _, i := range []int{0, len(sealed) / 2, len(sealed) - 1} {
+			tampered := append([]byte(nil), sealed...)
+			tampered[i] ^= 0x01
+			_, err := box.Open(tampered)
+			assert.Error(t, err, "byte %d", i)
+		}
+	})
+
+	t.Run("other key is rejected", func(t *testing.T) {
+		sealed, err := box.Seal(secret)
+		assert.NoError(t, err)
+
+		other, err := New("AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=")
+		assert.NoError(t, err)
+		_, err = other.Open(sealed)
+		assert.Error(t, err)
+	})
+
+	t.Run("too short", func(t *testing.T) {
+		_, err := box.Open([]byte("short"))
+		assert.EqualError(t, err, "ciphertext is too short")
+	})
+}
@@ -0,0 +1,24 @@
+package config
+
+import "os"
+
+type Config struct {
+    AuthService  string
+    GeoService   string
+    UserService  string
+    // ClientID и ClientSecret - учетные данные proxy для получения токена сервиса.
+    // Секрет задается переменной PROXY_CLIENT_SECRET и должен совпадать с
+    // AUTH_PROXY_CLIENT_SECRET auth сервиса.
+    ClientID     string
+    ClientSecret string
+}
+
+func New() *Config {
+    return &Config{
+        AuthService:  "auth:50051",
+        GeoService:   "geo:50052",
+        UserService:  "user:50053",
+        ClientID:     "proxy",
+        ClientSecret: os.Getenv("PROXY_CLIENT_SECRET"),
+    }
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// httpStatus сопоставляет ошибке gRPC сервиса HTTP статус ответа
+func httpStatus(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// ErrorResponse Ошибка проверки полей запроса
+type ErrorResponse struct {
+	Error  string       `json:"error" example:"password does not meet requirements"`
+	Fields []FieldError `json:"fields"`
+}
+
+// FieldError Нарушенное требование к полю запроса
+type FieldError struct {
+	Field       string `json:"field" example:"password"`
+	Description string `json:"description" example:"must be at least 10 characters long"`
+}
+
+// writeError отвечает клиенту статусом, соответствующим ошибке gRPC сервиса.
+// Если сервис перечислил ошибки в полях запроса, они возвращаются как ErrorResponse.
+func writeError(w http.ResponseWriter, err error) {
+	setRetryAfter(w, err)
+
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok || len(badRequest.FieldViolations) == 0 {
+			continue
+		}
+		resp := ErrorResponse{Error: st.Message()}
+		for _, violation := range badRequest.FieldViolations {
+			resp.Fields = append(resp.Fields, FieldError{
+				Field:       violation.Field,
+				Description: violation.Description,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(httpStatus(err))
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	http.Error(w, st.Message(), httpStatus(err))
+}
+
+// setRetryAfter выставляет заголовок Retry-After по деталям RetryInfo ошибки
+func setRetryAfter(w http.ResponseWriter, err error) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.RetryDelay != nil {
+			seconds := int64(math.Ceil(info.RetryDelay.AsDuration().Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			return
+		}
+	}
+}
+
+// clientIP возвращает адрес клиента, от которого proxy получил запрос
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
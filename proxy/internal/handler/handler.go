@@ -12,22 +12,30 @@ package handler
 import (
 	"context"
 	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
 	pb_geo "dd/pkg/geo"
 	pb_user "dd/pkg/user"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
+	"strings"
 
 	"log"
 
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 // RegisterRequest Запрос на регистрацию
 type RegisterRequest struct {
 	Email    string `json:"email" example:"user@example.com"`
-	Password string `json:"password" example:"password123"`
+	Password string `json:"password" example:"Correct-horse-42"`
 }
 
 // LoginRequest Запрос на вход
@@ -36,9 +44,125 @@ type LoginRequest struct {
 	Password string `json:"password" example:"password123"`
 }
 
-// AuthResponse Ответ с токеном
+// AuthResponse Ответ с токеном. Если у пользователя подключен второй фактор,
+// вместо токенов возвращается mfa_token для /auth/mfa/verify
 type AuthResponse struct {
-	Token string `json:"token" example:"eyJhbGciOiJIUzI1NiIs..."`
+	Token        string `json:"token,omitempty" example:"eyJhbGciOiJIUzI1NiIs..."`
+	RefreshToken string `json:"refresh_token,omitempty" example:"q0Vb3nX1bq3d9y..."`
+	ExpiresIn    int64  `json:"expires_in,omitempty" example:"900"`
+	MFARequired  bool   `json:"mfa_required,omitempty" example:"false"`
+	MFAToken     string `json:"mfa_token,omitempty"`
+}
+
+// RefreshRequest Запрос на обновление токена
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" example:"q0Vb3nX1bq3d9y..."`
+}
+
+// LogoutRequest Запрос на выход
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token" example:"q0Vb3nX1bq3d9y..."`
+}
+
+// UnlockAccountRequest Запрос на снятие блокировки входа
+type UnlockAccountRequest struct {
+	Email string `json:"email" example:"user@example.com"`
+}
+
+// ForgotPasswordRequest Запрос письма для сброса пароля
+type ForgotPasswordRequest struct {
+	Email string `json:"email" example:"user@example.com"`
+}
+
+// ResetPasswordRequest Запрос на установку нового пароля по токену из письма
+type ResetPasswordRequest struct {
+	Token    string `json:"token" example:"dGhpcyBpcyBub3QgYSByZWFsIHRva2Vu"`
+	Password string `json:"password" example:"Battery-staple-42"`
+}
+
+// VerifyEmailRequest Запрос на подтверждение email по токену из письма
+type VerifyEmailRequest struct {
+	Token string `json:"token" example:"dGhpcyBpcyBub3QgYSByZWFsIHRva2Vu"`
+}
+
+// VerifyMFARequest Запрос на завершение входа кодом второго фактора
+type VerifyMFARequest struct {
+	MFAToken string `json:"mfa_token" example:"dGhpcyBpcyBub3QgYSByZWFsIHRva2Vu"`
+	Code     string `json:"code" example:"123456"`
+}
+
+// EnrollTOTPResponse Данные для подключения приложения-аутентификатора
+type EnrollTOTPResponse struct {
+	OtpauthURI    string   `json:"otpauth_uri" example:"otpauth://totp/DD:user@example.com?secret=JBSWY3DPEHPK3PXP&issuer=DD"`
+	Secret        string   `json:"secret" example:"JBSWY3DPEHPK3PXP"`
+	RecoveryCodes []string `json:"recovery_codes" example:"abcde-fghij"`
+}
+
+// ConfirmTOTPRequest Запрос на подтверждение подключения TOTP
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" example:"123456"`
+}
+
+// CreateAPIKeyRequest Запрос на выпуск API ключа
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" example:"ci"`
+	Scopes []string `json:"scopes" example:"geo:search"`
+	// Срок действия в секундах, 0 - бессрочный
+	TTLSeconds int64 `json:"ttl_seconds,omitempty" example:"2592000"`
+	// Суточная квота запросов к геосервису, 0 - квота по умолчанию
+	DailyQuota int32 `json:"daily_quota,omitempty" example:"500"`
+}
+
+// APIKeyResponse API ключ. Сам ключ возвращается только при создании.
+type APIKeyResponse struct {
+	ID         string   `json:"id" example:"0b7d1a2e-6f9e-4c55-9a51-2d2f0c9f3b1a"`
+	Name       string   `json:"name" example:"ci"`
+	Prefix     string   `json:"prefix" example:"ddk_Xq3v9bTf"`
+	Scopes     []string `json:"scopes" example:"geo:search"`
+	DailyQuota int32    `json:"daily_quota,omitempty" example:"500"`
+	CreatedAt  string   `json:"created_at" example:"2024-03-03T12:00:00Z"`
+	LastUsedAt string   `json:"last_used_at,omitempty" example:"2024-03-04T08:30:00Z"`
+	ExpiresAt  string   `json:"expires_at,omitempty"`
+	RevokedAt  string   `json:"revoked_at,omitempty"`
+	Key        string   `json:"key,omitempty" example:"ddk_Xq3v9bTf..."`
+}
+
+// ListAPIKeysResponse Ответ со списком API ключей
+type ListAPIKeysResponse struct {
+	APIKeys []APIKeyResponse `json:"api_keys"`
+}
+
+// SessionResponse Сессия входа
+type SessionResponse struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	IP         string `json:"ip,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	// Current - сессия, в которой выпущен токен запроса
+	Current bool `json:"current"`
+}
+
+// ListSessionsResponse Ответ со списком сессий
+type ListSessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// JSONWebKey Публичный ключ проверки подписи токенов (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty" example:"RSA"`
+	Kid string `json:"kid" example:"3f2a9c1e7b6d4a05"`
+	Use string `json:"use" example:"sig"`
+	Alg string `json:"alg" example:"RS256"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty" example:"AQAB"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSResponse Набор ключей проверки подписи
+type JWKSResponse struct {
+	Keys []JSONWebKey `json:"keys"`
 }
 
 // Структуры для запросов geo сервиса
@@ -75,15 +199,96 @@ type GeocodeResponse struct {
 // Структуры для ответов user сервиса
 // ProfileResponse Ответ с данными пользователя
 type ProfileResponse struct {
-	ID        string `json:"id" example:"123"`
-	Email     string `json:"email" example:"user@example.com"`
-	CreatedAt string `json:"created_at" example:"2024-03-03T12:00:00Z"`
+	ID            string   `json:"id" example:"123"`
+	Email         string   `json:"email" example:"user@example.com"`
+	Role          string   `json:"role" example:"user"`
+	EmailVerified bool     `json:"email_verified" example:"true"`
+	MFAEnabled    bool     `json:"mfa_enabled" example:"false"`
+	CreatedAt     string   `json:"created_at" example:"2024-03-03T12:00:00Z"`
+	DisplayName   string   `json:"display_name,omitempty" example:"Иван Петров"`
+	Phone         string   `json:"phone,omitempty" example:"+79991234567"`
+	Locale        string   `json:"locale,omitempty" example:"ru-RU"`
+	Timezone      string   `json:"timezone,omitempty" example:"Europe/Moscow"`
+	AvatarURL     string   `json:"avatar_url,omitempty" example:"https://cdn.example.com/avatars/123.png"`
+	HomeAddress   *Address `json:"home_address,omitempty"`
+	UpdatedAt     string   `json:"updated_at,omitempty" example:"2024-03-04T08:30:00Z"`
+	// Version передается в PATCH /user/profile для защиты от одновременных изменений
+	Version int64 `json:"version" example:"3"`
+}
+
+// UpdateProfileRequest Частичное изменение профиля. Меняются только
+// переданные поля; null или пустая строка очищает поле.
+type UpdateProfileRequest struct {
+	DisplayName *string  `json:"display_name,omitempty" example:"Иван Петров"`
+	Phone       *string  `json:"phone,omitempty" example:"+79991234567"`
+	Locale      *string  `json:"locale,omitempty" example:"ru-RU"`
+	Timezone    *string  `json:"timezone,omitempty" example:"Europe/Moscow"`
+	AvatarURL   *string  `json:"avatar_url,omitempty" example:"https://cdn.example.com/avatars/123.png"`
+	HomeAddress *Address `json:"home_address,omitempty"`
+	// Version - версия профиля из последнего ответа; если профиль успел
+	// измениться, запрос отклоняется с 409
+	Version int64 `json:"version" example:"3"`
+}
+
+// UpdateUserRequest Запрос на изменение данных текущего пользователя
+type UpdateUserRequest struct {
+	Email string `json:"email" example:"new@example.com"`
+}
+
+// ChangePasswordRequest Запрос на смену пароля
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" example:"Correct-horse-42"`
+	NewPassword     string `json:"new_password" example:"Battery-staple-42"`
 }
 
 // ListUsersResponse Ответ со списком пользователей
 type ListUsersResponse struct {
 	Users []ProfileResponse `json:"users"`
-	Total int32             `json:"total" example:"42"`
+	// Total - число пользователей по фильтрам, только при include_total=true
+	Total int32 `json:"total,omitempty" example:"42"`
+	// NextCursor - курсор следующей страницы; на последней странице отсутствует
+	NextCursor string `json:"next_cursor,omitempty" example:"eyJzIjoiLWNyZWF0ZWRfYXQiLCJpZCI6IjEyMyJ9"`
+}
+
+// DataJobResponse Задача выгрузки или стирания данных пользователя
+type DataJobResponse struct {
+	ID     string `json:"id" example:"0b8f6a7e-5d1c-4f8e-9a57-3f2d1c0e9b4a"`
+	Type   string `json:"type" example:"export" enums:"export,erase"`
+	UserID string `json:"user_id" example:"123"`
+	Status string `json:"status" example:"pending" enums:"pending,running,succeeded,failed"`
+	// Error - причина последней неудачной попытки
+	Error      string `json:"error,omitempty"`
+	CreatedAt  string `json:"created_at" example:"2024-03-01T12:00:00Z"`
+	FinishedAt string `json:"finished_at,omitempty" example:"2024-03-01T12:00:05Z"`
+	// ExpiresAt - до какого времени архив выгрузки доступен для скачивания
+	ExpiresAt string `json:"expires_at,omitempty" example:"2024-03-08T12:00:05Z"`
+}
+
+// AuditEventResponse Запись журнала аудита
+type AuditEventResponse struct {
+	ID      int64  `json:"id" example:"42"`
+	Time    string `json:"time" example:"2024-03-01T12:00:00.123456Z"`
+	Service string `json:"service" example:"auth" enums:"auth,user"`
+	// Actor - ID пользователя или client_id сервиса; пусто для анонимного вызова
+	Actor     string `json:"actor,omitempty" example:"123"`
+	ActorType string `json:"actor_type" example:"user" enums:"user,service,anonymous"`
+	Action    string `json:"action" example:"auth.login"`
+	// Target - ID объекта действия; для неудачного входа - email
+	Target    string `json:"target,omitempty" example:"123"`
+	IP        string `json:"ip,omitempty" example:"203.0.113.7"`
+	UserAgent string `json:"user_agent,omitempty" example:"Mozilla/5.0"`
+	Result    string `json:"result" example:"success" enums:"success,failure,denied"`
+	Reason    string `json:"reason,omitempty"`
+	// PrevHash и Hash связывают записи в цепочку
+	PrevHash string `json:"prev_hash" example:"0000000000000000000000000000000000000000000000000000000000000000"`
+	Hash     string `json:"hash" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+}
+
+// QueryAuditLogResponse Страница журнала аудита, начиная с новых записей
+type QueryAuditLogResponse struct {
+	Events []AuditEventResponse `json:"events"`
+	// NextCursor - курсор следующей страницы; на последней странице отсутствует
+	NextCursor string `json:"next_cursor,omitempty" example:"NDI"`
 }
 
 type Handler struct {
@@ -102,14 +307,35 @@ func New(authClient pb_auth.AuthServiceClient, geoClient pb_geo.GeoServiceClient
 
 // Auth endpoints
 
+// clientContext передает сервису адрес и User-Agent клиента: они нужны
+// для ограничения попыток входа, запоминаются в сессии и попадают в журнал
+// аудита. Сервисы принимают их только вместе с токеном сервиса proxy.
+func clientContext(r *http.Request) context.Context {
+	return metadata.NewOutgoingContext(context.Background(), metadata.Pairs(
+		"x-client-ip", clientIP(r),
+		"x-user-agent", r.UserAgent(),
+	))
+}
+
+// callerContext передает сервису токен вызывающего вместе с адресом и
+// User-Agent клиента, которые попадают в журнал аудита
+func callerContext(r *http.Request) context.Context {
+	return metadata.NewOutgoingContext(context.Background(), metadata.Pairs(
+		"authorization", r.Header.Get("Authorization"),
+		"x-client-ip", clientIP(r),
+		"x-user-agent", r.UserAgent(),
+	))
+}
+
 // @Summary Register user
-// @Description Регистрация нового пользователя
+// @Description Регистрация нового пользователя. Пароль должен соответствовать политике паролей и не встречаться в известных утечках
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body RegisterRequest true "Данные для регистрации"
 // @Success 200 {object} AuthResponse
-// @Failure 400 {string} string "Invalid request"
+// @Failure 400 {object} ErrorResponse "Invalid request or password does not meet requirements"
+// @Failure 409 {string} string "User already exists"
 // @Failure 500 {string} string "Internal server error"
 // @Router /auth/register [post]
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
@@ -126,14 +352,14 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Отправляем запрос в auth сервис
-	resp, err := h.authClient.Register(context.Background(), &pb_auth.RegisterRequest{
+	resp, err := h.authClient.Register(clientContext(r), &pb_auth.RegisterRequest{
 		Email:    req.Email,
 		Password: req.Password,
 	})
 
 	if err != nil {
 		log.Printf("Registration failed: %v", err)
-		http.Error(w, "Registration failed: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -146,14 +372,16 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	// Отправляем ответ клиенту
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(AuthResponse{
-		Token: resp.Token,
+		Token:        resp.Token,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
 	}); err != nil {
 		log.Printf("Failed to encode response: %v", err)
 	}
 }
 
 // @Summary Login user
-// @Description Аутентификация пользователя
+// @Description Аутентификация пользователя. Если подключен второй фактор, возвращается mfa_token для /auth/mfa/verify
 // @Tags auth
 // @Accept json
 // @Produce json
@@ -161,6 +389,10 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} AuthResponse
 // @Failure 400 {string} string "Invalid request"
 // @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 423 {string} string "Account is temporarily locked"
+// @Failure 429 {string} string "Too many failed login attempts"
+// @Header 423,429 {integer} Retry-After "Секунд до следующей попытки"
 // @Router /auth/login [post]
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
@@ -176,208 +408,1325 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Отправляем запрос в auth сервис
-	resp, err := h.authClient.Login(context.Background(), &pb_auth.LoginRequest{
+	resp, err := h.authClient.Login(clientContext(r), &pb_auth.LoginRequest{
 		Email:    req.Email,
 		Password: req.Password,
 	})
 
 	if err != nil {
-		http.Error(w, "Login failed", http.StatusUnauthorized)
+		switch status.Code(err) {
+		case codes.ResourceExhausted:
+			setRetryAfter(w, err)
+			http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		case codes.PermissionDenied:
+			if authn.IsAccountLocked(err) {
+				setRetryAfter(w, err)
+				http.Error(w, "Account is temporarily locked", http.StatusLocked)
+				return
+			}
+			writeError(w, err)
+		default:
+			http.Error(w, "Login failed", http.StatusUnauthorized)
+		}
 		return
 	}
 
 	// Отправляем ответ клиенту
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(AuthResponse{
-		Token: resp.Token,
+		Token:        resp.Token,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
+		MFARequired:  resp.MfaRequired,
+		MFAToken:     resp.MfaToken,
 	})
 }
 
-// Geo endpoints
-
-// @Summary Search address
-// @Description Поиск адреса по строке
-// @Tags geo
+// @Summary Verify second factor
+// @Description Завершение входа кодом из приложения-аутентификатора или кодом восстановления
+// @Tags auth
 // @Accept json
 // @Produce json
-// @Param Authorization header string true "Bearer token"
-// @Param request body SearchAddressRequest true "Параметры поиска"
-// @Success 200 {object} SearchAddressResponse
+// @Param request body VerifyMFARequest true "Токен из ответа /auth/login и код"
+// @Success 200 {object} AuthResponse
 // @Failure 400 {string} string "Invalid request"
-// @Failure 401 {string} string "Unauthorized"
-// @Router /address/search [post]
-func (h *Handler) SearchAddress(w http.ResponseWriter, r *http.Request) {
-	var req SearchAddressRequest
+// @Failure 401 {string} string "Invalid code or expired mfa token"
+// @Router /auth/mfa/verify [post]
+func (h *Handler) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req VerifyMFARequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if req.MFAToken == "" || req.Code == "" {
+		http.Error(w, "MFA token and code are required", http.StatusBadRequest)
 		return
 	}
 
-	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(
-		"authorization", token,
-	))
-
-	resp, err := h.geoClient.SearchAddress(ctx, &pb_geo.SearchAddressRequest{
-		Query: req.Query,
+	resp, err := h.authClient.VerifyMFA(clientContext(r), &pb_auth.VerifyMFARequest{
+		MfaToken: req.MFAToken,
+		Code:     req.Code,
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token:        resp.Token,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
+	})
 }
 
-// @Summary Geocode coordinates
-// @Description Получение адреса по координатам
-// @Tags geo
-// @Accept json
+// @Summary Start OAuth login
+// @Description Перенаправление на страницу входа внешнего OIDC провайдера
+// @Tags auth
+// @Param provider path string true "Провайдер" example(mock)
+// @Success 302
+// @Failure 404 {string} string "Unknown provider"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/oauth/{provider}/start [get]
+func (h *Handler) StartOAuth(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.authClient.StartOAuth(context.Background(), &pb_auth.StartOAuthRequest{
+		Provider: mux.Vars(r)["provider"],
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, resp.AuthorizationUrl, http.StatusFound)
+}
+
+// @Summary OAuth callback
+// @Description Адрес, на который провайдер возвращает пользователя после входа. Если подключен второй фактор, возвращается mfa_token для /auth/mfa/verify
+// @Tags auth
 // @Produce json
-// @Param Authorization header string true "Bearer token"
-// @Param request body GeocodeRequest true "Координаты"
-// @Success 200 {object} GeocodeResponse
-// @Failure 400 {string} string "Invalid request"
+// @Param provider path string true "Провайдер" example(mock)
+// @Param state query string true "state из запроса авторизации"
+// @Param code query string false "Код авторизации"
+// @Param error query string false "Ошибка, возвращенная провайдером"
+// @Success 200 {object} AuthResponse
+// @Failure 400 {string} string "Invalid request or email is already registered"
 // @Failure 401 {string} string "Unauthorized"
-// @Router /address/geocode [post]
-func (h *Handler) Geocode(w http.ResponseWriter, r *http.Request) {
-	var req GeocodeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+// @Router /auth/oauth/{provider}/callback [get]
+func (h *Handler) OAuthCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if providerErr := q.Get("error"); providerErr != "" {
+		http.Error(w, "Login was rejected by provider: "+providerErr, http.StatusUnauthorized)
 		return
 	}
 
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if q.Get("state") == "" || q.Get("code") == "" {
+		http.Error(w, "State and code are required", http.StatusBadRequest)
 		return
 	}
 
-	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(
-		"authorization", token,
-	))
+	resp, err := h.authClient.CompleteOAuth(clientContext(r), &pb_auth.CompleteOAuthRequest{
+		Provider: mux.Vars(r)["provider"],
+		State:    q.Get("state"),
+		Code:     q.Get("code"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-	resp, err := h.geoClient.Geocode(ctx, &pb_geo.GeocodeRequest{
-		Address: fmt.Sprintf("%s,%s", req.Lat, req.Lng),
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token:        resp.Token,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
+		MFARequired:  resp.MfaRequired,
+		MFAToken:     resp.MfaToken,
 	})
+}
+
+// @Summary Enroll TOTP
+// @Description Создание секрета для приложения-аутентификатора и кодов восстановления. Второй фактор начинает действовать после подтверждения
+// @Tags auth
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} EnrollTOTPResponse
+// @Failure 400 {string} string "TOTP is already enabled"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/mfa/totp/enroll [post]
+func (h *Handler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+
+	resp, err := h.userClient.EnrollTOTP(ctx, &pb_user.EnrollTOTPRequest{})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(EnrollTOTPResponse{
+		OtpauthURI:    resp.OtpauthUri,
+		Secret:        resp.Secret,
+		RecoveryCodes: resp.RecoveryCodes,
+	})
 }
 
-// User endpoints
+// @Summary Confirm TOTP
+// @Description Включение второго фактора первым кодом из приложения-аутентификатора
+// @Tags auth
+// @Accept json
+// @Param Authorization header string true "Bearer token"
+// @Param request body ConfirmTOTPRequest true "Код из приложения"
+// @Success 204
+// @Failure 400 {string} string "Invalid code"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/mfa/totp/confirm [post]
+func (h *Handler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req ConfirmTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-// @Summary Get user profile
-// @Description Получение профиля пользователя
-// @Tags user
+	if req.Code == "" {
+		http.Error(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := callerContext(r)
+
+	if _, err := h.userClient.ConfirmTOTP(ctx, &pb_user.ConfirmTOTPRequest{Code: req.Code}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Refresh token
+// @Description Обмен refresh-токена на новую пару токенов. Предъявленный refresh-токен становится недействительным
+// @Tags auth
 // @Accept json
 // @Produce json
-// @Param Authorization header string true "Bearer token"
-// @Param email query string true "Email пользователя"
-// @Success 200 {object} ProfileResponse
+// @Param request body RefreshRequest true "Refresh-токен"
+// @Success 200 {object} AuthResponse
 // @Failure 400 {string} string "Invalid request"
 // @Failure 401 {string} string "Unauthorized"
-// @Failure 404 {string} string "User not found"
-// @Router /user/profile [get]
-func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// @Router /auth/refresh [post]
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Создаем контекст с метаданными
-	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(
-		"authorization", token,
-	))
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
 
-	resp, err := h.userClient.GetProfile(ctx, &pb_user.GetProfileRequest{
-		Email: r.URL.Query().Get("email"),
+	resp, err := h.authClient.RefreshToken(clientContext(r), &pb_auth.RefreshTokenRequest{
+		RefreshToken: req.RefreshToken,
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	profile := ProfileResponse{
-		ID:        resp.User.Id,
-		Email:     resp.User.Email,
-		CreatedAt: resp.User.CreatedAt,
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AuthResponse{
+		Token:        resp.Token,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
+	})
+}
+
+// @Summary Logout
+// @Description Отзыв refresh-токена и всех токенов, полученных из него ротацией
+// @Tags auth
+// @Accept json
+// @Param request body LogoutRequest true "Refresh-токен"
+// @Success 204
+// @Failure 400 {string} string "Invalid request"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/logout [post]
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(profile)
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.authClient.Logout(clientContext(r), &pb_auth.LogoutRequest{
+		RefreshToken: req.RefreshToken,
+	}); err != nil {
+		log.Printf("Logout failed: %v", err)
+		http.Error(w, "Logout failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// @Summary List users
-// @Description Получение списка пользователей
-// @Tags user
+// @Summary Unlock account
+// @Description Снятие блокировки входа, наложенной после серии неудачных попыток. Доступно только администраторам
+// @Tags auth
 // @Accept json
-// @Produce json
 // @Param Authorization header string true "Bearer token"
-// @Param page query integer false "Номер страницы" default(1)
-// @Param per_page query integer false "Количество записей на странице" default(10)
-// @Success 200 {object} ListUsersResponse
+// @Param request body UnlockAccountRequest true "Учетная запись"
+// @Success 204
+// @Failure 400 {string} string "Invalid request"
 // @Failure 401 {string} string "Unauthorized"
-// @Router /user/list [get]
-func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
-	token := r.Header.Get("Authorization")
-	if token == "" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+// @Failure 403 {string} string "Forbidden"
+// @Router /auth/unlock [post]
+func (h *Handler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	var req UnlockAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	// Получаем параметры пагинации
-	page := 1
-	perPage := 10
-	if p := r.URL.Query().Get("page"); p != "" {
-		if val, err := strconv.Atoi(p); err == nil && val > 0 {
-			page = val
-		}
+	if req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
 	}
-	if pp := r.URL.Query().Get("per_page"); pp != "" {
-		if val, err := strconv.Atoi(pp); err == nil && val > 0 {
-			perPage = val
-		}
+
+	ctx := callerContext(r)
+
+	if _, err := h.authClient.UnlockAccount(ctx, &pb_auth.UnlockAccountRequest{
+		Email: req.Email,
+	}); err != nil {
+		writeError(w, err)
+		return
 	}
 
-	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs(
-		"authorization", token,
-	))
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	resp, err := h.userClient.ListUsers(ctx, &pb_user.ListUsersRequest{
-		Page:    int32(page),
-		PerPage: int32(perPage),
-	})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// @Summary Forgot password
+// @Description Отправка письма со ссылкой для сброса пароля. Ответ не зависит от того, зарегистрирован ли email
+// @Tags auth
+// @Accept json
+// @Param request body ForgotPasswordRequest true "Email учетной записи"
+// @Success 202
+// @Failure 400 {string} string "Invalid request"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/password/forgot [post]
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	var users []ProfileResponse
-	for _, u := range resp.Users {
-		users = append(users, ProfileResponse{
-			ID:        u.Id,
-			Email:     u.Email,
-			CreatedAt: u.CreatedAt,
-		})
+	if req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
 	}
 
-	response := ListUsersResponse{
-		Users: users,
-		Total: resp.Total,
+	if _, err := h.userClient.RequestPasswordReset(clientContext(r), &pb_user.RequestPasswordResetRequest{
+		Email: req.Email,
+	}); err != nil {
+		log.Printf("Password reset request failed: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// @Summary Reset password
+// @Description Установка нового пароля по одноразовому токену из письма
+// @Tags auth
+// @Accept json
+// @Param request body ResetPasswordRequest true "Токен и новый пароль"
+// @Success 204
+// @Failure 400 {object} ErrorResponse "Invalid or expired token or password does not meet requirements"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/password/reset [post]
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" || req.Password == "" {
+		http.Error(w, "Token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.userClient.ResetPassword(clientContext(r), &pb_user.ResetPasswordRequest{
+		Token:       req.Token,
+		NewPassword: req.Password,
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Send verification email
+// @Description Повторная отправка письма для подтверждения email текущего пользователя
+// @Tags auth
+// @Param Authorization header string true "Bearer token"
+// @Success 202
+// @Failure 400 {string} string "Email is already verified"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/email/verification [post]
+func (h *Handler) SendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+
+	if _, err := h.userClient.SendVerification(ctx, &pb_user.SendVerificationRequest{}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// @Summary Verify email
+// @Description Подтверждение email по одноразовому токену из письма
+// @Tags auth
+// @Accept json
+// @Param request body VerifyEmailRequest true "Токен из письма"
+// @Success 204
+// @Failure 400 {string} string "Invalid or expired token"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/email/verify [post]
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
 	}
 
+	if req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.userClient.VerifyEmail(clientContext(r), &pb_user.VerifyEmailRequest{
+		Token: req.Token,
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary JSON Web Key Set
+// @Description Публичные ключи для локальной проверки подписи access-токенов. Ключи ротируются, ключ подбирается по kid из заголовка токена
+// @Tags auth
+// @Produce json
+// @Success 200 {object} JWKSResponse
+// @Failure 500 {string} string "Internal server error"
+// @Router /.well-known/jwks.json [get]
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.authClient.GetJWKS(context.Background(), &pb_auth.GetJWKSRequest{})
+	if err != nil {
+		log.Printf("Failed to get JWKS: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	keys := make([]JSONWebKey, 0, len(resp.Keys))
+	for _, k := range resp.Keys {
+		keys = append(keys, JSONWebKey{
+			Kty: k.Kty,
+			Kid: k.Kid,
+			Use: k.Use,
+			Alg: k.Alg,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Crv,
+			X:   k.X,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(JWKSResponse{
+		Keys: keys,
+	})
+}
+
+// @Summary Create API key
+// @Description Выпуск API ключа для машинного клиента. Ключ показывается только в этом ответе и передается в заголовке X-API-Key
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param request body CreateAPIKeyRequest true "Название и разрешения ключа"
+// @Success 201 {object} APIKeyResponse
+// @Failure 400 {string} string "Invalid request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/api-keys [post]
+func (h *Handler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" || len(req.Scopes) == 0 {
+		http.Error(w, "Name and scopes are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := callerContext(r)
+
+	resp, err := h.authClient.CreateAPIKey(ctx, &pb_auth.CreateAPIKeyRequest{
+		Name:       req.Name,
+		Scopes:     req.Scopes,
+		TtlSeconds: req.TTLSeconds,
+		DailyQuota: req.DailyQuota,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	key := apiKeyResponse(resp.ApiKey)
+	key.Key = resp.Key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// @Summary List API keys
+// @Description Список API ключей текущего пользователя, включая отозванные
+// @Tags auth
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} ListAPIKeysResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/api-keys [get]
+func (h *Handler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+
+	resp, err := h.authClient.ListAPIKeys(ctx, &pb_auth.ListAPIKeysRequest{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	keys := make([]APIKeyResponse, 0, len(resp.ApiKeys))
+	for _, k := range resp.ApiKeys {
+		keys = append(keys, apiKeyResponse(k))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListAPIKeysResponse{
+		APIKeys: keys,
+	})
+}
+
+// @Summary Revoke API key
+// @Description Отзыв API ключа. Администратор может отозвать ключ любого пользователя
+// @Tags auth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "ID ключа"
+// @Success 204
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "API key not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/api-keys/{id} [delete]
+func (h *Handler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+
+	_, err := h.authClient.RevokeAPIKey(ctx, &pb_auth.RevokeAPIKeyRequest{
+		Id: mux.Vars(r)["id"],
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiKeyResponse(k *pb_auth.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.Id,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.Scopes,
+		DailyQuota: k.DailyQuota,
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: k.LastUsedAt,
+		ExpiresAt:  k.ExpiresAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
+
+// @Summary List sessions
+// @Description Устройства, на которых выполнен вход в учетную запись текущего пользователя
+// @Tags auth
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} ListSessionsResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/sessions [get]
+func (h *Handler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+
+	resp, err := h.authClient.ListSessions(ctx, &pb_auth.ListSessionsRequest{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	sessions := make([]SessionResponse, 0, len(resp.Sessions))
+	for _, s := range resp.Sessions {
+		sessions = append(sessions, SessionResponse{
+			ID:         s.Id,
+			UserAgent:  s.UserAgent,
+			IP:         s.Ip,
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			Current:    s.Current,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ListSessionsResponse{
+		Sessions: sessions,
+	})
+}
+
+// @Summary Revoke session
+// @Description Выход на другом устройстве: refresh-токен сессии и выпущенные в ней access-токены перестают действовать
+// @Tags auth
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "ID сессии"
+// @Success 204
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Session not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /auth/sessions/{id} [delete]
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+
+	_, err := h.authClient.RevokeSession(ctx, &pb_auth.RevokeSessionRequest{
+		Id: mux.Vars(r)["id"],
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Geo endpoints
+
+// credentialsContext переносит в метаданные запроса к сервису токен из
+// заголовка Authorization или, если его нет, API ключ из X-API-Key
+func credentialsContext(r *http.Request) (context.Context, bool) {
+	if token := r.Header.Get("Authorization"); token != "" {
+		return metadata.NewOutgoingContext(context.Background(), metadata.Pairs(
+			"authorization", token,
+		)), true
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return metadata.NewOutgoingContext(context.Background(), metadata.Pairs(
+			"x-api-key", key,
+		)), true
+	}
+	return nil, false
+}
+
+// @Summary Search address
+// @Description Поиск адреса по строке
+// @Tags geo
+// @Accept json
+// @Produce json
+// @Param Authorization header string false "Bearer token"
+// @Param X-API-Key header string false "API ключ с разрешением geo:search"
+// @Param request body SearchAddressRequest true "Параметры поиска"
+// @Success 200 {object} SearchAddressResponse
+// @Failure 400 {string} string "Invalid request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 429 {string} string "Daily quota exceeded"
+// @Router /address/search [post]
+func (h *Handler) SearchAddress(w http.ResponseWriter, r *http.Request) {
+	var req SearchAddressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, ok := credentialsContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := h.geoClient.SearchAddress(ctx, &pb_geo.SearchAddressRequest{
+		Query: req.Query,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// @Summary Geocode coordinates
+// @Description Получение адреса по координатам
+// @Tags geo
+// @Accept json
+// @Produce json
+// @Param Authorization header string false "Bearer token"
+// @Param X-API-Key header string false "API ключ с разрешением geo:geocode"
+// @Param request body GeocodeRequest true "Координаты"
+// @Success 200 {object} GeocodeResponse
+// @Failure 400 {string} string "Invalid request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 429 {string} string "Daily quota exceeded"
+// @Router /address/geocode [post]
+func (h *Handler) Geocode(w http.ResponseWriter, r *http.Request) {
+	var req GeocodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, ok := credentialsContext(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := h.geoClient.Geocode(ctx, &pb_geo.GeocodeRequest{
+		Address: fmt.Sprintf("%s,%s", req.Lat, req.Lng),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// User endpoints
+
+// @Summary Get user profile
+// @Description Получение профиля текущего пользователя, определяемого по токену
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Success 200 {object} ProfileResponse
+// @Failure 400 {string} string "Invalid request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Router /user/profile [get]
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Создаем контекст с метаданными
+	ctx := callerContext(r)
+
+	resp, err := h.userClient.GetProfile(ctx, &pb_user.GetProfileRequest{})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profileResponse(resp.User))
+}
+
+// @Summary List users
+// @Description Получение списка пользователей постранично по курсору. Доступно только администраторам.
+// @Description Ссылки на первую и следующую страницы передаются также в заголовке Link (RFC 8288)
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param per_page query integer false "Количество записей на странице, не больше 100" default(10)
+// @Param cursor query string false "next_cursor предыдущей страницы"
+// @Param email query string false "Начало email без учета регистра"
+// @Param created_after query string false "Зарегистрированы не раньше, RFC 3339"
+// @Param created_before query string false "Зарегистрированы раньше, RFC 3339"
+// @Param role query string false "Роль"
+// @Param status query string false "active, unverified или deleted" default(active)
+// @Param sort query string false "created_at, -created_at, email или -email" default(-created_at)
+// @Param include_total query boolean false "Посчитать total"
+// @Success 200 {object} ListUsersResponse
+// @Header 200 {string} Link "Ссылки rel=first и rel=next"
+// @Failure 400 {object} ErrorResponse "Invalid parameters"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /user/list [get]
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	req := &pb_user.ListUsersRequest{
+		Cursor:        query.Get("cursor"),
+		EmailPrefix:   query.Get("email"),
+		CreatedAfter:  query.Get("created_after"),
+		CreatedBefore: query.Get("created_before"),
+		Role:          query.Get("role"),
+		Status:        query.Get("status"),
+		Sort:          query.Get("sort"),
+	}
+	// Размер страницы по умолчанию и его предел проверяет user сервис
+	if pp := query.Get("per_page"); pp != "" {
+		val, err := strconv.Atoi(pp)
+		if err != nil {
+			http.Error(w, "Invalid per_page", http.StatusBadRequest)
+			return
+		}
+		req.PerPage = int32(val)
+	}
+	if it := query.Get("include_total"); it != "" {
+		val, err := strconv.ParseBool(it)
+		if err != nil {
+			http.Error(w, "Invalid include_total", http.StatusBadRequest)
+			return
+		}
+		req.IncludeTotal = val
+	}
+
+	ctx := callerContext(r)
+
+	resp, err := h.userClient.ListUsers(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	users := []ProfileResponse{}
+	for _, u := range resp.Users {
+		users = append(users, profileResponse(u))
+	}
+
+	response := ListUsersResponse{
+		Users:      users,
+		Total:      resp.Total,
+		NextCursor: resp.NextCursor,
+	}
+
+	links := []string{pageLink(r.URL, "", "first")}
+	if resp.NextCursor != "" {
+		links = append(links, pageLink(r.URL, resp.NextCursor, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// pageLink возвращает значение заголовка Link (RFC 8288) на страницу списка
+// с теми же параметрами запроса и курсором cursor
+func pageLink(u *url.URL, cursor, rel string) string {
+	query := u.Query()
+	query.Del("cursor")
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	link := url.URL{Path: u.Path, RawQuery: query.Encode()}
+	return fmt.Sprintf("<%s>; rel=%q", link.String(), rel)
+}
+
+// @Summary Update user
+// @Description Изменение email текущего пользователя. Новый адрес нужно подтвердить по ссылке из письма, на старый приходит уведомление
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param request body UpdateUserRequest true "Новые данные"
+// @Success 200 {object} ProfileResponse
+// @Failure 400 {string} string "Invalid request"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "Email is already registered"
+// @Router /user [patch]
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var req UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Email == "" {
+		http.Error(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := callerContext(r)
+
+	resp, err := h.userClient.UpdateUser(ctx, &pb_user.UpdateUserRequest{
+		Email: req.Email,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profileResponse(resp.User))
+}
+
+// @Summary Update user profile
+// @Description Частичное изменение профиля текущего пользователя: меняются только переданные поля. Версия профиля из последнего ответа обязательна; если профиль успел измениться, возвращается 409 и профиль нужно перечитать
+// @Tags user
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param request body UpdateProfileRequest true "Изменяемые поля и версия профиля"
+// @Success 200 {object} ProfileResponse
+// @Failure 400 {object} ErrorResponse "Invalid profile fields"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "Profile was modified concurrently"
+// @Router /user/profile [patch]
+func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Маска изменений составляется из ключей тела запроса
+	profile := &pb_user.Profile{}
+	var version int64
+	var paths []string
+	for name, raw := range fields {
+		var err error
+		switch name {
+		case "version":
+			err = json.Unmarshal(raw, &version)
+		case "display_name":
+			err = json.Unmarshal(raw, &profile.DisplayName)
+		case "phone":
+			err = json.Unmarshal(raw, &profile.Phone)
+		case "locale":
+			err = json.Unmarshal(raw, &profile.Locale)
+		case "timezone":
+			err = json.Unmarshal(raw, &profile.Timezone)
+		case "avatar_url":
+			err = json.Unmarshal(raw, &profile.AvatarUrl)
+		case "home_address":
+			var address *Address
+			if err = json.Unmarshal(raw, &address); err == nil && address != nil {
+				profile.HomeAddress = &pb_user.Address{
+					City:   address.City,
+					Street: address.Street,
+					House:  address.House,
+					Lat:    address.Lat,
+					Lon:    address.Lon,
+				}
+			}
+		default:
+			http.Error(w, fmt.Sprintf("Unknown field %q", name), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid value of %q", name), http.StatusBadRequest)
+			return
+		}
+		if name != "version" {
+			paths = append(paths, name)
+		}
+	}
+
+	if version <= 0 {
+		http.Error(w, "Version is required", http.StatusBadRequest)
+		return
+	}
+	if len(paths) == 0 {
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+	sort.Strings(paths)
+
+	ctx := callerContext(r)
+
+	resp, err := h.userClient.UpdateProfile(ctx, &pb_user.UpdateProfileRequest{
+		Profile:    profile,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+		Version:    version,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profileResponse(resp.User))
+}
+
+func profileResponse(u *pb_user.User) ProfileResponse {
+	profile := ProfileResponse{
+		ID:            u.Id,
+		Email:         u.Email,
+		Role:          u.Role,
+		EmailVerified: u.EmailVerified,
+		MFAEnabled:    u.MfaEnabled,
+		CreatedAt:     u.CreatedAt,
+		DisplayName:   u.DisplayName,
+		Phone:         u.Phone,
+		Locale:        u.Locale,
+		Timezone:      u.Timezone,
+		AvatarURL:     u.AvatarUrl,
+		UpdatedAt:     u.UpdatedAt,
+		Version:       u.Version,
+	}
+	if a := u.HomeAddress; a != nil {
+		profile.HomeAddress = &Address{City: a.City, Street: a.Street, House: a.House, Lat: a.Lat, Lon: a.Lon}
+	}
+	return profile
+}
+
+// @Summary Change password
+// @Description Смена пароля текущего пользователя. Остальные сессии завершаются, текущая остается
+// @Tags user
+// @Accept json
+// @Param Authorization header string true "Bearer token"
+// @Param request body ChangePasswordRequest true "Текущий и новый пароль"
+// @Success 204
+// @Failure 400 {object} ErrorResponse "Current password is incorrect or new password does not meet requirements"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user/password [put]
+func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		http.Error(w, "Current and new passwords are required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := callerContext(r)
+
+	if _, err := h.userClient.ChangePassword(ctx, &pb_user.ChangePasswordRequest{
+		CurrentPassword: req.CurrentPassword,
+		NewPassword:     req.NewPassword,
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Delete account
+// @Description Удаление учетной записи текущего пользователя. Сессии и API ключи отзываются, email освобождается по истечении срока хранения
+// @Tags user
+// @Param Authorization header string true "Bearer token"
+// @Success 204
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user [delete]
+func (h *Handler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	h.deleteUser(w, r, "")
+}
+
+// @Summary Delete user
+// @Description Удаление учетной записи пользователя. Доступно только администраторам
+// @Tags user
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "ID пользователя"
+// @Success 204
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user/{id} [delete]
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	h.deleteUser(w, r, mux.Vars(r)["id"])
+}
+
+func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request, userID string) {
+	ctx := callerContext(r)
+
+	if _, err := h.userClient.DeleteUser(ctx, &pb_user.DeleteUserRequest{
+		UserId: userID,
+	}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary Export my data
+// @Description Выгрузка всех данных текущего пользователя: профиля, сессий, API ключей и адресных запросов. Архив собирается в фоне; готовность проверяется по ссылке из Location
+// @Tags user
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Success 202 {object} DataJobResponse
+// @Header 202 {string} Location "Адрес задачи"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user/export [post]
+func (h *Handler) ExportMyData(w http.ResponseWriter, r *http.Request) {
+	h.exportUserData(w, r, "")
+}
+
+// @Summary Export user data
+// @Description Выгрузка данных пользователя. Доступно только администраторам
+// @Tags user
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "ID пользователя"
+// @Success 202 {object} DataJobResponse
+// @Header 202 {string} Location "Адрес задачи"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user/{id}/export [post]
+func (h *Handler) ExportUserData(w http.ResponseWriter, r *http.Request) {
+	h.exportUserData(w, r, mux.Vars(r)["id"])
+}
+
+func (h *Handler) exportUserData(w http.ResponseWriter, r *http.Request, userID string) {
+	ctx := callerContext(r)
+
+	resp, err := h.userClient.ExportUserData(ctx, &pb_user.ExportUserDataRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeDataJobAccepted(w, resp.Job)
+}
+
+// @Summary Erase my account
+// @Description Безвозвратное стирание учетной записи текущего пользователя: запись обезличивается, сессии, API ключи и адресные запросы удаляются. Выполняется в фоне
+// @Tags user
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Success 202 {object} DataJobResponse
+// @Header 202 {string} Location "Адрес задачи"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user/erase [post]
+func (h *Handler) EraseAccount(w http.ResponseWriter, r *http.Request) {
+	h.eraseUser(w, r, "")
+}
+
+// @Summary Erase user
+// @Description Безвозвратное стирание учетной записи пользователя. Доступно только администраторам
+// @Tags user
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "ID пользователя"
+// @Success 202 {object} DataJobResponse
+// @Header 202 {string} Location "Адрес задачи"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "User not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user/{id}/erase [post]
+func (h *Handler) EraseUser(w http.ResponseWriter, r *http.Request) {
+	h.eraseUser(w, r, mux.Vars(r)["id"])
+}
+
+func (h *Handler) eraseUser(w http.ResponseWriter, r *http.Request, userID string) {
+	ctx := callerContext(r)
+
+	resp, err := h.userClient.EraseUser(ctx, &pb_user.EraseUserRequest{
+		UserId: userID,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeDataJobAccepted(w, resp.Job)
+}
+
+// @Summary Get data job
+// @Description Состояние задачи выгрузки или стирания данных
+// @Tags user
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "ID задачи"
+// @Success 200 {object} DataJobResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Job not found"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user/jobs/{id} [get]
+func (h *Handler) GetDataJob(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+
+	resp, err := h.userClient.GetDataJob(ctx, &pb_user.GetDataJobRequest{
+		Id: mux.Vars(r)["id"],
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dataJobResponse(resp.Job))
+}
+
+// @Summary Download data export
+// @Description Архив завершенной выгрузки. Архив хранится ограниченное время, см. expires_at задачи
+// @Tags user
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param id path string true "ID задачи"
+// @Success 200 {file} file "JSON архив"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "Job not found or export expired"
+// @Failure 400 {string} string "Export is not finished"
+// @Failure 500 {string} string "Internal server error"
+// @Router /user/jobs/{id}/archive [get]
+func (h *Handler) GetDataExport(w http.ResponseWriter, r *http.Request) {
+	ctx := callerContext(r)
+
+	id := mux.Vars(r)["id"]
+	resp, err := h.userClient.GetDataExport(ctx, &pb_user.GetDataExportRequest{
+		JobId: id,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.json"`, id))
+	w.Write(resp.Archive)
+}
+
+// writeDataJobAccepted отвечает на постановку задачи в очередь
+func writeDataJobAccepted(w http.ResponseWriter, job *pb_user.DataJob) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/user/jobs/"+url.PathEscape(job.Id))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(dataJobResponse(job))
+}
+
+func dataJobResponse(job *pb_user.DataJob) DataJobResponse {
+	return DataJobResponse{
+		ID:         job.Id,
+		Type:       job.Type,
+		UserID:     job.UserId,
+		Status:     job.Status,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt,
+		FinishedAt: job.FinishedAt,
+		ExpiresAt:  job.ExpiresAt,
+	}
+}
+
+// Audit endpoints
+
+// @Summary Query audit log
+// @Description Просмотр журнала аудита постранично по курсору, начиная с новых записей. Доступно только администраторам; сам просмотр тоже попадает в журнал.
+// @Description Ссылки на первую и следующую страницы передаются также в заголовке Link (RFC 8288)
+// @Tags audit
+// @Produce json
+// @Param Authorization header string true "Bearer token"
+// @Param actor query string false "ID пользователя или client_id сервиса"
+// @Param target query string false "ID объекта действия или email"
+// @Param action query string false "Действие, например auth.login"
+// @Param result query string false "success, failure или denied"
+// @Param service query string false "auth или user"
+// @Param since query string false "Не раньше, RFC 3339"
+// @Param until query string false "Раньше, RFC 3339"
+// @Param per_page query integer false "Количество записей на странице, не больше 500" default(50)
+// @Param cursor query string false "next_cursor предыдущей страницы"
+// @Success 200 {object} QueryAuditLogResponse
+// @Header 200 {string} Link "Ссылки rel=first и rel=next"
+// @Failure 400 {object} ErrorResponse "Invalid parameters"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /audit [get]
+func (h *Handler) QueryAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := &pb_user.QueryAuditLogRequest{
+		Actor:   query.Get("actor"),
+		Target:  query.Get("target"),
+		Action:  query.Get("action"),
+		Result:  query.Get("result"),
+		Service: query.Get("service"),
+		Since:   query.Get("since"),
+		Until:   query.Get("until"),
+		Cursor:  query.Get("cursor"),
+	}
+	if pp := query.Get("per_page"); pp != "" {
+		val, err := strconv.Atoi(pp)
+		if err != nil {
+			http.Error(w, "Invalid per_page", http.StatusBadRequest)
+			return
+		}
+		req.PerPage = int32(val)
+	}
+
+	resp, err := h.userClient.QueryAuditLog(callerContext(r), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	response := QueryAuditLogResponse{
+		Events:     []AuditEventResponse{},
+		NextCursor: resp.NextCursor,
+	}
+	for _, e := range resp.Events {
+		response.Events = append(response.Events, AuditEventResponse{
+			ID:        e.Id,
+			Time:      e.Time,
+			Service:   e.Service,
+			Actor:     e.Actor,
+			ActorType: e.ActorType,
+			Action:    e.Action,
+			Target:    e.Target,
+			IP:        e.Ip,
+			UserAgent: e.UserAgent,
+			Result:    e.Result,
+			Reason:    e.Reason,
+			PrevHash:  e.PrevHash,
+			Hash:      e.Hash,
+		})
+	}
+
+	links := []string{pageLink(r.URL, "", "first")}
+	if resp.NextCursor != "" {
+		links = append(links, pageLink(r.URL, resp.NextCursor, "next"))
+	}
+	w.Header().Set("Link", strings.Join(links, ", "))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -7,14 +7,22 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
 	pb_geo "dd/pkg/geo"
 	pb_user "dd/pkg/user"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 // Моки клиентов
@@ -47,6 +55,150 @@ func (m *MockAuthClient) ValidateToken(ctx context.Context, req *pb_auth.Validat
 	return nil, args.Error(1)
 }
 
+func (m *MockAuthClient) RefreshToken(ctx context.Context, req *pb_auth.RefreshTokenRequest, opts ...grpc.CallOption) (*pb_auth.RefreshTokenResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.RefreshTokenResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) Logout(ctx context.Context, req *pb_auth.LogoutRequest, opts ...grpc.CallOption) (*pb_auth.LogoutResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.LogoutResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) RevokeToken(ctx context.Context, req *pb_auth.RevokeTokenRequest, opts ...grpc.CallOption) (*pb_auth.RevokeTokenResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.RevokeTokenResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) RevokeAllSessions(ctx context.Context, req *pb_auth.RevokeAllSessionsRequest, opts ...grpc.CallOption) (*pb_auth.RevokeAllSessionsResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.RevokeAllSessionsResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) GetJWKS(ctx context.Context, req *pb_auth.GetJWKSRequest, opts ...grpc.CallOption) (*pb_auth.GetJWKSResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.GetJWKSResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) UnlockAccount(ctx context.Context, req *pb_auth.UnlockAccountRequest, opts ...grpc.CallOption) (*pb_auth.UnlockAccountResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.UnlockAccountResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) VerifyMFA(ctx context.Context, req *pb_auth.VerifyMFARequest, opts ...grpc.CallOption) (*pb_auth.VerifyMFAResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.VerifyMFAResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) StartOAuth(ctx context.Context, req *pb_auth.StartOAuthRequest, opts ...grpc.CallOption) (*pb_auth.StartOAuthResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.StartOAuthResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) CompleteOAuth(ctx context.Context, req *pb_auth.CompleteOAuthRequest, opts ...grpc.CallOption) (*pb_auth.LoginResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.LoginResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) CreateAPIKey(ctx context.Context, req *pb_auth.CreateAPIKeyRequest, opts ...grpc.CallOption) (*pb_auth.CreateAPIKeyResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.CreateAPIKeyResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) ListAPIKeys(ctx context.Context, req *pb_auth.ListAPIKeysRequest, opts ...grpc.CallOption) (*pb_auth.ListAPIKeysResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.ListAPIKeysResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) RevokeAPIKey(ctx context.Context, req *pb_auth.RevokeAPIKeyRequest, opts ...grpc.CallOption) (*pb_auth.RevokeAPIKeyResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.RevokeAPIKeyResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) ValidateAPIKey(ctx context.Context, req *pb_auth.ValidateAPIKeyRequest, opts ...grpc.CallOption) (*pb_auth.ValidateAPIKeyResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.ValidateAPIKeyResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) Token(ctx context.Context, req *pb_auth.TokenRequest, opts ...grpc.CallOption) (*pb_auth.TokenResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.TokenResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) ListSessions(ctx context.Context, req *pb_auth.ListSessionsRequest, opts ...grpc.CallOption) (*pb_auth.ListSessionsResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.ListSessionsResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) RevokeSession(ctx context.Context, req *pb_auth.RevokeSessionRequest, opts ...grpc.CallOption) (*pb_auth.RevokeSessionResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.RevokeSessionResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) ExportUserData(ctx context.Context, req *pb_auth.ExportUserDataRequest, opts ...grpc.CallOption) (*pb_auth.ExportUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.ExportUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) PurgeUserData(ctx context.Context, req *pb_auth.PurgeUserDataRequest, opts ...grpc.CallOption) (*pb_auth.PurgeUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.PurgeUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 // Geo методы
 func (m *MockGeoClient) SearchAddress(ctx context.Context, req *pb_geo.SearchAddressRequest, opts ...grpc.CallOption) (*pb_geo.SearchAddressResponse, error) {
 	args := m.Called(ctx, req)
@@ -64,6 +216,22 @@ func (m *MockGeoClient) Geocode(ctx context.Context, req *pb_geo.GeocodeRequest,
 	return nil, args.Error(1)
 }
 
+func (m *MockGeoClient) ExportUserData(ctx context.Context, req *pb_geo.ExportUserDataRequest, opts ...grpc.CallOption) (*pb_geo.ExportUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_geo.ExportUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockGeoClient) PurgeUserData(ctx context.Context, req *pb_geo.PurgeUserDataRequest, opts ...grpc.CallOption) (*pb_geo.PurgeUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_geo.PurgeUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 // User методы
 func (m *MockUserClient) GetProfile(ctx context.Context, req *pb_user.GetProfileRequest, opts ...grpc.CallOption) (*pb_user.GetProfileResponse, error) {
 	args := m.Called(ctx, req)
@@ -81,6 +249,14 @@ func (m *MockUserClient) ListUsers(ctx context.Context, req *pb_user.ListUsersRe
 	return nil, args.Error(1)
 }
 
+func (m *MockUserClient) VerifyCredentials(ctx context.Context, req *pb_user.VerifyCredentialsRequest, opts ...grpc.CallOption) (*pb_user.VerifyCredentialsResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.VerifyCredentialsResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func (m *MockUserClient) CreateUser(ctx context.Context, req *pb_user.CreateUserRequest, opts ...grpc.CallOption) (*pb_user.CreateUserResponse, error) {
 	args := m.Called(ctx, req)
 	if resp := args.Get(0); resp != nil {
@@ -89,6 +265,142 @@ func (m *MockUserClient) CreateUser(ctx context.Context, req *pb_user.CreateUser
 	return nil, args.Error(1)
 }
 
+func (m *MockUserClient) RequestPasswordReset(ctx context.Context, req *pb_user.RequestPasswordResetRequest, opts ...grpc.CallOption) (*pb_user.RequestPasswordResetResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.RequestPasswordResetResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ResetPassword(ctx context.Context, req *pb_user.ResetPasswordRequest, opts ...grpc.CallOption) (*pb_user.ResetPasswordResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ResetPasswordResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) SendVerification(ctx context.Context, req *pb_user.SendVerificationRequest, opts ...grpc.CallOption) (*pb_user.SendVerificationResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.SendVerificationResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) VerifyEmail(ctx context.Context, req *pb_user.VerifyEmailRequest, opts ...grpc.CallOption) (*pb_user.VerifyEmailResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.VerifyEmailResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) EnrollTOTP(ctx context.Context, req *pb_user.EnrollTOTPRequest, opts ...grpc.CallOption) (*pb_user.EnrollTOTPResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.EnrollTOTPResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ConfirmTOTP(ctx context.Context, req *pb_user.ConfirmTOTPRequest, opts ...grpc.CallOption) (*pb_user.ConfirmTOTPResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ConfirmTOTPResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) VerifyTOTP(ctx context.Context, req *pb_user.VerifyTOTPRequest, opts ...grpc.CallOption) (*pb_user.VerifyTOTPResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.VerifyTOTPResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ResolveExternalIdentity(ctx context.Context, req *pb_user.ResolveExternalIdentityRequest, opts ...grpc.CallOption) (*pb_user.ResolveExternalIdentityResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ResolveExternalIdentityResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) UpdateUser(ctx context.Context, req *pb_user.UpdateUserRequest, opts ...grpc.CallOption) (*pb_user.UpdateUserResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.UpdateUserResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ChangePassword(ctx context.Context, req *pb_user.ChangePasswordRequest, opts ...grpc.CallOption) (*pb_user.ChangePasswordResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ChangePasswordResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) DeleteUser(ctx context.Context, req *pb_user.DeleteUserRequest, opts ...grpc.CallOption) (*pb_user.DeleteUserResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.DeleteUserResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) UpdateProfile(ctx context.Context, req *pb_user.UpdateProfileRequest, opts ...grpc.CallOption) (*pb_user.UpdateProfileResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.UpdateProfileResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) ExportUserData(ctx context.Context, req *pb_user.ExportUserDataRequest, opts ...grpc.CallOption) (*pb_user.ExportUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.ExportUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) EraseUser(ctx context.Context, req *pb_user.EraseUserRequest, opts ...grpc.CallOption) (*pb_user.EraseUserResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.EraseUserResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) GetDataJob(ctx context.Context, req *pb_user.GetDataJobRequest, opts ...grpc.CallOption) (*pb_user.GetDataJobResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.GetDataJobResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) GetDataExport(ctx context.Context, req *pb_user.GetDataExportRequest, opts ...grpc.CallOption) (*pb_user.GetDataExportResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.GetDataExportResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserClient) QueryAuditLog(ctx context.Context, req *pb_user.QueryAuditLogRequest, opts ...grpc.CallOption) (*pb_user.QueryAuditLogResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_user.QueryAuditLogResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func setupTest() (*Handler, *MockAuthClient, *MockGeoClient, *MockUserClient) {
 	mockAuth := &MockAuthClient{}
 	mockGeo := &MockGeoClient{}
@@ -136,6 +448,50 @@ func TestHandler_Register(t *testing.T) {
 
 		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
+
+	t.Run("weak password", func(t *testing.T) {
+		st, err := status.New(codes.InvalidArgument, "password does not meet requirements").WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "password", Description: "must be at least 10 characters long"},
+				{Field: "password", Description: "has appeared in a data breach and must not be used"},
+			},
+		})
+		assert.NoError(t, err)
+		mockAuth.On("Register", mock.Anything, &pb_auth.RegisterRequest{
+			Email:    "weak@example.com",
+			Password: "qwerty",
+		}).Return(nil, st.Err())
+
+		body := bytes.NewBuffer([]byte(`{"email": "weak@example.com", "password": "qwerty"}`))
+		req := httptest.NewRequest("POST", "/api/auth/register", body)
+		w := httptest.NewRecorder()
+
+		h.Register(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var resp ErrorResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, "password does not meet requirements", resp.Error)
+		assert.Equal(t, []FieldError{
+			{Field: "password", Description: "must be at least 10 characters long"},
+			{Field: "password", Description: "has appeared in a data breach and must not be used"},
+		}, resp.Fields)
+	})
+
+	t.Run("user already exists", func(t *testing.T) {
+		mockAuth.On("Register", mock.Anything, &pb_auth.RegisterRequest{
+			Email:    "existing@example.com",
+			Password: "Correct-horse-42",
+		}).Return(nil, status.Error(codes.AlreadyExists, "user already exists"))
+
+		body := bytes.NewBuffer([]byte(`{"email": "existing@example.com", "password": "Correct-horse-42"}`))
+		req := httptest.NewRequest("POST", "/api/auth/register", body)
+		w := httptest.NewRecorder()
+
+		h.Register(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
 }
 
 func TestHandler_Login(t *testing.T) {
@@ -167,13 +523,223 @@ func TestHandler_Login(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, "test-token", response.Token)
 	})
-}
 
-func TestHandler_SearchAddress(t *testing.T) {
-	h, _, mockGeo, _ := setupTest()
+	t.Run("client ip and user agent are forwarded", func(t *testing.T) {
+		mockAuth.On("Login", mock.MatchedBy(func(ctx context.Context) bool {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			return len(md.Get("x-client-ip")) == 1 && md.Get("x-client-ip")[0] == "203.0.113.7" &&
+				len(md.Get("x-user-agent")) == 1 && md.Get("x-user-agent")[0] == "Firefox"
+		}), &pb_auth.LoginRequest{
+			Email:    "ip@example.com",
+			Password: "password123",
+		}).Return(&pb_auth.LoginResponse{Token: "test-token"}, nil)
 
-	t.Run("successful search", func(t *testing.T) {
-		mockGeo.On("SearchAddress", mock.Anything, &pb_geo.SearchAddressRequest{
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email": "ip@example.com", "password": "password123"}`))
+		req.RemoteAddr = "203.0.113.7:54321"
+		req.Header.Set("User-Agent", "Firefox")
+		w := httptest.NewRecorder()
+
+		h.Login(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	limited := func(code codes.Code, msg string) error {
+		st, err := status.New(code, msg).WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(30 * time.Second),
+		})
+		assert.NoError(t, err)
+		return st.Err()
+	}
+
+	t.Run("throttled", func(t *testing.T) {
+		mockAuth.On("Login", mock.Anything, &pb_auth.LoginRequest{
+			Email:    "throttled@example.com",
+			Password: "password123",
+		}).Return(nil, limited(codes.ResourceExhausted, "too many failed login attempts"))
+
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email": "throttled@example.com", "password": "password123"}`))
+		w := httptest.NewRecorder()
+
+		h.Login(w, req)
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("locked", func(t *testing.T) {
+		st, err := status.Convert(limited(codes.PermissionDenied, "account is temporarily locked")).WithDetails(&errdetails.ErrorInfo{
+			Domain: authn.ErrorDomain,
+			Reason: authn.ReasonAccountLocked,
+		})
+		assert.NoError(t, err)
+		locked := st.Err()
+
+		mockAuth.On("Login", mock.Anything, &pb_auth.LoginRequest{
+			Email:    "locked@example.com",
+			Password: "password123",
+		}).Return(nil, locked)
+
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email": "locked@example.com", "password": "password123"}`))
+		w := httptest.NewRecorder()
+
+		h.Login(w, req)
+
+		assert.Equal(t, http.StatusLocked, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("permission denied without lockout", func(t *testing.T) {
+		mockAuth.On("Login", mock.Anything, &pb_auth.LoginRequest{
+			Email:    "denied@example.com",
+			Password: "password123",
+		}).Return(nil, status.Error(codes.PermissionDenied, "service tokens are not accepted"))
+
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email": "denied@example.com", "password": "password123"}`))
+		w := httptest.NewRecorder()
+
+		h.Login(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, w.Header().Get("Retry-After"))
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		mockAuth.On("Login", mock.Anything, &pb_auth.LoginRequest{
+			Email:    "test@example.com",
+			Password: "wrong-password",
+		}).Return(nil, status.Error(codes.Unauthenticated, "authentication failed"))
+
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email": "test@example.com", "password": "wrong-password"}`))
+		w := httptest.NewRecorder()
+
+		h.Login(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestHandler_UnlockAccount(t *testing.T) {
+	h, mockAuth, _, _ := setupTest()
+
+	mockAuth.On("UnlockAccount", mock.Anything, &pb_auth.UnlockAccountRequest{
+		Email: "locked@example.com",
+	}).Return(&pb_auth.UnlockAccountResponse{}, nil)
+
+	req := httptest.NewRequest("POST", "/api/auth/unlock", bytes.NewBufferString(`{"email": "locked@example.com"}`))
+	req.Header.Set("Authorization", "admin-token")
+	w := httptest.NewRecorder()
+
+	h.UnlockAccount(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mockAuth.AssertExpectations(t)
+}
+
+func TestHandler_Refresh(t *testing.T) {
+	h, mockAuth, _, _ := setupTest()
+
+	t.Run("successful refresh", func(t *testing.T) {
+		// Адрес клиента нужен auth для журнала и новой сессии
+		mockAuth.On("RefreshToken", mock.MatchedBy(func(ctx context.Context) bool {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			return len(md.Get("x-client-ip")) == 1 && md.Get("x-client-ip")[0] == "203.0.113.7"
+		}), &pb_auth.RefreshTokenRequest{
+			RefreshToken: "old-refresh",
+		}).Return(&pb_auth.RefreshTokenResponse{
+			Token:        "new-token",
+			RefreshToken: "new-refresh",
+			ExpiresIn:    900,
+		}, nil)
+
+		body := bytes.NewBuffer([]byte(`{"refresh_token": "old-refresh"}`))
+		req := httptest.NewRequest("POST", "/api/auth/refresh", body)
+		req.RemoteAddr = "203.0.113.7:54321"
+		w := httptest.NewRecorder()
+
+		h.Refresh(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response AuthResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "new-token", response.Token)
+		assert.Equal(t, "new-refresh", response.RefreshToken)
+		assert.Equal(t, int64(900), response.ExpiresIn)
+	})
+
+	t.Run("rejected refresh token", func(t *testing.T) {
+		mockAuth.On("RefreshToken", mock.Anything, &pb_auth.RefreshTokenRequest{
+			RefreshToken: "reused",
+		}).Return(nil, status.Error(codes.Unauthenticated, "invalid refresh token"))
+
+		body := bytes.NewBuffer([]byte(`{"refresh_token": "reused"}`))
+		req := httptest.NewRequest("POST", "/api/auth/refresh", body)
+		w := httptest.NewRecorder()
+
+		h.Refresh(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("missing refresh token", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/auth/refresh", bytes.NewBuffer([]byte(`{}`)))
+		w := httptest.NewRecorder()
+
+		h.Refresh(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestHandler_Logout(t *testing.T) {
+	h, mockAuth, _, _ := setupTest()
+
+	mockAuth.On("Logout", mock.Anything, &pb_auth.LogoutRequest{
+		RefreshToken: "refresh",
+	}).Return(&pb_auth.LogoutResponse{}, nil)
+
+	body := bytes.NewBuffer([]byte(`{"refresh_token": "refresh"}`))
+	req := httptest.NewRequest("POST", "/api/auth/logout", body)
+	w := httptest.NewRecorder()
+
+	h.Logout(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mockAuth.AssertExpectations(t)
+}
+
+func TestHandler_JWKS(t *testing.T) {
+	h, mockAuth, _, _ := setupTest()
+
+	mockAuth.On("GetJWKS", mock.Anything, mock.Anything).Return(&pb_auth.GetJWKSResponse{
+		Keys: []*pb_auth.JSONWebKey{
+			{Kty: "OKP", Kid: "k1", Use: "sig", Alg: "EdDSA", Crv: "Ed25519", X: "abc"},
+		},
+	}, nil)
+
+	req := httptest.NewRequest("GET", "/.well-known/jwks.json", nil)
+	w := httptest.NewRecorder()
+
+	h.JWKS(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Cache-Control"))
+
+	var response map[string][]map[string]string
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"kty": "OKP", "kid": "k1", "use": "sig", "alg": "EdDSA", "crv": "Ed25519", "x": "abc"},
+	}, response["keys"])
+}
+
+func TestHandler_SearchAddress(t *testing.T) {
+	h, _, mockGeo, _ := setupTest()
+
+	t.Run("successful search", func(t *testing.T) {
+		mockGeo.On("SearchAddress", mock.Anything, &pb_geo.SearchAddressRequest{
 			Query: "test address",
 		}).Return(&pb_geo.SearchAddressResponse{
 			Addresses: []*pb_geo.Address{
@@ -199,6 +765,46 @@ func TestHandler_SearchAddress(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 	})
 
+	t.Run("api key is forwarded", func(t *testing.T) {
+		mockGeo.On("SearchAddress", mock.MatchedBy(func(ctx context.Context) bool {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			return len(md.Get("x-api-key")) == 1 && md.Get("x-api-key")[0] == "ddk_test" && len(md.Get("authorization")) == 0
+		}), &pb_geo.SearchAddressRequest{
+			Query: "api key address",
+		}).Return(&pb_geo.SearchAddressResponse{}, nil)
+
+		body := bytes.NewBuffer([]byte(`{
+			"query": "api key address"
+		}`))
+		req := httptest.NewRequest("POST", "/api/address/search", body)
+		req.Header.Set("X-API-Key", "ddk_test")
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		h.SearchAddress(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("quota exceeded", func(t *testing.T) {
+		st, err := status.New(codes.ResourceExhausted, "daily quota exceeded").WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(90*time.Minute + 500*time.Millisecond),
+		})
+		assert.NoError(t, err)
+		mockGeo.On("SearchAddress", mock.Anything, &pb_geo.SearchAddressRequest{
+			Query: "over quota",
+		}).Return(nil, st.Err())
+
+		req := httptest.NewRequest("POST", "/api/address/search", bytes.NewBufferString(`{"query": "over quota"}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.SearchAddress(w, req)
+
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "5401", w.Header().Get("Retry-After"))
+	})
+
 	t.Run("unauthorized", func(t *testing.T) {
 		body := bytes.NewBuffer([]byte(`{
 			"query": "test address"
@@ -217,9 +823,7 @@ func TestHandler_GetProfile(t *testing.T) {
 	h, _, _, mockUser := setupTest()
 
 	t.Run("successful profile retrieval", func(t *testing.T) {
-		mockUser.On("GetProfile", mock.Anything, &pb_user.GetProfileRequest{
-			Email: "test@example.com",
-		}).Return(&pb_user.GetProfileResponse{
+		mockUser.On("GetProfile", mock.Anything, &pb_user.GetProfileRequest{}).Return(&pb_user.GetProfileResponse{
 			User: &pb_user.User{
 				Id:        "123",
 				Email:     "test@example.com",
@@ -227,7 +831,7 @@ func TestHandler_GetProfile(t *testing.T) {
 			},
 		}, nil)
 
-		req := httptest.NewRequest("GET", "/api/user/profile?email=test@example.com", nil)
+		req := httptest.NewRequest("GET", "/api/user/profile", nil)
 		req.Header.Set("Authorization", "test-token")
 		w := httptest.NewRecorder()
 
@@ -281,8 +885,10 @@ func TestHandler_ListUsers(t *testing.T) {
 
 	t.Run("successful listing", func(t *testing.T) {
 		mockUser.On("ListUsers", mock.Anything, &pb_user.ListUsersRequest{
-			Page:    1,
-			PerPage: 10,
+			PerPage:      2,
+			EmailPrefix:  "user",
+			Sort:         "email",
+			IncludeTotal: true,
 		}).Return(&pb_user.ListUsersResponse{
 			Users: []*pb_user.User{
 				{
@@ -296,10 +902,11 @@ func TestHandler_ListUsers(t *testing.T) {
 					CreatedAt: "2024-01-01",
 				},
 			},
-			Total: 2,
+			Total:      3,
+			NextCursor: "next+page",
 		}, nil)
 
-		req := httptest.NewRequest("GET", "/api/user/list?page=1&per_page=10", nil)
+		req := httptest.NewRequest("GET", "/api/user/list?per_page=2&email=user&sort=email&include_total=true", nil)
 		req.Header.Set("Authorization", "test-token")
 		w := httptest.NewRecorder()
 
@@ -311,6 +918,799 @@ func TestHandler_ListUsers(t *testing.T) {
 		err := json.Unmarshal(w.Body.Bytes(), &response)
 		assert.NoError(t, err)
 		assert.Len(t, response.Users, 2)
-		assert.Equal(t, int32(2), response.Total)
+		assert.Equal(t, int32(3), response.Total)
+		assert.Equal(t, "next+page", response.NextCursor)
+
+		// Ссылки сохраняют фильтры, курсор экранирован
+		assert.Equal(t,
+			`</api/user/list?email=user&include_total=true&per_page=2&sort=email>; rel="first", `+
+				`</api/user/list?cursor=next%2Bpage&email=user&include_total=true&per_page=2&sort=email>; rel="next"`,
+			w.Header().Get("Link"))
+	})
+
+	t.Run("last page", func(t *testing.T) {
+		mockUser.On("ListUsers", mock.Anything, &pb_user.ListUsersRequest{
+			Cursor: "abc",
+		}).Return(&pb_user.ListUsersResponse{}, nil)
+
+		req := httptest.NewRequest("GET", "/api/user/list?cursor=abc", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ListUsers(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"users": []}`, w.Body.String())
+		assert.Equal(t, `</api/user/list>; rel="first"`, w.Header().Get("Link"))
+	})
+
+	t.Run("invalid per_page", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/user/list?per_page=ten", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ListUsers(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		mockUser.On("ListUsers", mock.Anything, &pb_user.ListUsersRequest{
+			PerPage: 10,
+		}).Return(nil, status.Error(codes.PermissionDenied, "access denied"))
+
+		req := httptest.NewRequest("GET", "/api/user/list?per_page=10", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ListUsers(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+}
+
+func TestHandler_PasswordReset(t *testing.T) {
+	h, _, _, mockUser := setupTest()
+
+	t.Run("forgot password", func(t *testing.T) {
+		mockUser.On("RequestPasswordReset", mock.Anything, &pb_user.RequestPasswordResetRequest{
+			Email: "test@example.com",
+		}).Return(&pb_user.RequestPasswordResetResponse{}, nil)
+
+		req := httptest.NewRequest("POST", "/api/auth/password/forgot", bytes.NewBufferString(`{"email": "test@example.com"}`))
+		w := httptest.NewRecorder()
+
+		h.ForgotPassword(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	})
+
+	t.Run("reset password", func(t *testing.T) {
+		mockUser.On("ResetPassword", mock.Anything, &pb_user.ResetPasswordRequest{
+			Token:       "valid-token",
+			NewPassword: "new-password",
+		}).Return(&pb_user.ResetPasswordResponse{}, nil)
+
+		req := httptest.NewRequest("POST", "/api/auth/password/reset", bytes.NewBufferString(`{"token": "valid-token", "password": "new-password"}`))
+		w := httptest.NewRecorder()
+
+		h.ResetPassword(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		mockUser.On("ResetPassword", mock.Anything, &pb_user.ResetPasswordRequest{
+			Token:       "expired-token",
+			NewPassword: "new-password",
+		}).Return(nil, status.Error(codes.InvalidArgument, "invalid or expired token"))
+
+		req := httptest.NewRequest("POST", "/api/auth/password/reset", bytes.NewBufferString(`{"token": "expired-token", "password": "new-password"}`))
+		w := httptest.NewRecorder()
+
+		h.ResetPassword(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestHandler_EmailVerification(t *testing.T) {
+	h, _, _, mockUser := setupTest()
+
+	t.Run("send verification", func(t *testing.T) {
+		mockUser.On("SendVerification", mock.MatchedBy(func(ctx context.Context) bool {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			return len(md.Get("authorization")) == 1 && md.Get("authorization")[0] == "test-token"
+		}), &pb_user.SendVerificationRequest{}).Return(&pb_user.SendVerificationResponse{}, nil)
+
+		req := httptest.NewRequest("POST", "/api/auth/email/verification", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.SendVerification(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	})
+
+	t.Run("verify email", func(t *testing.T) {
+		mockUser.On("VerifyEmail", mock.Anything, &pb_user.VerifyEmailRequest{
+			Token: "valid-token",
+		}).Return(&pb_user.VerifyEmailResponse{}, nil)
+
+		req := httptest.NewRequest("POST", "/api/auth/email/verify", bytes.NewBufferString(`{"token": "valid-token"}`))
+		w := httptest.NewRecorder()
+
+		h.VerifyEmail(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/api/auth/email/verify", bytes.NewBufferString(`{}`))
+		w := httptest.NewRecorder()
+
+		h.VerifyEmail(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestHandler_MFA(t *testing.T) {
+	h, mockAuth, _, mockUser := setupTest()
+
+	t.Run("login requires second factor", func(t *testing.T) {
+		mockAuth.On("Login", mock.Anything, &pb_auth.LoginRequest{
+			Email:    "mfa@example.com",
+			Password: "password123",
+		}).Return(&pb_auth.LoginResponse{MfaRequired: true, MfaToken: "mfa-token"}, nil)
+
+		req := httptest.NewRequest("POST", "/api/auth/login", bytes.NewBufferString(`{"email": "mfa@example.com", "password": "password123"}`))
+		w := httptest.NewRecorder()
+
+		h.Login(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"mfa_required": true, "mfa_token": "mfa-token"}`, w.Body.String())
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		mockAuth.On("VerifyMFA", mock.Anything, &pb_auth.VerifyMFARequest{
+			MfaToken: "mfa-token",
+			Code:     "123456",
+		}).Return(&pb_auth.VerifyMFAResponse{Token: "access", RefreshToken: "refresh", ExpiresIn: 900}, nil)
+
+		req := httptest.NewRequest("POST", "/api/auth/mfa/verify", bytes.NewBufferString(`{"mfa_token": "mfa-token", "code": "123456"}`))
+		w := httptest.NewRecorder()
+
+		h.VerifyMFA(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp AuthResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, "access", resp.Token)
+		assert.False(t, resp.MFARequired)
+	})
+
+	t.Run("invalid code", func(t *testing.T) {
+		mockAuth.On("VerifyMFA", mock.Anything, &pb_auth.VerifyMFARequest{
+			MfaToken: "mfa-token",
+			Code:     "000000",
+		}).Return(nil, status.Error(codes.Unauthenticated, "invalid code"))
+
+		req := httptest.NewRequest("POST", "/api/auth/mfa/verify", bytes.NewBufferString(`{"mfa_token": "mfa-token", "code": "000000"}`))
+		w := httptest.NewRecorder()
+
+		h.VerifyMFA(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("enroll", func(t *testing.T) {
+		mockUser.On("EnrollTOTP", mock.MatchedBy(func(ctx context.Context) bool {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			return len(md.Get("authorization")) == 1 && md.Get("authorization")[0] == "test-token"
+		}), &pb_user.EnrollTOTPRequest{}).Return(&pb_user.EnrollTOTPResponse{
+			OtpauthUri:    "otpauth://totp/DD:user@example.com?secret=ABC",
+			Secret:        "ABC",
+			RecoveryCodes: []string{"abcde-fghij"},
+		}, nil)
+
+		req := httptest.NewRequest("POST", "/api/auth/mfa/totp/enroll", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.EnrollTOTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp EnrollTOTPResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, "ABC", resp.Secret)
+		assert.Equal(t, []string{"abcde-fghij"}, resp.RecoveryCodes)
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		mockUser.On("ConfirmTOTP", mock.Anything, &pb_user.ConfirmTOTPRequest{Code: "123456"}).
+			Return(&pb_user.ConfirmTOTPResponse{}, nil)
+
+		req := httptest.NewRequest("POST", "/api/auth/mfa/totp/confirm", bytes.NewBufferString(`{"code": "123456"}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ConfirmTOTP(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+}
+
+func TestHandler_OAuth(t *testing.T) {
+	h, mockAuth, _, _ := setupTest()
+
+	t.Run("start redirects to provider", func(t *testing.T) {
+		mockAuth.On("StartOAuth", mock.Anything, &pb_auth.StartOAuthRequest{Provider: "mock"}).
+			Return(&pb_auth.StartOAuthResponse{AuthorizationUrl: "http://idp.example.com/authorize?state=abc"}, nil)
+
+		req := httptest.NewRequest("GET", "/api/auth/oauth/mock/start", nil)
+		req = mux.SetURLVars(req, map[string]string{"provider": "mock"})
+		w := httptest.NewRecorder()
+
+		h.StartOAuth(w, req)
+
+		assert.Equal(t, http.StatusFound, w.Code)
+		assert.Equal(t, "http://idp.example.com/authorize?state=abc", w.Header().Get("Location"))
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		mockAuth.On("StartOAuth", mock.Anything, &pb_auth.StartOAuthRequest{Provider: "unknown"}).
+			Return(nil, status.Error(codes.NotFound, "unknown provider"))
+
+		req := httptest.NewRequest("GET", "/api/auth/oauth/unknown/start", nil)
+		req = mux.SetURLVars(req, map[string]string{"provider": "unknown"})
+		w := httptest.NewRecorder()
+
+		h.StartOAuth(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("callback", func(t *testing.T) {
+		mockAuth.On("CompleteOAuth", mock.Anything, &pb_auth.CompleteOAuthRequest{
+			Provider: "mock",
+			State:    "abc",
+			Code:     "code",
+		}).Return(&pb_auth.LoginResponse{Token: "access", RefreshToken: "refresh", ExpiresIn: 900}, nil)
+
+		req := httptest.NewRequest("GET", "/api/auth/oauth/mock/callback?state=abc&code=code", nil)
+		req = mux.SetURLVars(req, map[string]string{"provider": "mock"})
+		w := httptest.NewRecorder()
+
+		h.OAuthCallback(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp AuthResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, "access", resp.Token)
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/auth/oauth/mock/callback?state=abc&error=access_denied", nil)
+		req = mux.SetURLVars(req, map[string]string{"provider": "mock"})
+		w := httptest.NewRecorder()
+
+		h.OAuthCallback(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestHandler_APIKeys(t *testing.T) {
+	h, mockAuth, _, _ := setupTest()
+
+	t.Run("create", func(t *testing.T) {
+		mockAuth.On("CreateAPIKey", mock.Anything, &pb_auth.CreateAPIKeyRequest{
+			Name:   "ci",
+			Scopes: []string{"geo:search"},
+		}).Return(&pb_auth.CreateAPIKeyResponse{
+			ApiKey: &pb_auth.APIKey{Id: "key-1", Name: "ci", Prefix: "ddk_abcdefgh", Scopes: []string{"geo:search"}},
+			Key:    "ddk_abcdefgh-secret",
+		}, nil)
+
+		body := bytes.NewBuffer([]byte(`{"name": "ci", "scopes": ["geo:search"]}`))
+		req := httptest.NewRequest("POST", "/api/auth/api-keys", body)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.CreateAPIKey(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var resp APIKeyResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Equal(t, "key-1", resp.ID)
+		assert.Equal(t, "ddk_abcdefgh-secret", resp.Key)
+	})
+
+	t.Run("create without scopes", func(t *testing.T) {
+		body := bytes.NewBuffer([]byte(`{"name": "ci"}`))
+		req := httptest.NewRequest("POST", "/api/auth/api-keys", body)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.CreateAPIKey(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		mockAuth.On("ListAPIKeys", mock.Anything, &pb_auth.ListAPIKeysRequest{}).
+			Return(&pb_auth.ListAPIKeysResponse{
+				ApiKeys: []*pb_auth.APIKey{{Id: "key-1", Name: "ci"}},
+			}, nil)
+
+		req := httptest.NewRequest("GET", "/api/auth/api-keys", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ListAPIKeys(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp ListAPIKeysResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Len(t, resp.APIKeys, 1)
+		assert.Empty(t, resp.APIKeys[0].Key)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		mockAuth.On("RevokeAPIKey", mock.Anything, &pb_auth.RevokeAPIKeyRequest{Id: "key-1"}).
+			Return(&pb_auth.RevokeAPIKeyResponse{}, nil)
+
+		req := httptest.NewRequest("DELETE", "/api/auth/api-keys/key-1", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "key-1"})
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.RevokeAPIKey(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("revoke unknown key", func(t *testing.T) {
+		mockAuth.On("RevokeAPIKey", mock.Anything, &pb_auth.RevokeAPIKeyRequest{Id: "key-2"}).
+			Return(nil, status.Error(codes.NotFound, "API key not found"))
+
+		req := httptest.NewRequest("DELETE", "/api/auth/api-keys/key-2", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "key-2"})
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.RevokeAPIKey(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestHandler_Sessions(t *testing.T) {
+	h, mockAuth, _, _ := setupTest()
+
+	t.Run("list", func(t *testing.T) {
+		mockAuth.On("ListSessions", mock.Anything, &pb_auth.ListSessionsRequest{}).
+			Return(&pb_auth.ListSessionsResponse{
+				Sessions: []*pb_auth.Session{
+					{Id: "session-1", UserAgent: "Firefox", Ip: "203.0.113.7", Current: true},
+					{Id: "session-2", UserAgent: "Safari"},
+				},
+			}, nil)
+
+		req := httptest.NewRequest("GET", "/api/auth/sessions", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ListSessions(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var resp ListSessionsResponse
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		assert.Len(t, resp.Sessions, 2)
+		assert.Equal(t, "session-1", resp.Sessions[0].ID)
+		assert.True(t, resp.Sessions[0].Current)
+	})
+
+	t.Run("revoke", func(t *testing.T) {
+		mockAuth.On("RevokeSession", mock.Anything, &pb_auth.RevokeSessionRequest{Id: "session-2"}).
+			Return(&pb_auth.RevokeSessionResponse{}, nil)
+
+		req := httptest.NewRequest("DELETE", "/api/auth/sessions/session-2", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "session-2"})
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.RevokeSession(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("revoke unknown session", func(t *testing.T) {
+		mockAuth.On("RevokeSession", mock.Anything, &pb_auth.RevokeSessionRequest{Id: "session-3"}).
+			Return(nil, status.Error(codes.NotFound, "session not found"))
+
+		req := httptest.NewRequest("DELETE", "/api/auth/sessions/session-3", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "session-3"})
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.RevokeSession(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestHandler_UserAccount(t *testing.T) {
+	h, _, _, mockUser := setupTest()
+
+	t.Run("update email", func(t *testing.T) {
+		mockUser.On("UpdateUser", mock.Anything, &pb_user.UpdateUserRequest{Email: "new@example.com"}).
+			Return(&pb_user.UpdateUserResponse{
+				User: &pb_user.User{Id: "123", Email: "new@example.com", Role: "user"},
+			}, nil)
+
+		req := httptest.NewRequest("PATCH", "/api/user", bytes.NewBufferString(`{"email": "new@example.com"}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.UpdateUser(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response ProfileResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "new@example.com", response.Email)
+		assert.False(t, response.EmailVerified)
+	})
+
+	t.Run("email taken", func(t *testing.T) {
+		mockUser.On("UpdateUser", mock.Anything, &pb_user.UpdateUserRequest{Email: "taken@example.com"}).
+			Return(nil, status.Error(codes.AlreadyExists, "email is already registered"))
+
+		req := httptest.NewRequest("PATCH", "/api/user", bytes.NewBufferString(`{"email": "taken@example.com"}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.UpdateUser(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("change password", func(t *testing.T) {
+		mockUser.On("ChangePassword", mock.Anything, &pb_user.ChangePasswordRequest{
+			CurrentPassword: "Correct-horse-42",
+			NewPassword:     "Battery-staple-42",
+		}).Return(&pb_user.ChangePasswordResponse{}, nil)
+
+		req := httptest.NewRequest("PUT", "/api/user/password", bytes.NewBufferString(
+			`{"current_password": "Correct-horse-42", "new_password": "Battery-staple-42"}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ChangePassword(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("wrong current password", func(t *testing.T) {
+		st, err := status.New(codes.InvalidArgument, "current password is incorrect").WithDetails(&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "current_password", Description: "is incorrect"},
+			},
+		})
+		assert.NoError(t, err)
+		mockUser.On("ChangePassword", mock.Anything, &pb_user.ChangePasswordRequest{
+			CurrentPassword: "wrong",
+			NewPassword:     "Battery-staple-42",
+		}).Return(nil, st.Err())
+
+		req := httptest.NewRequest("PUT", "/api/user/password", bytes.NewBufferString(
+			`{"current_password": "wrong", "new_password": "Battery-staple-42"}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ChangePassword(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, []FieldError{{Field: "current_password", Description: "is incorrect"}}, response.Fields)
+	})
+
+	t.Run("change password without current", func(t *testing.T) {
+		req := httptest.NewRequest("PUT", "/api/user/password", bytes.NewBufferString(`{"new_password": "Battery-staple-42"}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ChangePassword(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("delete own account", func(t *testing.T) {
+		mockUser.On("DeleteUser", mock.Anything, &pb_user.DeleteUserRequest{}).
+			Return(&pb_user.DeleteUserResponse{}, nil)
+
+		req := httptest.NewRequest("DELETE", "/api/user", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.DeleteAccount(w, req)
+
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("admin deletes unknown user", func(t *testing.T) {
+		mockUser.On("DeleteUser", mock.Anything, &pb_user.DeleteUserRequest{UserId: "456"}).
+			Return(nil, status.Error(codes.NotFound, "user not found"))
+
+		req := httptest.NewRequest("DELETE", "/api/user/456", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "456"})
+		req.Header.Set("Authorization", "admin-token")
+		w := httptest.NewRecorder()
+
+		h.DeleteUser(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestHandler_UpdateProfile(t *testing.T) {
+	h, _, _, mockUser := setupTest()
+
+	t.Run("partial update", func(t *testing.T) {
+		// Маска составляется из переданных полей, null очищает адрес
+		mockUser.On("UpdateProfile", mock.Anything, mock.MatchedBy(func(req *pb_user.UpdateProfileRequest) bool {
+			return req.Version == 3 &&
+				assert.ObjectsAreEqual([]string{"display_name", "home_address", "timezone"}, req.UpdateMask.GetPaths()) &&
+				req.Profile.DisplayName == "Test User" &&
+				req.Profile.Timezone == "Europe/Moscow" &&
+				req.Profile.HomeAddress == nil
+		})).Return(&pb_user.UpdateProfileResponse{
+			User: &pb_user.User{
+				Id:          "123",
+				Email:       "test@example.com",
+				DisplayName: "Test User",
+				Timezone:    "Europe/Moscow",
+				Version:     4,
+			},
+		}, nil).Once()
+
+		req := httptest.NewRequest("PATCH", "/api/user/profile", bytes.NewBufferString(
+			`{"display_name": "Test User", "timezone": "Europe/Moscow", "home_address": null, "version": 3}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.UpdateProfile(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response ProfileResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "Test User", response.DisplayName)
+		assert.Equal(t, int64(4), response.Version)
+	})
+
+	t.Run("concurrent modification", func(t *testing.T) {
+		mockUser.On("UpdateProfile", mock.Anything, mock.MatchedBy(func(req *pb_user.UpdateProfileRequest) bool {
+			return req.Version == 2
+		})).Return(nil, status.Error(codes.Aborted, "profile was modified concurrently, current version is 4")).Once()
+
+		req := httptest.NewRequest("PATCH", "/api/user/profile", bytes.NewBufferString(
+			`{"locale": "ru-RU", "version": 2}`))
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.UpdateProfile(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		for name, body := range map[string]string{
+			"no version":    `{"locale": "ru-RU"}`,
+			"no fields":     `{"version": 2}`,
+			"unknown field": `{"email": "new@example.com", "version": 2}`,
+			"wrong type":    `{"phone": 79991234567, "version": 2}`,
+		} {
+			req := httptest.NewRequest("PATCH", "/api/user/profile", bytes.NewBufferString(body))
+			req.Header.Set("Authorization", "test-token")
+			w := httptest.NewRecorder()
+
+			h.UpdateProfile(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		}
+	})
+}
+
+func TestHandler_UserData(t *testing.T) {
+	h, _, _, mockUser := setupTest()
+
+	job := &pb_user.DataJob{Id: "job-1", Type: "export", UserId: "123", Status: "pending", CreatedAt: "2024-03-01T12:00:00Z"}
+
+	t.Run("export my data", func(t *testing.T) {
+		mockUser.On("ExportUserData", mock.Anything, &pb_user.ExportUserDataRequest{}).
+			Return(&pb_user.ExportUserDataResponse{Job: job}, nil).Once()
+
+		req := httptest.NewRequest("POST", "/api/user/export", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.ExportMyData(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "/api/user/jobs/job-1", w.Header().Get("Location"))
+		var response DataJobResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "job-1", response.ID)
+		assert.Equal(t, "pending", response.Status)
+	})
+
+	t.Run("admin erases user", func(t *testing.T) {
+		mockUser.On("EraseUser", mock.Anything, &pb_user.EraseUserRequest{UserId: "456"}).
+			Return(&pb_user.EraseUserResponse{Job: &pb_user.DataJob{Id: "job-2", Type: "erase", UserId: "456", Status: "pending"}}, nil).Once()
+
+		req := httptest.NewRequest("POST", "/api/user/456/erase", nil)
+		req.Header.Set("Authorization", "test-token")
+		req = mux.SetURLVars(req, map[string]string{"id": "456"})
+		w := httptest.NewRecorder()
+
+		h.EraseUser(w, req)
+
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.Equal(t, "/api/user/jobs/job-2", w.Header().Get("Location"))
+	})
+
+	t.Run("job status", func(t *testing.T) {
+		mockUser.On("GetDataJob", mock.Anything, &pb_user.GetDataJobRequest{Id: "job-1"}).
+			Return(&pb_user.GetDataJobResponse{Job: job}, nil).Once()
+
+		req := httptest.NewRequest("GET", "/api/user/jobs/job-1", nil)
+		req.Header.Set("Authorization", "test-token")
+		req = mux.SetURLVars(req, map[string]string{"id": "job-1"})
+		w := httptest.NewRecorder()
+
+		h.GetDataJob(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response DataJobResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "export", response.Type)
+	})
+
+	t.Run("download archive", func(t *testing.T) {
+		mockUser.On("GetDataExport", mock.Anything, &pb_user.GetDataExportRequest{JobId: "job-1"}).
+			Return(&pb_user.GetDataExportResponse{Archive: []byte(`{"user":{"id":"123"}}`)}, nil).Once()
+
+		req := httptest.NewRequest("GET", "/api/user/jobs/job-1/archive", nil)
+		req.Header.Set("Authorization", "test-token")
+		req = mux.SetURLVars(req, map[string]string{"id": "job-1"})
+		w := httptest.NewRecorder()
+
+		h.GetDataExport(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `attachment; filename="export-job-1.json"`, w.Header().Get("Content-Disposition"))
+		assert.JSONEq(t, `{"user":{"id":"123"}}`, w.Body.String())
+	})
+
+	t.Run("archive not ready", func(t *testing.T) {
+		mockUser.On("GetDataExport", mock.Anything, &pb_user.GetDataExportRequest{JobId: "job-3"}).
+			Return(nil, status.Error(codes.FailedPrecondition, "export is running")).Once()
+
+		req := httptest.NewRequest("GET", "/api/user/jobs/job-3/archive", nil)
+		req.Header.Set("Authorization", "test-token")
+		req = mux.SetURLVars(req, map[string]string{"id": "job-3"})
+		w := httptest.NewRecorder()
+
+		h.GetDataExport(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
 	})
 }
+
+func TestHandler_QueryAuditLog(t *testing.T) {
+	h, _, _, mockUser := setupTest()
+
+	t.Run("successful query", func(t *testing.T) {
+		// Адрес и User-Agent администратора тоже попадают в журнал
+		mockUser.On("QueryAuditLog",
+			mock.MatchedBy(func(ctx context.Context) bool {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				return len(md.Get("authorization")) == 1 && md.Get("authorization")[0] == "test-token" &&
+					len(md.Get("x-client-ip")) == 1 && md.Get("x-client-ip")[0] == "192.0.2.1" &&
+					len(md.Get("x-user-agent")) == 1 && md.Get("x-user-agent")[0] == "curl/8.0"
+			}),
+			&pb_user.QueryAuditLogRequest{
+				Action:  "auth.login",
+				Result:  "failure",
+				PerPage: 1,
+			},
+		).Return(&pb_user.QueryAuditLogResponse{
+			Events: []*pb_user.AuditEvent{
+				{
+					Id:        42,
+					Time:      "2024-03-01T12:00:00Z",
+					Service:   "auth",
+					ActorType: "anonymous",
+					Action:    "auth.login",
+					Target:    "test@example.com",
+					Ip:        "203.0.113.7",
+					Result:    "failure",
+					Reason:    "invalid credentials",
+					PrevHash:  "prev",
+					Hash:      "hash",
+				},
+			},
+			NextCursor: "NDI",
+		}, nil).Once()
+
+		req := httptest.NewRequest("GET", "/api/audit?action=auth.login&result=failure&per_page=1", nil)
+		req.Header.Set("Authorization", "test-token")
+		req.Header.Set("User-Agent", "curl/8.0")
+		w := httptest.NewRecorder()
+
+		h.QueryAuditLog(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response QueryAuditLogResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		if assert.Len(t, response.Events, 1) {
+			assert.Equal(t, int64(42), response.Events[0].ID)
+			assert.Equal(t, "test@example.com", response.Events[0].Target)
+			assert.Equal(t, "203.0.113.7", response.Events[0].IP)
+			assert.Equal(t, "hash", response.Events[0].Hash)
+		}
+		assert.Equal(t, "NDI", response.NextCursor)
+		assert.Equal(t,
+			`</api/audit?action=auth.login&per_page=1&result=failure>; rel="first", `+
+				`</api/audit?action=auth.login&cursor=NDI&per_page=1&result=failure>; rel="next"`,
+			w.Header().Get("Link"))
+	})
+
+	t.Run("empty page", func(t *testing.T) {
+		mockUser.On("QueryAuditLog", mock.Anything, &pb_user.QueryAuditLogRequest{
+			Target: "123",
+			Cursor: "NDI",
+		}).Return(&pb_user.QueryAuditLogResponse{}, nil).Once()
+
+		req := httptest.NewRequest("GET", "/api/audit?target=123&cursor=NDI", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.QueryAuditLog(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"events": []}`, w.Body.String())
+		assert.Equal(t, `</api/audit?target=123>; rel="first"`, w.Header().Get("Link"))
+	})
+
+	t.Run("invalid per_page", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/audit?per_page=all", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.QueryAuditLog(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("invalid filter", func(t *testing.T) {
+		mockUser.On("QueryAuditLog", mock.Anything, &pb_user.QueryAuditLogRequest{
+			Since: "yesterday",
+		}).Return(nil, status.Error(codes.InvalidArgument, "audit log request is invalid")).Once()
+
+		req := httptest.NewRequest("GET", "/api/audit?since=yesterday", nil)
+		req.Header.Set("Authorization", "test-token")
+		w := httptest.NewRecorder()
+
+		h.QueryAuditLog(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	mockUser.AssertExpectations(t)
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RouteKey возвращает ключ правила для маршрута запроса: метод и шаблон пути,
+// например "GET /api/user/list"
+func RouteKey(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return r.Method + " " + r.URL.Path
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return r.Method + " " + r.URL.Path
+	}
+	return r.Method + " " + tpl
+}
+
+// Authorize проверяет токен из заголовка Authorization или, если его нет,
+// API ключ из X-API-Key и применяет к маршруту правило из policy.
+// Вызывающий сохраняется в контексте запроса, а в правила владельца
+// передается сам *http.Request. На публичных маршрутах недействительные
+// учетные данные не отклоняются: с истекшим токеном клиент приходит
+// именно за новым.
+func Authorize(verifier *authn.Verifier, policy authz.Policy) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			key := RouteKey(r)
+			public := policy[key].Public
+
+			var principal *authn.Principal
+			var err error
+			if token := r.Header.Get("Authorization"); token != "" {
+				principal, err = verifier.Verify(ctx, token)
+			} else if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+				principal, err = verifier.VerifyAPIKey(ctx, apiKey)
+			}
+			if err != nil && !public {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if err == nil && principal != nil {
+				ctx = authn.NewContext(ctx, principal)
+			}
+
+			r = r.WithContext(ctx)
+			if err := policy.Authorize(ctx, key, r); err != nil {
+				if status.Code(err) == codes.Unauthenticated {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
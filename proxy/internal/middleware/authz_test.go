@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeySource struct {
+	pub ed25519.PublicKey
+}
+
+func (s testKeySource) Keys(ctx context.Context) ([]authn.JSONWebKey, error) {
+	return []authn.JSONWebKey{authn.NewJSONWebKey("test", "EdDSA", s.pub)}, nil
+}
+
+// testAPIKeys - API ключи, известные тестам, и их разрешения
+type testAPIKeys map[string][]string
+
+func (k testAPIKeys) ResolveAPIKey(ctx context.Context, key string) (*authn.Principal, error) {
+	scopes, ok := k[key]
+	if !ok {
+		return nil, authn.ErrInvalidToken
+	}
+	return &authn.Principal{UserID: "owner", APIKeyID: key, Scopes: scopes}, nil
+}
+
+func TestAuthorize(t *testing.T) {
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	sign := func(ttl time.Duration, email string, roles ...string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, authn.Claims{
+			Email: email,
+			Roles: roles,
+			Scope: authz.ScopeGeoSearch + " " + authz.ScopeGeoGeocode,
+			StandardClaims: jwt.StandardClaims{
+				Subject:   email,
+				Issuer:    "dd-auth",
+				Audience:  "dd-api",
+				ExpiresAt: time.Now().Add(ttl).Unix(),
+			},
+		})
+		tok.Header["kid"] = "test"
+		signed, err := tok.SignedString(private)
+		assert.NoError(t, err)
+		return "Bearer " + signed
+	}
+	token := func(email string, roles ...string) string {
+		return sign(time.Minute, email, roles...)
+	}
+
+	policy := authz.Policy{
+		"POST /api/auth/login": {Public: true},
+		"GET /api/user/profile": {
+			Roles: []string{authz.RoleAdmin},
+			Owner: func(p *authn.Principal, req interface{}) bool {
+				return req.(*http.Request).URL.Query().Get("email") == p.Email
+			},
+		},
+		"GET /api/user/list":        {Roles: []string{authz.RoleAdmin}},
+		"POST /api/address/search":  {Scopes: []string{authz.ScopeGeoSearch}},
+		"POST /api/address/geocode": {Scopes: []string{authz.ScopeGeoGeocode}},
+		"GET /api/auth/api-keys":    {},
+	}
+	apiKeys := testAPIKeys{"ddk_search": {authz.ScopeGeoSearch}}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	r := mux.NewRouter()
+	r.Use(Authorize(authn.NewVerifier(testKeySource{pub: pub}, "dd-auth", "dd-api", authn.WithAPIKeys(apiKeys, time.Minute)), policy))
+	r.Handle("/api/auth/login", ok).Methods("POST")
+	r.Handle("/api/user/profile", ok).Methods("GET")
+	r.Handle("/api/user/list", ok).Methods("GET")
+	r.Handle("/api/user/secret", ok).Methods("GET")
+	r.Handle("/api/address/search", ok).Methods("POST")
+	r.Handle("/api/address/geocode", ok).Methods("POST")
+	r.Handle("/api/auth/api-keys", ok).Methods("GET")
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		apiKey string
+		code   int
+	}{
+		{"public route", "POST", "/api/auth/login", "", "", http.StatusOK},
+		{"public route with invalid token", "POST", "/api/auth/login", "Bearer garbage", "", http.StatusOK},
+		{"public route with expired token", "POST", "/api/auth/login", sign(-time.Minute, "user@example.com", authz.RoleUser), "", http.StatusOK},
+		{"public route with unknown api key", "POST", "/api/auth/login", "", "ddk_unknown", http.StatusOK},
+		{"anonymous", "GET", "/api/user/list", "", "", http.StatusUnauthorized},
+		{"invalid token", "GET", "/api/user/list", "Bearer garbage", "", http.StatusUnauthorized},
+		{"expired token", "GET", "/api/user/list", sign(-time.Minute, "admin@example.com", authz.RoleAdmin), "", http.StatusUnauthorized},
+		{"user lists users", "GET", "/api/user/list", token("user@example.com", authz.RoleUser), "", http.StatusForbidden},
+		{"admin lists users", "GET", "/api/user/list", token("admin@example.com", authz.RoleAdmin), "", http.StatusOK},
+		{"own profile", "GET", "/api/user/profile?email=user@example.com", token("user@example.com", authz.RoleUser), "", http.StatusOK},
+		{"other profile", "GET", "/api/user/profile?email=admin@example.com", token("user@example.com", authz.RoleUser), "", http.StatusForbidden},
+		{"route without policy", "GET", "/api/user/secret", token("admin@example.com", authz.RoleAdmin), "", http.StatusForbidden},
+		{"user searches address", "POST", "/api/address/search", token("user@example.com", authz.RoleUser), "", http.StatusOK},
+		{"api key with scope", "POST", "/api/address/search", "", "ddk_search", http.StatusOK},
+		{"api key without scope", "POST", "/api/address/geocode", "", "ddk_search", http.StatusForbidden},
+		{"api key manages keys", "GET", "/api/auth/api-keys", "", "ddk_search", http.StatusForbidden},
+		{"unknown api key", "POST", "/api/address/search", "", "ddk_unknown", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", tt.token)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
@@ -2,11 +2,15 @@ package main
 
 import (
 	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
 	pb_geo "dd/pkg/geo"
 	pb_user "dd/pkg/user"
+	"dd/proxy/internal/config"
 	"dd/proxy/internal/handler"
+	"dd/proxy/internal/middleware"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"google.golang.org/grpc"
@@ -17,20 +21,38 @@ import (
 )
 
 func main() {
-	// Подключаемся к микросервисам
-	authConn, err := grpc.Dial("auth:50051", grpc.WithInsecure(), grpc.WithBlock())
+	cfg := config.New()
+	if cfg.ClientSecret == "" {
+		log.Fatalf("PROXY_CLIENT_SECRET is not set")
+	}
+
+	// Токен сервиса запрашивается по отдельному соединению без учетных данных
+	tokenConn, err := grpc.Dial(cfg.AuthService, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("failed to connect to auth service: %v", err)
+	}
+	defer tokenConn.Close()
+
+	serviceCreds := authn.NewServiceCredentials(authn.NewAuthServiceTokenSource(
+		pb_auth.NewAuthServiceClient(tokenConn), cfg.ClientID, cfg.ClientSecret))
+
+	// Подключаемся к микросервисам. Вызовы auth и user подписываются токеном
+	// proxy: без токена пользователя, например проверка API ключей, - от имени
+	// proxy, а в вызовах пользователя токен proxy передается отдельно, и по
+	// нему сервисы доверяют адресу клиента.
+	authConn, err := grpc.Dial(cfg.AuthService, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithPerRPCCredentials(serviceCreds))
 	if err != nil {
 		log.Fatalf("failed to connect to auth service: %v", err)
 	}
 	defer authConn.Close()
 
-	geoConn, err := grpc.Dial("geo:50052", grpc.WithInsecure(), grpc.WithBlock())
+	geoConn, err := grpc.Dial(cfg.GeoService, grpc.WithInsecure(), grpc.WithBlock())
 	if err != nil {
 		log.Fatalf("failed to connect to geo service: %v", err)
 	}
 	defer geoConn.Close()
 
-	userConn, err := grpc.Dial("user:50053", grpc.WithInsecure(), grpc.WithBlock())
+	userConn, err := grpc.Dial(cfg.UserService, grpc.WithInsecure(), grpc.WithBlock(), grpc.WithPerRPCCredentials(serviceCreds))
 	if err != nil {
 		log.Fatalf("failed to connect to user service: %v", err)
 	}
@@ -44,12 +66,40 @@ func main() {
 	// Инициализируем handler
 	h := handler.New(authClient, geoClient, userClient)
 
+	// Токены проверяются локально по ключам auth сервиса, отзыв и API ключи - через auth
+	verifier := authn.NewVerifier(
+		authn.NewAuthServiceKeySource(authClient),
+		"dd-auth",
+		"dd-api",
+		authn.WithRevocationCheck(authn.NewAuthServiceRevocationChecker(authClient), 30*time.Second),
+		authn.WithAPIKeys(authn.NewAuthServiceAPIKeyResolver(authClient), 30*time.Second),
+	)
+
 	// Настраиваем маршруты
 	r := mux.NewRouter()
+	r.Use(middleware.Authorize(verifier, routePolicy))
 
 	// Auth routes
 	r.HandleFunc("/api/auth/register", h.Register).Methods("POST")
 	r.HandleFunc("/api/auth/login", h.Login).Methods("POST")
+	r.HandleFunc("/api/auth/refresh", h.Refresh).Methods("POST")
+	r.HandleFunc("/api/auth/logout", h.Logout).Methods("POST")
+	r.HandleFunc("/api/auth/unlock", h.UnlockAccount).Methods("POST")
+	r.HandleFunc("/api/auth/mfa/verify", h.VerifyMFA).Methods("POST")
+	r.HandleFunc("/api/auth/mfa/totp/enroll", h.EnrollTOTP).Methods("POST")
+	r.HandleFunc("/api/auth/mfa/totp/confirm", h.ConfirmTOTP).Methods("POST")
+	r.HandleFunc("/api/auth/oauth/{provider}/start", h.StartOAuth).Methods("GET")
+	r.HandleFunc("/api/auth/oauth/{provider}/callback", h.OAuthCallback).Methods("GET")
+	r.HandleFunc("/api/auth/api-keys", h.CreateAPIKey).Methods("POST")
+	r.HandleFunc("/api/auth/api-keys", h.ListAPIKeys).Methods("GET")
+	r.HandleFunc("/api/auth/api-keys/{id}", h.RevokeAPIKey).Methods("DELETE")
+	r.HandleFunc("/api/auth/sessions", h.ListSessions).Methods("GET")
+	r.HandleFunc("/api/auth/sessions/{id}", h.RevokeSession).Methods("DELETE")
+	r.HandleFunc("/api/auth/password/forgot", h.ForgotPassword).Methods("POST")
+	r.HandleFunc("/api/auth/password/reset", h.ResetPassword).Methods("POST")
+	r.HandleFunc("/api/auth/email/verification", h.SendVerification).Methods("POST")
+	r.HandleFunc("/api/auth/email/verify", h.VerifyEmail).Methods("POST")
+	r.HandleFunc("/.well-known/jwks.json", h.JWKS).Methods("GET")
 
 	// Geo routes
 	r.HandleFunc("/api/address/search", h.SearchAddress).Methods("POST")
@@ -57,7 +107,21 @@ func main() {
 
 	// User routes
 	r.HandleFunc("/api/user/profile", h.GetProfile).Methods("GET")
+	r.HandleFunc("/api/user/profile", h.UpdateProfile).Methods("PATCH")
 	r.HandleFunc("/api/user/list", h.ListUsers).Methods("GET")
+	r.HandleFunc("/api/user", h.UpdateUser).Methods("PATCH")
+	r.HandleFunc("/api/user", h.DeleteAccount).Methods("DELETE")
+	r.HandleFunc("/api/user/password", h.ChangePassword).Methods("PUT")
+	r.HandleFunc("/api/user/{id}", h.DeleteUser).Methods("DELETE")
+	r.HandleFunc("/api/user/export", h.ExportMyData).Methods("POST")
+	r.HandleFunc("/api/user/erase", h.EraseAccount).Methods("POST")
+	r.HandleFunc("/api/user/jobs/{id}", h.GetDataJob).Methods("GET")
+	r.HandleFunc("/api/user/jobs/{id}/archive", h.GetDataExport).Methods("GET")
+	r.HandleFunc("/api/user/{id}/export", h.ExportUserData).Methods("POST")
+	r.HandleFunc("/api/user/{id}/erase", h.EraseUser).Methods("POST")
+
+	// Audit routes
+	r.HandleFunc("/api/audit", h.QueryAuditLog).Methods("GET")
 
 	// Swagger
 	r.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
@@ -0,0 +1,58 @@
+package main
+
+import "dd/pkg/authz"
+
+// routePolicy - правила доступа к маршрутам proxy. Маршрут без правила запрещен.
+var routePolicy = authz.Policy{
+	"POST /api/auth/register": {Public: true},
+	"POST /api/auth/login":    {Public: true},
+	"POST /api/auth/refresh":  {Public: true},
+	"POST /api/auth/logout":   {Public: true},
+	"POST /api/auth/unlock":   {Roles: []string{authz.RoleAdmin}},
+
+	"POST /api/auth/password/forgot":    {Public: true},
+	"POST /api/auth/password/reset":     {Public: true},
+	"POST /api/auth/email/verify":       {Public: true},
+	"POST /api/auth/email/verification": {},
+
+	"POST /api/auth/mfa/verify":       {Public: true},
+	"POST /api/auth/mfa/totp/enroll":  {},
+	"POST /api/auth/mfa/totp/confirm": {},
+
+	"GET /api/auth/oauth/{provider}/start":    {Public: true},
+	"GET /api/auth/oauth/{provider}/callback": {Public: true},
+
+	// Ключами управляет только пользователь с токеном: сам API ключ сюда не допускается
+	"POST /api/auth/api-keys":        {},
+	"GET /api/auth/api-keys":         {},
+	"DELETE /api/auth/api-keys/{id}": {},
+
+	"GET /api/auth/sessions":         {},
+	"DELETE /api/auth/sessions/{id}": {},
+
+	"GET /.well-known/jwks.json": {Public: true},
+	"GET /swagger/":              {Public: true},
+
+	// Доступны пользователю с токеном и API ключу с нужным разрешением
+	"POST /api/address/search":  {Scopes: []string{authz.ScopeGeoSearch}},
+	"POST /api/address/geocode": {Scopes: []string{authz.ScopeGeoGeocode}},
+
+	"GET /api/user/profile": {},
+	"GET /api/user/list":    {Roles: []string{authz.RoleAdmin}},
+
+	"PATCH /api/user":         {},
+	"PATCH /api/user/profile": {},
+	"PUT /api/user/password":  {},
+	"DELETE /api/user":        {},
+	"DELETE /api/user/{id}":   {Roles: []string{authz.RoleAdmin}},
+
+	// Задачу видит ее владелец; чужие данные выгружает и стирает только администратор
+	"POST /api/user/export":           {},
+	"POST /api/user/erase":            {},
+	"GET /api/user/jobs/{id}":         {},
+	"GET /api/user/jobs/{id}/archive": {},
+	"POST /api/user/{id}/export":      {Roles: []string{authz.RoleAdmin}},
+	"POST /api/user/{id}/erase":       {Roles: []string{authz.RoleAdmin}},
+
+	"GET /api/audit": {Roles: []string{authz.RoleAdmin}},
+}
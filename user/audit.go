@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"dd/pkg/audit"
+)
+
+// runVerifyAudit выполняет подкоманду "verify-audit": пересчитывает цепочку
+// хэшей журнала аудита и сообщает о первой не сходящейся записи
+func runVerifyAudit(db *sql.DB) error {
+	result, err := audit.NewPostgres(db, "user").Verify(context.Background())
+	if err != nil {
+		return err
+	}
+	if result.BrokenID != 0 {
+		return fmt.Errorf("audit log chain is broken at record %d", result.BrokenID)
+	}
+	fmt.Printf("Audit log is intact, %d records checked\n", result.Checked)
+	return nil
+}
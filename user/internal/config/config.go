@@ -1,8 +1,68 @@
 package config
 
+import (
+    "os"
+    "time"
+
+    "dd/user/internal/password"
+)
+
 type Config struct {
-    GRPCPort string
-    DB       DBConfig
+    GRPCPort     string
+    AuthService  string
+    GeoService   string
+    // ClientID и ClientSecret - учетные данные user для получения токена сервиса.
+    // Секрет задается переменной USER_CLIENT_SECRET и должен совпадать с
+    // AUTH_USER_CLIENT_SECRET auth сервиса.
+    ClientID     string
+    ClientSecret string
+    // ProxyClientID - client_id proxy; только от него журнал аудита принимает
+    // адрес и User-Agent клиента
+    ProxyClientID string
+    JWTIssuer    string
+    JWTAudience  string
+    RedisAddr    string
+    // Storage - "postgres" или "memory". В памяти данные не переживают
+    // перезапуск, а события публикуются сразу; режим для разработки.
+    Storage      string
+    DB           DBConfig
+    Mail         MailConfig
+    Outbox       OutboxConfig
+
+    // Ссылки в письмах; к ним дописывается токен
+    PasswordResetURL     string
+    EmailVerificationURL string
+    PasswordResetTTL     time.Duration
+    EmailVerificationTTL time.Duration
+
+    // MFAIssuer - имя сервиса в приложении-аутентификаторе
+    MFAIssuer string
+    // MFAEncryptionKey - ключ AES-256 в base64 для шифрования TOTP секретов
+    MFAEncryptionKey string
+
+    // PasswordPolicy - требования к паролю при регистрации и сбросе
+    PasswordPolicy password.Policy
+    // PasswordHashing - алгоритм и параметры хэшей новых паролей. Хэши со
+    // слабыми параметрами пересчитываются при следующем успешном входе.
+    PasswordHashing password.HashConfig
+    // BreachedPasswordsFile - набор утекших паролей в формате Pwned Passwords
+    // (строки "SHA1:COUNT"); пустой путь отключает проверку
+    BreachedPasswordsFile string
+
+    // DeletedUserGracePeriod - сколько email удаленной учетной записи остается
+    // занятым; после этого его можно зарегистрировать снова
+    DeletedUserGracePeriod time.Duration
+    // DeletedUserCleanupInterval - как часто освобождаются email удаленных учетных записей
+    DeletedUserCleanupInterval time.Duration
+
+    // MigrateOnStart - применять миграции схемы при запуске сервиса. Если
+    // выключено, схему обновляет отдельный запуск "user migrate".
+    MigrateOnStart bool
+
+    // DataJobPollInterval - как часто проверяются новые задачи выгрузки и стирания данных
+    DataJobPollInterval time.Duration
+    // DataExportRetention - сколько хранится готовый архив выгрузки
+    DataExportRetention time.Duration
 }
 
 type DBConfig struct {
@@ -13,9 +73,42 @@ type DBConfig struct {
     DBName   string
 }
 
+// OutboxConfig - публикация событий о пользователях
+type OutboxConfig struct {
+    // Stream - поток Redis, в который публикуются события
+    Stream string
+    // MaxLen - примерная длина потока; старые события вытесняются
+    MaxLen int64
+    // BatchSize - сколько событий публикуется за один проход
+    BatchSize    int
+    PollInterval time.Duration
+    // Retention - сколько опубликованные события хранятся в таблице outbox
+    Retention time.Duration
+}
+
+type MailConfig struct {
+    // Driver - "smtp", "file" или "log"
+    Driver       string
+    From         string
+    SMTPHost     string
+    SMTPPort     string
+    SMTPUsername string
+    SMTPPassword string
+    FilePath     string
+}
+
 func New() *Config {
     return &Config{
-        GRPCPort: ":50053",
+        GRPCPort:     ":50053",
+        AuthService:  "auth:50051",
+        GeoService:   "geo:50052",
+        ClientID:     "user",
+        ClientSecret: os.Getenv("USER_CLIENT_SECRET"),
+        ProxyClientID: "proxy",
+        JWTIssuer:    "dd-auth",
+        JWTAudience:  "dd-api",
+        RedisAddr:    "redis:6379",
+        Storage:      "postgres",
         DB: DBConfig{
             Host:     "postgres",
             Port:     "5432",
@@ -23,5 +116,48 @@ func New() *Config {
             Password: "postgres",
             DBName:   "userdb",
         },
+        Outbox: OutboxConfig{
+            Stream:       "user-events",
+            MaxLen:       100000,
+            BatchSize:    100,
+            PollInterval: time.Second,
+            Retention:    7 * 24 * time.Hour,
+        },
+        Mail: MailConfig{
+            Driver:   "log",
+            From:     "DD <noreply@dd.local>",
+            SMTPHost: "smtp",
+            SMTPPort: "587",
+            FilePath: "/tmp/dd-mail.log",
+        },
+        PasswordResetURL:     "http://localhost:3000/reset-password?token=",
+        EmailVerificationURL: "http://localhost:3000/verify-email?token=",
+        PasswordResetTTL:     time.Hour,
+        EmailVerificationTTL: 48 * time.Hour,
+        MFAIssuer:            "DD",
+        MFAEncryptionKey:     "ZAUmh2O8Sk3sNle/oZXLWWgXWgtapl81FzW3xWupU00=",
+        PasswordPolicy: password.Policy{
+            MinLength:      10,
+            MaxLength:      72,
+            MinCharClasses: 3,
+            RejectEmail:    true,
+        },
+        BreachedPasswordsFile: "user/data/breached-passwords.txt",
+        PasswordHashing: password.HashConfig{
+            Algorithm:  password.AlgorithmArgon2id,
+            BcryptCost: 12,
+            Argon2: password.Argon2Params{
+                Memory:      64 * 1024,
+                Iterations:  3,
+                Parallelism: 2,
+                SaltLength:  16,
+                KeyLength:   32,
+            },
+        },
+        DeletedUserGracePeriod:     30 * 24 * time.Hour,
+        DeletedUserCleanupInterval: time.Hour,
+        MigrateOnStart:             true,
+        DataJobPollInterval:        5 * time.Second,
+        DataExportRetention:        7 * 24 * time.Hour,
     }
-} 
\ No newline at end of file
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// WriterMailer вместо отправки записывает письма в io.Writer.
+// Используется при локальной разработке и в тестах.
+type WriterMailer struct {
+	from string
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterMailer создает отправителя, пишущего письма в w
+func NewWriterMailer(w io.Writer, from string) *WriterMailer {
+	return &WriterMailer{w: w, from: from}
+}
+
+// NewFileMailer создает отправителя, дописывающего письма в файл path
+func NewFileMailer(path, from string) (*WriterMailer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mail file: %v", err)
+	}
+	return NewWriterMailer(f, from), nil
+}
+
+// NewLogMailer создает отправителя, выводящего письма в лог сервиса
+func NewLogMailer(from string) *WriterMailer {
+	return NewWriterMailer(log.Writer(), from)
+}
+
+func (m *WriterMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := fmt.Fprintf(m.w, "%s\r\n", format(m.from, msg)); err != nil {
+		return fmt.Errorf("failed to write mail to %s: %v", msg.To, err)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// testFS - три миграции; у второй нет down файла
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"001_create_users.up.sql":   {Data: []byte("CREATE TABLE users ();")},
+		"001_create_users.down.sql": {Data: []byte("DROP TABLE users;")},
+		"002_add_index.up.sql":      {Data: []byte("CREATE INDEX idx ON users(id);")},
+		"003_add_column.up.sql":     {Data: []byte("ALTER TABLE users ADD COLUMN x INT;")},
+		"003_add_column.down.sql":   {Data: []byte("ALTER TABLE users DROP COLUMN x;")},
+		"README.md":                 {Data: []byte("not a migration")},
+	}
+}
+
+func setupTest(t *testing.T) (*Runner, sqlmock.Sqlmock) {
+	db, dbMock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	runner, err := New(db, testFS())
+	assert.NoError(t, err)
+	return runner, dbMock
+}
+
+// expectLock ожидает блокировку и создание schema_migrations, затем чтение
+// примененных миграций с версиями и контрольными суммами applied
+func expectLock(dbMock sqlmock.Sqlmock, applied map[int64]string) {
+	dbMock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rows := sqlmock.NewRows([]string{"version", "name", "checksum", "applied_at"})
+	for _, version := range []int64{1, 2, 3, 4} {
+		if sum, ok := applied[version]; ok {
+			rows.AddRow(version, "migration", sum, time.Now())
+		}
+	}
+	dbMock.ExpectQuery(`SELECT version, name, checksum, applied_at FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func expectUnlock(dbMock sqlmock.Sqlmock) {
+	dbMock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectApply(dbMock sqlmock.Sqlmock, version int64, name, up string) {
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(regexp.QuoteMeta(up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec(`INSERT INTO schema_migrations \(version, name, checksum\) VALUES \(\$1, \$2, \$3\)`).
+		WithArgs(version, name, checksum(up)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+}
+
+func expectRollback(dbMock sqlmock.Sqlmock, version int64, down string) {
+	dbMock.ExpectBegin()
+	dbMock.ExpectExec(regexp.QuoteMeta(down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	dbMock.ExpectExec(`DELETE FROM schema_migrations WHERE version = \$1`).
+		WithArgs(version).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	dbMock.ExpectCommit()
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("migrations are sorted with checksums", func(t *testing.T) {
+		migrations, err := load(testFS())
+		assert.NoError(t, err)
+		if !assert.Len(t, migrations, 3) {
+			return
+		}
+
+		assert.Equal(t, []int64{1, 2, 3}, []int64{migrations[0].Version, migrations[1].Version, migrations[2].Version})
+		assert.Equal(t, "create_users", migrations[0].Name)
+		assert.Equal(t, "DROP TABLE users;", migrations[0].Down)
+		assert.Empty(t, migrations[1].Down)
+		// Контрольная сумма считается только от up файла
+		assert.Equal(t, checksum("CREATE TABLE users ();"), migrations[0].Checksum)
+	})
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		err   string
+	}{
+		{
+			name:  "no up file",
+			files: fstest.MapFS{"001_create_users.down.sql": {Data: []byte("DROP TABLE users;")}},
+			err:   "migration 1_create_users has no up file",
+		},
+		{
+			name: "conflicting names",
+			files: fstest.MapFS{
+				"001_create_users.up.sql": {Data: []byte("CREATE TABLE users ();")},
+				"001_create_roles.up.sql": {Data: []byte("CREATE TABLE roles ();")},
+			},
+			err: `migration 1 has conflicting names`,
+		},
+		{
+			name:  "zero version",
+			files: fstest.MapFS{"000_init.up.sql": {Data: []byte("SELECT 1;")}},
+			err:   "invalid migration version in 000_init.up.sql",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.files)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+}
+
+func TestRunner_Up(t *testing.T) {
+	migrations, err := load(testFS())
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		applied map[int64]string
+		expect  func(dbMock sqlmock.Sqlmock)
+		count   int
+		err     string
+	}{
+		{
+			name:    "empty database",
+			applied: nil,
+			expect: func(dbMock sqlmock.Sqlmock) {
+				for _, m := range migrations {
+					expectApply(dbMock, m.Version, m.Name, m.Up)
+				}
+			},
+			count: 3,
+		},
+		{
+			name:    "only pending migrations are applied",
+			applied: map[int64]string{1: migrations[0].Checksum, 2: migrations[1].Checksum},
+			expect: func(dbMock sqlmock.Sqlmock) {
+				expectApply(dbMock, 3, "add_column", migrations[2].Up)
+			},
+			count: 1,
+		},
+		{
+			name:    "applied migration was modified",
+			applied: map[int64]string{1: migrations[0].Checksum, 2: checksum("CREATE INDEX old ON users(id);")},
+			expect:  func(dbMock sqlmock.Sqlmock) {},
+			err:     "migration 2_add_index was modified after it had been applied",
+		},
+		{
+			// Базу обновила более новая версия сервиса
+			name: "unknown applied version is tolerated",
+			applied: map[int64]string{
+				1: migrations[0].Checksum, 2: migrations[1].Checksum, 3: migrations[2].Checksum,
+				4: checksum("ALTER TABLE users ADD COLUMN y INT;"),
+			},
+			expect: func(dbMock sqlmock.Sqlmock) {},
+			count:  0,
+		},
+		{
+			name:    "failed migration is rolled back",
+			applied: map[int64]string{1: migrations[0].Checksum, 2: migrations[1].Checksum},
+			expect: func(dbMock sqlmock.Sqlmock) {
+				dbMock.ExpectBegin()
+				dbMock.ExpectExec(regexp.QuoteMeta(migrations[2].Up)).WillReturnError(assert.AnError)
+				dbMock.ExpectRollback()
+			},
+			err: "failed to apply migration 3_add_column",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, dbMock := setupTest(t)
+			expectLock(dbMock, tt.applied)
+			tt.expect(dbMock)
+			expectUnlock(dbMock)
+
+			count, err := runner.Up(context.Background())
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.err)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.count, count)
+			assert.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRunner_Down(t *testing.T) {
+	migrations, err := load(testFS())
+	assert.NoError(t, err)
+	all := map[int64]string{1: migrations[0].Checksum, 2: migrations[1].Checksum, 3: migrations[2].Checksum}
+
+	tests := []struct {
+		name    string
+		applied map[int64]string
+		steps   int
+		expect  func(dbMock sqlmock.Sqlmock)
+		count   int
+		err     string
+	}{
+		{
+			name:    "last migration",
+			applied: all,
+			steps:   1,
+			expect: func(dbMock sqlmock.Sqlmock) {
+				expectRollback(dbMock, 3, migrations[2].Down)
+			},
+			count: 1,
+		},
+		{
+			name:    "not applied migrations are skipped",
+			applied: map[int64]string{1: migrations[0].Checksum},
+			steps:   1,
+			expect: func(dbMock sqlmock.Sqlmock) {
+				expectRollback(dbMock, 1, migrations[0].Down)
+			},
+			count: 1,
+		},
+		{
+			name:    "migration without down file stops rollback",
+			applied: all,
+			steps:   3,
+			expect: func(dbMock sqlmock.Sqlmock) {
+				expectRollback(dbMock, 3, migrations[2].Down)
+			},
+			count: 1,
+			err:   "migration 2_add_index cannot be rolled back: no down file",
+		},
+		{
+			name:    "more steps than applied",
+			applied: map[int64]string{1: migrations[0].Checksum},
+			steps:   5,
+			expect: func(dbMock sqlmock.Sqlmock) {
+				expectRollback(dbMock, 1, migrations[0].Down)
+			},
+			count: 1,
+		},
+		{
+			name:    "applied migration was modified",
+			applied: map[int64]string{1: checksum("CREATE TABLE users (id INT);")},
+			steps:   1,
+			expect:  func(dbMock sqlmock.Sqlmock) {},
+			err:     "migration 1_create_users was modified after it had been applied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, dbMock := setupTest(t)
+			expectLock(dbMock, tt.applied)
+			tt.expect(dbMock)
+			expectUnlock(dbMock)
+
+			count, err := runner.Down(context.Background(), tt.steps)
+			if tt.err != "" {
+				if assert.Error(t, err) {
+					assert.Contains(t, err.Error(), tt.err)
+				}
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.count, count)
+			assert.NoError(t, dbMock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRunner_Status(t *testing.T) {
+	migrations, err := load(testFS())
+	assert.NoError(t, err)
+
+	runner, dbMock := setupTest(t)
+	expectLock(dbMock, map[int64]string{1: migrations[0].Checksum})
+	expectUnlock(dbMock)
+
+	statuses, err := runner.Status(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+	if assert.Len(t, statuses, 3) {
+		assert.True(t, statuses[0].Applied)
+		assert.False(t, statuses[0].AppliedAt.IsZero())
+		assert.False(t, statuses[1].Applied)
+		assert.False(t, statuses[2].Applied)
+	}
+}
@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// Состояния задачи выгрузки или стирания данных
+const (
+	DataJobPending   = "pending"
+	DataJobRunning   = "running"
+	DataJobSucceeded = "succeeded"
+	DataJobFailed    = "failed"
+)
+
+// DataJob - задача выгрузки или стирания данных пользователя
+type DataJob struct {
+	ID          string
+	Type        string
+	UserID      string
+	RequestedBy string
+	Status      string
+	Attempts    int
+	Error       string
+	CreatedAt   time.Time
+	FinishedAt  *time.Time
+	ExpiresAt   *time.Time
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Identity - учетная запись внешнего провайдера, привязанная к пользователю
+type Identity struct {
+	Provider string
+	Subject  string
+	UserID   string
+	// Email - адрес, который провайдер сообщил при привязке
+	Email     string
+	CreatedAt time.Time
+}
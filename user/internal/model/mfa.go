@@ -0,0 +1,10 @@
+package model
+
+// MFA - второй фактор пользователя
+type MFA struct {
+	// SealedSecret - секрет TOTP, зашифрованный secretbox
+	SealedSecret []byte
+	Confirmed    bool
+	// LastUsedStep - шаг последнего принятого кода; коды этого и прошлых шагов не принимаются
+	LastUsedStep int64
+}
@@ -1,10 +1,64 @@
 package model
 
-import "time"
+import (
+    "database/sql/driver"
+    "encoding/json"
+    "fmt"
+    "time"
+)
 
 type User struct {
-    ID        string    `json:"id" db:"id"`
-    Email     string    `json:"email" db:"email"`
-    Password  string    `json:"-" db:"password_hash"`
-    CreatedAt time.Time `json:"created_at" db:"created_at"`
-} 
\ No newline at end of file
+    ID            string    `json:"id" db:"id"`
+    Email         string    `json:"email" db:"email"`
+    Password      string    `json:"-" db:"password_hash"`
+    Role          string    `json:"role" db:"role"`
+    EmailVerified bool      `json:"email_verified" db:"email_verified"`
+    MFAEnabled    bool      `json:"mfa_enabled" db:"mfa_enabled"`
+    CreatedAt     time.Time `json:"created_at" db:"created_at"`
+
+    DisplayName string    `json:"display_name" db:"display_name"`
+    Phone       string    `json:"phone" db:"phone"`
+    Locale      string    `json:"locale" db:"locale"`
+    Timezone    string    `json:"timezone" db:"timezone"`
+    AvatarURL   string    `json:"avatar_url" db:"avatar_url"`
+    HomeAddress *Address  `json:"home_address,omitempty" db:"home_address"`
+    UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+    // Version растет при каждом изменении профиля и защищает от потерянных обновлений
+    Version int64 `json:"version" db:"version"`
+}
+
+// Address - адрес из результатов поиска geo сервиса. Хранится в JSONB.
+type Address struct {
+    City   string `json:"city"`
+    Street string `json:"street"`
+    House  string `json:"house"`
+    Lat    string `json:"lat"`
+    Lon    string `json:"lon"`
+}
+
+// Value сохраняет адрес как JSON. Строка, а не []byte: lib/pq передает
+// []byte как bytea, и Postgres не принял бы такое значение для JSONB.
+func (a *Address) Value() (driver.Value, error) {
+    if a == nil {
+        return nil, nil
+    }
+    data, err := json.Marshal(a)
+    if err != nil {
+        return nil, err
+    }
+    return string(data), nil
+}
+
+// Scan читает адрес из JSON
+func (a *Address) Scan(src interface{}) error {
+    var data []byte
+    switch v := src.(type) {
+    case []byte:
+        data = v
+    case string:
+        data = []byte(v)
+    default:
+        return fmt.Errorf("cannot scan %T into Address", src)
+    }
+    return json.Unmarshal(data, a)
+}
@@ -0,0 +1,70 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Message - событие, полученное из брокера. Offset - его положение в потоке:
+// подтвержденное смещение группы сохраняется брокером, и после перезапуска
+// группа продолжает чтение с неподтвержденных событий.
+type Message struct {
+	Offset string
+	Event  Event
+}
+
+// Broker доставляет события группам потребителей. Каждая группа получает
+// все события в порядке публикации; событие, которое не подтвердили через
+// Ack, доставляется группе снова.
+type Broker interface {
+	Publish(ctx context.Context, event Event) error
+	// Fetch возвращает до count неподтвержденных событий группы. Если их нет,
+	// брокер может ждать новых событий, но не дольше, чем до отмены ctx.
+	Fetch(ctx context.Context, group, consumer string, count int) ([]Message, error)
+	// Ack подтверждает обработку событий группой
+	Ack(ctx context.Context, group string, offsets ...string) error
+}
+
+// Handler обрабатывает событие. Ошибка оставляет событие неподтвержденным.
+type Handler func(ctx context.Context, event Event) error
+
+// Consume читает события группы и передает их handler, пока не будет отменен ctx.
+// Обработанные события подтверждаются пачкой; после ошибки handler оставшиеся
+// события пачки не обрабатываются и будут доставлены снова.
+func Consume(ctx context.Context, broker Broker, group, consumer string, handler Handler) error {
+	const (
+		batchSize = 100
+		// retryDelay - пауза после ошибки или пустой выборки
+		retryDelay = time.Second
+	)
+
+	for ctx.Err() == nil {
+		messages, err := broker.Fetch(ctx, group, consumer, batchSize)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to fetch events for %s: %v", group, err)
+		}
+
+		var done []string
+		for _, msg := range messages {
+			if err := handler(ctx, msg.Event); err != nil {
+				log.Printf("Failed to handle event %s (%s) in %s: %v", msg.Event.ID, msg.Event.Type, group, err)
+				break
+			}
+			done = append(done, msg.Offset)
+		}
+		if len(done) > 0 {
+			if err := broker.Ack(ctx, group, done...); err != nil {
+				log.Printf("Failed to ack events for %s: %v", group, err)
+			}
+		}
+
+		if len(done) == 0 || len(done) < len(messages) {
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+	return ctx.Err()
+}
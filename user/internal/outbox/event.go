@@ -0,0 +1,105 @@
+// Package outbox публикует события жизненного цикла пользователей. Событие
+// записывается в таблицу user_outbox той же транзакцией, что и изменение,
+// а Relay переносит записанные события в брокер. Поэтому событие не теряется
+// и не появляется без изменения, но может быть доставлено повторно:
+// потребители должны пропускать уже обработанные ID.
+//
+// События одного пользователя публикуются в порядке изменений: событие
+// пишется после изменения строки пользователя, и следующее изменение ждет
+// фиксации предыдущего. Порядок событий разных пользователей не
+// гарантирован: id в user_outbox выдается при вставке, а транзакции
+// фиксируются в другом порядке, поэтому событие с меньшим id может быть
+// опубликовано позже. Потребители не должны на него опираться.
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Типы событий
+const (
+	TypeUserCreated      = "user.created"
+	TypeUserEmailChanged = "user.email_changed"
+	TypeUserDeleted      = "user.deleted"
+	TypeUserErased       = "user.erased"
+)
+
+// Event - событие о пользователе. Payload - JSON структуры, соответствующей Type.
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	UserID    string          `json:"user_id"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// UserCreated - содержимое user.created
+type UserCreated struct {
+	Email         string `json:"email"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// UserEmailChanged - содержимое user.email_changed
+type UserEmailChanged struct {
+	OldEmail string `json:"old_email"`
+	NewEmail string `json:"new_email"`
+}
+
+// UserDeleted - содержимое user.deleted
+type UserDeleted struct{}
+
+// UserErased - содержимое user.erased. Получив его, потребитель должен
+// удалить все, что хранит о пользователе.
+type UserErased struct{}
+
+// NewEvent создает событие с новым ID
+func NewEvent(eventType, userID string, payload interface{}) (Event, error) {
+	id, err := newID()
+	if err != nil {
+		return Event{}, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to encode %s payload: %v", eventType, err)
+	}
+	return Event{
+		ID:        id,
+		Type:      eventType,
+		UserID:    userID,
+		Payload:   data,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// Write записывает событие в outbox в транзакции tx. Событие будет
+// опубликовано, только если tx зафиксируется.
+func Write(ctx context.Context, tx *sql.Tx, eventType, userID string, payload interface{}) error {
+	event, err := NewEvent(eventType, userID, payload)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO user_outbox (event_id, type, user_id, payload, created_at)
+         VALUES ($1, $2, $3, $4, $5)`,
+		event.ID, event.Type, event.UserID, string(event.Payload), event.CreatedAt); err != nil {
+		return fmt.Errorf("failed to write %s event: %v", eventType, err)
+	}
+	return nil
+}
+
+// newID создает случайный UUID версии 4
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate event ID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,65 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// MemoryBroker хранит события в памяти процесса, для тестов и запуска без
+// Redis. Смещение группы - номер первого неподтвержденного события: Ack
+// подтверждает и все события до указанного.
+type MemoryBroker struct {
+	mu      sync.Mutex
+	events  []Event
+	offsets map[string]int
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{offsets: make(map[string]int)}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, event Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.events = append(b.events, event)
+	return nil
+}
+
+// Fetch не ждет новых событий: если их нет, сразу возвращает пустой список
+func (b *MemoryBroker) Fetch(ctx context.Context, group, consumer string, count int) ([]Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var messages []Message
+	for i := b.offsets[group]; i < len(b.events) && len(messages) < count; i++ {
+		messages = append(messages, Message{Offset: strconv.Itoa(i + 1), Event: b.events[i]})
+	}
+	return messages, nil
+}
+
+func (b *MemoryBroker) Ack(ctx context.Context, group string, offsets ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, offset := range offsets {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 1 || n > len(b.events) {
+			return fmt.Errorf("invalid offset %q", offset)
+		}
+		if n > b.offsets[group] {
+			b.offsets[group] = n
+		}
+	}
+	return nil
+}
+
+// Events возвращает все опубликованные события
+func (b *MemoryBroker) Events() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]Event(nil), b.events...)
+}
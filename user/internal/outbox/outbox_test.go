@@ -0,0 +1,270 @@
+package outbox
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingBroker отказывает в публикации события failID
+type failingBroker struct {
+	*MemoryBroker
+	failID string
+}
+
+func (b *failingBroker) Publish(ctx context.Context, event Event) error {
+	if event.ID == b.failID {
+		return errors.New("broker is down")
+	}
+	return b.MemoryBroker.Publish(ctx, event)
+}
+
+func outboxRows(ids ...int64) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "event_id", "type", "user_id", "payload", "created_at"})
+	for _, id := range ids {
+		rows.AddRow(id, fmt.Sprintf("event-%d", id), TypeUserCreated, "123", `{"email":"test@example.com"}`, time.Now())
+	}
+	return rows
+}
+
+func TestRelay_PublishPending(t *testing.T) {
+	setup := func(t *testing.T, broker Broker) (*Relay, sqlmock.Sqlmock) {
+		db, dbMock, err := sqlmock.New()
+		assert.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return NewRelay(db, broker, 10, time.Second, time.Hour), dbMock
+	}
+	expectLock := func(dbMock sqlmock.Sqlmock, locked bool) {
+		dbMock.ExpectBegin()
+		dbMock.ExpectQuery(`SELECT pg_try_advisory_xact_lock`).
+			WithArgs(relayLockID).
+			WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(locked))
+	}
+
+	t.Run("events are published by id and marked", func(t *testing.T) {
+		broker := NewMemoryBroker()
+		relay, dbMock := setup(t, broker)
+
+		expectLock(dbMock, true)
+		dbMock.ExpectQuery(`SELECT (.+) FROM user_outbox WHERE published_at IS NULL ORDER BY id LIMIT \$1`).
+			WithArgs(10).
+			WillReturnRows(outboxRows(1, 2))
+		dbMock.ExpectExec(`UPDATE user_outbox SET published_at = NOW\(\) WHERE id = ANY\(\$1\)`).
+			WithArgs(pq.Array([]int64{1, 2})).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		dbMock.ExpectCommit()
+
+		n, err := relay.PublishPending(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+
+		events := broker.Events()
+		if assert.Len(t, events, 2) {
+			assert.Equal(t, "event-1", events[0].ID)
+			assert.Equal(t, "event-2", events[1].ID)
+			assert.JSONEq(t, `{"email":"test@example.com"}`, string(events[0].Payload))
+		}
+	})
+
+	t.Run("lock is held by another replica", func(t *testing.T) {
+		broker := NewMemoryBroker()
+		relay, dbMock := setup(t, broker)
+
+		expectLock(dbMock, false)
+		dbMock.ExpectRollback()
+
+		n, err := relay.PublishPending(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+		assert.Empty(t, broker.Events())
+	})
+
+	t.Run("only events before publish error are marked", func(t *testing.T) {
+		broker := &failingBroker{MemoryBroker: NewMemoryBroker(), failID: "event-2"}
+		relay, dbMock := setup(t, broker)
+
+		expectLock(dbMock, true)
+		dbMock.ExpectQuery(`SELECT (.+) FROM user_outbox`).
+			WillReturnRows(outboxRows(1, 2, 3))
+		dbMock.ExpectExec(`UPDATE user_outbox SET published_at`).
+			WithArgs(pq.Array([]int64{1})).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		dbMock.ExpectCommit()
+
+		n, err := relay.PublishPending(context.Background())
+		assert.EqualError(t, err, "broker is down")
+		assert.Equal(t, 1, n)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+		assert.Len(t, broker.Events(), 1)
+	})
+
+	t.Run("nothing is marked if first publish fails", func(t *testing.T) {
+		broker := &failingBroker{MemoryBroker: NewMemoryBroker(), failID: "event-1"}
+		relay, dbMock := setup(t, broker)
+
+		expectLock(dbMock, true)
+		dbMock.ExpectQuery(`SELECT (.+) FROM user_outbox`).
+			WillReturnRows(outboxRows(1))
+		dbMock.ExpectRollback()
+
+		n, err := relay.PublishPending(context.Background())
+		assert.EqualError(t, err, "broker is down")
+		assert.Equal(t, 0, n)
+		assert.NoError(t, dbMock.ExpectationsWereMet())
+	})
+}
+
+func TestRelay_Cleanup(t *testing.T) {
+	db, dbMock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	relay := NewRelay(db, NewMemoryBroker(), 10, time.Second, time.Hour)
+	dbMock.ExpectExec(`DELETE FROM user_outbox WHERE published_at < \$1`).
+		WithArgs(near(time.Now().Add(-time.Hour))).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	assert.NoError(t, relay.Cleanup(context.Background()))
+	assert.NoError(t, dbMock.ExpectationsWereMet())
+}
+
+// near совпадает с временем, отличающимся от заданного не больше чем на минуту
+type near time.Time
+
+func (n near) Match(v driver.Value) bool {
+	ts, ok := v.(time.Time)
+	d := ts.Sub(time.Time(n))
+	return ok && d > -time.Minute && d < time.Minute
+}
+
+func TestMemoryBroker(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryBroker()
+	for _, id := range []string{"e1", "e2", "e3"} {
+		assert.NoError(t, broker.Publish(ctx, Event{ID: id, Type: TypeUserCreated}))
+	}
+
+	t.Run("unacked events are fetched again", func(t *testing.T) {
+		messages, err := broker.Fetch(ctx, "mailer", "c1", 2)
+		assert.NoError(t, err)
+		if assert.Len(t, messages, 2) {
+			assert.Equal(t, "e1", messages[0].Event.ID)
+			assert.Equal(t, "e2", messages[1].Event.ID)
+		}
+
+		again, err := broker.Fetch(ctx, "mailer", "c1", 2)
+		assert.NoError(t, err)
+		assert.Equal(t, messages, again)
+	})
+
+	t.Run("ack moves group offset", func(t *testing.T) {
+		messages, err := broker.Fetch(ctx, "mailer", "c1", 10)
+		assert.NoError(t, err)
+		assert.NoError(t, broker.Ack(ctx, "mailer", messages[1].Offset))
+
+		// Подтверждение события подтверждает и предыдущие
+		messages, err = broker.Fetch(ctx, "mailer", "c1", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, messages, 1) {
+			assert.Equal(t, "e3", messages[0].Event.ID)
+		}
+	})
+
+	t.Run("groups are independent", func(t *testing.T) {
+		messages, err := broker.Fetch(ctx, "geo", "c1", 10)
+		assert.NoError(t, err)
+		assert.Len(t, messages, 3)
+	})
+
+	t.Run("invalid offset", func(t *testing.T) {
+		assert.Error(t, broker.Ack(ctx, "mailer", "4"))
+		assert.Error(t, broker.Ack(ctx, "mailer", "x"))
+	})
+}
+
+func TestRedisBroker(t *testing.T) {
+	ctx := context.Background()
+	redisClient := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { redisClient.Close() })
+	broker := NewRedisBroker(redisClient, "user-events", 1000, 10*time.Millisecond)
+
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 123, time.UTC)
+	first := Event{ID: "e1", Type: TypeUserCreated, UserID: "123", Payload: []byte(`{"email":"test@example.com"}`), CreatedAt: createdAt}
+	assert.NoError(t, broker.Publish(ctx, first))
+	assert.NoError(t, broker.Publish(ctx, Event{ID: "e2", Type: TypeUserDeleted, UserID: "123", Payload: []byte(`{}`), CreatedAt: createdAt}))
+
+	var messages []Message
+
+	t.Run("new group reads stream from start", func(t *testing.T) {
+		var err error
+		messages, err = broker.Fetch(ctx, "mailer", "c1", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, messages, 2) {
+			assert.Equal(t, first, messages[0].Event)
+			assert.Equal(t, "e2", messages[1].Event.ID)
+		}
+	})
+
+	t.Run("pending events are fetched again", func(t *testing.T) {
+		again, err := broker.Fetch(ctx, "mailer", "c1", 10)
+		assert.NoError(t, err)
+		assert.Equal(t, messages, again)
+	})
+
+	t.Run("acked events are not fetched", func(t *testing.T) {
+		assert.NoError(t, broker.Ack(ctx, "mailer", messages[0].Offset))
+
+		pending, err := broker.Fetch(ctx, "mailer", "c1", 10)
+		assert.NoError(t, err)
+		if assert.Len(t, pending, 1) {
+			assert.Equal(t, "e2", pending[0].Event.ID)
+		}
+
+		assert.NoError(t, broker.Ack(ctx, "mailer", pending[0].Offset))
+		pending, err = broker.Fetch(ctx, "mailer", "c1", 10)
+		assert.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("invalid payload is dropped", func(t *testing.T) {
+		event := decodeEvent(map[string]interface{}{"id": "e3", "payload": "{"})
+		assert.Equal(t, "e3", event.ID)
+		assert.Nil(t, event.Payload)
+	})
+}
+
+func TestConsume(t *testing.T) {
+	broker := NewMemoryBroker()
+	for _, id := range []string{"e1", "e2"} {
+		assert.NoError(t, broker.Publish(context.Background(), Event{ID: id}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var handled []string
+	err := Consume(ctx, broker, "mailer", "c1", func(ctx context.Context, event Event) error {
+		handled = append(handled, event.ID)
+		if len(handled) == 2 {
+			cancel()
+		}
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, []string{"e1", "e2"}, handled)
+
+	messages, err := broker.Fetch(context.Background(), "mailer", "c1", 10)
+	assert.NoError(t, err)
+	assert.Empty(t, messages)
+}
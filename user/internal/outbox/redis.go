@@ -0,0 +1,134 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBroker хранит события в Redis Stream. Смещения групп ведет сам Redis:
+// группа потребителей помнит последнее выданное событие, а выданные, но не
+// подтвержденные события остаются в ее списке ожидания (PEL) до XACK.
+type RedisBroker struct {
+	redisClient *redis.Client
+	stream      string
+	// maxLen - примерная длина потока, старые события вытесняются
+	maxLen int64
+	// block - сколько Fetch ждет новых событий
+	block time.Duration
+
+	// groups - группы, уже созданные этим процессом
+	groups sync.Map
+}
+
+// NewRedisBroker создает брокер поверх потока stream
+func NewRedisBroker(redisClient *redis.Client, stream string, maxLen int64, block time.Duration) *RedisBroker {
+	return &RedisBroker{
+		redisClient: redisClient,
+		stream:      stream,
+		maxLen:      maxLen,
+		block:       block,
+	}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, event Event) error {
+	err := b.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.stream,
+		MaxLen: b.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"id":         event.ID,
+			"type":       event.Type,
+			"user_id":    event.UserID,
+			"payload":    string(event.Payload),
+			"created_at": event.CreatedAt.Format(time.RFC3339Nano),
+		},
+	}).Err()
+	if err != nil {
+		return fmt.Errorf("failed to publish event %s: %v", event.ID, err)
+	}
+	return nil
+}
+
+// Fetch сначала возвращает события, выданные consumer раньше, но не
+// подтвержденные, и только потом новые
+func (b *RedisBroker) Fetch(ctx context.Context, group, consumer string, count int) ([]Message, error) {
+	if err := b.ensureGroup(ctx, group); err != nil {
+		return nil, err
+	}
+
+	messages, err := b.read(ctx, group, consumer, "0", count, -1)
+	if err != nil || len(messages) > 0 {
+		return messages, err
+	}
+	return b.read(ctx, group, consumer, ">", count, b.block)
+}
+
+func (b *RedisBroker) read(ctx context.Context, group, consumer, start string, count int, block time.Duration) ([]Message, error) {
+	streams, err := b.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{b.stream, start},
+		Count:    int64(count),
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read events: %v", err)
+	}
+
+	var messages []Message
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			messages = append(messages, Message{Offset: msg.ID, Event: decodeEvent(msg.Values)})
+		}
+	}
+	return messages, nil
+}
+
+func (b *RedisBroker) Ack(ctx context.Context, group string, offsets ...string) error {
+	if err := b.redisClient.XAck(ctx, b.stream, group, offsets...).Err(); err != nil {
+		return fmt.Errorf("failed to ack events: %v", err)
+	}
+	return nil
+}
+
+// ensureGroup создает группу, читающую поток с начала: новая группа
+// получает и события, опубликованные до ее появления
+func (b *RedisBroker) ensureGroup(ctx context.Context, group string) error {
+	if _, ok := b.groups.Load(group); ok {
+		return nil
+	}
+	err := b.redisClient.XGroupCreateMkStream(ctx, b.stream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group %s: %v", group, err)
+	}
+	b.groups.Store(group, true)
+	return nil
+}
+
+func decodeEvent(values map[string]interface{}) Event {
+	field := func(name string) string {
+		s, _ := values[name].(string)
+		return s
+	}
+	createdAt, _ := time.Parse(time.RFC3339Nano, field("created_at"))
+	payload := json.RawMessage(field("payload"))
+	if !json.Valid(payload) {
+		payload = nil
+	}
+	return Event{
+		ID:        field("id"),
+		Type:      field("type"),
+		UserID:    field("user_id"),
+		Payload:   payload,
+		CreatedAt: createdAt,
+	}
+}
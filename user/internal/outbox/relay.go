@@ -0,0 +1,151 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// relayLockID - ключ advisory lock релея: события публикует одна реплика
+// за раз, иначе реплики публиковали бы одни и те же события и путали порядок
+// событий одного пользователя
+const relayLockID int64 = 0x757365726f757462 // "useroutb"
+
+// Relay переносит события из user_outbox в брокер
+type Relay struct {
+	db        *sql.DB
+	broker    Broker
+	batchSize int
+	interval  time.Duration
+	// retention - сколько опубликованные события хранятся в user_outbox
+	retention time.Duration
+}
+
+func NewRelay(db *sql.DB, broker Broker, batchSize int, interval, retention time.Duration) *Relay {
+	return &Relay{
+		db:        db,
+		broker:    broker,
+		batchSize: batchSize,
+		interval:  interval,
+		retention: retention,
+	}
+}
+
+// Run публикует новые события каждые interval, пока не будет отменен ctx
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Выбираем пачки, пока события не кончатся
+		for {
+			n, err := r.PublishPending(ctx)
+			if err != nil {
+				log.Printf("Failed to relay outbox events: %v", err)
+				break
+			}
+			if n < r.batchSize {
+				break
+			}
+		}
+
+		if err := r.Cleanup(ctx); err != nil {
+			log.Printf("Failed to clean up outbox: %v", err)
+		}
+	}
+}
+
+// PublishPending публикует до batchSize неопубликованных событий по
+// возрастанию id и возвращает их число. Событие помечается опубликованным
+// только после Publish, поэтому сбой между ними приведет к повторной
+// публикации, но не к потере.
+//
+// Порядок id совпадает с порядком фиксации только для событий одного
+// пользователя: событие незафиксированной транзакции с меньшим id будет
+// опубликовано в следующий раз, после уже выбранных, см. описание пакета.
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Блокировка снимается вместе с транзакцией. Пока ее держит другая реплика,
+	// эта пропускает ход.
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, relayLockID).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("failed to lock outbox: %v", err)
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, event_id, type, user_id, payload, created_at FROM user_outbox
+         WHERE published_at IS NULL
+         ORDER BY id LIMIT $1`,
+		r.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %v", err)
+	}
+
+	var ids []int64
+	var events []Event
+	for rows.Next() {
+		var id int64
+		var event Event
+		var payload string
+		if err := rows.Scan(&id, &event.ID, &event.Type, &event.UserID, &payload, &event.CreatedAt); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan outbox event: %v", err)
+		}
+		event.Payload = []byte(payload)
+		ids = append(ids, id)
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %v", err)
+	}
+
+	// При ошибке публикации отмечаются события до нее, остальные повторятся в следующий раз
+	published := 0
+	var publishErr error
+	for _, event := range events {
+		if publishErr = r.broker.Publish(ctx, event); publishErr != nil {
+			break
+		}
+		published++
+	}
+	if published == 0 {
+		return 0, publishErr
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE user_outbox SET published_at = NOW() WHERE id = ANY($1)`,
+		pq.Array(ids[:published])); err != nil {
+		return 0, fmt.Errorf("failed to mark events published: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox: %v", err)
+	}
+	return published, publishErr
+}
+
+// Cleanup удаляет события, опубликованные раньше срока хранения
+func (r *Relay) Cleanup(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM user_outbox WHERE published_at < $1`,
+		time.Now().Add(-r.retention))
+	return err
+}
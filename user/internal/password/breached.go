@@ -0,0 +1,97 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// prefixLength - длина префикса SHA-1, по которому запрашивается диапазон
+const prefixLength = 5
+
+// RangeSource отдает утекшие пароли по первым пяти символам их SHA-1, как
+// range API Pwned Passwords: ни пароль, ни его полный хэш источнику не передаются.
+// Результат - суффиксы хэшей в верхнем регистре и сколько раз пароль встречался в утечках.
+type RangeSource interface {
+	Range(ctx context.Context, prefix string) (map[string]int, error)
+}
+
+// Breached возвращает, сколько раз пароль встречался в утечках; 0 - не встречался
+func Breached(ctx context.Context, source RangeSource, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := source.Range(ctx, hash[:prefixLength])
+	if err != nil {
+		return 0, err
+	}
+	return suffixes[hash[prefixLength:]], nil
+}
+
+// Corpus - набор утекших паролей, загруженный в память и разбитый по префиксам
+type Corpus struct {
+	ranges map[string]map[string]int
+}
+
+// LoadCorpus читает файл в формате выгрузки Pwned Passwords: по строке
+// "SHA1:COUNT" на пароль, хэш в шестнадцатеричном виде. Счетчик можно
+// опустить, пустые строки и строки, начинающиеся с #, пропускаются.
+func LoadCorpus(path string) (*Corpus, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password corpus: %v", err)
+	}
+	defer f.Close()
+
+	corpus := &Corpus{ranges: make(map[string]map[string]int)}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, countText, hasCount := strings.Cut(text, ":")
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-1 hash", path, line)
+		}
+		count := 1
+		if hasCount {
+			count, err = strconv.Atoi(countText)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("%s:%d: invalid count", path, line)
+			}
+		}
+
+		hash = strings.ToUpper(hash)
+		prefix := hash[:prefixLength]
+		if corpus.ranges[prefix] == nil {
+			corpus.ranges[prefix] = make(map[string]int)
+		}
+		corpus.ranges[prefix][hash[prefixLength:]] = count
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password corpus: %v", err)
+	}
+
+	return corpus, nil
+}
+
+// Range возвращает суффиксы хэшей с префиксом prefix
+func (c *Corpus) Range(ctx context.Context, prefix string) (map[string]int, error) {
+	return c.ranges[strings.ToUpper(prefix)], nil
+}
+
+// Len возвращает число паролей в наборе
+func (c *Corpus) Len() int {
+	n := 0
+	for _, suffixes := range c.ranges {
+		n += len(suffixes)
+	}
+	return n
+}
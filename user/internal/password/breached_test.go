@@ -0,0 +1,98 @@
+package password
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SHA-1 паролей "password" и "123456"
+const (
+	passwordSHA1 = "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"
+	numbersSHA1  = "7C4A8D09CA3762AF61E59520943DC26494F8941B"
+)
+
+func writeCorpus(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadCorpus(t *testing.T) {
+	t.Run("valid corpus", func(t *testing.T) {
+		// Хэш в нижнем регистре, без счетчика, комментарии и пустые строки
+		corpus, err := LoadCorpus(writeCorpus(t, "# top passwords\n"+passwordSHA1+":3861493\n\n7c4a8d09ca3762af61e59520943dc26494f8941b\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, corpus.Len())
+
+		ctx := context.Background()
+		count, err := Breached(ctx, corpus, "password")
+		assert.NoError(t, err)
+		assert.Equal(t, 3861493, count)
+
+		count, err = Breached(ctx, corpus, "123456")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		count, err = Breached(ctx, corpus, "Correct-horse1")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+
+	tests := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{name: "short hash", content: "5BAA61E4:1\n", err: ":1: invalid SHA-1 hash"},
+		{name: "not hex", content: "# header\nZBAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n", err: ":2: invalid SHA-1 hash"},
+		{name: "invalid count", content: passwordSHA1 + ":many\n", err: ":1: invalid count"},
+		{name: "zero count", content: passwordSHA1 + ":0\n", err: ":1: invalid count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadCorpus(writeCorpus(t, tt.content))
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadCorpus(filepath.Join(t.TempDir(), "missing.txt"))
+		assert.ErrorContains(t, err, "failed to open breached password corpus")
+	})
+}
+
+// rangeFunc позволяет задать RangeSource функцией
+type rangeFunc func(ctx context.Context, prefix string) (map[string]int, error)
+
+func (f rangeFunc) Range(ctx context.Context, prefix string) (map[string]int, error) {
+	return f(ctx, prefix)
+}
+
+func TestBreached(t *testing.T) {
+	t.Run("only prefix is sent to source", func(t *testing.T) {
+		var prefixes []string
+		source := rangeFunc(func(ctx context.Context, prefix string) (map[string]int, error) {
+			prefixes = append(prefixes, prefix)
+			return map[string]int{passwordSHA1[prefixLength:]: 10}, nil
+		})
+
+		count, err := Breached(context.Background(), source, "password")
+		assert.NoError(t, err)
+		assert.Equal(t, 10, count)
+		assert.Equal(t, []string{passwordSHA1[:prefixLength]}, prefixes)
+	})
+
+	t.Run("source error", func(t *testing.T) {
+		source := rangeFunc(func(ctx context.Context, prefix string) (map[string]int, error) {
+			return nil, assert.AnError
+		})
+		_, err := Breached(context.Background(), source, "password")
+		assert.Equal(t, assert.AnError, err)
+	})
+}
@@ -0,0 +1,178 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+// ErrUnknownHash возвращается для хэша в неизвестном формате
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Argon2Params - параметры Argon2id. Memory задается в КиБ.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// HashConfig - алгоритм и параметры, с которыми хэшируются новые пароли
+type HashConfig struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+// Hasher хэширует пароли по текущей политике и проверяет хэши любого
+// поддерживаемого алгоритма. Хэши хранятся строками в формате PHC
+// ($argon2id$v=19$m=...,t=...,p=...$соль$хэш) или в родном формате bcrypt
+// ($2a$...): алгоритм и параметры определяются по самой строке, поэтому
+// в базе могут одновременно лежать хэши разных версий.
+type Hasher struct {
+	cfg HashConfig
+	// dummy - хэш по текущей политике для сравнения при неизвестном пользователе
+	dummy string
+}
+
+// NewHasher создает Hasher с политикой cfg
+func NewHasher(cfg HashConfig) (*Hasher, error) {
+	switch cfg.Algorithm {
+	case AlgorithmBcrypt:
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgorithmArgon2id:
+		p := cfg.Argon2
+		if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 || p.SaltLength == 0 || p.KeyLength == 0 {
+			return nil, fmt.Errorf("argon2id parameters must be positive")
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm: %q", cfg.Algorithm)
+	}
+
+	h := &Hasher{cfg: cfg}
+	dummy, err := h.Hash("dummy password")
+	if err != nil {
+		return nil, err
+	}
+	h.dummy = dummy
+	return h, nil
+}
+
+// Hash хэширует пароль по текущей политике
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.cfg.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cfg.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash password: %v", err)
+		}
+		return string(hash), nil
+	}
+
+	p := h.cfg.Argon2
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %v", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		AlgorithmArgon2id, argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify сравнивает пароль с хэшем. rehash сообщает, что пароль верный,
+// но хэш получен другим алгоритмом или более слабыми параметрами, чем
+// требует текущая политика, и его стоит пересчитать.
+func (h *Hasher) Verify(password, encoded string) (match, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return h.verifyBcrypt(password, encoded)
+	case strings.HasPrefix(encoded, "$"+AlgorithmArgon2id+"$"):
+		return h.verifyArgon2id(password, encoded)
+	default:
+		return false, false, ErrUnknownHash
+	}
+}
+
+// VerifyDummy тратит на проверку столько же времени, сколько проверка
+// настоящего хэша, чтобы время ответа не выдавало существование пользователя
+func (h *Hasher) VerifyDummy(password string) {
+	h.Verify(password, h.dummy)
+}
+
+func (h *Hasher) verifyBcrypt(password, encoded string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("invalid bcrypt hash: %v", err)
+	}
+
+	if h.cfg.Algorithm != AlgorithmBcrypt {
+		return true, true, nil
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return true, false, nil
+	}
+	return true, cost < h.cfg.BcryptCost, nil
+}
+
+func (h *Hasher) verifyArgon2id(password, encoded string) (bool, bool, error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", соль, хэш
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2id version: %q", parts[2])
+	}
+	var p Argon2Params
+	// Нулевые t и p приводят к панике в argon2.IDKey
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil ||
+		p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return false, false, fmt.Errorf("invalid argon2id parameters: %q", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid argon2id salt: %v", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, false, fmt.Errorf("invalid argon2id hash")
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	actual := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return false, false, nil
+	}
+
+	if h.cfg.Algorithm != AlgorithmArgon2id {
+		return true, true, nil
+	}
+	want := h.cfg.Argon2
+	weaker := p.Memory < want.Memory || p.Iterations < want.Iterations || p.Parallelism < want.Parallelism ||
+		p.SaltLength < want.SaltLength || p.KeyLength < want.KeyLength
+	return true, weaker, nil
+}
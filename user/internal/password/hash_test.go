@@ -0,0 +1,173 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2 - минимальные параметры, чтобы тесты не тратили время и память
+var testArgon2 = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func newTestHasher(t *testing.T, cfg HashConfig) *Hasher {
+	hasher, err := NewHasher(cfg)
+	assert.NoError(t, err)
+	return hasher
+}
+
+func TestNewHasher(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  HashConfig
+		err  string
+	}{
+		{name: "bcrypt", cfg: HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}},
+		{name: "argon2id", cfg: HashConfig{Algorithm: AlgorithmArgon2id, Argon2: testArgon2}},
+		{name: "bcrypt cost too low", cfg: HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost - 1}, err: "bcrypt cost must be between 4 and 31"},
+		{name: "bcrypt cost too high", cfg: HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MaxCost + 1}, err: "bcrypt cost must be between 4 and 31"},
+		{
+			name: "zero argon2id parameter",
+			cfg:  HashConfig{Algorithm: AlgorithmArgon2id, Argon2: Argon2Params{Memory: 64, Iterations: 1, SaltLength: 16, KeyLength: 32}},
+			err:  "argon2id parameters must be positive",
+		},
+		{name: "unknown algorithm", cfg: HashConfig{Algorithm: "scrypt"}, err: `unknown password hash algorithm: "scrypt"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher, err := NewHasher(tt.cfg)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotEmpty(t, hasher.dummy)
+		})
+	}
+}
+
+func TestHasher_Hash(t *testing.T) {
+	hasher := newTestHasher(t, HashConfig{Algorithm: AlgorithmArgon2id, Argon2: testArgon2})
+
+	first, err := hasher.Hash("password")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(first, "$argon2id$v=19$m=64,t=1,p=1$"), first)
+
+	// Соль случайная, поэтому хэши одного пароля различаются
+	second, err := hasher.Hash("password")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	for _, encoded := range []string{first, second} {
+		match, rehash, err := hasher.Verify("password", encoded)
+		assert.NoError(t, err)
+		assert.True(t, match)
+		assert.False(t, rehash)
+
+		match, rehash, err = hasher.Verify("wrong password", encoded)
+		assert.NoError(t, err)
+		assert.False(t, match)
+		assert.False(t, rehash)
+	}
+}
+
+func TestHasher_Verify_Argon2idFormat(t *testing.T) {
+	hasher := newTestHasher(t, HashConfig{Algorithm: AlgorithmArgon2id, Argon2: testArgon2})
+	// "saltsaltsaltsalt" и "hash" в base64 без выравнивания
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "aGFzaA"
+
+	tests := []struct {
+		name    string
+		encoded string
+		err     string
+	}{
+		{name: "missing part", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt, err: ErrUnknownHash.Error()},
+		{name: "extra part", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$x", err: ErrUnknownHash.Error()},
+		{name: "unsupported version", encoded: "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key, err: `unsupported argon2id version: "v=16"`},
+		{name: "invalid version", encoded: "$argon2id$version$m=64,t=1,p=1$" + salt + "$" + key, err: `unsupported argon2id version: "version"`},
+		{name: "invalid parameters", encoded: "$argon2id$v=19$m=64,p=1$" + salt + "$" + key, err: `invalid argon2id parameters: "m=64,p=1"`},
+		{name: "zero memory", encoded: "$argon2id$v=19$m=0,t=1,p=1$" + salt + "$" + key, err: `invalid argon2id parameters: "m=0,t=1,p=1"`},
+		{name: "zero iterations", encoded: "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key, err: `invalid argon2id parameters: "m=64,t=0,p=1"`},
+		{name: "zero parallelism", encoded: "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key, err: `invalid argon2id parameters: "m=64,t=1,p=0"`},
+		{name: "invalid salt", encoded: "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key, err: "invalid argon2id salt"},
+		{name: "empty hash", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$", err: "invalid argon2id hash"},
+		{name: "invalid hash", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!", err: "invalid argon2id hash"},
+		{name: "unknown algorithm", encoded: "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key, err: ErrUnknownHash.Error()},
+		{name: "plain text", encoded: "password", err: ErrUnknownHash.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, rehash, err := hasher.Verify("password", tt.encoded)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.err)
+			}
+			assert.False(t, match)
+			assert.False(t, rehash)
+		})
+	}
+}
+
+func TestHasher_Verify_Upgrade(t *testing.T) {
+	argon2Policy := HashConfig{Algorithm: AlgorithmArgon2id, Argon2: testArgon2}
+	bcryptPolicy := HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost + 1}
+
+	weaker := func(change func(p *Argon2Params)) HashConfig {
+		p := testArgon2
+		change(&p)
+		return HashConfig{Algorithm: AlgorithmArgon2id, Argon2: p}
+	}
+
+	tests := []struct {
+		name   string
+		stored HashConfig
+		policy HashConfig
+		rehash bool
+	}{
+		{name: "argon2id with same parameters", stored: argon2Policy, policy: argon2Policy},
+		{name: "bcrypt to argon2id", stored: bcryptPolicy, policy: argon2Policy, rehash: true},
+		{name: "argon2id with less memory", stored: weaker(func(p *Argon2Params) { p.Memory = 32 }), policy: argon2Policy, rehash: true},
+		{name: "argon2id with fewer iterations", stored: argon2Policy, policy: weaker(func(p *Argon2Params) { p.Iterations = 2 }), rehash: true},
+		{name: "argon2id with less parallelism", stored: argon2Policy, policy: weaker(func(p *Argon2Params) { p.Parallelism = 2 }), rehash: true},
+		{name: "argon2id with shorter salt", stored: weaker(func(p *Argon2Params) { p.SaltLength = 8 }), policy: argon2Policy, rehash: true},
+		{name: "argon2id with shorter key", stored: weaker(func(p *Argon2Params) { p.KeyLength = 16 }), policy: argon2Policy, rehash: true},
+		// Более сильные параметры не понижаются
+		{name: "argon2id with stronger parameters", stored: weaker(func(p *Argon2Params) { p.Memory = 128 }), policy: argon2Policy},
+		{name: "bcrypt with same cost", stored: bcryptPolicy, policy: bcryptPolicy},
+		{name: "bcrypt with lower cost", stored: HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost}, policy: bcryptPolicy, rehash: true},
+		{name: "argon2id to bcrypt", stored: argon2Policy, policy: bcryptPolicy, rehash: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := newTestHasher(t, tt.stored).Hash("password")
+			assert.NoError(t, err)
+			hasher := newTestHasher(t, tt.policy)
+
+			match, rehash, err := hasher.Verify("password", encoded)
+			assert.NoError(t, err)
+			assert.True(t, match)
+			assert.Equal(t, tt.rehash, rehash)
+
+			// Неверный пароль не требует пересчета независимо от параметров
+			match, rehash, err = hasher.Verify("wrong password", encoded)
+			assert.NoError(t, err)
+			assert.False(t, match)
+			assert.False(t, rehash)
+
+			if tt.rehash {
+				// Пересчитанный хэш соответствует политике
+				upgraded, err := hasher.Hash("password")
+				assert.NoError(t, err)
+				_, rehash, err = hasher.Verify("password", upgraded)
+				assert.NoError(t, err)
+				assert.False(t, rehash)
+			}
+		})
+	}
+}
+
+func TestHasher_Verify_InvalidBcrypt(t *testing.T) {
+	hasher := newTestHasher(t, HashConfig{Algorithm: AlgorithmBcrypt, BcryptCost: bcrypt.MinCost})
+	_, _, err := hasher.Verify("password", "$2a$04$short")
+	assert.ErrorContains(t, err, "invalid bcrypt hash")
+}
@@ -0,0 +1,88 @@
+// Package password проверяет новые пароли пользователей: требования к
+// составу и отсутствие пароля в наборах утекших паролей.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policy - требования к новому паролю. Нулевые значения отключают проверку.
+type Policy struct {
+	MinLength int
+	// MaxLength считается в байтах: bcrypt не принимает пароли длиннее 72 байт
+	MaxLength int
+	// MinCharClasses - сколько из четырех классов символов (строчные буквы,
+	// заглавные буквы, цифры, прочие символы) должно встречаться в пароле
+	MinCharClasses int
+	// RejectEmail запрещает пароли, содержащие email или его локальную часть
+	RejectEmail bool
+}
+
+// minEmailPartLength - локальная часть email короче этого не ищется в пароле,
+// иначе короткие имена вроде "a@example.com" запрещали бы почти любой пароль
+const minEmailPartLength = 3
+
+// Validate возвращает описания нарушенных требований; пустой список - пароль подходит
+func (p Policy) Validate(password, email string) []string {
+	var violations []string
+
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("must be at most %d bytes long", p.MaxLength))
+	}
+	if p.MinCharClasses > 0 && charClasses(password) < p.MinCharClasses {
+		violations = append(violations, fmt.Sprintf(
+			"must contain at least %d of: lowercase letters, uppercase letters, digits, other characters", p.MinCharClasses))
+	}
+	if p.RejectEmail && containsEmail(password, email) {
+		violations = append(violations, "must not contain the email address")
+	}
+
+	return violations
+}
+
+func charClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			n++
+		}
+	}
+	return n
+}
+
+func containsEmail(password, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+
+	local := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		local = email[:at]
+	}
+	return len(local) >= minEmailPartLength && strings.Contains(password, local)
+}
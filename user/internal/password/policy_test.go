@@ -0,0 +1,52 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Validate(t *testing.T) {
+	policy := Policy{MinLength: 8, MaxLength: 72, MinCharClasses: 3, RejectEmail: true}
+
+	tests := []struct {
+		name       string
+		password   string
+		email      string
+		violations []string
+	}{
+		{name: "valid", password: "Correct-horse1", email: "test@example.com"},
+		{name: "too short", password: "Ab1-", violations: []string{"must be at least 8 characters long"}},
+		// Длина считается в символах, а не в байтах
+		{name: "multibyte characters", password: "Пароль-1", email: "test@example.com"},
+		{name: "too long", password: "Aa1-" + strings.Repeat("a", 69), violations: []string{"must be at most 72 bytes long"}},
+		{
+			name:       "not enough classes",
+			password:   "lowercase123",
+			violations: []string{"must contain at least 3 of: lowercase letters, uppercase letters, digits, other characters"},
+		},
+		{name: "contains email", password: "X-TEST@example.com", email: "test@example.com", violations: []string{"must not contain the email address"}},
+		{name: "contains local part", password: "Secret-Test1", email: "test@example.com", violations: []string{"must not contain the email address"}},
+		{name: "short local part is ignored", password: "Secret-ab1", email: "ab@example.com"},
+		{
+			name:     "several violations",
+			password: "test",
+			email:    "test@example.com",
+			violations: []string{
+				"must be at least 8 characters long",
+				"must contain at least 3 of: lowercase letters, uppercase letters, digits, other characters",
+				"must not contain the email address",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.violations, policy.Validate(tt.password, tt.email))
+		})
+	}
+
+	t.Run("zero policy accepts anything", func(t *testing.T) {
+		assert.Empty(t, Policy{}.Validate("", "test@example.com"))
+	})
+}
@@ -0,0 +1,328 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"dd/user/internal/model"
+)
+
+// PostgresDataJobs хранит задачи в таблице user_data_jobs, а отметки о
+// стирании - в user_tombstones
+type PostgresDataJobs struct {
+	db *sql.DB
+}
+
+const dataJobColumns = `id, type, user_id, requested_by, status, attempts, error, created_at, finished_at, expires_at`
+
+func scanDataJob(row RowScanner, job *model.DataJob) error {
+	return row.Scan(&job.ID, &job.Type, &job.UserID, &job.RequestedBy, &job.Status, &job.Attempts, &job.Error,
+		&job.CreatedAt, &job.FinishedAt, &job.ExpiresAt)
+}
+
+func NewPostgresDataJobs(db *sql.DB) *PostgresDataJobs {
+	return &PostgresDataJobs{db: db}
+}
+
+func (r *PostgresDataJobs) Enqueue(ctx context.Context, jobType, userID, requestedBy string) (*model.DataJob, error) {
+	var job model.DataJob
+	err := scanDataJob(conn(ctx, r.db).QueryRowContext(ctx,
+		`INSERT INTO user_data_jobs (type, user_id, requested_by)
+         VALUES ($1, $2, $3)
+         ON CONFLICT (user_id, type) WHERE status IN ('pending', 'running') DO NOTHING
+         RETURNING `+dataJobColumns,
+		jobType, userID, requestedBy), &job)
+	if err == sql.ErrNoRows {
+		err = scanDataJob(conn(ctx, r.db).QueryRowContext(ctx,
+			`SELECT `+dataJobColumns+` FROM user_data_jobs
+             WHERE user_id = $1 AND type = $2 AND status IN ('pending', 'running')`,
+			userID, jobType), &job)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job: %v", err)
+	}
+	return &job, nil
+}
+
+func (r *PostgresDataJobs) Get(ctx context.Context, id string) (*model.DataJob, error) {
+	var job model.DataJob
+	// Сравнение по тексту: ID не в формате UUID просто не находится
+	err := scanDataJob(conn(ctx, r.db).QueryRowContext(ctx,
+		`SELECT `+dataJobColumns+` FROM user_data_jobs WHERE id::text = $1`,
+		id), &job)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %v", err)
+	}
+	return &job, nil
+}
+
+func (r *PostgresDataJobs) Archive(ctx context.Context, id string) ([]byte, error) {
+	var archive []byte
+	err := conn(ctx, r.db).QueryRowContext(ctx,
+		`SELECT archive FROM user_data_jobs WHERE id = $1`,
+		id).Scan(&archive)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export: %v", err)
+	}
+	return archive, nil
+}
+
+func (r *PostgresDataJobs) Take(ctx context.Context, staleBefore time.Time) (*model.DataJob, error) {
+	var job model.DataJob
+	err := scanDataJob(conn(ctx, r.db).QueryRowContext(ctx,
+		`UPDATE user_data_jobs SET status = 'running', started_at = NOW(), attempts = attempts + 1
+         WHERE id = (
+             SELECT id FROM user_data_jobs
+             WHERE status = 'pending' OR (status = 'running' AND started_at < $1)
+             ORDER BY created_at
+             LIMIT 1
+             FOR UPDATE SKIP LOCKED
+         )
+         RETURNING `+dataJobColumns,
+		staleBefore), &job)
+	if err == sql.ErrNoRows {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take data job: %v", err)
+	}
+	return &job, nil
+}
+
+func (r *PostgresDataJobs) Retry(ctx context.Context, id, reason string) error {
+	return r.finish(ctx, id, model.DataJobPending, reason)
+}
+
+func (r *PostgresDataJobs) Fail(ctx context.Context, id, reason string) error {
+	return r.finish(ctx, id, model.DataJobFailed, reason)
+}
+
+// finish сохраняет ошибку задачи; время завершения ставится только неудачной задаче
+func (r *PostgresDataJobs) finish(ctx context.Context, id, status, reason string) error {
+	if _, err := conn(ctx, r.db).ExecContext(ctx,
+		`UPDATE user_data_jobs
+         SET status = $2, error = $3, finished_at = CASE WHEN $2 = 'failed' THEN NOW() END
+         WHERE id = $1`,
+		id, status, reason); err != nil {
+		return fmt.Errorf("failed to save job %s: %v", id, err)
+	}
+	return nil
+}
+
+func (r *PostgresDataJobs) Complete(ctx context.Context, id string, archive []byte, expiresAt *time.Time) error {
+	if _, err := conn(ctx, r.db).ExecContext(ctx,
+		`UPDATE user_data_jobs
+         SET status = 'succeeded', error = '', archive = $2, finished_at = NOW(), expires_at = $3
+         WHERE id = $1`,
+		id, nullableJSON(archive), expiresAt); err != nil {
+		return fmt.Errorf("failed to save job %s: %v", id, err)
+	}
+	return nil
+}
+
+func (r *PostgresDataJobs) ExpireArchives(ctx context.Context) (int64, error) {
+	res, err := conn(ctx, r.db).ExecContext(ctx,
+		`UPDATE user_data_jobs SET archive = NULL WHERE archive IS NOT NULL AND expires_at < NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire data exports: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+func (r *PostgresDataJobs) AddTombstone(ctx context.Context, job *model.DataJob) (bool, error) {
+	res, err := conn(ctx, r.db).ExecContext(ctx,
+		`INSERT INTO user_tombstones (user_id, job_id, requested_by) VALUES ($1, $2, $3)
+         ON CONFLICT (user_id) DO NOTHING`,
+		job.UserID, job.ID, job.RequestedBy)
+	if err != nil {
+		return false, fmt.Errorf("failed to record tombstone: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// nullableJSON передает JSON строкой, а пустой - как NULL: lib/pq передает
+// []byte как bytea, и Postgres не принял бы такое значение для JSONB
+func nullableJSON(data []byte) interface{} {
+	if data == nil {
+		return nil
+	}
+	return string(data)
+}
+
+// MemoryDataJobs хранит задачи в памяти процесса
+type MemoryDataJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*memoryDataJob
+	// tombstones - ID стертых пользователей
+	tombstones map[string]bool
+}
+
+type memoryDataJob struct {
+	job       model.DataJob
+	startedAt time.Time
+	archive   []byte
+}
+
+func NewMemoryDataJobs() *MemoryDataJobs {
+	return &MemoryDataJobs{
+		jobs:       make(map[string]*memoryDataJob),
+		tombstones: make(map[string]bool),
+	}
+}
+
+func (r *MemoryDataJobs) Enqueue(ctx context.Context, jobType, userID, requestedBy string) (*model.DataJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, j := range r.jobs {
+		if j.job.UserID == userID && j.job.Type == jobType && active(j.job.Status) {
+			job := j.job
+			return &job, nil
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job: %v", err)
+	}
+	j := &memoryDataJob{job: model.DataJob{
+		ID:          id,
+		Type:        jobType,
+		UserID:      userID,
+		RequestedBy: requestedBy,
+		Status:      model.DataJobPending,
+		CreatedAt:   time.Now(),
+	}}
+	r.jobs[id] = j
+	job := j.job
+	return &job, nil
+}
+
+func (r *MemoryDataJobs) Get(ctx context.Context, id string) (*model.DataJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, ok := r.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	job := j.job
+	return &job, nil
+}
+
+func (r *MemoryDataJobs) Archive(ctx context.Context, id string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	j, ok := r.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return j.archive, nil
+}
+
+func (r *MemoryDataJobs) Take(ctx context.Context, staleBefore time.Time) (*model.DataJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var next *memoryDataJob
+	for _, j := range r.jobs {
+		stale := j.job.Status == model.DataJobRunning && j.startedAt.Before(staleBefore)
+		if j.job.Status != model.DataJobPending && !stale {
+			continue
+		}
+		if next == nil || j.job.CreatedAt.Before(next.job.CreatedAt) {
+			next = j
+		}
+	}
+	if next == nil {
+		return nil, ErrJobNotFound
+	}
+
+	next.job.Status = model.DataJobRunning
+	next.job.Attempts++
+	next.startedAt = time.Now()
+	job := next.job
+	return &job, nil
+}
+
+func (r *MemoryDataJobs) Retry(ctx context.Context, id, reason string) error {
+	return r.update(id, func(j *memoryDataJob) {
+		j.job.Status = model.DataJobPending
+		j.job.Error = reason
+		j.job.FinishedAt = nil
+	})
+}
+
+func (r *MemoryDataJobs) Fail(ctx context.Context, id, reason string) error {
+	return r.update(id, func(j *memoryDataJob) {
+		now := time.Now()
+		j.job.Status = model.DataJobFailed
+		j.job.Error = reason
+		j.job.FinishedAt = &now
+	})
+}
+
+func (r *MemoryDataJobs) Complete(ctx context.Context, id string, archive []byte, expiresAt *time.Time) error {
+	return r.update(id, func(j *memoryDataJob) {
+		now := time.Now()
+		j.job.Status = model.DataJobSucceeded
+		j.job.Error = ""
+		j.job.FinishedAt = &now
+		j.job.ExpiresAt = expiresAt
+		j.archive = archive
+	})
+}
+
+// update изменяет задачу под блокировкой; несуществующая задача пропускается, как в UPDATE
+func (r *MemoryDataJobs) update(id string, fn func(j *memoryDataJob)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if j, ok := r.jobs[id]; ok {
+		fn(j)
+	}
+	return nil
+}
+
+func (r *MemoryDataJobs) ExpireArchives(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var n int64
+	for _, j := range r.jobs {
+		if j.archive != nil && j.job.ExpiresAt != nil && j.job.ExpiresAt.Before(now) {
+			j.archive = nil
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryDataJobs) AddTombstone(ctx context.Context, job *model.DataJob) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.tombstones[job.UserID] {
+		return false, nil
+	}
+	r.tombstones[job.UserID] = true
+	return true, nil
+}
+
+// active сообщает, ждет ли задача или выполняется
+func active(status string) bool {
+	return status == model.DataJobPending || status == model.DataJobRunning
+}
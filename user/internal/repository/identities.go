@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"dd/user/internal/model"
+)
+
+// PostgresIdentities хранит учетные записи провайдеров в таблице user_identities
+type PostgresIdentities struct {
+	db *sql.DB
+}
+
+func NewPostgresIdentities(db *sql.DB) *PostgresIdentities {
+	return &PostgresIdentities{db: db}
+}
+
+func (r *PostgresIdentities) Find(ctx context.Context, provider, subject string) (string, error) {
+	var userID string
+	err := conn(ctx, r.db).QueryRowContext(ctx,
+		`SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2`,
+		provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get identity: %v", err)
+	}
+	return userID, nil
+}
+
+func (r *PostgresIdentities) Link(ctx context.Context, identity *model.Identity) error {
+	err := conn(ctx, r.db).QueryRowContext(ctx,
+		`INSERT INTO user_identities (provider, subject, user_id, email)
+         VALUES ($1, $2, $3, $4)
+         RETURNING created_at`,
+		identity.Provider, identity.Subject, identity.UserID, identity.Email).Scan(&identity.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrIdentityLinked
+	}
+	if err != nil {
+		return fmt.Errorf("failed to link identity: %v", err)
+	}
+	return nil
+}
+
+func (r *PostgresIdentities) ListByUser(ctx context.Context, userID string) ([]*model.Identity, error) {
+	rows, err := conn(ctx, r.db).QueryContext(ctx,
+		`SELECT provider, subject, email, created_at FROM user_identities WHERE user_id = $1 ORDER BY created_at`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get identities: %v", err)
+	}
+	defer rows.Close()
+
+	var identities []*model.Identity
+	for rows.Next() {
+		identity := &model.Identity{UserID: userID}
+		var email sql.NullString
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &email, &identity.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %v", err)
+		}
+		identity.Email = email.String
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get identities: %v", err)
+	}
+	return identities, nil
+}
+
+func (r *PostgresIdentities) DeleteByUser(ctx context.Context, userID string) error {
+	if _, err := conn(ctx, r.db).ExecContext(ctx,
+		`DELETE FROM user_identities WHERE user_id = $1`,
+		userID); err != nil {
+		return fmt.Errorf("failed to unlink identities: %v", err)
+	}
+	return nil
+}
+
+// MemoryIdentities хранит учетные записи провайдеров в памяти процесса
+type MemoryIdentities struct {
+	mu sync.RWMutex
+	// identities - учетные записи по провайдеру и subject
+	identities map[identityKey]model.Identity
+}
+
+type identityKey struct {
+	provider string
+	subject  string
+}
+
+func NewMemoryIdentities() *MemoryIdentities {
+	return &MemoryIdentities{identities: make(map[identityKey]model.Identity)}
+}
+
+func (r *MemoryIdentities) Find(ctx context.Context, provider, subject string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	identity, ok := r.identities[identityKey{provider, subject}]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return identity.UserID, nil
+}
+
+func (r *MemoryIdentities) Link(ctx context.Context, identity *model.Identity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := identityKey{identity.Provider, identity.Subject}
+	if _, ok := r.identities[key]; ok {
+		return ErrIdentityLinked
+	}
+	identity.CreatedAt = time.Now()
+	r.identities[key] = *identity
+	return nil
+}
+
+func (r *MemoryIdentities) ListByUser(ctx context.Context, userID string) ([]*model.Identity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var identities []*model.Identity
+	for _, identity := range r.identities {
+		if identity.UserID == userID {
+			identity := identity
+			identities = append(identities, &identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+	})
+	return identities, nil
+}
+
+func (r *MemoryIdentities) DeleteByUser(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, identity := range r.identities {
+		if identity.UserID == userID {
+			delete(r.identities, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dd/pkg/authz"
+	"dd/user/internal/model"
+	"dd/user/internal/outbox"
+)
+
+// Memory хранит пользователей в памяти процесса. Как и Postgres, он
+// освобождает email удаленной учетной записи только в ReleaseDeleted и Erase.
+// События публикуются в broker сразу под блокировкой хранилища, поэтому
+// попадают в него в порядке изменений.
+type Memory struct {
+	mu    sync.RWMutex
+	users map[string]*memoryUser
+	// emails - ID владельца каждого занятого email
+	emails map[string]string
+	// broker получает события изменений; nil - события не публикуются
+	broker outbox.Broker
+	// mfa сообщает, включен ли у пользователя второй фактор; nil - ни у кого
+	mfa *MemoryMFA
+}
+
+type memoryUser struct {
+	user      model.User
+	deleted   bool
+	deletedAt time.Time
+}
+
+func NewMemory(broker outbox.Broker) *Memory {
+	return &Memory{
+		users:  make(map[string]*memoryUser),
+		emails: make(map[string]string),
+		broker: broker,
+	}
+}
+
+// NewMemoryStore создает хранилища в памяти процесса. События изменений
+// пользователей публикуются в broker, см. Memory.
+func NewMemoryStore(broker outbox.Broker) Store {
+	users := NewMemory(broker)
+	users.mfa = NewMemoryMFA()
+	return Store{
+		Users:      users,
+		Tokens:     NewMemoryTokens(),
+		MFA:        users.mfa,
+		Identities: NewMemoryIdentities(),
+		DataJobs:   NewMemoryDataJobs(),
+		Tx:         memoryTx{},
+	}
+}
+
+// memoryTx сразу выполняет изменения: хранилища в памяти транзакций не поддерживают
+type memoryTx struct{}
+
+func (memoryTx) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (r *Memory) Create(ctx context.Context, user *model.User) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.emails[user.Email]; ok {
+		return ErrAlreadyExists
+	}
+
+	now := time.Now()
+	stored := model.User{
+		ID:            id,
+		Email:         user.Email,
+		Password:      user.Password,
+		Role:          authz.RoleUser,
+		EmailVerified: user.EmailVerified,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Version:       1,
+	}
+	err = r.publish(ctx, outbox.TypeUserCreated, id, outbox.UserCreated{
+		Email:         stored.Email,
+		Role:          stored.Role,
+		EmailVerified: stored.EmailVerified,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.users[id] = &memoryUser{user: stored}
+	r.emails[stored.Email] = id
+	*user = r.copy(stored)
+	return nil
+}
+
+func (r *Memory) GetByID(ctx context.Context, id string) (*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok || u.deleted {
+		return nil, ErrNotFound
+	}
+	user := r.copy(u.user)
+	return &user, nil
+}
+
+func (r *Memory) GetIncludingDeleted(ctx context.Context, id string) (*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user := r.copy(u.user)
+	return &user, nil
+}
+
+func (r *Memory) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	r.mu.RLock()
+	id, ok := r.emails[email]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.GetByID(ctx, id)
+}
+
+func (r *Memory) List(ctx context.Context, opts ListOptions) ([]*model.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// less сравнивает записи в порядке списка
+	less := func(a, b *Position) bool {
+		var cmp int
+		if opts.Sort == SortEmail {
+			cmp = strings.Compare(a.Email, b.Email)
+		} else {
+			cmp = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		if cmp == 0 {
+			cmp = strings.Compare(a.ID, b.ID)
+		}
+		if opts.Desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	position := func(user *model.User) *Position {
+		return &Position{ID: user.ID, CreatedAt: user.CreatedAt, Email: user.Email}
+	}
+
+	var users []*model.User
+	for _, u := range r.users {
+		if !matches(u, opts.Filter) {
+			continue
+		}
+		if opts.After != nil && !less(opts.After, position(&u.user)) {
+			continue
+		}
+		user := r.copy(u.user)
+		users = append(users, &user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return less(position(users[i]), position(users[j]))
+	})
+
+	if opts.Limit < len(users) {
+		users = users[:opts.Limit]
+	}
+	return users, nil
+}
+
+func (r *Memory) Update(ctx context.Context, user *model.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[user.ID]
+	if !ok || u.deleted {
+		return ErrNotFound
+	}
+	if u.user.Version != user.Version {
+		return ErrVersionConflict
+	}
+	oldEmail := u.user.Email
+	if user.Email != oldEmail {
+		if _, ok := r.emails[user.Email]; ok {
+			return ErrAlreadyExists
+		}
+		err := r.publish(ctx, outbox.TypeUserEmailChanged, user.ID, outbox.UserEmailChanged{
+			OldEmail: oldEmail,
+			NewEmail: user.Email,
+		})
+		if err != nil {
+			return err
+		}
+		delete(r.emails, oldEmail)
+		r.emails[user.Email] = user.ID
+	}
+
+	u.user.Email = user.Email
+	u.user.EmailVerified = user.EmailVerified
+	u.user.DisplayName = user.DisplayName
+	u.user.Phone = user.Phone
+	u.user.Locale = user.Locale
+	u.user.Timezone = user.Timezone
+	u.user.AvatarURL = user.AvatarURL
+	u.user.HomeAddress = user.HomeAddress
+	u.user.UpdatedAt = time.Now()
+	u.user.Version++
+
+	*user = r.copy(u.user)
+	return nil
+}
+
+func (r *Memory) SetPassword(ctx context.Context, id, oldHash, newHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok || u.user.Password != oldHash {
+		return ErrVersionConflict
+	}
+	u.user.Password = newHash
+	return nil
+}
+
+func (r *Memory) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok || u.deleted {
+		return ErrNotFound
+	}
+	if err := r.publish(ctx, outbox.TypeUserDeleted, id, outbox.UserDeleted{}); err != nil {
+		return err
+	}
+	u.deleted = true
+	u.deletedAt = time.Now()
+	return nil
+}
+
+func (r *Memory) ReleaseDeleted(ctx context.Context, before time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for id, u := range r.users {
+		if !u.deleted || !u.deletedAt.Before(before) || strings.HasPrefix(u.user.Email, "deleted:") {
+			continue
+		}
+		if r.emails[u.user.Email] == id {
+			delete(r.emails, u.user.Email)
+		}
+		u.user.Email = "deleted:" + id
+		u.user.Password = ""
+		n++
+	}
+	return n, nil
+}
+
+func (r *Memory) Erase(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := r.publish(ctx, outbox.TypeUserErased, id, outbox.UserErased{}); err != nil {
+		return err
+	}
+
+	if r.emails[u.user.Email] == id {
+		delete(r.emails, u.user.Email)
+	}
+	u.user = model.User{
+		ID:        id,
+		Email:     "erased:" + id,
+		Role:      u.user.Role,
+		CreatedAt: u.user.CreatedAt,
+		UpdatedAt: time.Now(),
+		Version:   u.user.Version + 1,
+	}
+	if !u.deleted {
+		u.deleted = true
+		u.deletedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *Memory) Count(ctx context.Context, filter Filter) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var count int64
+	for _, u := range r.users {
+		if matches(u, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// matches проверяет запись по фильтру так же, как условия запроса Postgres
+func matches(u *memoryUser, filter Filter) bool {
+	switch filter.Status {
+	case StatusDeleted:
+		if !u.deleted {
+			return false
+		}
+	case StatusUnverified:
+		if u.deleted || u.user.EmailVerified {
+			return false
+		}
+	default:
+		if u.deleted {
+			return false
+		}
+	}
+	if filter.EmailPrefix != "" && !strings.HasPrefix(strings.ToLower(u.user.Email), strings.ToLower(filter.EmailPrefix)) {
+		return false
+	}
+	if !filter.CreatedAfter.IsZero() && u.user.CreatedAt.Before(filter.CreatedAfter) {
+		return false
+	}
+	if !filter.CreatedBefore.IsZero() && !u.user.CreatedAt.Before(filter.CreatedBefore) {
+		return false
+	}
+	return filter.Role == "" || u.user.Role == filter.Role
+}
+
+// publish отправляет событие изменения; вызывается под r.mu
+func (r *Memory) publish(ctx context.Context, eventType, userID string, payload interface{}) error {
+	if r.broker == nil {
+		return nil
+	}
+	event, err := outbox.NewEvent(eventType, userID, payload)
+	if err != nil {
+		return err
+	}
+	return r.broker.Publish(ctx, event)
+}
+
+// copy копирует запись для вызывающего и отмечает, включен ли второй фактор
+func (r *Memory) copy(user model.User) model.User {
+	user = copyUser(user)
+	user.MFAEnabled = r.mfa != nil && r.mfa.enabled(user.ID)
+	return user
+}
+
+// copyUser копирует запись вместе с адресом, чтобы вызывающий не мог
+// изменить хранимые данные в обход Update
+func copyUser(user model.User) model.User {
+	if user.HomeAddress != nil {
+		address := *user.HomeAddress
+		user.HomeAddress = &address
+	}
+	return user
+}
+
+// newID создает случайный UUID версии 4, как gen_random_uuid() в Postgres
+func newID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate user ID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,233 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"dd/user/internal/model"
+)
+
+// PostgresMFA хранит второй фактор в таблицах user_mfa и user_recovery_codes
+type PostgresMFA struct {
+	db *sql.DB
+}
+
+func NewPostgresMFA(db *sql.DB) *PostgresMFA {
+	return &PostgresMFA{db: db}
+}
+
+func (r *PostgresMFA) Enroll(ctx context.Context, userID string, sealedSecret []byte, codeHashes []string) error {
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		// Подтвержденный секрет не перезаписывается
+		res, err := tx.ExecContext(ctx,
+			`INSERT INTO user_mfa (user_id, secret_encrypted)
+             VALUES ($1, $2)
+             ON CONFLICT (user_id) DO UPDATE
+             SET secret_encrypted = EXCLUDED.secret_encrypted, last_used_step = 0, created_at = NOW()
+             WHERE user_mfa.confirmed_at IS NULL`,
+			userID, sealedSecret)
+		if err != nil {
+			return fmt.Errorf("failed to store TOTP secret: %v", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrMFAEnabled
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`DELETE FROM user_recovery_codes WHERE user_id = $1`,
+			userID); err != nil {
+			return fmt.Errorf("failed to replace recovery codes: %v", err)
+		}
+		for _, hash := range codeHashes {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`,
+				userID, hash); err != nil {
+				return fmt.Errorf("failed to store recovery codes: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *PostgresMFA) Get(ctx context.Context, userID string) (*model.MFA, error) {
+	var mfa model.MFA
+	var confirmedAt sql.NullTime
+	err := conn(ctx, r.db).QueryRowContext(ctx,
+		`SELECT secret_encrypted, confirmed_at, last_used_step FROM user_mfa WHERE user_id = $1`,
+		userID).Scan(&mfa.SealedSecret, &confirmedAt, &mfa.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TOTP secret: %v", err)
+	}
+	mfa.Confirmed = confirmedAt.Valid
+	return &mfa, nil
+}
+
+func (r *PostgresMFA) Confirm(ctx context.Context, userID string, step int64) error {
+	_, err := conn(ctx, r.db).ExecContext(ctx,
+		`UPDATE user_mfa SET confirmed_at = NOW(), last_used_step = $1
+         WHERE user_id = $2 AND confirmed_at IS NULL`,
+		step, userID)
+	if err != nil {
+		return fmt.Errorf("failed to confirm TOTP: %v", err)
+	}
+	return nil
+}
+
+func (r *PostgresMFA) UseStep(ctx context.Context, userID string, step int64) (bool, error) {
+	res, err := conn(ctx, r.db).ExecContext(ctx,
+		`UPDATE user_mfa SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1`,
+		step, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to save TOTP step: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (r *PostgresMFA) UseRecoveryCode(ctx context.Context, userID, codeHash string) (int, bool, error) {
+	res, err := conn(ctx, r.db).ExecContext(ctx,
+		`UPDATE user_recovery_codes SET used_at = NOW()
+         WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, codeHash)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to use recovery code: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, false, nil
+	}
+
+	var left int
+	err = conn(ctx, r.db).QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`,
+		userID).Scan(&left)
+	if err != nil {
+		return 0, true, fmt.Errorf("failed to count recovery codes: %v", err)
+	}
+	return left, true, nil
+}
+
+func (r *PostgresMFA) DeleteByUser(ctx context.Context, userID string) error {
+	for _, query := range []string{
+		`DELETE FROM user_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM user_mfa WHERE user_id = $1`,
+	} {
+		if _, err := conn(ctx, r.db).ExecContext(ctx, query, userID); err != nil {
+			return fmt.Errorf("failed to delete second factor: %v", err)
+		}
+	}
+	return nil
+}
+
+// MemoryMFA хранит второй фактор в памяти процесса
+type MemoryMFA struct {
+	mu      sync.RWMutex
+	factors map[string]*memoryMFA
+}
+
+type memoryMFA struct {
+	mfa model.MFA
+	// codes - хэши кодов восстановления и признак использования
+	codes map[string]bool
+}
+
+func NewMemoryMFA() *MemoryMFA {
+	return &MemoryMFA{factors: make(map[string]*memoryMFA)}
+}
+
+func (r *MemoryMFA) Enroll(ctx context.Context, userID string, sealedSecret []byte, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.factors[userID]; ok && f.mfa.Confirmed {
+		return ErrMFAEnabled
+	}
+	codes := make(map[string]bool, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	r.factors[userID] = &memoryMFA{
+		mfa:   model.MFA{SealedSecret: append([]byte(nil), sealedSecret...)},
+		codes: codes,
+	}
+	return nil
+}
+
+func (r *MemoryMFA) Get(ctx context.Context, userID string) (*model.MFA, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.factors[userID]
+	if !ok {
+		return nil, ErrMFANotEnrolled
+	}
+	mfa := f.mfa
+	mfa.SealedSecret = append([]byte(nil), f.mfa.SealedSecret...)
+	return &mfa, nil
+}
+
+func (r *MemoryMFA) Confirm(ctx context.Context, userID string, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.factors[userID]; ok && !f.mfa.Confirmed {
+		f.mfa.Confirmed = true
+		f.mfa.LastUsedStep = step
+	}
+	return nil
+}
+
+func (r *MemoryMFA) UseStep(ctx context.Context, userID string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.factors[userID]
+	if !ok || f.mfa.LastUsedStep >= step {
+		return false, nil
+	}
+	f.mfa.LastUsedStep = step
+	return true, nil
+}
+
+func (r *MemoryMFA) UseRecoveryCode(ctx context.Context, userID, codeHash string) (int, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.factors[userID]
+	if !ok {
+		return 0, false, nil
+	}
+	if used, ok := f.codes[codeHash]; !ok || used {
+		return 0, false, nil
+	}
+	f.codes[codeHash] = true
+
+	left := 0
+	for _, used := range f.codes {
+		if !used {
+			left++
+		}
+	}
+	return left, true, nil
+}
+
+func (r *MemoryMFA) DeleteByUser(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.factors, userID)
+	return nil
+}
+
+// enabled сообщает, подтвержден ли второй фактор пользователя
+func (r *MemoryMFA) enabled(userID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	f, ok := r.factors[userID]
+	return ok && f.mfa.Confirmed
+}
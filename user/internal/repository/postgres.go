@@ -0,0 +1,376 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"dd/user/internal/model"
+	"dd/user/internal/outbox"
+
+	"github.com/lib/pq"
+)
+
+// MFAEnabledColumn вычисляет, подтвердил ли пользователь подключение TOTP
+const MFAEnabledColumn = `EXISTS (SELECT 1 FROM user_mfa
+         WHERE user_mfa.user_id = users.id AND user_mfa.confirmed_at IS NOT NULL) AS mfa_enabled`
+
+// Columns - столбцы полной записи пользователя, см. Scan
+const Columns = `id, email, password_hash, role, email_verified, ` + MFAEnabledColumn + `, created_at,
+         display_name, phone, locale, timezone, avatar_url, home_address, updated_at, version`
+
+// RowScanner - общий интерфейс *sql.Row и *sql.Rows
+type RowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Scan читает строку, выбранную столбцами Columns
+func Scan(row RowScanner, user *model.User) error {
+	return row.Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.EmailVerified, &user.MFAEnabled, &user.CreatedAt,
+		&user.DisplayName, &user.Phone, &user.Locale, &user.Timezone, &user.AvatarURL, &user.HomeAddress,
+		&user.UpdatedAt, &user.Version)
+}
+
+// Postgres хранит пользователей в таблице users
+type Postgres struct {
+	db *sql.DB
+}
+
+// querier - общий интерфейс *sql.DB и *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txKey struct{}
+
+// WithTx возвращает контекст, в котором методы хранилищ Postgres
+// выполняются в транзакции tx и не фиксируют ее сами. Обычно транзакцию
+// открывает Store.Tx.
+func WithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// conn возвращает транзакцию из ctx или пул соединений db
+func conn(ctx context.Context, db *sql.DB) querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return db
+}
+
+// NewPostgresStore создает хранилища поверх базы db
+func NewPostgresStore(db *sql.DB) Store {
+	return Store{
+		Users:      NewPostgres(db),
+		Tokens:     NewPostgresTokens(db),
+		MFA:        NewPostgresMFA(db),
+		Identities: NewPostgresIdentities(db),
+		DataJobs:   NewPostgresDataJobs(db),
+		Tx:         postgresTx{db: db},
+	}
+}
+
+// postgresTx открывает транзакцию, общую для хранилищ Postgres
+type postgresTx struct {
+	db *sql.DB
+}
+
+func (t postgresTx) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return inTx(ctx, t.db, func(tx *sql.Tx) error {
+		return fn(WithTx(ctx, tx))
+	})
+}
+
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+func (r *Postgres) conn(ctx context.Context) querier {
+	return conn(ctx, r.db)
+}
+
+// Create записывает вместе с пользователем событие user.created
+func (r *Postgres) Create(ctx context.Context, user *model.User) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		err := Scan(tx.QueryRowContext(ctx,
+			`INSERT INTO users (email, password_hash, email_verified)
+             VALUES ($1, $2, $3)
+             RETURNING `+Columns,
+			user.Email, user.Password, user.EmailVerified), user)
+		if isUniqueViolation(err) {
+			return ErrAlreadyExists
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create user: %v", err)
+		}
+
+		return outbox.Write(ctx, tx, outbox.TypeUserCreated, user.ID, outbox.UserCreated{
+			Email:         user.Email,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+		})
+	})
+}
+
+func (r *Postgres) GetByID(ctx context.Context, id string) (*model.User, error) {
+	return r.get(ctx, `SELECT `+Columns+` FROM users WHERE id = $1 AND deleted_at IS NULL`, id)
+}
+
+func (r *Postgres) GetByEmail(ctx context.Context, email string) (*model.User, error) {
+	return r.get(ctx, `SELECT `+Columns+` FROM users WHERE email = $1 AND deleted_at IS NULL`, email)
+}
+
+func (r *Postgres) GetIncludingDeleted(ctx context.Context, id string) (*model.User, error) {
+	return r.get(ctx, `SELECT `+Columns+` FROM users WHERE id = $1`, id)
+}
+
+func (r *Postgres) get(ctx context.Context, query string, args ...interface{}) (*model.User, error) {
+	var user model.User
+	err := Scan(r.conn(ctx).QueryRowContext(ctx, query, args...), &user)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+	return &user, nil
+}
+
+func (r *Postgres) List(ctx context.Context, opts ListOptions) ([]*model.User, error) {
+	conditions, args := filterConditions(opts.Filter)
+
+	column, direction, op := "created_at", "ASC", ">"
+	if opts.Sort == SortEmail {
+		column = "email"
+	}
+	if opts.Desc {
+		direction, op = "DESC", "<"
+	}
+	if opts.After != nil {
+		var key interface{} = opts.After.CreatedAt
+		if opts.Sort == SortEmail {
+			key = opts.After.Email
+		}
+		args = append(args, key, opts.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, op, len(args)-1, len(args)))
+	}
+	args = append(args, opts.Limit)
+
+	rows, err := r.conn(ctx).QueryContext(ctx, fmt.Sprintf(
+		`SELECT `+Columns+` FROM users
+         WHERE %s
+         ORDER BY %s %s, id %s LIMIT $%d`,
+		strings.Join(conditions, " AND "), column, direction, direction, len(args)),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %v", err)
+	}
+	defer rows.Close()
+
+	var users []*model.User
+	for rows.Next() {
+		var user model.User
+		if err := Scan(rows, &user); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %v", err)
+		}
+		users = append(users, &user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %v", err)
+	}
+	return users, nil
+}
+
+// Update записывает событие user.email_changed, если email изменился
+func (r *Postgres) Update(ctx context.Context, user *model.User) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		var oldEmail string
+		err := tx.QueryRowContext(ctx,
+			`SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`,
+			user.ID).Scan(&oldEmail)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user: %v", err)
+		}
+
+		// Строка заблокирована, поэтому ее отсутствие означает только другую версию
+		err = Scan(tx.QueryRowContext(ctx,
+			`UPDATE users SET email = $1, email_verified = $2, display_name = $3, phone = $4, locale = $5,
+                 timezone = $6, avatar_url = $7, home_address = $8, updated_at = NOW(), version = version + 1
+             WHERE id = $9 AND version = $10
+             RETURNING `+Columns,
+			user.Email, user.EmailVerified, user.DisplayName, user.Phone, user.Locale,
+			user.Timezone, user.AvatarURL, user.HomeAddress, user.ID, user.Version), user)
+		if err == sql.ErrNoRows {
+			return ErrVersionConflict
+		}
+		if isUniqueViolation(err) {
+			return ErrAlreadyExists
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update user: %v", err)
+		}
+
+		if user.Email == oldEmail {
+			return nil
+		}
+		return outbox.Write(ctx, tx, outbox.TypeUserEmailChanged, user.ID, outbox.UserEmailChanged{
+			OldEmail: oldEmail,
+			NewEmail: user.Email,
+		})
+	})
+}
+
+// Delete записывает событие user.deleted
+func (r *Postgres) Delete(ctx context.Context, id string) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx,
+			`UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL`,
+			id)
+		if err != nil {
+			return fmt.Errorf("failed to delete user: %v", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		return outbox.Write(ctx, tx, outbox.TypeUserDeleted, id, outbox.UserDeleted{})
+	})
+}
+
+// SetPassword не меняет версию: пароль не входит в поля, которые сохраняет Update
+func (r *Postgres) SetPassword(ctx context.Context, id, oldHash, newHash string) error {
+	res, err := r.conn(ctx).ExecContext(ctx,
+		`UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3`,
+		newHash, id, oldHash)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (r *Postgres) ReleaseDeleted(ctx context.Context, before time.Time) (int64, error) {
+	res, err := r.conn(ctx).ExecContext(ctx,
+		`UPDATE users SET email = 'deleted:' || id, password_hash = ''
+         WHERE deleted_at < $1 AND email NOT LIKE 'deleted:%'`,
+		before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release emails of deleted users: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	return n, nil
+}
+
+// Erase записывает событие user.erased
+func (r *Postgres) Erase(ctx context.Context, id string) error {
+	return r.withTx(ctx, func(tx *sql.Tx) error {
+		// Новое событие сохраняется уже после очистки старых
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE user_outbox SET payload = '{}' WHERE user_id = $1`,
+			id); err != nil {
+			return fmt.Errorf("failed to erase user events: %v", err)
+		}
+
+		res, err := tx.ExecContext(ctx,
+			`UPDATE users SET email = 'erased:' || id, password_hash = '', email_verified = FALSE,
+                 display_name = '', phone = '', locale = '', timezone = '', avatar_url = '', home_address = NULL,
+                 deleted_at = COALESCE(deleted_at, NOW()), updated_at = NOW(), version = version + 1
+             WHERE id = $1`,
+			id)
+		if err != nil {
+			return fmt.Errorf("failed to anonymize user: %v", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrNotFound
+		}
+
+		return outbox.Write(ctx, tx, outbox.TypeUserErased, id, outbox.UserErased{})
+	})
+}
+
+func (r *Postgres) Count(ctx context.Context, filter Filter) (int64, error) {
+	conditions, args := filterConditions(filter)
+
+	var count int64
+	if err := r.conn(ctx).QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM users WHERE `+strings.Join(conditions, " AND "),
+		args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count users: %v", err)
+	}
+	return count, nil
+}
+
+// filterConditions переводит фильтр в условия WHERE с параметрами $1, $2...
+func filterConditions(filter Filter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	switch filter.Status {
+	case StatusDeleted:
+		conditions = append(conditions, "deleted_at IS NOT NULL")
+	case StatusUnverified:
+		conditions = append(conditions, "deleted_at IS NULL", "email_verified = FALSE")
+	default:
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+	if filter.EmailPrefix != "" {
+		add(`email ILIKE $%d ESCAPE '\'`, likeEscaper.Replace(filter.EmailPrefix)+"%")
+	}
+	if !filter.CreatedAfter.IsZero() {
+		add("created_at >= $%d", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		add("created_at < $%d", filter.CreatedBefore)
+	}
+	if filter.Role != "" {
+		add("role = $%d", filter.Role)
+	}
+	return conditions, args
+}
+
+// likeEscaper экранирует символы шаблона LIKE, чтобы префикс сравнивался буквально
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// withTx выполняет fn в транзакции: изменение и его событие сохраняются вместе
+func (r *Postgres) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return inTx(ctx, r.db, fn)
+}
+
+// inTx выполняет fn в транзакции из ctx, а если ее нет - в новой.
+// Транзакцию, переданную через WithTx, фиксирует тот, кто ее открыл.
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(tx)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
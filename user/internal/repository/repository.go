@@ -0,0 +1,213 @@
+// Package repository хранит учетные записи пользователей и связанные с ними
+// данные: одноразовые токены, второй фактор, учетные записи внешних
+// провайдеров и задачи выгрузки и стирания данных. Сервис обращается к
+// таблицам только через интерфейсы этого пакета: Postgres - основное
+// хранилище, Memory - для разработки и тестов.
+//
+// Изменения нескольких хранилищ сервис выполняет в Store.Tx. В Postgres они
+// фиксируются одной транзакцией; Memory транзакций не поддерживает и не
+// откатывает уже выполненные изменения.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"dd/user/internal/model"
+)
+
+var (
+	// ErrNotFound - пользователя нет или его учетная запись удалена
+	ErrNotFound = errors.New("user not found")
+	// ErrAlreadyExists - email занят другой, в том числе удаленной, учетной записью
+	ErrAlreadyExists = errors.New("email is already registered")
+	// ErrVersionConflict - запись изменилась после чтения, см. UserRepository.Update
+	ErrVersionConflict = errors.New("user was modified concurrently")
+	// ErrInvalidToken - одноразового токена нет, он уже использован или истек
+	ErrInvalidToken = errors.New("invalid or expired token")
+	// ErrMFANotEnrolled - пользователь не подключал второй фактор
+	ErrMFANotEnrolled = errors.New("TOTP is not enrolled")
+	// ErrMFAEnabled - второй фактор уже подтвержден, и его секрет не перезаписывается
+	ErrMFAEnabled = errors.New("TOTP is already enabled")
+	// ErrIdentityLinked - учетная запись провайдера уже привязана к пользователю
+	ErrIdentityLinked = errors.New("identity is already linked")
+	// ErrJobNotFound - задачи выгрузки или стирания данных нет
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// Status - состояние учетной записи для фильтра списка
+type Status string
+
+const (
+	// StatusActive - не удаленные учетные записи
+	StatusActive Status = "active"
+	// StatusUnverified - не удаленные учетные записи с неподтвержденным email
+	StatusUnverified Status = "unverified"
+	// StatusDeleted - удаленные учетные записи
+	StatusDeleted Status = "deleted"
+)
+
+// SortField - поле, по которому упорядочен список. При равных значениях
+// записи упорядочиваются по ID, поэтому порядок однозначен.
+type SortField string
+
+const (
+	SortCreatedAt SortField = "created_at"
+	SortEmail     SortField = "email"
+)
+
+// Filter ограничивает список пользователей. Пустые поля не применяются.
+type Filter struct {
+	// EmailPrefix - начало email без учета регистра
+	EmailPrefix string
+	// CreatedAfter и CreatedBefore - границы времени регистрации:
+	// нижняя включительно, верхняя нет
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Role          string
+	// Status по умолчанию StatusActive
+	Status Status
+}
+
+// Position - запись, после которой начинается страница. Заполняются ID
+// и поле сортировки.
+type Position struct {
+	ID        string
+	CreatedAt time.Time
+	Email     string
+}
+
+// ListOptions - страница списка пользователей
+type ListOptions struct {
+	Filter Filter
+	Sort   SortField
+	Desc   bool
+	// After - последняя запись предыдущей страницы; nil - первая страница
+	After *Position
+	Limit int
+}
+
+// UserRepository - хранилище учетных записей. Удаленные учетные записи
+// возвращают только List с фильтром StatusDeleted и GetIncludingDeleted.
+// Create, Update, Delete и Erase вместе с изменением сохраняют событие
+// жизненного цикла, см. пакет outbox.
+type UserRepository interface {
+	// Create сохраняет нового пользователя с email, хэшем пароля и подтверждением email из user.
+	// Остальные поля получают значения по умолчанию и записываются обратно в user.
+	Create(ctx context.Context, user *model.User) error
+	GetByID(ctx context.Context, id string) (*model.User, error)
+	GetByEmail(ctx context.Context, email string) (*model.User, error)
+	// GetIncludingDeleted возвращает пользователя, даже если его учетная запись удалена
+	GetIncludingDeleted(ctx context.Context, id string) (*model.User, error)
+	// List возвращает не больше opts.Limit пользователей, следующих за opts.After
+	List(ctx context.Context, opts ListOptions) ([]*model.User, error)
+	// Update сохраняет email, его подтверждение и поля профиля, если запись
+	// не менялась с версии user.Version, иначе возвращает ErrVersionConflict.
+	// Новые версия и время изменения записываются в user.
+	Update(ctx context.Context, user *model.User) error
+	// SetPassword заменяет хэш пароля, если он все еще равен oldHash,
+	// иначе возвращает ErrVersionConflict
+	SetPassword(ctx context.Context, id, oldHash, newHash string) error
+	// Delete помечает учетную запись удаленной
+	Delete(ctx context.Context, id string) error
+	// ReleaseDeleted освобождает email и стирает хэш пароля учетных записей,
+	// удаленных раньше before. Возвращает число обработанных учетных записей.
+	ReleaseDeleted(ctx context.Context, before time.Time) (int64, error)
+	// Erase обезличивает учетную запись, в том числе удаленную, и помечает ее
+	// удаленной. Email освобождается, а сохраненные ранее события о
+	// пользователе очищаются: они содержат его email.
+	Erase(ctx context.Context, id string) error
+	Count(ctx context.Context, filter Filter) (int64, error)
+}
+
+// TokenRepository - одноразовые токены из писем. Хранятся только хэши токенов.
+type TokenRepository interface {
+	// Issue сохраняет токен и погашает выданные ранее неиспользованные
+	// токены пользователя того же назначения
+	Issue(ctx context.Context, userID, purpose, hash string, expiresAt time.Time) error
+	// Consume погашает действующий токен и возвращает ID его владельца,
+	// иначе ErrInvalidToken
+	Consume(ctx context.Context, hash, purpose string) (string, error)
+	// Revoke погашает неиспользованные токены пользователя; пустой purpose - любого назначения
+	Revoke(ctx context.Context, userID, purpose string) error
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// MFARepository - второй фактор: секрет TOTP и коды восстановления.
+// Коды восстановления хранятся только хэшами.
+type MFARepository interface {
+	// Enroll заменяет секрет и коды восстановления пользователя новыми.
+	// Подтвержденный секрет не перезаписывается: возвращается ErrMFAEnabled.
+	Enroll(ctx context.Context, userID string, sealedSecret []byte, codeHashes []string) error
+	// Get возвращает второй фактор пользователя или ErrMFANotEnrolled
+	Get(ctx context.Context, userID string) (*model.MFA, error)
+	// Confirm включает второй фактор, принявший код шага step
+	Confirm(ctx context.Context, userID string, step int64) error
+	// UseStep запоминает шаг принятого кода и сообщает, был ли он больше
+	// прежнего. Так один код не принимается дважды, в том числе в параллельных запросах.
+	UseStep(ctx context.Context, userID string, step int64) (bool, error)
+	// UseRecoveryCode погашает неиспользованный код восстановления с хэшем
+	// codeHash. ok сообщает, был ли такой код, left - сколько кодов осталось.
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) (left int, ok bool, err error)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// IdentityRepository - учетные записи внешних провайдеров
+type IdentityRepository interface {
+	// Find возвращает ID пользователя, к которому привязана учетная запись
+	// провайдера, или ErrNotFound
+	Find(ctx context.Context, provider, subject string) (string, error)
+	// Link привязывает учетную запись провайдера к пользователю; время
+	// привязки записывается в identity. Если учетная запись уже привязана,
+	// возвращает ErrIdentityLinked.
+	Link(ctx context.Context, identity *model.Identity) error
+	// ListByUser возвращает учетные записи пользователя в порядке привязки
+	ListByUser(ctx context.Context, userID string) ([]*model.Identity, error)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// DataJobRepository - очередь задач выгрузки и стирания данных
+type DataJobRepository interface {
+	// Enqueue создает задачу, а если задача того же типа для пользователя
+	// уже ждет или выполняется, возвращает ее
+	Enqueue(ctx context.Context, jobType, userID, requestedBy string) (*model.DataJob, error)
+	// Get возвращает задачу или ErrJobNotFound
+	Get(ctx context.Context, id string) (*model.DataJob, error)
+	// Archive возвращает архив выгрузки; nil - архива нет или срок его хранения истек
+	Archive(ctx context.Context, id string) ([]byte, error)
+	// Take берет в работу самую старую ждущую задачу или выполняемую, начатую
+	// раньше staleBefore, и увеличивает число ее попыток. Одну задачу получает
+	// только один вызывающий. Если задач нет, возвращает ErrJobNotFound.
+	Take(ctx context.Context, staleBefore time.Time) (*model.DataJob, error)
+	// Retry возвращает задачу в очередь с ошибкой reason
+	Retry(ctx context.Context, id, reason string) error
+	// Fail завершает задачу с ошибкой reason
+	Fail(ctx context.Context, id, reason string) error
+	// Complete завершает задачу; archive хранится до expiresAt
+	Complete(ctx context.Context, id string, archive []byte, expiresAt *time.Time) error
+	// ExpireArchives удаляет архивы с истекшим сроком хранения и возвращает их
+	// число. Сами задачи остаются для истории.
+	ExpireArchives(ctx context.Context) (int64, error)
+	// AddTombstone отмечает, что пользователь стирается задачей job, и
+	// сообщает, новая ли это отметка. Отметка ставится один раз.
+	AddTombstone(ctx context.Context, job *model.DataJob) (bool, error)
+}
+
+// Transactor выполняет изменения нескольких хранилищ вместе
+type Transactor interface {
+	// InTx выполняет fn и фиксирует изменения, если fn не вернула ошибку.
+	// Методы хранилищ, вызванные с переданным в fn контекстом, выполняются
+	// в той же транзакции.
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Store - хранилища сервиса пользователей, созданные поверх одной базы
+type Store struct {
+	Users      UserRepository
+	Tokens     TokenRepository
+	MFA        MFARepository
+	Identities IdentityRepository
+	DataJobs   DataJobRepository
+	Tx         Transactor
+}
@@ -0,0 +1,614 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"dd/user/internal/model"
+	"dd/user/internal/outbox"
+)
+
+func setupTest(t *testing.T) (Store, sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+
+	return NewPostgresStore(db), mock, func() {
+		db.Close()
+	}
+}
+
+// userRows - строки полной записи пользователя, см. Columns
+func userRows(users ...model.User) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "email", "password_hash", "role", "email_verified", "mfa_enabled", "created_at",
+		"display_name", "phone", "locale", "timezone", "avatar_url", "home_address", "updated_at", "version"})
+	for _, u := range users {
+		address, _ := u.HomeAddress.Value()
+		rows.AddRow(u.ID, u.Email, u.Password, u.Role, u.EmailVerified, u.MFAEnabled, u.CreatedAt,
+			u.DisplayName, u.Phone, u.Locale, u.Timezone, u.AvatarURL, address, u.UpdatedAt, u.Version)
+	}
+	return rows
+}
+
+// dataJobRows - строки задачи, см. dataJobColumns
+func dataJobRows(jobs ...model.DataJob) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id", "type", "user_id", "requested_by", "status", "attempts", "error",
+		"created_at", "finished_at", "expires_at"})
+	for _, job := range jobs {
+		rows.AddRow(job.ID, job.Type, job.UserID, job.RequestedBy, job.Status, job.Attempts, job.Error,
+			job.CreatedAt, job.FinishedAt, job.ExpiresAt)
+	}
+	return rows
+}
+
+// expectOutboxEvent ожидает запись события в outbox
+func expectOutboxEvent(mock sqlmock.Sqlmock, eventType, userID string, payload interface{}) {
+	mock.ExpectExec("INSERT INTO user_outbox").
+		WithArgs(sqlmock.AnyArg(), eventType, userID, payload, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestPostgres_Create(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	t.Run("user and event are saved together", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO users").
+			WithArgs("test@example.com", "hash", false).
+			WillReturnRows(userRows(model.User{ID: "123", Email: "test@example.com", Password: "hash", Role: "user", Version: 1}))
+		expectOutboxEvent(mock, outbox.TypeUserCreated, "123", `{"email":"test@example.com","role":"user","email_verified":false}`)
+		mock.ExpectCommit()
+
+		user := &model.User{Email: "test@example.com", Password: "hash"}
+		assert.NoError(t, store.Users.Create(context.Background(), user))
+		assert.Equal(t, "123", user.ID)
+		assert.Equal(t, "user", user.Role)
+	})
+
+	t.Run("duplicate email", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO users").
+			WithArgs("test@example.com", "hash", false).
+			WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+
+		err := store.Users.Create(context.Background(), &model.User{Email: "test@example.com", Password: "hash"})
+		assert.Equal(t, ErrAlreadyExists, err)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_Update(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+
+	expectUpdate := func(oldEmail string, user model.User) *sqlmock.ExpectedQuery {
+		mock.ExpectQuery("SELECT email FROM users WHERE id = (.+) FOR UPDATE").
+			WithArgs(user.ID).
+			WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow(oldEmail))
+		return mock.ExpectQuery("UPDATE users SET (.+) WHERE id = \\$9 AND version = \\$10").
+			WithArgs(user.Email, user.EmailVerified, "", "", "", "", "", nil, user.ID, user.Version)
+	}
+
+	t.Run("email change is published", func(t *testing.T) {
+		user := model.User{ID: "123", Email: "new@example.com", Role: "user", Version: 1}
+		updated := user
+		updated.Version = 2
+
+		mock.ExpectBegin()
+		expectUpdate("old@example.com", user).WillReturnRows(userRows(updated))
+		expectOutboxEvent(mock, outbox.TypeUserEmailChanged, "123", `{"old_email":"old@example.com","new_email":"new@example.com"}`)
+		mock.ExpectCommit()
+
+		assert.NoError(t, store.Users.Update(context.Background(), &user))
+		assert.Equal(t, int64(2), user.Version)
+	})
+
+	t.Run("same email is not published", func(t *testing.T) {
+		user := model.User{ID: "123", Email: "new@example.com", EmailVerified: true, Role: "user", Version: 2}
+		updated := user
+		updated.Version = 3
+
+		mock.ExpectBegin()
+		expectUpdate("new@example.com", user).WillReturnRows(userRows(updated))
+		mock.ExpectCommit()
+
+		assert.NoError(t, store.Users.Update(context.Background(), &user))
+	})
+
+	t.Run("version conflict", func(t *testing.T) {
+		user := model.User{ID: "123", Email: "new@example.com", Version: 1}
+
+		mock.ExpectBegin()
+		expectUpdate("new@example.com", user).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		assert.Equal(t, ErrVersionConflict, store.Users.Update(context.Background(), &user))
+	})
+
+	t.Run("email taken", func(t *testing.T) {
+		user := model.User{ID: "123", Email: "taken@example.com", Version: 3}
+
+		mock.ExpectBegin()
+		expectUpdate("new@example.com", user).WillReturnError(&pq.Error{Code: "23505"})
+		mock.ExpectRollback()
+
+		assert.Equal(t, ErrAlreadyExists, store.Users.Update(context.Background(), &user))
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT email FROM users WHERE id = (.+) AND deleted_at IS NULL FOR UPDATE").
+			WithArgs("123").
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		assert.Equal(t, ErrNotFound, store.Users.Update(context.Background(), &model.User{ID: "123", Version: 3}))
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_DeleteAndErase(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET deleted_at = NOW\\(\\) WHERE id = (.+) AND deleted_at IS NULL").
+		WithArgs("123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectOutboxEvent(mock, outbox.TypeUserDeleted, "123", `{}`)
+	mock.ExpectCommit()
+	assert.NoError(t, store.Users.Delete(ctx, "123"))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET deleted_at").
+		WithArgs("123").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.Equal(t, ErrNotFound, store.Users.Delete(ctx, "123"))
+
+	// Старые события очищаются до записи user.erased
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE user_outbox SET payload = '{}'").
+		WithArgs("123").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE users SET email = 'erased:' \\|\\| id").
+		WithArgs("123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectOutboxEvent(mock, outbox.TypeUserErased, "123", `{}`)
+	mock.ExpectCommit()
+	assert.NoError(t, store.Users.Erase(ctx, "123"))
+
+	mock.ExpectExec("UPDATE users SET email = 'deleted:' \\|\\| id, password_hash = ''").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	n, err := store.Users.ReleaseDeleted(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	mock.ExpectExec("UPDATE users SET password_hash (.+) AND password_hash").
+		WithArgs("new", "123", "old").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, ErrVersionConflict, store.Users.SetPassword(ctx, "123", "old", "new"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgres_List(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	t.Run("first page", func(t *testing.T) {
+		mock.ExpectQuery(regexp.QuoteMeta(`WHERE deleted_at IS NULL
+         ORDER BY created_at DESC, id DESC LIMIT $1`)).
+			WithArgs(3).
+			WillReturnRows(userRows(
+				model.User{ID: "3", Email: "user3@example.com", Role: "admin", Version: 1},
+				model.User{ID: "2", Email: "user2@example.com", Role: "user", Version: 1},
+			))
+
+		users, err := store.Users.List(ctx, ListOptions{Sort: SortCreatedAt, Desc: true, Limit: 3})
+		assert.NoError(t, err)
+		if assert.Len(t, users, 2) {
+			assert.Equal(t, "admin", users[0].Role)
+		}
+	})
+
+	t.Run("filters and position", func(t *testing.T) {
+		after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		filter := Filter{EmailPrefix: "a_b", CreatedAfter: after, Role: "user", Status: StatusUnverified}
+
+		mock.ExpectQuery(regexp.QuoteMeta(`WHERE deleted_at IS NULL AND email_verified = FALSE AND email ILIKE $1 ESCAPE '\' AND created_at >= $2 AND role = $3 AND (email, id) > ($4, $5)
+         ORDER BY email ASC, id ASC LIMIT $6`)).
+			WithArgs(`a\_b%`, after, "user", "a_b@example.com", "7", 11).
+			WillReturnRows(userRows())
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM users WHERE deleted_at IS NULL AND email_verified = FALSE AND email ILIKE $1 ESCAPE '\' AND created_at >= $2 AND role = $3`)).
+			WithArgs(`a\_b%`, after, "user").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(4))
+
+		users, err := store.Users.List(ctx, ListOptions{
+			Filter: filter,
+			Sort:   SortEmail,
+			After:  &Position{ID: "7", Email: "a_b@example.com"},
+			Limit:  11,
+		})
+		assert.NoError(t, err)
+		assert.Empty(t, users)
+
+		count, err := store.Users.Count(ctx, filter)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), count)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresTx(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	t.Run("changes are committed together", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE user_tokens SET used_at").
+			WithArgs("123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM user_identities").
+			WithArgs("123").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := store.Tx.InTx(ctx, func(ctx context.Context) error {
+			if err := store.Tokens.Revoke(ctx, "123", ""); err != nil {
+				return err
+			}
+			return store.Identities.DeleteByUser(ctx, "123")
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("nested transaction is reused", func(t *testing.T) {
+		// Create не открывает свою транзакцию внутри общей
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO users").
+			WithArgs("test@example.com", "", true).
+			WillReturnRows(userRows(model.User{ID: "123", Email: "test@example.com", Role: "user", EmailVerified: true, Version: 1}))
+		expectOutboxEvent(mock, outbox.TypeUserCreated, "123", sqlmock.AnyArg())
+		mock.ExpectQuery("INSERT INTO user_identities").
+			WithArgs("mock", "sub-1", "123", "test@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(time.Now()))
+		mock.ExpectCommit()
+
+		err := store.Tx.InTx(ctx, func(ctx context.Context) error {
+			user := &model.User{Email: "test@example.com", EmailVerified: true}
+			if err := store.Users.Create(ctx, user); err != nil {
+				return err
+			}
+			return store.Identities.Link(ctx, &model.Identity{Provider: "mock", Subject: "sub-1", UserID: user.ID, Email: user.Email})
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("error rolls back", func(t *testing.T) {
+		failure := errors.New("failure")
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		err := store.Tx.InTx(ctx, func(ctx context.Context) error {
+			return failure
+		})
+		assert.Equal(t, failure, err)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresTokens(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	// Новый токен погашает выданные ранее
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE user_tokens SET used_at").
+		WithArgs("123", "password_reset").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO user_tokens").
+		WithArgs("hash", "123", "password_reset", expiresAt).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, store.Tokens.Issue(ctx, "123", "password_reset", "hash", expiresAt))
+
+	mock.ExpectQuery("UPDATE user_tokens SET used_at (.+) RETURNING user_id").
+		WithArgs("hash", "password_reset").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("123"))
+	userID, err := store.Tokens.Consume(ctx, "hash", "password_reset")
+	assert.NoError(t, err)
+	assert.Equal(t, "123", userID)
+
+	mock.ExpectQuery("UPDATE user_tokens SET used_at (.+) RETURNING user_id").
+		WithArgs("hash", "password_reset").
+		WillReturnError(sql.ErrNoRows)
+	_, err = store.Tokens.Consume(ctx, "hash", "password_reset")
+	assert.Equal(t, ErrInvalidToken, err)
+
+	mock.ExpectExec("UPDATE user_tokens SET used_at (.+) AND purpose").
+		WithArgs("123", "password_reset").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.NoError(t, store.Tokens.Revoke(ctx, "123", "password_reset"))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresMFA(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	t.Run("enroll", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO user_mfa (.+) WHERE user_mfa.confirmed_at IS NULL").
+			WithArgs("123", []byte("sealed")).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM user_recovery_codes").
+			WithArgs("123").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		for _, hash := range []string{"h1", "h2"} {
+			mock.ExpectExec("INSERT INTO user_recovery_codes").
+				WithArgs("123", hash).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectCommit()
+
+		assert.NoError(t, store.MFA.Enroll(ctx, "123", []byte("sealed"), []string{"h1", "h2"}))
+	})
+
+	t.Run("enroll when already enabled", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO user_mfa").
+			WithArgs("123", []byte("sealed")).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		assert.Equal(t, ErrMFAEnabled, store.MFA.Enroll(ctx, "123", []byte("sealed"), nil))
+	})
+
+	t.Run("get", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM user_mfa").
+			WithArgs("123").
+			WillReturnRows(sqlmock.NewRows([]string{"secret_encrypted", "confirmed_at", "last_used_step"}).
+				AddRow([]byte("sealed"), time.Now(), 42))
+		mock.ExpectQuery("SELECT (.+) FROM user_mfa").
+			WithArgs("456").
+			WillReturnError(sql.ErrNoRows)
+
+		mfa, err := store.MFA.Get(ctx, "123")
+		assert.NoError(t, err)
+		assert.True(t, mfa.Confirmed)
+		assert.Equal(t, int64(42), mfa.LastUsedStep)
+
+		_, err = store.MFA.Get(ctx, "456")
+		assert.Equal(t, ErrMFANotEnrolled, err)
+	})
+
+	t.Run("step is used once", func(t *testing.T) {
+		mock.ExpectExec("UPDATE user_mfa SET last_used_step (.+) AND last_used_step <").
+			WithArgs(int64(43), "123").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		ok, err := store.MFA.UseStep(ctx, "123", 43)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		mock.ExpectExec("UPDATE user_recovery_codes SET used_at").
+			WithArgs("123", "h1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT COUNT").
+			WithArgs("123").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectExec("UPDATE user_recovery_codes SET used_at").
+			WithArgs("123", "h1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		left, ok, err := store.MFA.UseRecoveryCode(ctx, "123", "h1")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 1, left)
+
+		_, ok, err = store.MFA.UseRecoveryCode(ctx, "123", "h1")
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresIdentities(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+	linked := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT user_id FROM user_identities").
+		WithArgs("google", "g-1").
+		WillReturnError(sql.ErrNoRows)
+	_, err := store.Identities.Find(ctx, "google", "g-1")
+	assert.Equal(t, ErrNotFound, err)
+
+	mock.ExpectQuery("INSERT INTO user_identities").
+		WithArgs("google", "g-1", "123", "test@gmail.com").
+		WillReturnError(&pq.Error{Code: "23505"})
+	err = store.Identities.Link(ctx, &model.Identity{Provider: "google", Subject: "g-1", UserID: "123", Email: "test@gmail.com"})
+	assert.Equal(t, ErrIdentityLinked, err)
+
+	// Email провайдер может не передать
+	mock.ExpectQuery("SELECT provider, subject, email, created_at FROM user_identities").
+		WithArgs("123").
+		WillReturnRows(sqlmock.NewRows([]string{"provider", "subject", "email", "created_at"}).
+			AddRow("google", "g-1", "test@gmail.com", linked).
+			AddRow("github", "gh-1", nil, linked))
+	identities, err := store.Identities.ListByUser(ctx, "123")
+	assert.NoError(t, err)
+	if assert.Len(t, identities, 2) {
+		assert.Equal(t, "test@gmail.com", identities[0].Email)
+		assert.Empty(t, identities[1].Email)
+		assert.Equal(t, "123", identities[1].UserID)
+	}
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresDataJobs(t *testing.T) {
+	store, mock, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	job := model.DataJob{ID: "job-1", Type: "export", UserID: "123", RequestedBy: "123", Status: model.DataJobPending, CreatedAt: created}
+
+	t.Run("active job is reused", func(t *testing.T) {
+		mock.ExpectQuery("INSERT INTO user_data_jobs (.+) DO NOTHING").
+			WithArgs("export", "123", "123").
+			WillReturnRows(dataJobRows())
+		mock.ExpectQuery("SELECT (.+) FROM user_data_jobs").
+			WithArgs("123", "export").
+			WillReturnRows(dataJobRows(job))
+
+		got, err := store.DataJobs.Enqueue(ctx, "export", "123", "123")
+		assert.NoError(t, err)
+		assert.Equal(t, "job-1", got.ID)
+	})
+
+	t.Run("unknown job", func(t *testing.T) {
+		mock.ExpectQuery("SELECT (.+) FROM user_data_jobs WHERE id::text").
+			WithArgs("not-a-uuid").
+			WillReturnRows(dataJobRows())
+
+		_, err := store.DataJobs.Get(ctx, "not-a-uuid")
+		assert.Equal(t, ErrJobNotFound, err)
+	})
+
+	t.Run("take", func(t *testing.T) {
+		staleBefore := time.Now()
+		running := job
+		running.Status = model.DataJobRunning
+		running.Attempts = 1
+		mock.ExpectQuery("UPDATE user_data_jobs SET status = 'running'(.+)FOR UPDATE SKIP LOCKED").
+			WithArgs(staleBefore).
+			WillReturnRows(dataJobRows(running))
+		mock.ExpectQuery("UPDATE user_data_jobs SET status = 'running'").
+			WithArgs(staleBefore).
+			WillReturnRows(dataJobRows())
+
+		got, err := store.DataJobs.Take(ctx, staleBefore)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, got.Attempts)
+
+		_, err = store.DataJobs.Take(ctx, staleBefore)
+		assert.Equal(t, ErrJobNotFound, err)
+	})
+
+	t.Run("finish", func(t *testing.T) {
+		expiresAt := created.Add(24 * time.Hour)
+		mock.ExpectExec("UPDATE user_data_jobs").
+			WithArgs("job-1", model.DataJobPending, "geo is down").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		// Архив передается строкой: lib/pq передал бы []byte как bytea
+		mock.ExpectExec("UPDATE user_data_jobs SET status = 'succeeded'").
+			WithArgs("job-1", `{"user":{}}`, expiresAt).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE user_data_jobs SET status = 'succeeded'").
+			WithArgs("job-2", nil, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, store.DataJobs.Retry(ctx, "job-1", "geo is down"))
+		assert.NoError(t, store.DataJobs.Complete(ctx, "job-1", []byte(`{"user":{}}`), &expiresAt))
+		assert.NoError(t, store.DataJobs.Complete(ctx, "job-2", nil, nil))
+	})
+
+	t.Run("tombstone is added once", func(t *testing.T) {
+		erase := &model.DataJob{ID: "job-2", Type: "erase", UserID: "456", RequestedBy: "999"}
+		mock.ExpectExec("INSERT INTO user_tombstones").
+			WithArgs("456", "job-2", "999").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO user_tombstones").
+			WithArgs("456", "job-2", "999").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		added, err := store.DataJobs.AddTombstone(ctx, erase)
+		assert.NoError(t, err)
+		assert.True(t, added)
+		added, err = store.DataJobs.AddTombstone(ctx, erase)
+		assert.NoError(t, err)
+		assert.False(t, added)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(nil)
+	ctx := context.Background()
+
+	user := &model.User{Email: "test@example.com"}
+	assert.NoError(t, store.Users.Create(ctx, user))
+
+	t.Run("mfa flag follows confirmation", func(t *testing.T) {
+		assert.NoError(t, store.MFA.Enroll(ctx, user.ID, []byte("sealed"), []string{"h1"}))
+		stored, err := store.Users.GetByID(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.False(t, stored.MFAEnabled)
+
+		assert.NoError(t, store.MFA.Confirm(ctx, user.ID, 10))
+		stored, err = store.Users.GetByID(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.True(t, stored.MFAEnabled)
+		assert.Equal(t, ErrMFAEnabled, store.MFA.Enroll(ctx, user.ID, nil, nil))
+
+		ok, err := store.MFA.UseStep(ctx, user.ID, 10)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("token is consumed once", func(t *testing.T) {
+		assert.NoError(t, store.Tokens.Issue(ctx, user.ID, "verify", "old", time.Now().Add(time.Hour)))
+		assert.NoError(t, store.Tokens.Issue(ctx, user.ID, "verify", "new", time.Now().Add(time.Hour)))
+
+		_, err := store.Tokens.Consume(ctx, "old", "verify")
+		assert.Equal(t, ErrInvalidToken, err)
+		userID, err := store.Tokens.Consume(ctx, "new", "verify")
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, userID)
+		_, err = store.Tokens.Consume(ctx, "new", "verify")
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("stale running job is taken again", func(t *testing.T) {
+		job, err := store.DataJobs.Enqueue(ctx, "export", user.ID, user.ID)
+		assert.NoError(t, err)
+
+		taken, err := store.DataJobs.Take(ctx, time.Now().Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, job.ID, taken.ID)
+		_, err = store.DataJobs.Take(ctx, time.Now().Add(-time.Hour))
+		assert.Equal(t, ErrJobNotFound, err)
+
+		taken, err = store.DataJobs.Take(ctx, time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, taken.Attempts)
+	})
+}
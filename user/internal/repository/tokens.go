@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PostgresTokens хранит токены в таблице user_tokens
+type PostgresTokens struct {
+	db *sql.DB
+}
+
+func NewPostgresTokens(db *sql.DB) *PostgresTokens {
+	return &PostgresTokens{db: db}
+}
+
+func (r *PostgresTokens) Issue(ctx context.Context, userID, purpose, hash string, expiresAt time.Time) error {
+	return inTx(ctx, r.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE user_tokens SET used_at = NOW()
+             WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+			userID, purpose)
+		if err != nil {
+			return fmt.Errorf("failed to invalidate previous tokens: %v", err)
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO user_tokens (token_hash, user_id, purpose, expires_at)
+             VALUES ($1, $2, $3, $4)`,
+			hash, userID, purpose, expiresAt)
+		if err != nil {
+			return fmt.Errorf("failed to store token: %v", err)
+		}
+		return nil
+	})
+}
+
+func (r *PostgresTokens) Consume(ctx context.Context, hash, purpose string) (string, error) {
+	var userID string
+	err := conn(ctx, r.db).QueryRowContext(ctx,
+		`UPDATE user_tokens SET used_at = NOW()
+         WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+         RETURNING user_id`,
+		hash, purpose).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to consume token: %v", err)
+	}
+	return userID, nil
+}
+
+func (r *PostgresTokens) Revoke(ctx context.Context, userID, purpose string) error {
+	var err error
+	if purpose == "" {
+		_, err = conn(ctx, r.db).ExecContext(ctx,
+			`UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`,
+			userID)
+	} else {
+		_, err = conn(ctx, r.db).ExecContext(ctx,
+			`UPDATE user_tokens SET used_at = NOW() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+			userID, purpose)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to invalidate tokens: %v", err)
+	}
+	return nil
+}
+
+func (r *PostgresTokens) DeleteByUser(ctx context.Context, userID string) error {
+	if _, err := conn(ctx, r.db).ExecContext(ctx,
+		`DELETE FROM user_tokens WHERE user_id = $1`,
+		userID); err != nil {
+		return fmt.Errorf("failed to delete tokens: %v", err)
+	}
+	return nil
+}
+
+// MemoryTokens хранит токены в памяти процесса
+type MemoryTokens struct {
+	mu sync.Mutex
+	// tokens - токены по хэшу
+	tokens map[string]*memoryToken
+}
+
+type memoryToken struct {
+	userID    string
+	purpose   string
+	expiresAt time.Time
+	used      bool
+}
+
+func NewMemoryTokens() *MemoryTokens {
+	return &MemoryTokens{tokens: make(map[string]*memoryToken)}
+}
+
+func (r *MemoryTokens) Issue(ctx context.Context, userID, purpose, hash string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revoke(userID, purpose)
+	r.tokens[hash] = &memoryToken{userID: userID, purpose: purpose, expiresAt: expiresAt}
+	return nil
+}
+
+func (r *MemoryTokens) Consume(ctx context.Context, hash, purpose string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[hash]
+	if !ok || token.used || token.purpose != purpose || !time.Now().Before(token.expiresAt) {
+		return "", ErrInvalidToken
+	}
+	token.used = true
+	return token.userID, nil
+}
+
+func (r *MemoryTokens) Revoke(ctx context.Context, userID, purpose string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.revoke(userID, purpose)
+	return nil
+}
+
+// revoke погашает токены пользователя; вызывается под r.mu
+func (r *MemoryTokens) revoke(userID, purpose string) {
+	for _, token := range r.tokens {
+		if token.userID == userID && (purpose == "" || token.purpose == purpose) {
+			token.used = true
+		}
+	}
+}
+
+func (r *MemoryTokens) DeleteByUser(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for hash, token := range r.tokens {
+		if token.userID == userID {
+			delete(r.tokens, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"dd/pkg/audit"
+	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+	pb "dd/pkg/user"
+	"dd/user/internal/mailer"
+	"dd/user/internal/model"
+	"dd/user/internal/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UpdateUser меняет email вызывающего. Новый адрес считается неподтвержденным,
+// на него отправляется письмо для подтверждения, а на старый - уведомление.
+func (s *UserService) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "email is required")
+	}
+
+	var oldEmail string
+	var user *model.User
+	err = s.withTx(ctx, func(ctx context.Context) error {
+		var err error
+		user, err = s.users.GetByID(ctx, principal.UserID)
+		if err == repository.ErrNotFound {
+			return status.Errorf(codes.NotFound, "user not found")
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		oldEmail = user.Email
+		if strings.EqualFold(oldEmail, email) {
+			return status.Errorf(codes.InvalidArgument, "email is unchanged")
+		}
+
+		// Update сохранит событие user.email_changed
+		user.Email = email
+		user.EmailVerified = false
+		err = s.users.Update(ctx, user)
+		switch {
+		case err == repository.ErrAlreadyExists:
+			return status.Errorf(codes.AlreadyExists, "email is already registered")
+		case err == repository.ErrVersionConflict:
+			return status.Errorf(codes.Aborted, "user was modified concurrently")
+		case err == repository.ErrNotFound:
+			return status.Errorf(codes.NotFound, "user not found")
+		case err != nil:
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		// Ссылки из писем, отправленных на старый адрес, больше не должны действовать
+		if err := s.tokens.Revoke(ctx, principal.UserID, ""); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s changed email", user.ID)
+	s.record(ctx, audit.Event{Action: audit.ActionEmailChange, Target: user.ID})
+
+	// Письма можно запросить повторно, поэтому их ошибки не отменяют изменение
+	if err := s.sendVerification(ctx, user.ID, user.Email); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
+	}
+	if err := s.mailer.Send(ctx, mailer.Message{
+		To:      oldEmail,
+		Subject: "Email изменен",
+		Body: fmt.Sprintf("Адрес вашей учетной записи изменен на %s.\n\n"+
+			"Если вы этого не делали, восстановите доступ через сброс пароля и обратитесь в поддержку.",
+			user.Email),
+	}); err != nil {
+		log.Printf("Failed to notify %s about email change: %v", oldEmail, err)
+	}
+
+	return &pb.UpdateUserResponse{
+		User: profileProto(user),
+	}, nil
+}
+
+// ChangePassword меняет пароль вызывающего после проверки текущего.
+// Остальные сессии пользователя завершаются, текущая остается.
+func (s *UserService) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "current and new passwords are required")
+	}
+
+	user, err := s.users.GetByID(ctx, principal.UserID)
+	if err == repository.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	email, oldHash := user.Email, user.Password
+	// Пароля нет у пользователей, созданных входом через внешнего провайдера
+	if oldHash == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "password is not set, use password reset")
+	}
+
+	match, _, err := s.passwords.Verify(req.CurrentPassword, oldHash)
+	if err != nil {
+		log.Printf("Failed to verify password of user %s: %v", principal.UserID, err)
+	}
+	if !match {
+		s.record(ctx, audit.Event{Action: audit.ActionPasswordChange, Target: principal.UserID, Result: audit.ResultFailure, Reason: "current password is incorrect"})
+		return nil, fieldError("current password is incorrect", currentPasswordField, "is incorrect")
+	}
+
+	if err := s.checkPassword(ctx, newPasswordField, req.NewPassword, email); err != nil {
+		return nil, err
+	}
+
+	newHash, err := s.passwords.Hash(req.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.withTx(ctx, func(ctx context.Context) error {
+		err := s.users.SetPassword(ctx, principal.UserID, oldHash, newHash)
+		if err == repository.ErrVersionConflict {
+			return status.Errorf(codes.Aborted, "password was changed concurrently")
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		// Ссылка на сброс, запрошенная до смены, вернула бы старый способ входа
+		if err := s.tokens.Revoke(ctx, principal.UserID, purposePasswordReset); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.revokeSessions(ctx, principal.UserID, true, false); err != nil {
+		log.Printf("Failed to revoke sessions of user %s after password change: %v", principal.UserID, err)
+		return nil, status.Errorf(codes.Internal, "password changed, but failed to revoke other sessions")
+	}
+
+	log.Printf("User %s changed password", principal.UserID)
+	s.record(ctx, audit.Event{Action: audit.ActionPasswordChange, Target: principal.UserID})
+
+	return &pb.ChangePasswordResponse{}, nil
+}
+
+// DeleteUser удаляет учетную запись: сессии и API ключи отзываются, а запись
+// помечается удаленной и больше не находится ни при входе, ни в списках.
+// Email остается занятым до конца срока хранения, см. ReleaseDeletedEmails.
+func (s *UserService) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userID := req.UserId
+	if userID == "" {
+		userID = principal.UserID
+	}
+	if userID != principal.UserID && !principal.HasRole(authz.RoleAdmin) {
+		s.record(ctx, audit.Event{Action: audit.ActionUserDelete, Target: userID, Result: audit.ResultDenied})
+		return nil, status.Errorf(codes.PermissionDenied, "cannot delete another user")
+	}
+
+	// Сессии отзываются первыми: если пометка не сохранится, удаление можно
+	// повторить, а удаленная учетная запись с живыми сессиями недопустима
+	if err := s.revokeSessions(ctx, userID, false, true); err != nil {
+		log.Printf("Failed to revoke sessions of user %s before deletion: %v", userID, err)
+		return nil, status.Errorf(codes.Internal, "failed to revoke sessions")
+	}
+
+	err = s.withTx(ctx, func(ctx context.Context) error {
+		// Delete сохранит событие user.deleted
+		err := s.users.Delete(ctx, userID)
+		if err == repository.ErrNotFound {
+			return status.Errorf(codes.NotFound, "user not found")
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		if err := s.tokens.Revoke(ctx, userID, ""); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		// Вход через провайдера создаст новую учетную запись, а не вернет удаленную
+		if err := s.identities.DeleteByUser(ctx, userID); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("User %s deleted by %s", userID, principal.UserID)
+	s.record(ctx, audit.Event{Action: audit.ActionUserDelete, Target: userID})
+
+	return &pb.DeleteUserResponse{}, nil
+}
+
+// ReleaseDeletedEmails освобождает email учетных записей, удаленных раньше
+// срока хранения, чтобы их можно было зарегистрировать снова. Вместе с email
+// стирается хэш пароля. Возвращает число обработанных учетных записей.
+func (s *UserService) ReleaseDeletedEmails(ctx context.Context) (int64, error) {
+	return s.users.ReleaseDeleted(ctx, time.Now().Add(-s.deletedUserGracePeriod))
+}
+
+// RunCleanup периодически освобождает email удаленных учетных записей,
+// пока не будет отменен ctx
+func (s *UserService) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(s.cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := s.ReleaseDeletedEmails(ctx)
+			if err != nil {
+				log.Printf("Failed to clean up deleted users: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Released emails of %d deleted users", n)
+			}
+		}
+	}
+}
+
+// revokeSessions завершает сессии пользователя в auth сервисе от имени
+// вызывающего: auth сам проверяет, что тот вправе это сделать
+func (s *UserService) revokeSessions(ctx context.Context, userID string, keepCurrent, revokeAPIKeys bool) error {
+	token, ok := authn.TokenFromMetadata(ctx)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "no token provided")
+	}
+
+	ctx = metadata.NewOutgoingContext(ctx, metadata.Pairs("authorization", token))
+	_, err := s.authClient.RevokeAllSessions(ctx, &pb_auth.RevokeAllSessionsRequest{
+		UserId:        userID,
+		KeepCurrent:   keepCurrent,
+		RevokeApiKeys: revokeAPIKeys,
+	})
+	return err
+}
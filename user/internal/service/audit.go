@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"dd/pkg/audit"
+	pb "dd/pkg/user"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Размер страницы QueryAuditLog
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+// record пишет событие в журнал аудита. Сбой журнала не отменяет уже
+// выполненное действие, поэтому только попадает в лог.
+func (s *UserService) record(ctx context.Context, event audit.Event) {
+	if err := s.auditLog.Record(ctx, event); err != nil {
+		log.Printf("Failed to record audit event %s: %v", event.Action, err)
+	}
+}
+
+// QueryAuditLog возвращает страницу журнала аудита, начиная с новых записей.
+// Чтение журнала само записывается в журнал.
+func (s *UserService) QueryAuditLog(ctx context.Context, req *pb.QueryAuditLogRequest) (*pb.QueryAuditLogResponse, error) {
+	var violations []*errdetails.BadRequest_FieldViolation
+	invalid := func(field, description string) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+	}
+
+	limit := int(req.PerPage)
+	if limit == 0 {
+		limit = defaultAuditPageSize
+	}
+	if limit < 0 || limit > maxAuditPageSize {
+		invalid("per_page", fmt.Sprintf("must be between 1 and %d", maxAuditPageSize))
+	}
+
+	filter := audit.Filter{
+		Actor:   req.Actor,
+		Target:  req.Target,
+		Action:  req.Action,
+		Result:  req.Result,
+		Service: req.Service,
+	}
+
+	switch filter.Result {
+	case "", audit.ResultSuccess, audit.ResultFailure, audit.ResultDenied:
+	default:
+		invalid("result", "must be one of success, failure, denied")
+	}
+
+	parseTime := func(field, value string) time.Time {
+		if value == "" {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			invalid(field, "must be an RFC 3339 timestamp, e.g. 2024-01-31T00:00:00Z")
+		}
+		return t
+	}
+	filter.Since = parseTime("since", req.Since)
+	filter.Until = parseTime("until", req.Until)
+
+	if req.Cursor != "" {
+		id, err := decodeAuditCursor(req.Cursor)
+		if err != nil {
+			invalid("cursor", "is malformed")
+		}
+		filter.BeforeID = id
+	}
+
+	if len(violations) > 0 {
+		return nil, badRequestError("audit log request is invalid", violations)
+	}
+
+	// Лишняя запись показывает, есть ли следующая страница
+	filter.Limit = limit + 1
+	events, err := s.auditLog.Query(ctx, filter)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &pb.QueryAuditLogResponse{}
+	if len(events) > limit {
+		events = events[:limit]
+		resp.NextCursor = encodeAuditCursor(events[limit-1].ID)
+	}
+	for _, event := range events {
+		resp.Events = append(resp.Events, auditEventProto(event))
+	}
+
+	s.record(ctx, audit.Event{Action: audit.ActionAuditQuery})
+
+	return resp, nil
+}
+
+// Курсор журнала - ID последней записи страницы; клиенту он передается как
+// непрозрачная строка
+func encodeAuditCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeAuditCursor(cursor string) (int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if id <= 0 {
+		return 0, fmt.Errorf("cursor has no position")
+	}
+	return id, nil
+}
+
+func auditEventProto(event audit.Event) *pb.AuditEvent {
+	return &pb.AuditEvent{
+		Id:        event.ID,
+		Time:      event.Time.UTC().Format(time.RFC3339Nano),
+		Service:   event.Service,
+		Actor:     event.Actor,
+		ActorType: event.ActorType,
+		Action:    event.Action,
+		Target:    event.Target,
+		Ip:        event.IP,
+		UserAgent: event.UserAgent,
+		Result:    event.Result,
+		Reason:    event.Reason,
+		PrevHash:  event.PrevHash,
+		Hash:      event.Hash,
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	pb "dd/pkg/user"
+	"dd/user/internal/model"
+	"dd/user/internal/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ResolveExternalIdentity находит или создает пользователя для учетной записи
+// внешнего провайдера. К существующему пользователю учетная запись привязывается
+// только по подтвержденному провайдером email, иначе владелец чужого email
+// получил бы доступ к аккаунту, зарегистрировав его у провайдера.
+func (s *UserService) ResolveExternalIdentity(ctx context.Context, req *pb.ResolveExternalIdentityRequest) (*pb.ResolveExternalIdentityResponse, error) {
+	if req.Provider == "" || req.Subject == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "provider and subject are required")
+	}
+
+	var user *model.User
+	created := false
+	err := s.withTx(ctx, func(ctx context.Context) error {
+		// Учетная запись удаленного пользователя отвязывается при удалении,
+		// поэтому найденный владелец может отсутствовать только при гонке
+		userID, err := s.identities.Find(ctx, req.Provider, req.Subject)
+		if err != nil && err != repository.ErrNotFound {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if err == nil {
+			user, err = s.users.GetByID(ctx, userID)
+			if err == nil {
+				return nil
+			}
+			if err != repository.ErrNotFound {
+				return status.Error(codes.Internal, err.Error())
+			}
+		}
+
+		email := strings.TrimSpace(req.Email)
+		if email == "" {
+			return status.Errorf(codes.InvalidArgument, "provider did not return an email")
+		}
+
+		user, err = s.users.GetByEmail(ctx, email)
+		switch {
+		case err == repository.ErrNotFound:
+			// Пустой хэш не совпадет ни с одним паролем: войти можно только через провайдера
+			// или после сброса пароля
+			user = &model.User{Email: email, EmailVerified: req.EmailVerified}
+			err := s.users.Create(ctx, user)
+			if err == repository.ErrAlreadyExists {
+				// Email удаленной учетной записи занят до конца срока хранения
+				return status.Errorf(codes.FailedPrecondition, "email is already registered")
+			}
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			created = true
+		case err != nil:
+			return status.Error(codes.Internal, err.Error())
+		case !req.EmailVerified:
+			return status.Errorf(codes.FailedPrecondition, "email is already registered")
+		default:
+			if err := s.markEmailVerified(ctx, user); err != nil {
+				return err
+			}
+		}
+
+		err = s.identities.Link(ctx, &model.Identity{
+			Provider: req.Provider,
+			Subject:  req.Subject,
+			UserID:   user.ID,
+			Email:    email,
+		})
+		if err == repository.ErrIdentityLinked {
+			return status.Errorf(codes.Aborted, "identity is being linked concurrently")
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if created {
+		log.Printf("Created user %s for %s identity", user.ID, req.Provider)
+	}
+
+	return &pb.ResolveExternalIdentityResponse{
+		User: &pb.User{
+			Id:            user.ID,
+			Email:         user.Email,
+			CreatedAt:     user.CreatedAt.String(),
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+			MfaEnabled:    user.MFAEnabled,
+		},
+		Created: created,
+	}, nil
+}
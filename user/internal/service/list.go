@@ -0,0 +1,162 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"dd/pkg/audit"
+	pb "dd/pkg/user"
+	"dd/user/internal/repository"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Размер страницы ListUsers
+const (
+	defaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// listCursor - содержимое курсора ListUsers: последняя запись страницы и
+// сортировка, для которой курсор выдан. Клиенту он передается как
+// непрозрачная строка.
+type listCursor struct {
+	Sort      string    `json:"s"`
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"c"`
+	Email     string    `json:"e,omitempty"`
+}
+
+// ListUsers возвращает страницу пользователей. Страницы отсчитываются от
+// последней записи предыдущей (keyset), поэтому их выборка не замедляется
+// к концу списка, а записи, добавленные между запросами, не сдвигают страницы.
+func (s *UserService) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	var violations []*errdetails.BadRequest_FieldViolation
+	invalid := func(field, description string) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+	}
+
+	limit := int(req.PerPage)
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit < 0 || limit > maxPageSize {
+		invalid("per_page", fmt.Sprintf("must be between 1 and %d", maxPageSize))
+	}
+
+	opts := repository.ListOptions{
+		Filter: repository.Filter{
+			EmailPrefix: strings.TrimSpace(req.EmailPrefix),
+			Role:        req.Role,
+			Status:      repository.Status(req.Status),
+		},
+		Sort: repository.SortCreatedAt,
+		Desc: true,
+	}
+
+	switch opts.Filter.Status {
+	case "":
+		opts.Filter.Status = repository.StatusActive
+	case repository.StatusActive, repository.StatusUnverified, repository.StatusDeleted:
+	default:
+		invalid("status", "must be one of active, unverified, deleted")
+	}
+
+	parseTime := func(field, value string) time.Time {
+		if value == "" {
+			return time.Time{}
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			invalid(field, "must be an RFC 3339 timestamp, e.g. 2024-01-31T00:00:00Z")
+		}
+		return t
+	}
+	opts.Filter.CreatedAfter = parseTime("created_after", req.CreatedAfter)
+	opts.Filter.CreatedBefore = parseTime("created_before", req.CreatedBefore)
+
+	sort := req.Sort
+	if sort == "" {
+		sort = "-created_at"
+	}
+	switch strings.TrimPrefix(sort, "-") {
+	case string(repository.SortCreatedAt):
+		opts.Sort = repository.SortCreatedAt
+	case string(repository.SortEmail):
+		opts.Sort = repository.SortEmail
+	default:
+		invalid("sort", "must be one of created_at, -created_at, email, -email")
+	}
+	opts.Desc = strings.HasPrefix(sort, "-")
+
+	if req.Cursor != "" {
+		cursor, err := decodeCursor(req.Cursor)
+		switch {
+		case err != nil:
+			invalid("cursor", "is malformed")
+		case cursor.Sort != sort:
+			invalid("cursor", "was issued for another sort order")
+		default:
+			opts.After = &repository.Position{ID: cursor.ID, CreatedAt: cursor.CreatedAt, Email: cursor.Email}
+		}
+	}
+
+	if len(violations) > 0 {
+		return nil, badRequestError("list request is invalid", violations)
+	}
+
+	// Лишняя запись показывает, есть ли следующая страница
+	opts.Limit = limit + 1
+	list, err := s.users.List(ctx, opts)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &pb.ListUsersResponse{}
+	if len(list) > limit {
+		list = list[:limit]
+		last := list[limit-1]
+		resp.NextCursor = encodeCursor(listCursor{Sort: sort, ID: last.ID, CreatedAt: last.CreatedAt, Email: last.Email})
+	}
+	for _, user := range list {
+		resp.Users = append(resp.Users, profileProto(user))
+	}
+
+	if req.IncludeTotal {
+		total, err := s.users.Count(ctx, opts.Filter)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Total = int32(total)
+	}
+
+	s.record(ctx, audit.Event{Action: audit.ActionUserList})
+
+	return resp, nil
+}
+
+func encodeCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(raw string) (listCursor, error) {
+	var cursor listCursor
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, err
+	}
+	if cursor.ID == "" {
+		return cursor, fmt.Errorf("cursor has no position")
+	}
+	return cursor, nil
+}
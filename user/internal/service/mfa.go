@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"log"
+	"strings"
+	"time"
+
+	"dd/pkg/audit"
+	"dd/pkg/authn"
+	pb "dd/pkg/user"
+	"dd/user/internal/repository"
+	"dd/user/internal/totp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const recoveryCodeCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// EnrollTOTP создает для вызывающего новый TOTP секрет и коды восстановления.
+// Повторный вызов до подтверждения заменяет секрет и коды.
+func (s *UserService) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to enroll TOTP: %v", err)
+	}
+	sealed, err := s.secrets.Seal(secret)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to enroll TOTP: %v", err)
+	}
+
+	recoveryCodes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to enroll TOTP: %v", err)
+	}
+
+	codeHashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		codeHashes = append(codeHashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	// Подтвержденный секрет не перезаписывается
+	err = s.mfa.Enroll(ctx, principal.UserID, sealed, codeHashes)
+	if err == repository.ErrMFAEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "TOTP is already enabled")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.EnrollTOTPResponse{
+		OtpauthUri:    totp.URI(s.mfaIssuer, principal.Email, secret),
+		Secret:        totp.EncodeSecret(secret),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// ConfirmTOTP включает второй фактор после проверки первого кода из приложения
+func (s *UserService) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if req.Code == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "code is required")
+	}
+
+	secret, confirmed, lastStep, err := s.loadTOTP(ctx, principal.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if confirmed {
+		return nil, status.Errorf(codes.FailedPrecondition, "TOTP is already enabled")
+	}
+
+	step, ok := totp.Validate(secret, req.Code, time.Now(), lastStep)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid code")
+	}
+
+	if err := s.mfa.Confirm(ctx, principal.UserID, step); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("TOTP enabled for user %s", principal.UserID)
+	s.record(ctx, audit.Event{Action: audit.ActionMFAEnable, Target: principal.UserID})
+
+	return &pb.ConfirmTOTPResponse{}, nil
+}
+
+// VerifyTOTP проверяет второй фактор пользователя при входе. Принимается код
+// из приложения или неиспользованный код восстановления; оба одноразовые.
+func (s *UserService) VerifyTOTP(ctx context.Context, req *pb.VerifyTOTPRequest) (*pb.VerifyTOTPResponse, error) {
+	if req.UserId == "" || req.Code == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "user id and code are required")
+	}
+
+	secret, confirmed, lastStep, err := s.loadTOTP(ctx, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if !confirmed {
+		return nil, status.Errorf(codes.FailedPrecondition, "TOTP is not enabled")
+	}
+
+	if step, ok := totp.Validate(secret, req.Code, time.Now(), lastStep); ok {
+		// UseStep не дает принять один код в двух параллельных запросах
+		used, err := s.mfa.UseStep(ctx, req.UserId, step)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if used {
+			return &pb.VerifyTOTPResponse{}, nil
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "invalid code")
+	}
+
+	left, ok, err := s.mfa.UseRecoveryCode(ctx, req.UserId, hashToken(normalizeRecoveryCode(req.Code)))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid code")
+	}
+
+	log.Printf("Recovery code used by user %s, %d left", req.UserId, left)
+
+	return &pb.VerifyTOTPResponse{
+		RecoveryCodeUsed:  true,
+		RecoveryCodesLeft: int32(left),
+	}, nil
+}
+
+// loadTOTP возвращает расшифрованный секрет пользователя
+func (s *UserService) loadTOTP(ctx context.Context, userID string) ([]byte, bool, int64, error) {
+	mfa, err := s.mfa.Get(ctx, userID)
+	if err == repository.ErrMFANotEnrolled {
+		return nil, false, 0, status.Errorf(codes.FailedPrecondition, "TOTP is not enrolled")
+	}
+	if err != nil {
+		return nil, false, 0, status.Error(codes.Internal, err.Error())
+	}
+
+	secret, err := s.secrets.Open(mfa.SealedSecret)
+	if err != nil {
+		return nil, false, 0, status.Errorf(codes.Internal, "failed to load TOTP secret: %v", err)
+	}
+	return secret, mfa.Confirmed, mfa.LastUsedStep, nil
+}
+
+// newRecoveryCodes создает коды восстановления вида "abcde-fghij"
+func newRecoveryCodes() ([]string, error) {
+	result := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(raw))[:10]
+		result = append(result, code[:5]+"-"+code[5:])
+	}
+	return result, nil
+}
+
+// normalizeRecoveryCode приводит введенный код к виду, в котором хранится хэш
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"dd/user/internal/password"
+	"dd/user/internal/repository"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Поля, к которым относятся нарушения в деталях BadRequest. Они совпадают
+// с полями запросов proxy: password - при регистрации и сбросе пароля,
+// current_password и new_password - при смене пароля.
+const (
+	passwordField        = "password"
+	currentPasswordField = "current_password"
+	newPasswordField     = "new_password"
+)
+
+// checkPassword проверяет новый пароль по политике и набору утекших паролей.
+// Нарушения возвращаются как InvalidArgument с деталями BadRequest для поля field.
+func (s *UserService) checkPassword(ctx context.Context, field, newPassword, email string) error {
+	violations := s.passwordPolicy.Validate(newPassword, email)
+
+	if s.breachedPasswords != nil {
+		count, err := password.Breached(ctx, s.breachedPasswords, newPassword)
+		if err != nil {
+			// Недоступность набора утечек не должна мешать регистрации
+			log.Printf("Failed to check password against breached corpus: %v", err)
+		} else if count > 0 {
+			violations = append(violations, "has appeared in a data breach and must not be used")
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return fieldError("password does not meet requirements", field, violations...)
+}
+
+// fieldError возвращает InvalidArgument с нарушениями поля field в деталях BadRequest
+func fieldError(message, field string, violations ...string) error {
+	var fieldViolations []*errdetails.BadRequest_FieldViolation
+	for _, violation := range violations {
+		fieldViolations = append(fieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: violation,
+		})
+	}
+	return badRequestError(message, fieldViolations)
+}
+
+// badRequestError возвращает InvalidArgument с нарушениями в деталях BadRequest
+func badRequestError(message string, violations []*errdetails.BadRequest_FieldViolation) error {
+	st, err := status.New(codes.InvalidArgument, message).WithDetails(&errdetails.BadRequest{
+		FieldViolations: violations,
+	})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, message)
+	}
+	return st.Err()
+}
+
+// rehashPassword пересчитывает хэш верного пароля по текущей политике.
+// Хэш заменяется, только если его не успели изменить, например сбросом пароля.
+// Ошибка не мешает входу: хэш пересчитается при следующем входе.
+func (s *UserService) rehashPassword(ctx context.Context, userID, oldHash, plain string) {
+	newHash, err := s.passwords.Hash(plain)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %s: %v", userID, err)
+		return
+	}
+
+	err = s.users.SetPassword(ctx, userID, oldHash, newHash)
+	if err == repository.ErrVersionConflict {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store rehashed password of user %s: %v", userID, err)
+		return
+	}
+
+	log.Printf("Password hash of user %s upgraded", userID)
+}
@@ -0,0 +1,46 @@
+package service
+
+import "dd/pkg/authz"
+
+// authenticator допускает только auth сервис
+var authenticator = authz.Rule{Roles: []string{authz.RoleService}, Scopes: []string{authz.ScopeUserAuthenticate}}
+
+// Policy - правила доступа к методам UserService
+var Policy = authz.Policy{
+	// Регистрация и вход выполняются auth сервисом от своего имени. Другим
+	// сервисам разрешение ScopeUserAuthenticate не выдается.
+	"/user.UserService/CreateUser":        authenticator,
+	"/user.UserService/VerifyCredentials": authenticator,
+	// Профиль всегда принадлежит вызывающему, см. GetProfile
+	"/user.UserService/GetProfile": {},
+	"/user.UserService/ListUsers":  {Roles: []string{authz.RoleAdmin}},
+
+	// Учетной записью управляет ее владелец; чужую удалить может администратор, см. DeleteUser
+	"/user.UserService/UpdateUser":     {},
+	"/user.UserService/UpdateProfile":  {},
+	"/user.UserService/ChangePassword": {},
+	"/user.UserService/DeleteUser":     {},
+
+	// Токен из письма сам подтверждает право на операцию
+	"/user.UserService/RequestPasswordReset": {Public: true},
+	"/user.UserService/ResetPassword":        {Public: true},
+	"/user.UserService/VerifyEmail":          {Public: true},
+	"/user.UserService/SendVerification":     {},
+
+	// Второй фактор подключает сам пользователь, а проверяет его auth сервис при входе
+	"/user.UserService/EnrollTOTP":  {},
+	"/user.UserService/ConfirmTOTP": {},
+	"/user.UserService/VerifyTOTP":  authenticator,
+
+	// Вызывается auth сервисом после проверки ID токена провайдера
+	"/user.UserService/ResolveExternalIdentity": authenticator,
+
+	// Свои данные выгружает и стирает владелец, чужие - администратор, см. enqueueDataJob
+	"/user.UserService/ExportUserData": {},
+	"/user.UserService/EraseUser":      {},
+	"/user.UserService/GetDataJob":     {},
+	"/user.UserService/GetDataExport":  {},
+
+	// Журнал аудита, в том числе записи auth сервиса
+	"/user.UserService/QueryAuditLog": {Roles: []string{authz.RoleAdmin}},
+}
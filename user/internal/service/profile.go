@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"dd/pkg/authn"
+	pb "dd/pkg/user"
+	"dd/user/internal/model"
+	"dd/user/internal/repository"
+
+	"golang.org/x/text/language"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Ограничения полей профиля
+const (
+	maxDisplayNameLength = 100
+	maxAvatarURLLength   = 2048
+	maxAddressPartLength = 255
+)
+
+// phonePattern - номер в формате E.164: "+", код страны и до 15 цифр всего
+var phonePattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// profileProto переводит полный профиль в ответ сервиса
+func profileProto(user *model.User) *pb.User {
+	resp := &pb.User{
+		Id:            user.ID,
+		Email:         user.Email,
+		CreatedAt:     user.CreatedAt.String(),
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+		MfaEnabled:    user.MFAEnabled,
+		DisplayName:   user.DisplayName,
+		Phone:         user.Phone,
+		Locale:        user.Locale,
+		Timezone:      user.Timezone,
+		AvatarUrl:     user.AvatarURL,
+		UpdatedAt:     user.UpdatedAt.String(),
+		Version:       user.Version,
+	}
+	if a := user.HomeAddress; a != nil {
+		resp.HomeAddress = &pb.Address{City: a.City, Street: a.Street, House: a.House, Lat: a.Lat, Lon: a.Lon}
+	}
+	return resp
+}
+
+// UpdateProfile меняет поля профиля вызывающего из update_mask. Изменение
+// применяется, только если профиль не менялся после чтения версии version,
+// иначе возвращается ABORTED, и клиент должен перечитать профиль.
+func (s *UserService) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (*pb.UpdateProfileResponse, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetUpdateMask().GetPaths()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "update_mask is required")
+	}
+	if req.Version <= 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "version is required")
+	}
+
+	profile := req.Profile
+	if profile == nil {
+		profile = &pb.Profile{}
+	}
+
+	// Изменения применяются к прочитанной записи только после проверки всех полей
+	var changes []func(user *model.User)
+	var violations []*errdetails.BadRequest_FieldViolation
+	set := func(change func(user *model.User)) {
+		changes = append(changes, change)
+	}
+	invalid := func(field, description string) {
+		violations = append(violations, &errdetails.BadRequest_FieldViolation{Field: field, Description: description})
+	}
+
+	seen := make(map[string]bool)
+	for _, path := range req.UpdateMask.Paths {
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		switch path {
+		case "display_name":
+			name := strings.TrimSpace(profile.DisplayName)
+			if utf8.RuneCountInString(name) > maxDisplayNameLength {
+				invalid(path, fmt.Sprintf("must be at most %d characters long", maxDisplayNameLength))
+			}
+			set(func(user *model.User) { user.DisplayName = name })
+		case "phone":
+			if profile.Phone != "" && !phonePattern.MatchString(profile.Phone) {
+				invalid(path, "must be in E.164 format, e.g. +79991234567")
+			}
+			set(func(user *model.User) { user.Phone = profile.Phone })
+		case "locale":
+			locale := profile.Locale
+			if locale != "" {
+				tag, err := language.Parse(locale)
+				if err != nil {
+					invalid(path, "must be a BCP 47 language tag, e.g. ru-RU")
+				}
+				locale = tag.String()
+			}
+			set(func(user *model.User) { user.Locale = locale })
+		case "timezone":
+			// Пустая строка означает UTC, поэтому проверяется только непустое значение
+			if profile.Timezone != "" {
+				if _, err := time.LoadLocation(profile.Timezone); err != nil || profile.Timezone == "Local" {
+					invalid(path, "must be an IANA time zone, e.g. Europe/Moscow")
+				}
+			}
+			set(func(user *model.User) { user.Timezone = profile.Timezone })
+		case "avatar_url":
+			if profile.AvatarUrl != "" && !validAvatarURL(profile.AvatarUrl) {
+				invalid(path, fmt.Sprintf("must be an absolute http(s) URL of at most %d bytes", maxAvatarURLLength))
+			}
+			set(func(user *model.User) { user.AvatarURL = profile.AvatarUrl })
+		case "home_address":
+			var address *model.Address
+			if a := profile.HomeAddress; a != nil {
+				address = &model.Address{City: a.City, Street: a.Street, House: a.House, Lat: a.Lat, Lon: a.Lon}
+				for _, part := range []string{a.City, a.Street, a.House, a.Lat, a.Lon} {
+					if len(part) > maxAddressPartLength {
+						invalid(path, fmt.Sprintf("parts must be at most %d bytes long", maxAddressPartLength))
+						break
+					}
+				}
+			}
+			set(func(user *model.User) { user.HomeAddress = address })
+		default:
+			invalid("update_mask", fmt.Sprintf("unknown field %q", path))
+		}
+	}
+	if len(violations) > 0 {
+		return nil, badRequestError("profile is invalid", violations)
+	}
+
+	user, err := s.users.GetByID(ctx, principal.UserID)
+	if err == repository.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user.Version != req.Version {
+		return nil, versionConflict(user.Version)
+	}
+
+	for _, change := range changes {
+		change(user)
+	}
+	// Update проверит версию еще раз: запись могли изменить после чтения
+	err = s.users.Update(ctx, user)
+	if err == repository.ErrVersionConflict {
+		current, err := s.users.GetByID(ctx, principal.UserID)
+		if err != nil {
+			return nil, status.Errorf(codes.Aborted, "profile was modified concurrently")
+		}
+		return nil, versionConflict(current.Version)
+	}
+	if err == repository.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("User %s updated profile to version %d", user.ID, user.Version)
+
+	return &pb.UpdateProfileResponse{User: profileProto(user)}, nil
+}
+
+// versionConflict сообщает клиенту текущую версию профиля, которую нужно перечитать
+func versionConflict(current int64) error {
+	return status.Errorf(codes.Aborted, "profile was modified concurrently, current version is %d", current)
+}
+
+func validAvatarURL(raw string) bool {
+	if len(raw) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
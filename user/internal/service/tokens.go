@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"dd/pkg/audit"
+	"dd/pkg/authn"
+	pb "dd/pkg/user"
+	"dd/user/internal/mailer"
+	"dd/user/internal/model"
+	"dd/user/internal/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Назначение одноразовых токенов из таблицы user_tokens
+const (
+	purposePasswordReset     = "password_reset"
+	purposeEmailVerification = "email_verification"
+)
+
+// RequestPasswordReset отправляет письмо со ссылкой для сброса пароля.
+// Ответ не зависит от того, зарегистрирован ли email.
+func (s *UserService) RequestPasswordReset(ctx context.Context, req *pb.RequestPasswordResetRequest) (*pb.RequestPasswordResetResponse, error) {
+	if req.Email == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "email is required")
+	}
+
+	user, err := s.users.GetByEmail(ctx, req.Email)
+	if err == repository.ErrNotFound {
+		log.Printf("Password reset requested for unknown email %s", req.Email)
+		return &pb.RequestPasswordResetResponse{}, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	userID, email := user.ID, user.Email
+
+	token, err := s.issueToken(ctx, userID, purposePasswordReset, s.passwordResetTTL)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to issue reset token: %v", err)
+	}
+
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Сброс пароля",
+		Body: fmt.Sprintf("Чтобы задать новый пароль, перейдите по ссылке:\n%s%s\n\n"+
+			"Ссылка действует %s. Если вы не запрашивали сброс, просто проигнорируйте это письмо.",
+			s.passwordResetURL, token, s.passwordResetTTL),
+	})
+	if err != nil {
+		// Ошибка не возвращается, чтобы ответ не выдавал существование email
+		log.Printf("Failed to send password reset email to %s: %v", email, err)
+	}
+
+	return &pb.RequestPasswordResetResponse{}, nil
+}
+
+// ResetPassword задает новый пароль по токену из письма. Токен одноразовый.
+func (s *UserService) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
+	if req.Token == "" || req.NewPassword == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "token and new password are required")
+	}
+
+	var userID string
+	err := s.withTx(ctx, func(ctx context.Context) error {
+		var err error
+		userID, err = s.consumeToken(ctx, req.Token, purposePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		user, err := s.users.GetByID(ctx, userID)
+		if err == repository.ErrNotFound {
+			return status.Errorf(codes.InvalidArgument, "invalid or expired token")
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		// Неподходящий пароль откатывает транзакцию, и по ссылке можно попробовать еще раз.
+		// Email владельца нужен только для проверки, что пароль его не содержит.
+		var email string
+		if s.passwordPolicy.RejectEmail {
+			email = user.Email
+		}
+		if err := s.checkPassword(ctx, passwordField, req.NewPassword, email); err != nil {
+			return err
+		}
+
+		hashedPassword, err := s.passwords.Hash(req.NewPassword)
+		if err != nil {
+			return err
+		}
+
+		err = s.users.SetPassword(ctx, userID, user.Password, hashedPassword)
+		if err == repository.ErrVersionConflict {
+			return status.Errorf(codes.Aborted, "password was changed concurrently")
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		// Переход по ссылке из письма заодно подтверждает адрес
+		return s.markEmailVerified(ctx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(ctx, audit.Event{Action: audit.ActionPasswordReset, Target: userID})
+
+	return &pb.ResetPasswordResponse{}, nil
+}
+
+// SendVerification повторно отправляет вызывающему письмо для подтверждения email
+func (s *UserService) SendVerification(ctx context.Context, req *pb.SendVerificationRequest) (*pb.SendVerificationResponse, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetByID(ctx, principal.UserID)
+	if err == repository.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if user.EmailVerified {
+		return nil, status.Errorf(codes.FailedPrecondition, "email is already verified")
+	}
+
+	if err := s.sendVerification(ctx, principal.UserID, user.Email); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to send verification email: %v", err)
+	}
+
+	return &pb.SendVerificationResponse{}, nil
+}
+
+// VerifyEmail подтверждает email по токену из письма. Токен одноразовый.
+func (s *UserService) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	if req.Token == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "token is required")
+	}
+
+	var userID string
+	err := s.withTx(ctx, func(ctx context.Context) error {
+		var err error
+		userID, err = s.consumeToken(ctx, req.Token, purposeEmailVerification)
+		if err != nil {
+			return err
+		}
+
+		user, err := s.users.GetByID(ctx, userID)
+		if err == repository.ErrNotFound {
+			return status.Errorf(codes.InvalidArgument, "invalid or expired token")
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return s.markEmailVerified(ctx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.record(ctx, audit.Event{Action: audit.ActionEmailVerify, Target: userID})
+
+	return &pb.VerifyEmailResponse{}, nil
+}
+
+// markEmailVerified отмечает email пользователя подтвержденным, если он еще не подтвержден
+func (s *UserService) markEmailVerified(ctx context.Context, user *model.User) error {
+	if user.EmailVerified {
+		return nil
+	}
+	user.EmailVerified = true
+	err := s.users.Update(ctx, user)
+	if err == repository.ErrVersionConflict || err == repository.ErrNotFound {
+		return status.Errorf(codes.Aborted, "user was modified concurrently")
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+func (s *UserService) sendVerification(ctx context.Context, userID, email string) error {
+	token, err := s.issueToken(ctx, userID, purposeEmailVerification, s.emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Подтверждение email",
+		Body: fmt.Sprintf("Чтобы подтвердить адрес, перейдите по ссылке:\n%s%s\n\nСсылка действует %s.",
+			s.emailVerificationURL, token, s.emailVerificationTTL),
+	})
+}
+
+// issueToken создает одноразовый токен; выданные ранее неиспользованные
+// токены того же назначения перестают действовать. В базе хранится только хэш.
+func (s *UserService) issueToken(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate token: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	if err := s.tokens.Issue(ctx, userID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// consumeToken погашает действующий токен и возвращает его владельца
+func (s *UserService) consumeToken(ctx context.Context, token, purpose string) (string, error) {
+	userID, err := s.tokens.Consume(ctx, hashToken(token), purpose)
+	if err == repository.ErrInvalidToken {
+		return "", status.Errorf(codes.InvalidArgument, "invalid or expired token")
+	}
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	return userID, nil
+}
+
+// withTx выполняет fn в транзакции и фиксирует ее, если fn не вернула ошибку.
+// Методы хранилищ, вызванные с переданным в fn контекстом, выполняются в той же транзакции.
+// Ошибка fn возвращается как есть.
+func (s *UserService) withTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	var fnErr error
+	err := s.tx.InTx(ctx, func(ctx context.Context) error {
+		fnErr = fn(ctx)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
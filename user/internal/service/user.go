@@ -2,44 +2,105 @@ package service
 
 import (
 	"context"
-	"database/sql"
+	"dd/pkg/audit"
+	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
+	pb_geo "dd/pkg/geo"
+	"dd/pkg/secretbox"
 	pb "dd/pkg/user"
+	"dd/user/internal/config"
+	"dd/user/internal/mailer"
 	"dd/user/internal/model"
-	"fmt"
+	"dd/user/internal/password"
+	"dd/user/internal/repository"
+	"log"
+	"time"
 
-	"github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type UserService struct {
 	pb.UnimplementedUserServiceServer
-	db *sql.DB
+	users      repository.UserRepository
+	tokens     repository.TokenRepository
+	mfa        repository.MFARepository
+	identities repository.IdentityRepository
+	dataJobs   repository.DataJobRepository
+	// tx выполняет изменения нескольких хранилищ вместе, см. withTx
+	tx                   repository.Transactor
+	mailer               mailer.Mailer
+	passwordResetURL     string
+	emailVerificationURL string
+	passwordResetTTL     time.Duration
+	emailVerificationTTL time.Duration
+	secrets              *secretbox.Box
+	mfaIssuer            string
+	passwordPolicy       password.Policy
+	passwords            *password.Hasher
+	// breachedPasswords - набор утекших паролей; nil отключает проверку
+	breachedPasswords password.RangeSource
+	// authClient завершает сессии при смене пароля и удалении учетной записи
+	authClient pb_auth.AuthServiceClient
+	// geoClient выгружает и удаляет данные пользователя в geo сервисе
+	geoClient              pb_geo.GeoServiceClient
+	deletedUserGracePeriod time.Duration
+	cleanupInterval        time.Duration
+	dataJobPollInterval    time.Duration
+	dataExportRetention    time.Duration
+	// auditLog - журнал действий, значимых для безопасности; общий с auth сервисом
+	auditLog audit.Log
 }
 
-func New(db *sql.DB) *UserService {
-	return &UserService{db: db}
+func New(store repository.Store, m mailer.Mailer, secrets *secretbox.Box, passwords *password.Hasher, breached password.RangeSource, authClient pb_auth.AuthServiceClient, geoClient pb_geo.GeoServiceClient, auditLog audit.Log, cfg *config.Config) *UserService {
+	return &UserService{
+		users:                  store.Users,
+		tokens:                 store.Tokens,
+		mfa:                    store.MFA,
+		identities:             store.Identities,
+		dataJobs:               store.DataJobs,
+		tx:                     store.Tx,
+		mailer:                 m,
+		authClient:             authClient,
+		geoClient:              geoClient,
+		deletedUserGracePeriod: cfg.DeletedUserGracePeriod,
+		cleanupInterval:        cfg.DeletedUserCleanupInterval,
+		dataJobPollInterval:    cfg.DataJobPollInterval,
+		dataExportRetention:    cfg.DataExportRetention,
+		secrets:                secrets,
+		mfaIssuer:              cfg.MFAIssuer,
+		passwordPolicy:         cfg.PasswordPolicy,
+		passwords:              passwords,
+		breachedPasswords:      breached,
+		passwordResetURL:       cfg.PasswordResetURL,
+		emailVerificationURL:   cfg.EmailVerificationURL,
+		passwordResetTTL:       cfg.PasswordResetTTL,
+		emailVerificationTTL:   cfg.EmailVerificationTTL,
+		auditLog:               auditLog,
+	}
 }
 
 func (s *UserService) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %v", err)
+	if err := s.checkPassword(ctx, passwordField, req.Password, req.Email); err != nil {
+		return nil, err
 	}
 
-	var user model.User
-	err = s.db.QueryRowContext(ctx,
-		`INSERT INTO users (email, password_hash) 
-         VALUES ($1, $2) 
-         RETURNING id, email, password_hash, created_at`,
-		req.Email, string(hashedPassword)).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt)
-
+	hashedPassword, err := s.passwords.Hash(req.Password)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return nil, err
+	}
+
+	user := &model.User{Email: req.Email, Password: hashedPassword}
+	if err := s.users.Create(ctx, user); err != nil {
+		if err == repository.ErrAlreadyExists {
 			return nil, status.Errorf(codes.AlreadyExists, "user already exists")
 		}
-		return nil, fmt.Errorf("failed to create user: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// Регистрация не должна срываться из-за почты: письмо можно запросить повторно
+	if err := s.sendVerification(ctx, user.ID, user.Email); err != nil {
+		log.Printf("Failed to send verification email to %s: %v", user.Email, err)
 	}
 
 	return &pb.CreateUserResponse{
@@ -47,65 +108,68 @@ func (s *UserService) CreateUser(ctx context.Context, req *pb.CreateUserRequest)
 			Id:        user.ID,
 			Email:     user.Email,
 			CreatedAt: user.CreatedAt.String(),
+			Role:      user.Role,
 		},
 	}, nil
 }
 
-func (s *UserService) GetProfile(ctx context.Context, req *pb.GetProfileRequest) (*pb.GetProfileResponse, error) {
-	var user model.User
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, email, created_at FROM users WHERE email = $1`,
-		req.Email).Scan(&user.ID, &user.Email, &user.CreatedAt)
+// VerifyCredentials проверяет пароль пользователя и возвращает его данные.
+// Неизвестный email и неверный пароль неразличимы для вызывающего.
+func (s *UserService) VerifyCredentials(ctx context.Context, req *pb.VerifyCredentialsRequest) (*pb.VerifyCredentialsResponse, error) {
+	if req.Email == "" || req.Password == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "email and password are required")
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, status.Errorf(codes.NotFound, "user not found")
+	user, err := s.users.GetByEmail(ctx, req.Email)
+	if err == repository.ErrNotFound {
+		// Сравниваем с фиктивным хэшем, чтобы время ответа не выдавало существование email
+		s.passwords.VerifyDummy(req.Password)
+		return nil, status.Errorf(codes.Unauthenticated, "invalid credentials")
 	}
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to get user: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	return &pb.GetProfileResponse{
+	match, rehash, err := s.passwords.Verify(req.Password, user.Password)
+	if err != nil {
+		log.Printf("Failed to verify password of user %s: %v", user.ID, err)
+		return nil, status.Errorf(codes.Unauthenticated, "invalid credentials")
+	}
+	if !match {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid credentials")
+	}
+	if rehash {
+		s.rehashPassword(ctx, user.ID, user.Password, req.Password)
+	}
+
+	return &pb.VerifyCredentialsResponse{
 		User: &pb.User{
-			Id:        user.ID,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt.String(),
+			Id:            user.ID,
+			Email:         user.Email,
+			CreatedAt:     user.CreatedAt.String(),
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+			MfaEnabled:    user.MFAEnabled,
 		},
 	}, nil
 }
 
-func (s *UserService) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
-	offset := (req.Page - 1) * req.PerPage
-
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, email, created_at FROM users 
-         ORDER BY created_at DESC LIMIT $1 OFFSET $2`,
-		req.PerPage, offset)
+// GetProfile возвращает профиль аутентифицированного вызывающего
+func (s *UserService) GetProfile(ctx context.Context, req *pb.GetProfileRequest) (*pb.GetProfileResponse, error) {
+	principal, err := authn.Require(ctx)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to list users: %v", err)
+		return nil, err
 	}
-	defer rows.Close()
 
-	var users []*pb.User
-	for rows.Next() {
-		var user model.User
-		if err := rows.Scan(&user.ID, &user.Email, &user.CreatedAt); err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to scan user: %v", err)
-		}
-		users = append(users, &pb.User{
-			Id:        user.ID,
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt.String(),
-		})
+	user, err := s.users.GetByID(ctx, principal.UserID)
+	if err == repository.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
 	}
-
-	var total int32
-	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&total)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to count users: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	return &pb.ListUsersResponse{
-		Users: users,
-		Total: total,
+	return &pb.GetProfileResponse{
+		User: profileProto(user),
 	}, nil
 }
@@ -2,161 +2,1725 @@ package service
 
 import (
 	"context"
-	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
+	"dd/pkg/audit"
+	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+	pb_geo "dd/pkg/geo"
+	"dd/pkg/secretbox"
 	pb "dd/pkg/user"
+	"dd/user/internal/config"
+	"dd/user/internal/mailer"
+	"dd/user/internal/model"
+	"dd/user/internal/outbox"
+	"dd/user/internal/password"
+	"dd/user/internal/repository"
+	"dd/user/internal/totp"
 )
 
-func setupTest(t *testing.T) (*UserService, sqlmock.Sqlmock, func()) {
-	db, mock, err := sqlmock.New()
+// fakeMailer запоминает отправленные письма
+type fakeMailer struct {
+	messages []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// lastToken возвращает токен из ссылки последнего письма
+func (m *fakeMailer) lastToken(t *testing.T) string {
+	if !assert.NotEmpty(t, m.messages) {
+		return ""
+	}
+	match := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(m.messages[len(m.messages)-1].Body)
+	if !assert.Len(t, match, 2) {
+		return ""
+	}
+	return match[1]
+}
+
+// MockAuthClient - мок auth сервиса; реализованы только методы, которые вызывает UserService
+type MockAuthClient struct {
+	pb_auth.AuthServiceClient
+	mock.Mock
+}
+
+func (m *MockAuthClient) RevokeAllSessions(ctx context.Context, req *pb_auth.RevokeAllSessionsRequest, opts ...grpc.CallOption) (*pb_auth.RevokeAllSessionsResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.RevokeAllSessionsResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) ExportUserData(ctx context.Context, req *pb_auth.ExportUserDataRequest, opts ...grpc.CallOption) (*pb_auth.ExportUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.ExportUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockAuthClient) PurgeUserData(ctx context.Context, req *pb_auth.PurgeUserDataRequest, opts ...grpc.CallOption) (*pb_auth.PurgeUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_auth.PurgeUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// MockGeoClient - мок geo сервиса; реализованы только методы, которые вызывает UserService
+type MockGeoClient struct {
+	pb_geo.GeoServiceClient
+	mock.Mock
+}
+
+func (m *MockGeoClient) ExportUserData(ctx context.Context, req *pb_geo.ExportUserDataRequest, opts ...grpc.CallOption) (*pb_geo.ExportUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_geo.ExportUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockGeoClient) PurgeUserData(ctx context.Context, req *pb_geo.PurgeUserDataRequest, opts ...grpc.CallOption) (*pb_geo.PurgeUserDataResponse, error) {
+	args := m.Called(ctx, req)
+	if resp := args.Get(0); resp != nil {
+		return resp.(*pb_geo.PurgeUserDataResponse), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func testSecrets(t *testing.T) *secretbox.Box {
+	box, err := secretbox.New("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
 	assert.NoError(t, err)
+	return box
+}
 
-	service := &UserService{db: db}
+// testPasswords хэширует пароли bcrypt с минимальной стоимостью, чтобы тесты шли быстро
+func testPasswords(t *testing.T) *password.Hasher {
+	hasher, err := password.NewHasher(password.HashConfig{
+		Algorithm:  password.AlgorithmBcrypt,
+		BcryptCost: bcrypt.MinCost,
+	})
+	assert.NoError(t, err)
+	return hasher
+}
 
-	cleanup := func() {
-		db.Close()
+// newTestService создает сервис поверх хранилищ store
+func newTestService(t *testing.T, store repository.Store) *UserService {
+	return &UserService{
+		users:                store.Users,
+		tokens:               store.Tokens,
+		mfa:                  store.MFA,
+		identities:           store.Identities,
+		dataJobs:             store.DataJobs,
+		tx:                   store.Tx,
+		mailer:               &fakeMailer{},
+		passwordResetURL:     "http://localhost/reset-password?token=",
+		emailVerificationURL: "http://localhost/verify-email?token=",
+		passwordResetTTL:     time.Hour,
+		emailVerificationTTL: time.Hour,
+		secrets:              testSecrets(t),
+		passwords:            testPasswords(t),
+		mfaIssuer:            "DD",
+		authClient:           &MockAuthClient{},
+		geoClient:            &MockGeoClient{},
+		dataExportRetention:  24 * time.Hour,
+		auditLog:             audit.NewMemory("user", audit.WithProxy("proxy")),
 	}
+}
 
-	return service, mock, cleanup
+// setupTest создает сервис поверх хранилищ в памяти; тесты проверяют логику
+// сервиса, а запросы к Postgres проверяются в пакете repository
+func setupTest(t *testing.T) (*UserService, repository.Store) {
+	store := repository.NewMemoryStore(nil)
+	return newTestService(t, store), store
+}
+
+// createUser сохраняет пользователя с email и хэшем пароля
+func createUser(t *testing.T, store repository.Store, email, hash string) *model.User {
+	user := &model.User{Email: email, Password: hash}
+	assert.NoError(t, store.Users.Create(context.Background(), user))
+	return user
+}
+
+// enableMFA подключает пользователю подтвержденный второй фактор
+func enableMFA(t *testing.T, store repository.Store, userID string) {
+	assert.NoError(t, store.MFA.Enroll(context.Background(), userID, []byte("sealed"), nil))
+	assert.NoError(t, store.MFA.Confirm(context.Background(), userID, 1))
 }
 
 func TestUserService_CreateUser(t *testing.T) {
-	service, mock, cleanup := setupTest(t)
-	defer cleanup()
+	service, store := setupTest(t)
 
 	t.Run("successful creation", func(t *testing.T) {
-		// Подготавливаем мок для БД
-		mock.ExpectQuery("INSERT INTO users").
-			WithArgs("test@example.com", sqlmock.AnyArg()). // Пароль будет хэширован, поэтому используем AnyArg
-			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password_hash", "created_at"}).
-				AddRow("123", "test@example.com", "hashed_password", time.Now()))
-
-		// Выполняем запрос
 		resp, err := service.CreateUser(context.Background(), &pb.CreateUserRequest{
 			Email:    "test@example.com",
 			Password: "password123",
 		})
 
-		// Проверяем результат
 		assert.NoError(t, err)
 		assert.NotNil(t, resp)
 		assert.Equal(t, "test@example.com", resp.User.Email)
-		assert.Equal(t, "123", resp.User.Id)
+		assert.NotEmpty(t, resp.User.Id)
+		assert.Equal(t, "user", resp.User.Role)
+
+		// Сохраняется хэш, а не сам пароль
+		stored, err := store.Users.GetByID(context.Background(), resp.User.Id)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "password123", stored.Password)
+
+		// После регистрации отправляется письмо для подтверждения адреса
+		mails := service.mailer.(*fakeMailer)
+		assert.Len(t, mails.messages, 1)
+		assert.Equal(t, "test@example.com", mails.messages[0].To)
+		assert.Contains(t, mails.messages[0].Body, "http://localhost/verify-email?token=")
 	})
 
 	t.Run("duplicate email", func(t *testing.T) {
-		mock.ExpectQuery("INSERT INTO users").
-			WithArgs("existing@example.com", sqlmock.AnyArg()).
-			WillReturnError(&pq.Error{Code: "23505"}) // Имитируем ошибку дубликата
-
 		resp, err := service.CreateUser(context.Background(), &pb.CreateUserRequest{
-			Email:    "existing@example.com",
+			Email:    "test@example.com",
 			Password: "password123",
 		})
 
-		assert.Error(t, err)
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
 		assert.Nil(t, resp)
 	})
 }
 
-func TestUserService_GetProfile(t *testing.T) {
-	service, mock, cleanup := setupTest(t)
-	defer cleanup()
+func TestUserService_PasswordPolicy(t *testing.T) {
+	service, store := setupTest(t)
 
-	t.Run("existing user", func(t *testing.T) {
-		createdAt := time.Now()
-		mock.ExpectQuery("SELECT (.+) FROM users WHERE").
-			WithArgs("test@example.com").
-			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "created_at"}).
-				AddRow("123", "test@example.com", createdAt))
+	// Набор утечек в формате Pwned Passwords: SHA-1 "Password123!" и "Summer2024!"
+	corpusPath := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(corpusPath, []byte(
+		"# test corpus\n"+
+			"49EFEF5F70D47ADC2DB2EB397FBEF5F7BC560E29:52\n"+
+			"7e8b0a3433f1210a9699d85420e363a1b162ecac\n"), 0o600))
+	corpus, err := password.LoadCorpus(corpusPath)
+	assert.NoError(t, err)
+
+	service.breachedPasswords = corpus
+	service.passwordPolicy = password.Policy{
+		MinLength:      10,
+		MaxLength:      72,
+		MinCharClasses: 3,
+		RejectEmail:    true,
+	}
+
+	violations := func(t *testing.T, err error) []string {
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		var descriptions []string
+		for _, detail := range status.Convert(err).Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+				for _, v := range badRequest.FieldViolations {
+					assert.Equal(t, "password", v.Field)
+					descriptions = append(descriptions, v.Description)
+				}
+			}
+		}
+		return descriptions
+	}
+
+	rejected := []struct {
+		name       string
+		password   string
+		violations int
+	}{
+		{"too short and simple", "abc", 2},
+		{"too long", strings.Repeat("Aa1-", 19), 1},
+		{"single character class", "abcdefghijkl", 1},
+		{"contains email", "Alice.Smith-2024", 1},
+		{"breached", "Password123!", 1},
+		{"breached, hash in lower case", "Summer2024!", 1},
+	}
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.CreateUser(context.Background(), &pb.CreateUserRequest{
+				Email:    "alice.smith@example.com",
+				Password: tt.password,
+			})
+			assert.Len(t, violations(t, err), tt.violations)
+		})
+	}
+
+	t.Run("strong password", func(t *testing.T) {
+		_, err := service.CreateUser(context.Background(), &pb.CreateUserRequest{
+			Email:    "alice.smith@example.com",
+			Password: "Correct-horse-42",
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("reset checks the password against the email", func(t *testing.T) {
+		user, err := store.Users.GetByEmail(context.Background(), "alice.smith@example.com")
+		assert.NoError(t, err)
+		token, err := service.issueToken(context.Background(), user.ID, purposePasswordReset, time.Hour)
+		assert.NoError(t, err)
+
+		_, err = service.ResetPassword(context.Background(), &pb.ResetPasswordRequest{
+			Token:       token,
+			NewPassword: "Alice.Smith-2025",
+		})
+		assert.Equal(t, []string{"must not contain the email address"}, violations(t, err))
+	})
+}
+
+func TestUserService_VerifyCredentials(t *testing.T) {
+	service, store := setupTest(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	user := createUser(t, store, "test@example.com", string(hash))
+	enableMFA(t, store, user.ID)
+
+	t.Run("valid credentials", func(t *testing.T) {
+		resp, err := service.VerifyCredentials(context.Background(), &pb.VerifyCredentialsRequest{
+			Email:    "test@example.com",
+			Password: "password123",
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, resp.User.Id)
+		assert.Equal(t, "user", resp.User.Role)
+		assert.True(t, resp.User.MfaEnabled)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		resp, err := service.VerifyCredentials(context.Background(), &pb.VerifyCredentialsRequest{
+			Email:    "test@example.com",
+			Password: "wrong-password",
+		})
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("unknown email", func(t *testing.T) {
+		resp, err := service.VerifyCredentials(context.Background(), &pb.VerifyCredentialsRequest{
+			Email:    "nonexistent@example.com",
+			Password: "password123",
+		})
 
-		resp, err := service.GetProfile(context.Background(), &pb.GetProfileRequest{
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("empty password", func(t *testing.T) {
+		resp, err := service.VerifyCredentials(context.Background(), &pb.VerifyCredentialsRequest{
 			Email: "test@example.com",
 		})
 
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestUserService_PasswordRehash(t *testing.T) {
+	service, store := setupTest(t)
+	ctx := context.Background()
+
+	// Дешевые параметры Argon2id, чтобы тест шел быстро
+	current := password.Argon2Params{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	weaker := current
+	weaker.Iterations = 1
+	const currentPrefix = "$argon2id$v=19$m=64,t=2,p=1$"
+
+	hasher := func(params password.Argon2Params) *password.Hasher {
+		h, err := password.NewHasher(password.HashConfig{Algorithm: password.AlgorithmArgon2id, Argon2: params})
+		assert.NoError(t, err)
+		return h
+	}
+	service.passwords = hasher(current)
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	currentHash, err := service.passwords.Hash("password123")
+	assert.NoError(t, err)
+	weakerHash, err := hasher(weaker).Hash("password123")
+	assert.NoError(t, err)
+
+	user := createUser(t, store, "test@example.com", "")
+	// setHash заменяет хэш пароля пользователя
+	setHash := func(hash string) {
+		stored, err := store.Users.GetByID(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.NoError(t, store.Users.SetPassword(ctx, user.ID, stored.Password, hash))
+	}
+	storedHash := func() string {
+		stored, err := store.Users.GetByID(ctx, user.ID)
+		assert.NoError(t, err)
+		return stored.Password
+	}
+	login := func(password string) error {
+		_, err := service.VerifyCredentials(ctx, &pb.VerifyCredentialsRequest{
+			Email:    "test@example.com",
+			Password: password,
+		})
+		return err
+	}
+
+	t.Run("bcrypt hash is upgraded to argon2id", func(t *testing.T) {
+		setHash(string(bcryptHash))
+
+		assert.NoError(t, login("password123"))
+		assert.True(t, strings.HasPrefix(storedHash(), currentPrefix))
+	})
+
+	t.Run("weaker argon2id parameters are upgraded", func(t *testing.T) {
+		setHash(weakerHash)
+
+		assert.NoError(t, login("password123"))
+		assert.True(t, strings.HasPrefix(storedHash(), currentPrefix))
+	})
+
+	t.Run("current hash is kept", func(t *testing.T) {
+		setHash(currentHash)
+
+		assert.NoError(t, login("password123"))
+		assert.Equal(t, currentHash, storedHash())
+	})
+
+	t.Run("wrong password is not rehashed", func(t *testing.T) {
+		setHash(string(bcryptHash))
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(login("wrong-password")))
+		assert.Equal(t, string(bcryptHash), storedHash())
+	})
+
+	t.Run("unknown hash format", func(t *testing.T) {
+		setHash("plaintext")
+
+		assert.Equal(t, codes.Unauthenticated, status.Code(login("plaintext")))
+	})
+
+	t.Run("new users get argon2id hashes", func(t *testing.T) {
+		resp, err := service.CreateUser(ctx, &pb.CreateUserRequest{
+			Email:    "new@example.com",
+			Password: "password123",
+		})
+		assert.NoError(t, err)
+
+		stored, err := store.Users.GetByID(ctx, resp.User.Id)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(stored.Password, currentPrefix))
+	})
+}
+
+func TestUserService_GetProfile(t *testing.T) {
+	service, store := setupTest(t)
+
+	t.Run("existing user", func(t *testing.T) {
+		user := createUser(t, store, "test@example.com", "hash")
+		user.EmailVerified = true
+		user.DisplayName = "Test User"
+		user.Phone = "+79991234567"
+		user.Locale = "ru-RU"
+		user.Timezone = "Europe/Moscow"
+		user.HomeAddress = &model.Address{City: "Москва", Street: "Тверская", House: "1"}
+		assert.NoError(t, store.Users.Update(context.Background(), user))
+
+		ctx := authn.NewContext(context.Background(), &authn.Principal{UserID: user.ID, Email: "test@example.com"})
+		resp, err := service.GetProfile(ctx, &pb.GetProfileRequest{})
+
 		assert.NoError(t, err)
 		assert.NotNil(t, resp)
 		assert.Equal(t, "test@example.com", resp.User.Email)
-		assert.Equal(t, "123", resp.User.Id)
+		assert.Equal(t, user.ID, resp.User.Id)
+		assert.True(t, resp.User.EmailVerified)
+		assert.Equal(t, "Test User", resp.User.DisplayName)
+		assert.Equal(t, "Europe/Moscow", resp.User.Timezone)
+		assert.Equal(t, "Тверская", resp.User.HomeAddress.GetStreet())
+		assert.Equal(t, int64(2), resp.User.Version)
 	})
 
 	t.Run("non-existing user", func(t *testing.T) {
-		mock.ExpectQuery("SELECT (.+) FROM users WHERE").
-			WithArgs("nonexistent@example.com").
-			WillReturnError(sql.ErrNoRows)
-
-		resp, err := service.GetProfile(context.Background(), &pb.GetProfileRequest{
-			Email: "nonexistent@example.com",
-		})
+		ctx := authn.NewContext(context.Background(), &authn.Principal{UserID: "456"})
+		resp, err := service.GetProfile(ctx, &pb.GetProfileRequest{})
 
 		assert.Error(t, err)
 		assert.Nil(t, resp)
 		assert.Equal(t, codes.NotFound, status.Code(err))
 	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		resp, err := service.GetProfile(context.Background(), &pb.GetProfileRequest{})
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
 }
 
 func TestUserService_ListUsers(t *testing.T) {
-	service, mock, cleanup := setupTest(t)
-	defer cleanup()
+	service, store := setupTest(t)
+
+	for _, email := range []string{"user1@example.com", "user2@example.com", "user3@example.com"} {
+		createUser(t, store, email, "hash")
+		// Время регистрации различается, и порядок по нему однозначен
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Run("first page", func(t *testing.T) {
+		resp, err := service.ListUsers(context.Background(), &pb.ListUsersRequest{PerPage: 2})
 
-	t.Run("successful listing", func(t *testing.T) {
-		createdAt := time.Now()
-		// Мокаем запрос на получение списка пользователей
-		mock.ExpectQuery("SELECT (.+) FROM users").
-			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "created_at"}).
-				AddRow("1", "user1@example.com", createdAt).
-				AddRow("2", "user2@example.com", createdAt))
+		assert.NoError(t, err)
+		if assert.Len(t, resp.Users, 2) {
+			assert.Equal(t, "user3@example.com", resp.Users[0].Email)
+			assert.Equal(t, "user2@example.com", resp.Users[1].Email)
+		}
+		assert.NotEmpty(t, resp.NextCursor)
+		// Без include_total число записей не считается
+		assert.Zero(t, resp.Total)
 
-		// Мокаем запрос на подсчет общего количества
-		mock.ExpectQuery("SELECT COUNT").
-			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		cursor, err := decodeCursor(resp.NextCursor)
+		assert.NoError(t, err)
+		assert.Equal(t, resp.Users[1].Id, cursor.ID)
+	})
 
+	t.Run("filters", func(t *testing.T) {
 		resp, err := service.ListUsers(context.Background(), &pb.ListUsersRequest{
-			Page:    1,
-			PerPage: 10,
+			EmailPrefix:  "USER",
+			CreatedAfter: "2024-01-01T00:00:00Z",
+			Role:         "user",
+			Status:       "unverified",
+			Sort:         "email",
+			PerPage:      2,
+			IncludeTotal: true,
 		})
 
 		assert.NoError(t, err)
-		assert.NotNil(t, resp)
-		assert.Len(t, resp.Users, 2)
-		assert.Equal(t, int32(2), resp.Total)
-		assert.Equal(t, "user1@example.com", resp.Users[0].Email)
-		assert.Equal(t, "user2@example.com", resp.Users[1].Email)
+		if assert.Len(t, resp.Users, 2) {
+			assert.Equal(t, "user1@example.com", resp.Users[0].Email)
+			assert.Equal(t, "user2@example.com", resp.Users[1].Email)
+		}
+		assert.NotEmpty(t, resp.NextCursor)
+		assert.Equal(t, int32(3), resp.Total)
 	})
 
-	t.Run("empty list", func(t *testing.T) {
-		mock.ExpectQuery("SELECT (.+) FROM users").
-			WillReturnRows(sqlmock.NewRows([]string{"id", "email", "created_at"}))
+	t.Run("invalid request", func(t *testing.T) {
+		_, err := service.ListUsers(context.Background(), &pb.ListUsersRequest{
+			PerPage:       maxPageSize + 1,
+			CreatedBefore: "yesterday",
+			Status:        "banned",
+			Sort:          "password",
+			Cursor:        "not a cursor",
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		var fields []string
+		for _, detail := range status.Convert(err).Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+				for _, v := range badRequest.FieldViolations {
+					fields = append(fields, v.Field)
+				}
+			}
+		}
+		assert.Equal(t, []string{"per_page", "status", "created_before", "sort", "cursor"}, fields)
+	})
 
-		mock.ExpectQuery("SELECT COUNT").
-			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	t.Run("cursor of another sort order", func(t *testing.T) {
+		cursor := encodeCursor(listCursor{Sort: "-created_at", ID: "7", CreatedAt: time.Now()})
 
-		resp, err := service.ListUsers(context.Background(), &pb.ListUsersRequest{
-			Page:    1,
-			PerPage: 10,
-		})
+		_, err := service.ListUsers(context.Background(), &pb.ListUsersRequest{Cursor: cursor, Sort: "email"})
 
-		assert.NoError(t, err)
-		assert.NotNil(t, resp)
-		assert.Len(t, resp.Users, 0)
-		assert.Equal(t, int32(0), resp.Total)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
 	})
 }
 
+func TestUserService_ListUsersPages(t *testing.T) {
+	service, store := setupTest(t)
+	users := store.Users
+
+	for _, email := range []string{"carol@example.com", "alice@example.com", "bob@example.com", "dave@example.com", "admin@example.com"} {
+		assert.NoError(t, users.Create(context.Background(), &model.User{Email: email}))
+	}
+	deleted := &model.User{Email: "alex@example.com"}
+	assert.NoError(t, users.Create(context.Background(), deleted))
+	assert.NoError(t, users.Delete(context.Background(), deleted.ID))
+
+	// list проходит все страницы и возвращает email по порядку
+	list := func(req *pb.ListUsersRequest) []string {
+		var emails []string
+		for page := 0; page < 10; page++ {
+			resp, err := service.ListUsers(context.Background(), req)
+			if !assert.NoError(t, err) {
+				return nil
+			}
+			for _, user := range resp.Users {
+				emails = append(emails, user.Email)
+			}
+			if resp.NextCursor == "" {
+				return emails
+			}
+			req.Cursor = resp.NextCursor
+		}
+		t.Fatal("pagination did not stop")
+		return nil
+	}
+
+	assert.Equal(t,
+		[]string{"admin@example.com", "alice@example.com", "bob@example.com", "carol@example.com", "dave@example.com"},
+		list(&pb.ListUsersRequest{PerPage: 2, Sort: "email"}))
+	assert.Equal(t,
+		[]string{"alice@example.com", "admin@example.com"},
+		list(&pb.ListUsersRequest{PerPage: 1, Sort: "-email", EmailPrefix: "A"}))
+	assert.Equal(t,
+		[]string{"alex@example.com"},
+		list(&pb.ListUsersRequest{Status: "deleted"}))
+
+	// Порядок по умолчанию - от новых к старым
+	all := list(&pb.ListUsersRequest{PerPage: 3})
+	assert.Len(t, all, 5)
+	assert.Equal(t, "admin@example.com", all[0])
+
+	resp, err := service.ListUsers(context.Background(), &pb.ListUsersRequest{EmailPrefix: "a", IncludeTotal: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), resp.Total)
+}
+
+func TestPolicy(t *testing.T) {
+	user := &authn.Principal{UserID: "1", Email: "user@example.com", Roles: []string{authz.RoleUser}}
+	admin := &authn.Principal{UserID: "2", Email: "admin@example.com", Roles: []string{authz.RoleAdmin}}
+	authService := &authn.Principal{UserID: "auth", ClientID: "auth", Roles: []string{authz.RoleService}, Scopes: []string{authz.ScopeUserAuthenticate}}
+	geoService := &authn.Principal{UserID: "geo", ClientID: "geo", Roles: []string{authz.RoleService}}
+
+	tests := []struct {
+		name      string
+		principal *authn.Principal
+		method    string
+		req       interface{}
+		code      codes.Code
+	}{
+		{"anonymous create user", nil, "/user.UserService/CreateUser", &pb.CreateUserRequest{}, codes.Unauthenticated},
+		{"user creates user", user, "/user.UserService/CreateUser", &pb.CreateUserRequest{}, codes.PermissionDenied},
+		{"auth creates user", authService, "/user.UserService/CreateUser", &pb.CreateUserRequest{}, codes.OK},
+		{"other service creates user", geoService, "/user.UserService/CreateUser", &pb.CreateUserRequest{}, codes.PermissionDenied},
+		{"user lists users", user, "/user.UserService/ListUsers", &pb.ListUsersRequest{}, codes.PermissionDenied},
+		{"admin lists users", admin, "/user.UserService/ListUsers", &pb.ListUsersRequest{}, codes.OK},
+		{"anonymous lists users", nil, "/user.UserService/ListUsers", &pb.ListUsersRequest{}, codes.Unauthenticated},
+		{"anonymous verifies credentials", nil, "/user.UserService/VerifyCredentials", &pb.VerifyCredentialsRequest{}, codes.Unauthenticated},
+		{"auth verifies credentials", authService, "/user.UserService/VerifyCredentials", &pb.VerifyCredentialsRequest{}, codes.OK},
+		{"other service verifies credentials", geoService, "/user.UserService/VerifyCredentials", &pb.VerifyCredentialsRequest{}, codes.PermissionDenied},
+		{"user gets profile", user, "/user.UserService/GetProfile", &pb.GetProfileRequest{}, codes.OK},
+		{"anonymous gets profile", nil, "/user.UserService/GetProfile", &pb.GetProfileRequest{}, codes.Unauthenticated},
+		{"anonymous enrolls TOTP", nil, "/user.UserService/EnrollTOTP", &pb.EnrollTOTPRequest{}, codes.Unauthenticated},
+		{"user enrolls TOTP", user, "/user.UserService/EnrollTOTP", &pb.EnrollTOTPRequest{}, codes.OK},
+		{"anonymous verifies TOTP", nil, "/user.UserService/VerifyTOTP", &pb.VerifyTOTPRequest{}, codes.Unauthenticated},
+		{"auth verifies TOTP", authService, "/user.UserService/VerifyTOTP", &pb.VerifyTOTPRequest{}, codes.OK},
+		{"other service verifies TOTP", geoService, "/user.UserService/VerifyTOTP", &pb.VerifyTOTPRequest{}, codes.PermissionDenied},
+		{"auth resolves identity", authService, "/user.UserService/ResolveExternalIdentity", &pb.ResolveExternalIdentityRequest{}, codes.OK},
+		{"other service resolves identity", geoService, "/user.UserService/ResolveExternalIdentity", &pb.ResolveExternalIdentityRequest{}, codes.PermissionDenied},
+		{"user token with all scopes creates user", &authn.Principal{UserID: "1", Roles: []string{authz.RoleUser}, Scopes: authz.Scopes}, "/user.UserService/CreateUser", &pb.CreateUserRequest{}, codes.PermissionDenied},
+		{"service gets profile", authService, "/user.UserService/GetProfile", &pb.GetProfileRequest{}, codes.PermissionDenied},
+		{"user updates account", user, "/user.UserService/UpdateUser", &pb.UpdateUserRequest{}, codes.OK},
+		{"user updates profile", user, "/user.UserService/UpdateProfile", &pb.UpdateProfileRequest{}, codes.OK},
+		{"service updates profile", authService, "/user.UserService/UpdateProfile", &pb.UpdateProfileRequest{}, codes.PermissionDenied},
+		{"user changes password", user, "/user.UserService/ChangePassword", &pb.ChangePasswordRequest{}, codes.OK},
+		{"user deletes account", user, "/user.UserService/DeleteUser", &pb.DeleteUserRequest{}, codes.OK},
+		{"anonymous deletes account", nil, "/user.UserService/DeleteUser", &pb.DeleteUserRequest{}, codes.Unauthenticated},
+		{"service deletes account", authService, "/user.UserService/DeleteUser", &pb.DeleteUserRequest{}, codes.PermissionDenied},
+		{"user exports data", user, "/user.UserService/ExportUserData", &pb.ExportUserDataRequest{}, codes.OK},
+		{"anonymous exports data", nil, "/user.UserService/ExportUserData", &pb.ExportUserDataRequest{}, codes.Unauthenticated},
+		{"user erases account", user, "/user.UserService/EraseUser", &pb.EraseUserRequest{}, codes.OK},
+		{"service erases account", authService, "/user.UserService/EraseUser", &pb.EraseUserRequest{}, codes.PermissionDenied},
+		{"user gets data job", user, "/user.UserService/GetDataJob", &pb.GetDataJobRequest{}, codes.OK},
+		{"user gets data export", user, "/user.UserService/GetDataExport", &pb.GetDataExportRequest{}, codes.OK},
+		{"user queries audit log", user, "/user.UserService/QueryAuditLog", &pb.QueryAuditLogRequest{}, codes.PermissionDenied},
+		{"admin queries audit log", admin, "/user.UserService/QueryAuditLog", &pb.QueryAuditLogRequest{}, codes.OK},
+		{"unknown method", admin, "/user.UserService/DropUsers", nil, codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = authn.NewContext(ctx, tt.principal)
+			}
+
+			err := Policy.Authorize(ctx, tt.method, tt.req)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
 func TestNew(t *testing.T) {
-	db, _, err := sqlmock.New()
+	store := repository.NewMemoryStore(nil)
+	m := &fakeMailer{}
+	secrets, err := secretbox.New(config.New().MFAEncryptionKey)
 	assert.NoError(t, err)
-	defer db.Close()
 
-	service := New(db)
+	passwords := testPasswords(t)
+
+	auditLog := audit.NewMemory("user")
+	service := New(store, m, secrets, passwords, nil, &MockAuthClient{}, &MockGeoClient{}, auditLog, config.New())
 	assert.NotNil(t, service)
-	assert.Equal(t, db, service.db)
+	assert.Equal(t, store.Users, service.users)
+	assert.Equal(t, store.Tokens, service.tokens)
+	assert.Equal(t, store.MFA, service.mfa)
+	assert.Equal(t, store.Identities, service.identities)
+	assert.Equal(t, store.DataJobs, service.dataJobs)
+	assert.Equal(t, store.Tx, service.tx)
+	assert.Equal(t, auditLog, service.auditLog)
+	assert.Equal(t, m, service.mailer)
+}
+
+func TestUserService_PasswordReset(t *testing.T) {
+	service, store := setupTest(t)
+	mails := service.mailer.(*fakeMailer)
+	user := createUser(t, store, "test@example.com", "old_hash")
+
+	t.Run("request for known email", func(t *testing.T) {
+		_, err := service.RequestPasswordReset(context.Background(), &pb.RequestPasswordResetRequest{
+			Email: "test@example.com",
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, mails.messages, 1)
+		assert.Equal(t, "test@example.com", mails.messages[0].To)
+		assert.Contains(t, mails.messages[0].Body, "http://localhost/reset-password?token=")
+	})
+
+	t.Run("request for unknown email", func(t *testing.T) {
+		_, err := service.RequestPasswordReset(context.Background(), &pb.RequestPasswordResetRequest{
+			Email: "nonexistent@example.com",
+		})
+
+		// Ответ тот же, что и для существующего адреса, но письмо не отправляется
+		assert.NoError(t, err)
+		assert.Len(t, mails.messages, 1)
+	})
+
+	t.Run("reset with valid token", func(t *testing.T) {
+		_, err := service.ResetPassword(context.Background(), &pb.ResetPasswordRequest{
+			Token:       mails.lastToken(t),
+			NewPassword: "new-password",
+		})
+
+		assert.NoError(t, err)
+
+		// Переход по ссылке подтверждает адрес
+		stored, err := store.Users.GetByID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.NotEqual(t, "old_hash", stored.Password)
+		assert.True(t, stored.EmailVerified)
+	})
+
+	t.Run("reset with used or expired token", func(t *testing.T) {
+		_, err := service.ResetPassword(context.Background(), &pb.ResetPasswordRequest{
+			Token:       mails.lastToken(t),
+			NewPassword: "new-password",
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("reset without password", func(t *testing.T) {
+		_, err := service.ResetPassword(context.Background(), &pb.ResetPasswordRequest{
+			Token: "token",
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestUserService_EmailVerification(t *testing.T) {
+	service, store := setupTest(t)
+	mails := service.mailer.(*fakeMailer)
+	user := createUser(t, store, "test@example.com", "hash")
+	ctx := authn.NewContext(context.Background(), &authn.Principal{UserID: user.ID, Email: "test@example.com"})
+
+	t.Run("send verification", func(t *testing.T) {
+		_, err := service.SendVerification(ctx, &pb.SendVerificationRequest{})
+
+		assert.NoError(t, err)
+		assert.Len(t, mails.messages, 1)
+		assert.Equal(t, "test@example.com", mails.messages[0].To)
+	})
+
+	t.Run("verify with valid token", func(t *testing.T) {
+		_, err := service.VerifyEmail(context.Background(), &pb.VerifyEmailRequest{Token: mails.lastToken(t)})
+
+		assert.NoError(t, err)
+		stored, err := store.Users.GetByID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.True(t, stored.EmailVerified)
+	})
+
+	t.Run("verify with invalid token", func(t *testing.T) {
+		_, err := service.VerifyEmail(context.Background(), &pb.VerifyEmailRequest{Token: "forged"})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("already verified", func(t *testing.T) {
+		_, err := service.SendVerification(ctx, &pb.SendVerificationRequest{})
+
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		assert.Len(t, mails.messages, 1)
+	})
+}
+
+func TestUserService_TOTP(t *testing.T) {
+	service, store := setupTest(t)
+	user := createUser(t, store, "test@example.com", "hash")
+	ctx := authn.NewContext(context.Background(), &authn.Principal{UserID: user.ID, Email: "test@example.com"})
+
+	var secret []byte
+	var recoveryCodes []string
+
+	t.Run("enroll", func(t *testing.T) {
+		resp, err := service.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{})
+
+		assert.NoError(t, err)
+		assert.Contains(t, resp.OtpauthUri, "otpauth://totp/DD:test@example.com?")
+		assert.Contains(t, resp.OtpauthUri, "secret="+resp.Secret)
+		assert.Len(t, resp.RecoveryCodes, recoveryCodeCount)
+		assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, resp.RecoveryCodes[0])
+
+		secret, err = recoveryEncoding.DecodeString(resp.Secret)
+		assert.NoError(t, err)
+		recoveryCodes = resp.RecoveryCodes
+	})
+
+	t.Run("confirm with wrong code", func(t *testing.T) {
+		resp, err := service.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{Code: "000000"})
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("confirm", func(t *testing.T) {
+		// Код предыдущего шага еще принимается, а текущий остается для проверки входа
+		_, err := service.ConfirmTOTP(ctx, &pb.ConfirmTOTPRequest{Code: totp.Code(secret, time.Now().Add(-30*time.Second))})
+
+		assert.NoError(t, err)
+		stored, err := store.Users.GetByID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.True(t, stored.MFAEnabled)
+	})
+
+	t.Run("enroll when already enabled", func(t *testing.T) {
+		resp, err := service.EnrollTOTP(ctx, &pb.EnrollTOTPRequest{})
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("verify code", func(t *testing.T) {
+		resp, err := service.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{
+			UserId: user.ID,
+			Code:   totp.Code(secret, time.Now()),
+		})
+
+		assert.NoError(t, err)
+		assert.False(t, resp.RecoveryCodeUsed)
+	})
+
+	t.Run("replayed code", func(t *testing.T) {
+		// Шаг уже использован: код не проходит проверку и ищется среди кодов восстановления
+		resp, err := service.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{
+			UserId: user.ID,
+			Code:   totp.Code(secret, time.Now()),
+		})
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("verify recovery code", func(t *testing.T) {
+		resp, err := service.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{
+			UserId: user.ID,
+			Code:   strings.ToUpper(recoveryCodes[0]),
+		})
+
+		assert.NoError(t, err)
+		assert.True(t, resp.RecoveryCodeUsed)
+		assert.Equal(t, int32(9), resp.RecoveryCodesLeft)
+	})
+
+	t.Run("reused recovery code", func(t *testing.T) {
+		resp, err := service.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{
+			UserId: user.ID,
+			Code:   recoveryCodes[0],
+		})
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("verify without MFA", func(t *testing.T) {
+		resp, err := service.VerifyTOTP(context.Background(), &pb.VerifyTOTPRequest{UserId: "456", Code: "123456"})
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+}
+
+func TestUserService_ResolveExternalIdentity(t *testing.T) {
+	req := func(emailVerified bool) *pb.ResolveExternalIdentityRequest {
+		return &pb.ResolveExternalIdentityRequest{
+			Provider:      "mock",
+			Subject:       "sub-1",
+			Email:         "test@example.com",
+			EmailVerified: emailVerified,
+		}
+	}
+
+	t.Run("linked identity", func(t *testing.T) {
+		service, store := setupTest(t)
+		user := createUser(t, store, "other@example.com", "hash")
+		enableMFA(t, store, user.ID)
+		assert.NoError(t, store.Identities.Link(context.Background(), &model.Identity{Provider: "mock", Subject: "sub-1", UserID: user.ID}))
+
+		resp, err := service.ResolveExternalIdentity(context.Background(), req(true))
+
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, resp.User.Id)
+		assert.True(t, resp.User.MfaEnabled)
+		assert.False(t, resp.Created)
+	})
+
+	t.Run("new user", func(t *testing.T) {
+		service, store := setupTest(t)
+
+		resp, err := service.ResolveExternalIdentity(context.Background(), req(true))
+
+		assert.NoError(t, err)
+		assert.True(t, resp.Created)
+		assert.True(t, resp.User.EmailVerified)
+
+		// Повторный вход находит пользователя по привязанной учетной записи
+		userID, err := store.Identities.Find(context.Background(), "mock", "sub-1")
+		assert.NoError(t, err)
+		assert.Equal(t, resp.User.Id, userID)
+		stored, err := store.Users.GetByID(context.Background(), userID)
+		assert.NoError(t, err)
+		assert.Empty(t, stored.Password)
+	})
+
+	t.Run("existing user with verified email", func(t *testing.T) {
+		service, store := setupTest(t)
+		user := createUser(t, store, "test@example.com", "hash")
+
+		resp, err := service.ResolveExternalIdentity(context.Background(), req(true))
+
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, resp.User.Id)
+		assert.True(t, resp.User.EmailVerified)
+		assert.False(t, resp.Created)
+	})
+
+	t.Run("existing user with unverified email", func(t *testing.T) {
+		service, store := setupTest(t)
+		createUser(t, store, "test@example.com", "hash")
+
+		resp, err := service.ResolveExternalIdentity(context.Background(), req(false))
+
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		_, err = store.Identities.Find(context.Background(), "mock", "sub-1")
+		assert.Equal(t, repository.ErrNotFound, err)
+	})
+}
+
+// withCaller возвращает контекст запроса пользователя с токеном в метаданных,
+// как его видит сервис после проверки токена
+func withCaller(principal *authn.Principal) context.Context {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer user-token"))
+	return authn.NewContext(ctx, principal)
+}
+
+// forwardsToken проверяет, что auth сервис вызван с токеном вызывающего
+func forwardsToken(ctx context.Context) bool {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	return ok && len(md.Get("authorization")) == 1 && md.Get("authorization")[0] == "Bearer user-token"
+}
+
+// staleUsers возвращает записи на версию старше сохраненной, как если бы
+// их изменили между чтением и обновлением
+type staleUsers struct {
+	repository.UserRepository
+}
+
+func (r staleUsers) GetByID(ctx context.Context, id string) (*model.User, error) {
+	user, err := r.UserRepository.GetByID(ctx, id)
+	if err == nil {
+		user.Version--
+	}
+	return user, err
+}
+
+func TestUserService_UpdateUser(t *testing.T) {
+	service, store := setupTest(t)
+	mails := service.mailer.(*fakeMailer)
+	user := createUser(t, store, "old@example.com", "hash")
+	ctx := withCaller(&authn.Principal{UserID: user.ID, Email: "old@example.com"})
+
+	t.Run("change email", func(t *testing.T) {
+		token, err := service.issueToken(context.Background(), user.ID, purposePasswordReset, time.Hour)
+		assert.NoError(t, err)
+
+		resp, err := service.UpdateUser(ctx, &pb.UpdateUserRequest{Email: " new@example.com "})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "new@example.com", resp.User.Email)
+		assert.False(t, resp.User.EmailVerified)
+
+		// Подтверждение уходит на новый адрес, уведомление - на старый
+		if assert.Len(t, mails.messages, 2) {
+			assert.Equal(t, "new@example.com", mails.messages[0].To)
+			assert.Contains(t, mails.messages[0].Body, "verify-email?token=")
+			assert.Equal(t, "old@example.com", mails.messages[1].To)
+			assert.Contains(t, mails.messages[1].Body, "new@example.com")
+		}
+
+		// Ссылки из писем на старый адрес больше не действуют
+		_, err = store.Tokens.Consume(context.Background(), hashToken(token), purposePasswordReset)
+		assert.Equal(t, repository.ErrInvalidToken, err)
+	})
+
+	t.Run("email taken", func(t *testing.T) {
+		createUser(t, store, "taken@example.com", "hash")
+
+		_, err := service.UpdateUser(ctx, &pb.UpdateUserRequest{Email: "taken@example.com"})
+
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	})
+
+	t.Run("concurrent modification", func(t *testing.T) {
+		service.users = staleUsers{store.Users}
+		defer func() { service.users = store.Users }()
+
+		_, err := service.UpdateUser(ctx, &pb.UpdateUserRequest{Email: "other@example.com"})
+
+		assert.Equal(t, codes.Aborted, status.Code(err))
+	})
+
+	t.Run("same email", func(t *testing.T) {
+		_, err := service.UpdateUser(ctx, &pb.UpdateUserRequest{Email: "New@Example.com"})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("empty email", func(t *testing.T) {
+		_, err := service.UpdateUser(ctx, &pb.UpdateUserRequest{Email: "  "})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		assert.NoError(t, store.Users.Delete(context.Background(), user.ID))
+
+		_, err := service.UpdateUser(ctx, &pb.UpdateUserRequest{Email: "other@example.com"})
+
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+func TestUserService_ChangePassword(t *testing.T) {
+	currentHash, err := testPasswords(t).Hash("Current-pass-1")
+	assert.NoError(t, err)
+
+	// setup создает пользователя с паролем hash и контекст его запроса
+	setup := func(t *testing.T, hash string) (*UserService, repository.Store, *model.User, context.Context) {
+		service, store := setupTest(t)
+		user := createUser(t, store, "test@example.com", hash)
+		return service, store, user, withCaller(&authn.Principal{UserID: user.ID, Email: "test@example.com"})
+	}
+
+	t.Run("successful change", func(t *testing.T) {
+		service, store, user, ctx := setup(t, currentHash)
+		authClient := service.authClient.(*MockAuthClient)
+		token, err := service.issueToken(context.Background(), user.ID, purposePasswordReset, time.Hour)
+		assert.NoError(t, err)
+
+		// Текущая сессия остается, остальные завершаются
+		authClient.On("RevokeAllSessions",
+			mock.MatchedBy(forwardsToken),
+			mock.MatchedBy(func(req *pb_auth.RevokeAllSessionsRequest) bool {
+				return req.UserId == user.ID && req.KeepCurrent && !req.RevokeApiKeys
+			}),
+		).Return(&pb_auth.RevokeAllSessionsResponse{}, nil).Once()
+
+		_, err = service.ChangePassword(ctx, &pb.ChangePasswordRequest{
+			CurrentPassword: "Current-pass-1",
+			NewPassword:     "Battery-staple-42",
+		})
+
+		assert.NoError(t, err)
+		authClient.AssertExpectations(t)
+
+		stored, err := store.Users.GetByID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.NotEqual(t, currentHash, stored.Password)
+		// Ссылка для сброса, выданная до смены пароля, больше не действует
+		_, err = store.Tokens.Consume(context.Background(), hashToken(token), purposePasswordReset)
+		assert.Equal(t, repository.ErrInvalidToken, err)
+	})
+
+	t.Run("wrong current password", func(t *testing.T) {
+		service, store, user, ctx := setup(t, currentHash)
+
+		_, err := service.ChangePassword(ctx, &pb.ChangePasswordRequest{
+			CurrentPassword: "Wrong-pass-1",
+			NewPassword:     "Battery-staple-42",
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		var fields []string
+		for _, detail := range status.Convert(err).Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+				for _, v := range badRequest.FieldViolations {
+					fields = append(fields, v.Field)
+				}
+			}
+		}
+		assert.Equal(t, []string{currentPasswordField}, fields)
+
+		stored, err := store.Users.GetByID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, currentHash, stored.Password)
+	})
+
+	t.Run("weak new password", func(t *testing.T) {
+		service, _, _, ctx := setup(t, currentHash)
+		service.passwordPolicy = password.Policy{MinLength: 10}
+
+		_, err := service.ChangePassword(ctx, &pb.ChangePasswordRequest{
+			CurrentPassword: "Current-pass-1",
+			NewPassword:     "short",
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		badRequest, ok := status.Convert(err).Details()[0].(*errdetails.BadRequest)
+		if assert.True(t, ok) {
+			assert.Equal(t, newPasswordField, badRequest.FieldViolations[0].Field)
+		}
+	})
+
+	t.Run("no password set", func(t *testing.T) {
+		service, _, _, ctx := setup(t, "")
+
+		_, err := service.ChangePassword(ctx, &pb.ChangePasswordRequest{
+			CurrentPassword: "anything",
+			NewPassword:     "Battery-staple-42",
+		})
+
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("sessions not revoked", func(t *testing.T) {
+		service, _, _, ctx := setup(t, currentHash)
+		authClient := service.authClient.(*MockAuthClient)
+
+		authClient.On("RevokeAllSessions", mock.Anything, mock.Anything).
+			Return(nil, status.Error(codes.Unavailable, "auth is down")).Once()
+
+		_, err := service.ChangePassword(ctx, &pb.ChangePasswordRequest{
+			CurrentPassword: "Current-pass-1",
+			NewPassword:     "Battery-staple-42",
+		})
+
+		assert.Equal(t, codes.Internal, status.Code(err))
+	})
+}
+
+func TestUserService_DeleteUser(t *testing.T) {
+	admin := &authn.Principal{UserID: "1", Email: "admin@example.com", Roles: []string{authz.RoleAdmin}}
+	principal := func(user *model.User) *authn.Principal {
+		return &authn.Principal{UserID: user.ID, Email: user.Email, Roles: []string{authz.RoleUser}}
+	}
+	revokesAll := func(userID string) interface{} {
+		return mock.MatchedBy(func(req *pb_auth.RevokeAllSessionsRequest) bool {
+			return req.UserId == userID && !req.KeepCurrent && req.RevokeApiKeys
+		})
+	}
+
+	t.Run("delete own account", func(t *testing.T) {
+		service, store := setupTest(t)
+		authClient := service.authClient.(*MockAuthClient)
+		user := createUser(t, store, "test@example.com", "hash")
+		assert.NoError(t, store.Identities.Link(context.Background(), &model.Identity{Provider: "google", Subject: "g-1", UserID: user.ID}))
+		token, err := service.issueToken(context.Background(), user.ID, purposePasswordReset, time.Hour)
+		assert.NoError(t, err)
+
+		authClient.On("RevokeAllSessions", mock.MatchedBy(forwardsToken), revokesAll(user.ID)).
+			Return(&pb_auth.RevokeAllSessionsResponse{}, nil).Once()
+
+		_, err = service.DeleteUser(withCaller(principal(user)), &pb.DeleteUserRequest{})
+
+		assert.NoError(t, err)
+		authClient.AssertExpectations(t)
+
+		_, err = store.Users.GetByID(context.Background(), user.ID)
+		assert.Equal(t, repository.ErrNotFound, err)
+		// Учетные записи провайдеров отвязываются, ссылки из писем не действуют
+		_, err = store.Identities.Find(context.Background(), "google", "g-1")
+		assert.Equal(t, repository.ErrNotFound, err)
+		_, err = store.Tokens.Consume(context.Background(), hashToken(token), purposePasswordReset)
+		assert.Equal(t, repository.ErrInvalidToken, err)
+	})
+
+	t.Run("admin deletes another account", func(t *testing.T) {
+		service, store := setupTest(t)
+		authClient := service.authClient.(*MockAuthClient)
+		user := createUser(t, store, "test@example.com", "hash")
+
+		authClient.On("RevokeAllSessions", mock.Anything, revokesAll(user.ID)).
+			Return(&pb_auth.RevokeAllSessionsResponse{}, nil).Once()
+
+		_, err := service.DeleteUser(withCaller(admin), &pb.DeleteUserRequest{UserId: user.ID})
+
+		assert.NoError(t, err)
+		authClient.AssertExpectations(t)
+		_, err = store.Users.GetByID(context.Background(), user.ID)
+		assert.Equal(t, repository.ErrNotFound, err)
+	})
+
+	t.Run("user deletes another account", func(t *testing.T) {
+		service, store := setupTest(t)
+		user := createUser(t, store, "test@example.com", "hash")
+		other := createUser(t, store, "other@example.com", "hash")
+
+		_, err := service.DeleteUser(withCaller(principal(user)), &pb.DeleteUserRequest{UserId: other.ID})
+
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = store.Users.GetByID(context.Background(), other.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("already deleted", func(t *testing.T) {
+		service, store := setupTest(t)
+		authClient := service.authClient.(*MockAuthClient)
+		user := createUser(t, store, "test@example.com", "hash")
+		assert.NoError(t, store.Users.Delete(context.Background(), user.ID))
+
+		authClient.On("RevokeAllSessions", mock.Anything, revokesAll(user.ID)).
+			Return(&pb_auth.RevokeAllSessionsResponse{}, nil).Once()
+
+		_, err := service.DeleteUser(withCaller(admin), &pb.DeleteUserRequest{UserId: user.ID})
+
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("sessions not revoked", func(t *testing.T) {
+		service, store := setupTest(t)
+		authClient := service.authClient.(*MockAuthClient)
+		user := createUser(t, store, "test@example.com", "hash")
+
+		// Учетная запись не удаляется, пока живы ее сессии
+		authClient.On("RevokeAllSessions", mock.Anything, mock.Anything).
+			Return(nil, status.Error(codes.Unavailable, "auth is down")).Once()
+
+		_, err := service.DeleteUser(withCaller(principal(user)), &pb.DeleteUserRequest{})
+
+		assert.Equal(t, codes.Internal, status.Code(err))
+		_, err = store.Users.GetByID(context.Background(), user.ID)
+		assert.NoError(t, err)
+	})
+}
+
+func TestUserService_ReleaseDeletedEmails(t *testing.T) {
+	service, store := setupTest(t)
+	ctx := context.Background()
+
+	active := createUser(t, store, "active@example.com", "hash")
+	deleted := createUser(t, store, "deleted@example.com", "hash")
+	assert.NoError(t, store.Users.Delete(ctx, deleted.ID))
+
+	t.Run("grace period", func(t *testing.T) {
+		service.deletedUserGracePeriod = 30 * 24 * time.Hour
+
+		n, err := service.ReleaseDeletedEmails(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		service.deletedUserGracePeriod = 0
+
+		n, err := service.ReleaseDeletedEmails(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		// Освобожденный email можно зарегистрировать снова, повторный проход ничего не меняет
+		createUser(t, store, "deleted@example.com", "")
+		n, err = service.ReleaseDeletedEmails(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, n)
+
+		stored, err := store.Users.GetByID(ctx, active.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "hash", stored.Password)
+	})
+}
+
+func TestUserService_UpdateProfile(t *testing.T) {
+	service, store := setupTest(t)
+	users := store.Users
+
+	user := &model.User{Email: "test@example.com", Password: "hash"}
+	assert.NoError(t, users.Create(context.Background(), user))
+	ctx := authn.NewContext(context.Background(), &authn.Principal{UserID: user.ID, Email: user.Email})
+	mask := func(paths ...string) *fieldmaskpb.FieldMask {
+		return &fieldmaskpb.FieldMask{Paths: paths}
+	}
+
+	t.Run("partial update", func(t *testing.T) {
+		resp, err := service.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			Profile: &pb.Profile{
+				DisplayName: "  Test User ",
+				Phone:       "not in the mask",
+				Locale:      "ru-ru",
+				HomeAddress: &pb.Address{City: "Москва", Street: "Тверская", House: "1", Lat: "55.7558", Lon: "37.6173"},
+			},
+			UpdateMask: mask("display_name", "locale", "home_address"),
+			Version:    1,
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), resp.User.Version)
+		assert.Equal(t, "Test User", resp.User.DisplayName)
+		assert.Equal(t, "Москва", resp.User.HomeAddress.GetCity())
+
+		// Меняются только поля из маски
+		stored, err := users.GetByID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "ru-RU", stored.Locale)
+		assert.Empty(t, stored.Phone)
+		assert.Equal(t, "test@example.com", stored.Email)
+	})
+
+	t.Run("clear address", func(t *testing.T) {
+		resp, err := service.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			Profile:    &pb.Profile{},
+			UpdateMask: mask("home_address"),
+			Version:    2,
+		})
+
+		assert.NoError(t, err)
+		assert.Nil(t, resp.User.HomeAddress)
+		assert.Equal(t, "Test User", resp.User.DisplayName)
+	})
+
+	t.Run("stale version", func(t *testing.T) {
+		_, err := service.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			Profile:    &pb.Profile{Timezone: "Europe/Moscow"},
+			UpdateMask: mask("timezone"),
+			Version:    1,
+		})
+
+		assert.Equal(t, codes.Aborted, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "current version is 3")
+	})
+
+	t.Run("deleted user", func(t *testing.T) {
+		deleted := &model.User{Email: "deleted@example.com"}
+		assert.NoError(t, users.Create(context.Background(), deleted))
+		assert.NoError(t, users.Delete(context.Background(), deleted.ID))
+
+		_, err := service.UpdateProfile(authn.NewContext(context.Background(), &authn.Principal{UserID: deleted.ID}), &pb.UpdateProfileRequest{
+			Profile:    &pb.Profile{Timezone: "UTC"},
+			UpdateMask: mask("timezone"),
+			Version:    1,
+		})
+
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("invalid fields", func(t *testing.T) {
+		_, err := service.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			Profile: &pb.Profile{
+				Phone:     "8 999 123-45-67",
+				Locale:    "not a locale!",
+				Timezone:  "Mars/Olympus",
+				AvatarUrl: "javascript:alert(1)",
+			},
+			UpdateMask: mask("phone", "locale", "timezone", "avatar_url", "email"),
+			Version:    5,
+		})
+
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		var fields []string
+		for _, detail := range status.Convert(err).Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+				for _, v := range badRequest.FieldViolations {
+					fields = append(fields, v.Field)
+				}
+			}
+		}
+		assert.Equal(t, []string{"phone", "locale", "timezone", "avatar_url", "update_mask"}, fields)
+	})
+
+	t.Run("missing mask or version", func(t *testing.T) {
+		_, err := service.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			Profile: &pb.Profile{DisplayName: "Test"},
+			Version: 5,
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = service.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			Profile:    &pb.Profile{DisplayName: "Test"},
+			UpdateMask: mask("display_name"),
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestUserService_Events(t *testing.T) {
+	broker := outbox.NewMemoryBroker()
+	store := repository.NewMemoryStore(broker)
+	service := newTestService(t, store)
+	users := store.Users
+	ctx := context.Background()
+
+	resp, err := service.CreateUser(ctx, &pb.CreateUserRequest{
+		Email:    "test@example.com",
+		Password: "Correct-Horse-42",
+	})
+	assert.NoError(t, err)
+
+	user, err := users.GetByID(ctx, resp.User.Id)
+	assert.NoError(t, err)
+	user.Email = "new@example.com"
+	assert.NoError(t, users.Update(ctx, user))
+	assert.NoError(t, users.Delete(ctx, resp.User.Id))
+	assert.NoError(t, users.Erase(ctx, resp.User.Id))
+
+	erased, err := users.GetIncludingDeleted(ctx, resp.User.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "erased:"+resp.User.Id, erased.Email)
+	assert.Empty(t, erased.Password)
+
+	var types []string
+	for _, event := range broker.Events() {
+		assert.Equal(t, resp.User.Id, event.UserID)
+		types = append(types, event.Type)
+	}
+	assert.Equal(t, []string{outbox.TypeUserCreated, outbox.TypeUserEmailChanged, outbox.TypeUserDeleted, outbox.TypeUserErased}, types)
+	assert.JSONEq(t, `{"old_email":"test@example.com","new_email":"new@example.com"}`, string(broker.Events()[1].Payload))
+
+	t.Run("redelivery after handler error", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// Первая попытка обработать user.email_changed завершается ошибкой
+		var handled []string
+		failed := false
+		go outbox.Consume(ctx, broker, "mailer", "mailer-1", func(ctx context.Context, event outbox.Event) error {
+			if event.Type == outbox.TypeUserEmailChanged && !failed {
+				failed = true
+				return errors.New("temporary failure")
+			}
+			handled = append(handled, event.Type)
+			if len(handled) == len(types) {
+				cancel()
+			}
+			return nil
+		})
+		<-ctx.Done()
+		assert.Equal(t, types, handled)
+
+		// Подтвержденные события группе больше не выдаются, другой группе - выдаются
+		messages, err := broker.Fetch(context.Background(), "mailer", "mailer-1", 10)
+		assert.NoError(t, err)
+		assert.Empty(t, messages)
+		messages, err = broker.Fetch(context.Background(), "audit", "audit-1", 10)
+		assert.NoError(t, err)
+		assert.Len(t, messages, len(types))
+	})
+
+}
+
+func TestUserService_DataJobs(t *testing.T) {
+	service, store := setupTest(t)
+	mockAuth := service.authClient.(*MockAuthClient)
+	mockGeo := service.geoClient.(*MockGeoClient)
+	ctx := context.Background()
+
+	owner := createUser(t, store, "test@example.com", "hashed_password")
+	owner.DisplayName = "Test"
+	assert.NoError(t, store.Users.Update(ctx, owner))
+	other := createUser(t, store, "other@example.com", "hash")
+
+	user := &authn.Principal{UserID: owner.ID, Email: "test@example.com", Roles: []string{authz.RoleUser}}
+	admin := &authn.Principal{UserID: "999", Email: "admin@example.com", Roles: []string{authz.RoleAdmin}}
+
+	t.Run("no pending jobs", func(t *testing.T) {
+		ok, err := service.ProcessDataJob(ctx)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	var exportJob, eraseJob *pb.DataJob
+
+	t.Run("user exports own data", func(t *testing.T) {
+		resp, err := service.ExportUserData(authn.NewContext(ctx, user), &pb.ExportUserDataRequest{})
+		assert.NoError(t, err)
+		exportJob = resp.Job
+		assert.Equal(t, dataJobExport, resp.Job.Type)
+		assert.Equal(t, model.DataJobPending, resp.Job.Status)
+		assert.NotEmpty(t, resp.Job.CreatedAt)
+		assert.Empty(t, resp.Job.FinishedAt)
+	})
+
+	t.Run("active job is reused", func(t *testing.T) {
+		resp, err := service.ExportUserData(authn.NewContext(ctx, user), &pb.ExportUserDataRequest{})
+		assert.NoError(t, err)
+		assert.Equal(t, exportJob.Id, resp.Job.Id)
+	})
+
+	t.Run("user cannot erase another user", func(t *testing.T) {
+		_, err := service.EraseUser(authn.NewContext(ctx, user), &pb.EraseUserRequest{UserId: other.ID})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("admin erases user", func(t *testing.T) {
+		// Время создания задач различается, и очередь выдает их по порядку
+		time.Sleep(time.Millisecond)
+		resp, err := service.EraseUser(authn.NewContext(ctx, admin), &pb.EraseUserRequest{UserId: other.ID})
+		assert.NoError(t, err)
+		eraseJob = resp.Job
+		assert.Equal(t, dataJobErase, resp.Job.Type)
+		assert.NotEqual(t, exportJob.Id, resp.Job.Id)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		_, err := service.ExportUserData(authn.NewContext(ctx, admin), &pb.ExportUserDataRequest{UserId: "404"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("job of another user is hidden", func(t *testing.T) {
+		_, err := service.GetDataJob(authn.NewContext(ctx, user), &pb.GetDataJobRequest{Id: eraseJob.Id})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("export is not ready", func(t *testing.T) {
+		_, err := service.GetDataExport(authn.NewContext(ctx, user), &pb.GetDataExportRequest{JobId: exportJob.Id})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	})
+
+	t.Run("process export", func(t *testing.T) {
+		assert.NoError(t, store.Identities.Link(ctx, &model.Identity{Provider: "google", Subject: "g-1", UserID: owner.ID, Email: "test@gmail.com"}))
+		mockAuth.On("ExportUserData", mock.Anything, &pb_auth.ExportUserDataRequest{UserId: owner.ID}).
+			Return(&pb_auth.ExportUserDataResponse{Sessions: []*pb_auth.Session{{Id: "s-1"}}}, nil).Once()
+		mockGeo.On("ExportUserData", mock.Anything, &pb_geo.ExportUserDataRequest{UserId: owner.ID}).
+			Return(&pb_geo.ExportUserDataResponse{QuotaUsage: []*pb_geo.QuotaUsage{{Date: "2024-03-01", Requests: 7}}}, nil).Once()
+
+		ok, err := service.ProcessDataJob(ctx)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		mockAuth.AssertExpectations(t)
+		mockGeo.AssertExpectations(t)
+
+		job, err := store.DataJobs.Get(ctx, exportJob.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, model.DataJobSucceeded, job.Status)
+		assert.NotNil(t, job.ExpiresAt)
+
+		resp, err := service.GetDataExport(authn.NewContext(ctx, user), &pb.GetDataExportRequest{JobId: exportJob.Id})
+		assert.NoError(t, err)
+		data := string(resp.Archive)
+		assert.Contains(t, data, `"email":"test@example.com"`)
+		assert.Contains(t, data, `"provider":"google"`)
+		assert.Contains(t, data, `"sessions":[{"id":"s-1"}]`)
+		assert.Contains(t, data, `"quota_usage":[{"date":"2024-03-01","requests":7}]`)
+		assert.NotContains(t, data, "hashed_password")
+	})
+
+	t.Run("expired export", func(t *testing.T) {
+		expired := time.Now().Add(-time.Minute)
+		assert.NoError(t, store.DataJobs.Complete(ctx, exportJob.Id, []byte(`{}`), &expired))
+
+		n, err := service.ExpireDataExports(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+
+		_, err = service.GetDataExport(authn.NewContext(ctx, user), &pb.GetDataExportRequest{JobId: exportJob.Id})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("erase is retried after failure", func(t *testing.T) {
+		assert.NoError(t, store.Identities.Link(ctx, &model.Identity{Provider: "google", Subject: "g-2", UserID: other.ID}))
+		enableMFA(t, store, other.ID)
+
+		// Первая попытка обезличивает запись, но geo недоступен
+		mockAuth.On("PurgeUserData", mock.Anything, &pb_auth.PurgeUserDataRequest{UserId: other.ID}).
+			Return(&pb_auth.PurgeUserDataResponse{Sessions: 2}, nil).Twice()
+		mockGeo.On("PurgeUserData", mock.Anything, &pb_geo.PurgeUserDataRequest{UserId: other.ID}).
+			Return(nil, status.Error(codes.Unavailable, "geo is down")).Once()
+
+		ok, err := service.ProcessDataJob(ctx)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		job, err := store.DataJobs.Get(ctx, eraseJob.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, model.DataJobPending, job.Status)
+		assert.Contains(t, job.Error, "geo is down")
+
+		erased, err := store.Users.GetIncludingDeleted(ctx, other.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "erased:"+other.ID, erased.Email)
+		_, err = store.Identities.Find(ctx, "google", "g-2")
+		assert.Equal(t, repository.ErrNotFound, err)
+		_, err = store.MFA.Get(ctx, other.ID)
+		assert.Equal(t, repository.ErrMFANotEnrolled, err)
+
+		// Повторная попытка не трогает уже обезличенную запись и дочищает сервисы
+		mockGeo.On("PurgeUserData", mock.Anything, &pb_geo.PurgeUserDataRequest{UserId: other.ID}).
+			Return(&pb_geo.PurgeUserDataResponse{Lookups: 3}, nil).Once()
+
+		ok, err = service.ProcessDataJob(ctx)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		mockAuth.AssertExpectations(t)
+		mockGeo.AssertExpectations(t)
+
+		job, err = store.DataJobs.Get(ctx, eraseJob.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, model.DataJobSucceeded, job.Status)
+		unchanged, err := store.Users.GetIncludingDeleted(ctx, other.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, erased.Version, unchanged.Version)
+	})
+
+	t.Run("job fails after last attempt", func(t *testing.T) {
+		job, err := store.DataJobs.Enqueue(ctx, "unknown", owner.ID, owner.ID)
+		assert.NoError(t, err)
+
+		for i := 0; i < maxDataJobAttempts; i++ {
+			ok, err := service.ProcessDataJob(ctx)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+		}
+
+		job, err = store.DataJobs.Get(ctx, job.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, model.DataJobFailed, job.Status)
+		assert.Contains(t, job.Error, "unknown job type")
+		assert.NotNil(t, job.FinishedAt)
+
+		ok, err := service.ProcessDataJob(ctx)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestUserService_AuditLog(t *testing.T) {
+	service, store := setupTest(t)
+	auditLog := service.auditLog.(*audit.Memory)
+
+	currentHash, err := testPasswords(t).Hash("Current-pass-1")
+	assert.NoError(t, err)
+	user := createUser(t, store, "test@example.com", currentHash)
+
+	// Адрес и User-Agent клиента передает proxy
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"authorization", "Bearer user-token",
+		"x-client-ip", "203.0.113.7",
+		"x-user-agent", "Firefox",
+	))
+	ctx = authn.NewContext(ctx, &authn.Principal{UserID: user.ID, Email: "test@example.com"})
+	ctx = authn.NewServiceContext(ctx, "proxy")
+
+	_, err = service.ChangePassword(ctx, &pb.ChangePasswordRequest{
+		CurrentPassword: "Wrong-pass-1",
+		NewPassword:     "Battery-staple-42",
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	events := auditLog.Events()
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, audit.ActionPasswordChange, events[0].Action)
+		assert.Equal(t, audit.ResultFailure, events[0].Result)
+		assert.Equal(t, user.ID, events[0].Actor)
+		assert.Equal(t, audit.ActorUser, events[0].ActorType)
+		assert.Equal(t, user.ID, events[0].Target)
+		assert.Equal(t, "203.0.113.7", events[0].IP)
+		assert.Equal(t, "Firefox", events[0].UserAgent)
+		assert.Equal(t, "user", events[0].Service)
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, auditLog.Record(context.Background(), audit.Event{Actor: user.ID, Action: audit.ActionLogin, Target: user.ID}))
+	}
+	assert.NoError(t, auditLog.Record(context.Background(), audit.Event{Actor: "456", Action: audit.ActionLogin, Target: "456"}))
+
+	admin := authn.NewContext(context.Background(), &authn.Principal{UserID: "999", Roles: []string{authz.RoleAdmin}})
+
+	t.Run("pages by cursor", func(t *testing.T) {
+		resp, err := service.QueryAuditLog(admin, &pb.QueryAuditLogRequest{Target: user.ID, PerPage: 3})
+		assert.NoError(t, err)
+		if !assert.Len(t, resp.Events, 3) {
+			return
+		}
+		assert.Equal(t, []int64{4, 3, 2}, []int64{resp.Events[0].Id, resp.Events[1].Id, resp.Events[2].Id})
+		assert.Equal(t, resp.Events[1].Hash, resp.Events[0].PrevHash)
+		assert.NotEmpty(t, resp.NextCursor)
+
+		resp, err = service.QueryAuditLog(admin, &pb.QueryAuditLogRequest{Target: user.ID, PerPage: 3, Cursor: resp.NextCursor})
+		assert.NoError(t, err)
+		if assert.Len(t, resp.Events, 1) {
+			assert.Equal(t, audit.ActionPasswordChange, resp.Events[0].Action)
+		}
+		assert.Empty(t, resp.NextCursor)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		resp, err := service.QueryAuditLog(admin, &pb.QueryAuditLogRequest{Action: audit.ActionLogin, Actor: "456"})
+		assert.NoError(t, err)
+		if assert.Len(t, resp.Events, 1) {
+			assert.Equal(t, "456", resp.Events[0].Target)
+		}
+
+		resp, err = service.QueryAuditLog(admin, &pb.QueryAuditLogRequest{Result: audit.ResultFailure})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Events, 1)
+	})
+
+	t.Run("query is audited", func(t *testing.T) {
+		events := auditLog.Events()
+		last := events[len(events)-1]
+		assert.Equal(t, audit.ActionAuditQuery, last.Action)
+		assert.Equal(t, "999", last.Actor)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		_, err := service.QueryAuditLog(admin, &pb.QueryAuditLogRequest{
+			Result: "maybe",
+			Since:  "yesterday",
+			Cursor: "not a cursor",
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		var fields []string
+		for _, detail := range status.Convert(err).Details() {
+			if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+				for _, v := range badRequest.FieldViolations {
+					fields = append(fields, v.Field)
+				}
+			}
+		}
+		assert.ElementsMatch(t, []string{"result", "since", "cursor"}, fields)
+	})
 }
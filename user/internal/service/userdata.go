@@ -0,0 +1,367 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"dd/pkg/audit"
+	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+	pb_geo "dd/pkg/geo"
+	pb "dd/pkg/user"
+	"dd/user/internal/model"
+	"dd/user/internal/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Задачи выгрузки и стирания данных пользователя выполняются в фоне, см. RunDataJobs
+const (
+	dataJobExport = "export"
+	dataJobErase  = "erase"
+
+	// maxDataJobAttempts - сколько раз задача запускается, прежде чем считается неудачной
+	maxDataJobAttempts = 3
+	// dataJobTimeout - через сколько выполняемая задача считается брошенной
+	// остановленным экземпляром сервиса и запускается снова
+	dataJobTimeout = 10 * time.Minute
+)
+
+func dataJobProto(job *model.DataJob) *pb.DataJob {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	return &pb.DataJob{
+		Id:         job.ID,
+		Type:       job.Type,
+		UserId:     job.UserID,
+		Status:     job.Status,
+		Error:      job.Error,
+		CreatedAt:  job.CreatedAt.UTC().Format(time.RFC3339),
+		FinishedAt: formatTime(job.FinishedAt),
+		ExpiresAt:  formatTime(job.ExpiresAt),
+	}
+}
+
+// ExportUserData ставит в очередь выгрузку данных пользователя. Архив
+// собирается в фоне; его готовность проверяется через GetDataJob.
+func (s *UserService) ExportUserData(ctx context.Context, req *pb.ExportUserDataRequest) (*pb.ExportUserDataResponse, error) {
+	job, err := s.enqueueDataJob(ctx, dataJobExport, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ExportUserDataResponse{Job: dataJobProto(job)}, nil
+}
+
+// EraseUser ставит в очередь стирание учетной записи. Стереть можно и уже
+// удаленную учетную запись. После стирания владелец не сможет войти, поэтому
+// статус задачи, запрошенной им самим, сможет проверить только администратор.
+func (s *UserService) EraseUser(ctx context.Context, req *pb.EraseUserRequest) (*pb.EraseUserResponse, error) {
+	job, err := s.enqueueDataJob(ctx, dataJobErase, req.UserId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.EraseUserResponse{Job: dataJobProto(job)}, nil
+}
+
+// GetDataJob возвращает состояние задачи. Задача видна пользователю, чьи
+// данные она обрабатывает, тому, кто ее запросил, и администратору.
+func (s *UserService) GetDataJob(ctx context.Context, req *pb.GetDataJobRequest) (*pb.GetDataJobResponse, error) {
+	job, err := s.getDataJob(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GetDataJobResponse{Job: dataJobProto(job)}, nil
+}
+
+// GetDataExport возвращает архив завершенной выгрузки
+func (s *UserService) GetDataExport(ctx context.Context, req *pb.GetDataExportRequest) (*pb.GetDataExportResponse, error) {
+	job, err := s.getDataJob(ctx, req.JobId)
+	if err != nil {
+		return nil, err
+	}
+	if job.Type != dataJobExport {
+		return nil, status.Errorf(codes.InvalidArgument, "job is not an export")
+	}
+	if job.Status != model.DataJobSucceeded {
+		return nil, status.Errorf(codes.FailedPrecondition, "export is %s", job.Status)
+	}
+
+	archive, err := s.dataJobs.Archive(ctx, job.ID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// Архив удаляется по истечении срока хранения, см. ExpireDataExports
+	if archive == nil {
+		return nil, status.Errorf(codes.NotFound, "export has expired")
+	}
+
+	s.record(ctx, audit.Event{Action: audit.ActionDataExport, Target: job.UserID, Reason: "archive of job " + job.ID + " downloaded"})
+
+	return &pb.GetDataExportResponse{Archive: archive}, nil
+}
+
+// enqueueDataJob создает задачу для пользователя userID, а если такая задача
+// уже ждет или выполняется, возвращает ее. Пустой userID - вызывающий.
+func (s *UserService) enqueueDataJob(ctx context.Context, jobType, userID string) (*model.DataJob, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID == "" {
+		userID = principal.UserID
+	}
+	if userID != principal.UserID && !principal.HasRole(authz.RoleAdmin) {
+		s.record(ctx, audit.Event{Action: dataJobAction(jobType), Target: userID, Result: audit.ResultDenied})
+		return nil, status.Errorf(codes.PermissionDenied, "cannot access data of another user")
+	}
+
+	_, err = s.users.GetIncludingDeleted(ctx, userID)
+	if err == repository.ErrNotFound {
+		return nil, status.Errorf(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	job, err := s.dataJobs.Enqueue(ctx, jobType, userID, principal.UserID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Printf("User %s requested %s of user %s, job %s", principal.UserID, jobType, userID, job.ID)
+	s.record(ctx, audit.Event{Action: dataJobAction(jobType), Target: userID, Reason: "job " + job.ID + " requested"})
+
+	return job, nil
+}
+
+// getDataJob возвращает задачу, если вызывающий вправе ее видеть.
+// Чужая задача неотличима от несуществующей.
+func (s *UserService) getDataJob(ctx context.Context, id string) (*model.DataJob, error) {
+	principal, err := authn.Require(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "job id is required")
+	}
+
+	job, err := s.dataJobs.Get(ctx, id)
+	if err == repository.ErrJobNotFound {
+		return nil, status.Errorf(codes.NotFound, "job not found")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if job.UserID != principal.UserID && job.RequestedBy != principal.UserID && !principal.HasRole(authz.RoleAdmin) {
+		return nil, status.Errorf(codes.NotFound, "job not found")
+	}
+	return job, nil
+}
+
+// RunDataJobs выполняет задачи выгрузки и стирания данных и удаляет архивы
+// с истекшим сроком хранения, пока не будет отменен ctx
+func (s *UserService) RunDataJobs(ctx context.Context) {
+	ticker := time.NewTicker(s.dataJobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			ok, err := s.ProcessDataJob(ctx)
+			if err != nil {
+				log.Printf("Failed to process data job: %v", err)
+				break
+			}
+			if !ok {
+				break
+			}
+		}
+
+		if _, err := s.ExpireDataExports(ctx); err != nil {
+			log.Printf("Failed to expire data exports: %v", err)
+		}
+	}
+}
+
+// ProcessDataJob выполняет одну задачу из очереди и сообщает, была ли она.
+// Задачу берет только один экземпляр сервиса; неудачная задача возвращается
+// в очередь, пока не исчерпает maxDataJobAttempts попыток.
+func (s *UserService) ProcessDataJob(ctx context.Context) (bool, error) {
+	job, err := s.dataJobs.Take(ctx, time.Now().Add(-dataJobTimeout))
+	if err == repository.ErrJobNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var archive []byte
+	switch job.Type {
+	case dataJobExport:
+		archive, err = s.exportUserData(ctx, job.UserID)
+	case dataJobErase:
+		err = s.eraseUser(ctx, job)
+	default:
+		err = fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	if err != nil {
+		log.Printf("Data job %s (%s of user %s) failed, attempt %d: %v", job.ID, job.Type, job.UserID, job.Attempts, err)
+		finish := s.dataJobs.Retry
+		if job.Attempts >= maxDataJobAttempts {
+			finish = s.dataJobs.Fail
+		}
+		return true, finish(ctx, job.ID, err.Error())
+	}
+
+	var expiresAt *time.Time
+	if archive != nil {
+		t := time.Now().Add(s.dataExportRetention)
+		expiresAt = &t
+	}
+	if err := s.dataJobs.Complete(ctx, job.ID, archive, expiresAt); err != nil {
+		return true, err
+	}
+
+	log.Printf("Data job %s (%s of user %s) succeeded", job.ID, job.Type, job.UserID)
+
+	return true, nil
+}
+
+// ExpireDataExports удаляет архивы выгрузок с истекшим сроком хранения и
+// возвращает их число. Сами задачи остаются для истории.
+func (s *UserService) ExpireDataExports(ctx context.Context) (int64, error) {
+	return s.dataJobs.ExpireArchives(ctx)
+}
+
+// dataExport - архив выгрузки данных пользователя
+type dataExport struct {
+	ExportedAt time.Time          `json:"exported_at"`
+	User       *model.User        `json:"user"`
+	Identities []exportedIdentity `json:"identities"`
+	Sessions   []*pb_auth.Session `json:"sessions"`
+	APIKeys    []*pb_auth.APIKey  `json:"api_keys"`
+	Geo        exportedGeo        `json:"geo"`
+}
+
+type exportedIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportedGeo struct {
+	Lookups    []*pb_geo.CachedLookup `json:"lookups"`
+	QuotaUsage []*pb_geo.QuotaUsage   `json:"quota_usage"`
+}
+
+// exportUserData собирает данные пользователя из базы, auth и geo сервисов.
+// Секреты - хэш пароля, секрет TOTP, коды восстановления - не выгружаются.
+func (s *UserService) exportUserData(ctx context.Context, userID string) ([]byte, error) {
+	user, err := s.users.GetIncludingDeleted(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	export := dataExport{
+		ExportedAt: time.Now().UTC(),
+		User:       user,
+		Identities: []exportedIdentity{},
+	}
+
+	identities, err := s.identities.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		export.Identities = append(export.Identities, exportedIdentity{
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			Email:     identity.Email,
+			CreatedAt: identity.CreatedAt,
+		})
+	}
+
+	authData, err := s.authClient.ExportUserData(ctx, &pb_auth.ExportUserDataRequest{UserId: userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export auth data: %v", err)
+	}
+	export.Sessions = authData.Sessions
+	export.APIKeys = authData.ApiKeys
+
+	geoData, err := s.geoClient.ExportUserData(ctx, &pb_geo.ExportUserDataRequest{UserId: userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export geo data: %v", err)
+	}
+	export.Geo = exportedGeo{Lookups: geoData.Lookups, QuotaUsage: geoData.QuotaUsage}
+
+	return json.Marshal(export)
+}
+
+// eraseUser обезличивает учетную запись и удаляет данные пользователя в
+// auth и geo. Каждый шаг можно повторить, поэтому упавшая задача просто
+// запускается снова.
+//
+// Запись в базе обезличивается первой: после этого войти уже нельзя, и
+// удаление сессий в auth не обгонит новый вход.
+func (s *UserService) eraseUser(ctx context.Context, job *model.DataJob) error {
+	err := s.withTx(ctx, func(ctx context.Context) error {
+		// Отметка о стирании ставится один раз; если она уже есть, запись обезличена при прошлой попытке
+		added, err := s.dataJobs.AddTombstone(ctx, job)
+		if err != nil || !added {
+			return err
+		}
+
+		if err := s.tokens.DeleteByUser(ctx, job.UserID); err != nil {
+			return err
+		}
+		if err := s.identities.DeleteByUser(ctx, job.UserID); err != nil {
+			return err
+		}
+		if err := s.mfa.DeleteByUser(ctx, job.UserID); err != nil {
+			return err
+		}
+
+		// Erase очистит старые события о пользователе: они содержат его email
+		return s.users.Erase(ctx, job.UserID)
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.authClient.PurgeUserData(ctx, &pb_auth.PurgeUserDataRequest{UserId: job.UserID}); err != nil {
+		return fmt.Errorf("failed to purge auth data: %v", err)
+	}
+	if _, err := s.geoClient.PurgeUserData(ctx, &pb_geo.PurgeUserDataRequest{UserId: job.UserID}); err != nil {
+		return fmt.Errorf("failed to purge geo data: %v", err)
+	}
+
+	log.Printf("User %s erased, requested by %s", job.UserID, job.RequestedBy)
+	s.record(ctx, audit.Event{Actor: job.RequestedBy, Action: audit.ActionDataErase, Target: job.UserID, Reason: "job " + job.ID + " completed"})
+
+	return nil
+}
+
+func dataJobAction(jobType string) string {
+	if jobType == dataJobErase {
+		return audit.ActionDataErase
+	}
+	return audit.ActionDataExport
+}
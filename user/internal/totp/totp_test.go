@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcSecret - секрет SHA1 из тестовых векторов RFC 6238
+var rfcSecret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// Векторы RFC 6238 даны для 8 цифр; 6-значный код - их последние цифры
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, Code(rfcSecret, time.Unix(tt.unix, 0)), "time %d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / period
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		step     int64
+		ok       bool
+	}{
+		{name: "current step", code: Code(rfcSecret, now), step: current, ok: true},
+		{name: "previous step within skew", code: Code(rfcSecret, now.Add(-period*time.Second)), step: current - 1, ok: true},
+		{name: "next step within skew", code: Code(rfcSecret, now.Add(period*time.Second)), step: current + 1, ok: true},
+		{name: "two steps back is outside skew", code: Code(rfcSecret, now.Add(-2*period*time.Second))},
+		{name: "two steps ahead is outside skew", code: Code(rfcSecret, now.Add(2*period*time.Second))},
+		{name: "spaces are trimmed", code: " " + Code(rfcSecret, now) + "\n", step: current, ok: true},
+		{name: "wrong length", code: Code(rfcSecret, now)[:5]},
+		{name: "wrong code", code: "000000"},
+		{name: "used step is rejected", code: Code(rfcSecret, now), lastStep: current},
+		{name: "older step after newer is rejected", code: Code(rfcSecret, now.Add(-period*time.Second)), lastStep: current},
+		{name: "step after last used", code: Code(rfcSecret, now.Add(period*time.Second)), lastStep: current, step: current + 1, ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.lastStep)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.step, step)
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, secretSize)
+
+	uri := URI("DD", "test@example.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/DD:test@example.com?"))
+	assert.Contains(t, uri, "secret="+EncodeSecret(secret))
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+	assert.NotContains(t, EncodeSecret(secret), "=")
+}
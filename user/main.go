@@ -1,48 +1,76 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"dd/pkg/audit"
+	pb_auth "dd/pkg/auth"
+	"dd/pkg/authn"
+	"dd/pkg/authz"
+	pb_geo "dd/pkg/geo"
+	"dd/pkg/secretbox"
 	pb "dd/pkg/user"
 	"dd/user/internal/config"
+	"dd/user/internal/mailer"
+	"dd/user/internal/migrate"
+	"dd/user/internal/outbox"
+	"dd/user/internal/password"
+	"dd/user/internal/repository"
 	"dd/user/internal/service"
+	"dd/user/migrations"
 	"fmt"
 	"log"
 	"net"
+	"os"
+	"time"
+	// Часовые пояса профиля проверяются и там, где в системе нет базы зон
+	_ "time/tzdata"
 
 	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
 	"google.golang.org/grpc"
 )
 
 func main() {
 	cfg := config.New()
 
-	// Подключаемся к базе данных
-	dbURL := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		cfg.DB.Host, cfg.DB.Port, cfg.DB.User, cfg.DB.Password, cfg.DB.DBName)
+	// Хранилищу в памяти база не нужна
+	var db *sql.DB
+	if cfg.Storage != "memory" {
+		db = openDB(cfg)
+		defer db.Close()
+	}
 
-	db, err := sql.Open("postgres", dbURL)
-	if err != nil {
-		log.Fatalf("failed to connect to database: %v", err)
+	// Подкоманда "migrate" управляет схемой и завершает процесс
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if db == nil {
+			log.Fatalf("migrate: storage %q has no schema", cfg.Storage)
+		}
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
 	}
-	defer db.Close()
 
-	// Проверяем подключение
-	if err := db.Ping(); err != nil {
-		log.Fatalf("failed to ping database: %v", err)
+	// Подкоманда "verify-audit" проверяет целостность журнала аудита
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		if db == nil {
+			log.Fatalf("verify-audit: storage %q keeps no audit log", cfg.Storage)
+		}
+		if err := runVerifyAudit(db); err != nil {
+			log.Fatalf("verify-audit: %v", err)
+		}
+		return
 	}
 
-	// Выполняем миграцию
-	_, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-			email VARCHAR(255) UNIQUE NOT NULL,
-			password_hash VARCHAR(255) NOT NULL,
-			created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
-		);
-		CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-	`)
-	if err != nil {
-		log.Fatalf("failed to create table: %v", err)
+	if db != nil && cfg.MigrateOnStart {
+		runner, err := migrate.New(db, migrations.FS)
+		if err != nil {
+			log.Fatalf("failed to load migrations: %v", err)
+		}
+		if _, err := runner.Up(context.Background()); err != nil {
+			log.Fatalf("failed to migrate database: %v", err)
+		}
 	}
 
 	// Создаем gRPC сервер
@@ -51,12 +79,158 @@ func main() {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	userService := service.New(db)
+	if cfg.ClientSecret == "" {
+		log.Fatalf("USER_CLIENT_SECRET is not set")
+	}
+
+	// Токен сервиса запрашивается по отдельному соединению без учетных данных
+	tokenConn, err := grpc.Dial(cfg.AuthService, grpc.WithInsecure())
+	if err != nil {
+		log.Fatalf("failed to connect to auth service: %v", err)
+	}
+	defer tokenConn.Close()
+
+	serviceCreds := authn.NewServiceCredentials(authn.NewAuthServiceTokenSource(
+		pb_auth.NewAuthServiceClient(tokenConn), cfg.ClientID, cfg.ClientSecret))
+
+	// Токены вызывающих проверяются локально по ключам auth сервиса.
+	// Вызовы без токена пользователя подписываются токеном user сервиса.
+	authConn, err := grpc.Dial(cfg.AuthService, grpc.WithInsecure(), grpc.WithPerRPCCredentials(serviceCreds))
+	if err != nil {
+		log.Fatalf("failed to connect to auth service: %v", err)
+	}
+	defer authConn.Close()
+
+	// geo сервис отдает и удаляет данные пользователя для выгрузки и стирания
+	geoConn, err := grpc.Dial(cfg.GeoService, grpc.WithInsecure(), grpc.WithPerRPCCredentials(serviceCreds))
+	if err != nil {
+		log.Fatalf("failed to connect to geo service: %v", err)
+	}
+	defer geoConn.Close()
+
+	authClient := pb_auth.NewAuthServiceClient(authConn)
+	verifier := authn.NewVerifier(
+		authn.NewAuthServiceKeySource(authClient),
+		cfg.JWTIssuer,
+		cfg.JWTAudience,
+	)
+
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		authn.UnaryServerInterceptor(verifier),
+		authz.UnaryServerInterceptor(service.Policy),
+	))
+	m, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatalf("failed to create mailer: %v", err)
+	}
+
+	// Секреты TOTP хранятся в базе только в зашифрованном виде
+	secrets, err := secretbox.New(cfg.MFAEncryptionKey)
+	if err != nil {
+		log.Fatalf("failed to init MFA encryption: %v", err)
+	}
+
+	passwords, err := password.NewHasher(cfg.PasswordHashing)
+	if err != nil {
+		log.Fatalf("failed to init password hashing: %v", err)
+	}
+
+	var breached password.RangeSource
+	if cfg.BreachedPasswordsFile != "" {
+		corpus, err := password.LoadCorpus(cfg.BreachedPasswordsFile)
+		if err != nil {
+			log.Fatalf("failed to load breached passwords: %v", err)
+		}
+		log.Printf("Loaded %d breached password hashes", corpus.Len())
+		breached = corpus
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// События о пользователях публикуются в поток Redis
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisAddr,
+	})
+	defer redisClient.Close()
+	broker := outbox.NewRedisBroker(redisClient, cfg.Outbox.Stream, cfg.Outbox.MaxLen, 5*time.Second)
+
+	store, auditLog, err := newStore(ctx, cfg, db, broker)
+	if err != nil {
+		log.Fatalf("failed to init storage: %v", err)
+	}
+
+	userService := service.New(store, m, secrets, passwords, breached, authClient, pb_geo.NewGeoServiceClient(geoConn), auditLog, cfg)
 	pb.RegisterUserServiceServer(grpcServer, userService)
 
+	// Email удаленных учетных записей освобождается по истечении срока хранения
+	go userService.RunCleanup(ctx)
+
+	// Выгрузка и стирание данных выполняются в фоне
+	go userService.RunDataJobs(ctx)
+
 	log.Printf("Starting User service on port %s", cfg.GRPCPort)
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
 }
+
+// openDB подключается к базе данных сервиса
+func openDB(cfg *config.Config) *sql.DB {
+	dbURL := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		cfg.DB.Host, cfg.DB.Port, cfg.DB.User, cfg.DB.Password, cfg.DB.DBName)
+
+	db, err := sql.Open("postgres", dbURL)
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
+	}
+
+	// Проверяем подключение
+	if err := db.Ping(); err != nil {
+		log.Fatalf("failed to ping database: %v", err)
+	}
+	return db
+}
+
+// newStore создает хранилища и журнал аудита по настройкам и запускает их
+// фоновые задачи, пока не отменен ctx
+func newStore(ctx context.Context, cfg *config.Config, db *sql.DB, broker outbox.Broker) (repository.Store, audit.Log, error) {
+	switch cfg.Storage {
+	case "postgres":
+		// Журнал аудита пишется в фоне, чтобы запросы не ждали его блокировки.
+		// Без MigrateOnStart таблица появится после "user migrate up".
+		auditLog := audit.NewPostgres(db, "user", audit.WithProxy(cfg.ProxyClientID))
+		initCtx, cancelInit := context.WithTimeout(ctx, 2*time.Minute)
+		err := auditLog.Init(initCtx)
+		cancelInit()
+		if err != nil {
+			return repository.Store{}, nil, fmt.Errorf("failed to init audit log: %v", err)
+		}
+		go auditLog.Run(ctx)
+
+		// События из outbox публикуются после фиксации изменений
+		relay := outbox.NewRelay(db, broker, cfg.Outbox.BatchSize, cfg.Outbox.PollInterval, cfg.Outbox.Retention)
+		go relay.Run(ctx)
+
+		return repository.NewPostgresStore(db), auditLog, nil
+	case "memory":
+		log.Printf("Using in-memory storage: data will be lost on restart")
+		return repository.NewMemoryStore(broker), audit.NewMemory("user", audit.WithProxy(cfg.ProxyClientID)), nil
+	default:
+		return repository.Store{}, nil, fmt.Errorf("unknown storage: %q", cfg.Storage)
+	}
+}
+
+// newMailer создает отправителя писем по настройкам
+func newMailer(cfg config.MailConfig) (mailer.Mailer, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	case "file":
+		return mailer.NewFileMailer(cfg.FilePath, cfg.From)
+	case "log":
+		return mailer.NewLogMailer(cfg.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %q", cfg.Driver)
+	}
+}
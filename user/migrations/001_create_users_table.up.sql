@@ -5,4 +5,4 @@ CREATE TABLE IF NOT EXISTS users (
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE INDEX idx_users_email ON users(email); 
\ No newline at end of file
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
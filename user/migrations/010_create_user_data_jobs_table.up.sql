@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS user_data_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    type VARCHAR(16) NOT NULL,
+    user_id UUID NOT NULL,
+    requested_by VARCHAR(255) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    archive JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Незавершенная задача каждого типа у пользователя одна, повторный запрос возвращает ее
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_data_jobs_active ON user_data_jobs(user_id, type) WHERE status IN ('pending', 'running');
+
+-- Отметка о стирании учетной записи переживает саму запись
+CREATE TABLE IF NOT EXISTS user_tombstones (
+    user_id UUID PRIMARY KEY,
+    job_id UUID NOT NULL,
+    requested_by VARCHAR(255) NOT NULL,
+    erased_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,2 @@
+-- Собственная суточная квота ключа; 0 - квота geo сервиса по умолчанию
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS daily_quota INTEGER NOT NULL DEFAULT 0;
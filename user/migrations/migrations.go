@@ -0,0 +1,11 @@
+// Package migrations содержит SQL миграции схемы user сервиса. Файлы
+// называются NNN_описание.up.sql и NNN_описание.down.sql и встраиваются в
+// бинарный файл; применяет их пакет internal/migrate.
+package migrations
+
+import "embed"
+
+// FS - встроенные файлы миграций
+//
+//go:embed *.sql
+var FS embed.FS